@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxAccountNumberLength = 19
+	accountNumberAttempts  = 5
+)
+
+type ErrInvalidAccountNumber struct {
+	Number string
+}
+
+func (err *ErrInvalidAccountNumber) Code() string {
+	return "ErrInvalidAccountNumber"
+}
+
+func (err *ErrInvalidAccountNumber) messageArgs() []any {
+	return []any{err.Number}
+}
+
+func (err *ErrInvalidAccountNumber) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrAccountNumberNotFound struct {
+	Number string
+}
+
+func (err *ErrAccountNumberNotFound) Code() string {
+	return "ErrAccountNumberNotFound"
+}
+
+func (err *ErrAccountNumberNotFound) messageArgs() []any {
+	return []any{err.Number}
+}
+
+func (err *ErrAccountNumberNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrAccountNumberNotFound) Status() int {
+	return http.StatusNotFound
+}
+
+func isDigits(value string) bool {
+	for _, character := range value {
+		if character < '0' || character > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// luhnCheckDigit computes the digit that makes payload followed by it pass
+// the Luhn check. Counting from the check digit, every second digit is
+// doubled, so the rightmost digit of payload is the first one doubled.
+func luhnCheckDigit(payload string) byte {
+	sum := 0
+	for i := len(payload) - 1; i >= 0; i-- {
+		digit := int(payload[i] - '0')
+		if (len(payload)-i)%2 == 1 {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// validateAccountNumber rejects anything that is not a string of digits
+// ending in its Luhn check digit, so mistyped numbers are refused before
+// the database is asked. The length and prefix are not checked: numbers
+// issued under an earlier ACCOUNT_NUMBER_PREFIX or ACCOUNT_NUMBER_LENGTH
+// stay valid.
+func validateAccountNumber(number string) error {
+	if len(number) < 2 || len(number) > maxAccountNumberLength || !isDigits(number) ||
+		luhnCheckDigit(number[:len(number)-1]) != number[len(number)-1] {
+		return &ErrInvalidAccountNumber{Number: number}
+	}
+	return nil
+}
+
+// newAccountNumber returns ACCOUNT_NUMBER_PREFIX followed by random digits
+// and a Luhn check digit, AccountNumberLength digits in all.
+func newAccountNumber(config *Config) string {
+	payload := []byte(config.AccountNumberPrefix)
+	for len(payload) < config.AccountNumberLength-1 {
+		digit, _ := rand.Int(rand.Reader, big.NewInt(10))
+		payload = append(payload, byte('0'+digit.Int64()))
+	}
+	return string(payload) + string(luhnCheckDigit(string(payload)))
+}
+
+// assignAccountNumber picks a number no account has yet. Short formats can
+// run out of free numbers; after accountNumberAttempts tries the last one
+// is returned anyway and the unique index refuses the insert.
+func assignAccountNumber(ctx context.Context, accountCollection *mongo.Collection, config *Config) (string, error) {
+	var number string
+	for attempt := 0; attempt < accountNumberAttempts; attempt++ {
+		number = newAccountNumber(config)
+		err := accountCollection.FindOne(ctx, bson.D{{Key: "accountnumber", Value: number}}).Err()
+		if err == mongo.ErrNoDocuments {
+			return number, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return number, nil
+}
+
+func getAccountByNumberHandler(accountCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		number := ctx.Param("number")
+		if err := validateAccountNumber(number); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		var account BankAccount
+		err := accountCollection.FindOne(ctx.Request.Context(), bson.D{
+			{Key: "accountnumber", Value: number},
+		}).Decode(&account)
+		if err == mongo.ErrNoDocuments {
+			sendError(ctx, &ErrAccountNumberNotFound{Number: number})
+			return
+		}
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		respond(ctx, http.StatusOK, account)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLuhnCheckDigit(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    byte
+	}{
+		{payload: "7992739871", want: '3'},
+		{payload: "0", want: '0'},
+		{payload: "1", want: '8'},
+		{payload: "453201511283036", want: '6'},
+	}
+	for _, test := range tests {
+		if got := luhnCheckDigit(test.payload); got != test.want {
+			t.Fatalf("luhnCheckDigit(%q) = %c, want %c", test.payload, got, test.want)
+		}
+	}
+}
+
+func TestNewAccountNumber(t *testing.T) {
+	tests := []struct {
+		prefix string
+		length int
+	}{
+		{prefix: "", length: 10},
+		{prefix: "042", length: 12},
+		{prefix: "9", length: 2},
+		{prefix: "", length: maxAccountNumberLength},
+	}
+	for _, test := range tests {
+		config := &Config{AccountNumberPrefix: test.prefix, AccountNumberLength: test.length}
+		for i := 0; i < 50; i++ {
+			number := newAccountNumber(config)
+			if len(number) != test.length || !strings.HasPrefix(number, test.prefix) {
+				t.Fatalf("prefix %q, length %d: number = %q", test.prefix, test.length, number)
+			}
+			if err := validateAccountNumber(number); err != nil {
+				t.Fatalf("prefix %q, length %d: %q: %v", test.prefix, test.length, number, err)
+			}
+		}
+	}
+}
+
+func TestValidateAccountNumber(t *testing.T) {
+	valid := "7992739871" + "3"
+	if err := validateAccountNumber(valid); err != nil {
+		t.Fatalf("%q: %v", valid, err)
+	}
+
+	// Changing any single digit breaks the checksum.
+	for i := range valid {
+		for digit := byte('0'); digit <= '9'; digit++ {
+			if digit == valid[i] {
+				continue
+			}
+			tampered := valid[:i] + string(digit) + valid[i+1:]
+			var numberError *ErrInvalidAccountNumber
+			if err := validateAccountNumber(tampered); !errors.As(err, &numberError) || numberError.Number != tampered {
+				t.Fatalf("%q: error = %v, want ErrInvalidAccountNumber", tampered, err)
+			}
+		}
+	}
+
+	// So does swapping two adjacent, different digits.
+	swapped := "9792739871" + "3"
+	for _, number := range []string{swapped, "", "0", "79927a98713", "+7992739871", strings.Repeat("0", maxAccountNumberLength+1)} {
+		if validateAccountNumber(number) == nil {
+			t.Fatalf("%q: accepted", number)
+		}
+	}
+}
+
+func TestLoadConfigAccountNumber(t *testing.T) {
+	tests := []struct {
+		prefix string
+		length string
+		valid  bool
+		want   int
+	}{
+		{valid: true, want: 10},
+		{prefix: "042", length: "12", valid: true, want: 12},
+		{prefix: "04", length: "4", valid: true, want: 4},
+		{prefix: "04", length: "3"},
+		{prefix: "ab", length: "10"},
+		{length: strconv.Itoa(maxAccountNumberLength + 1)},
+		{length: "-1"},
+	}
+	for _, test := range tests {
+		t.Setenv("ACCOUNT_NUMBER_PREFIX", test.prefix)
+		t.Setenv("ACCOUNT_NUMBER_LENGTH", test.length)
+		config, err := loadConfig()
+		var configError *ErrInvalidConfig
+		switch {
+		case test.valid && err != nil:
+			t.Fatalf("prefix %q, length %q: %v", test.prefix, test.length, err)
+		case test.valid && (config.AccountNumberLength != test.want || config.AccountNumberPrefix != test.prefix):
+			t.Fatalf("prefix %q, length %q: config = %q, %d", test.prefix, test.length,
+				config.AccountNumberPrefix, config.AccountNumberLength)
+		case !test.valid && !errors.As(err, &configError):
+			t.Fatalf("prefix %q, length %q: error = %v, want ErrInvalidConfig", test.prefix, test.length, err)
+		}
+	}
+}
+
+func TestGetAccountByNumber(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AccountNumberPrefix = "042"
+		config.AccountNumberLength = 12
+	})
+	created := server.createAccount("alice")
+	if len(created.AccountNumber) != 12 || !strings.HasPrefix(created.AccountNumber, "042") {
+		t.Fatalf("account number = %q", created.AccountNumber)
+	}
+
+	recorder := server.request(http.MethodGet, "/account/by-number/"+created.AccountNumber, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if account := decodeResponse[BankAccount](t, recorder); account.UserName != "alice" {
+		t.Fatalf("account = %+v", account)
+	}
+
+	last := created.AccountNumber[len(created.AccountNumber)-1]
+	tampered := created.AccountNumber[:len(created.AccountNumber)-1] + string('0'+(last-'0'+1)%10)
+	recorder = server.request(http.MethodGet, "/account/by-number/"+tampered, nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidAccountNumber")
+
+	// A well-formed number nobody holds passes validation and is looked up.
+	unused := "7992739871" + "3"
+	recorder = server.request(http.MethodGet, "/account/by-number/"+unused, nil)
+	expectErrorCode(t, recorder, http.StatusNotFound, "ErrAccountNumberNotFound")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func startOfDay(moment time.Time) time.Time {
+	year, month, day := moment.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// applyDebtPenalties charges one day of penalty interest to every indebted
+// account that has not been charged yet today and is past its debt grace
+// period. The penalty is rounded to a whole unit with the configured
+// rounding mode and added with $inc, guarded by the debt that was read, so
+// a concurrent mutation makes the update miss instead of compounding twice.
+func applyDebtPenalties(
+	ctx context.Context, accountRepository *AccountRepository, transactionCollection *mongo.Collection,
+	config *Config, now time.Time,
+) (int, error) {
+	today := startOfDay(now)
+	accountCollection := accountRepository.Collection()
+
+	debtorSearchResult, err := accountCollection.Find(ctx, bson.D{
+		{Key: "debt", Value: bson.D{{Key: "$gt", Value: 0}}},
+		{Key: "lastpenaltydate", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gte", Value: today}}}}},
+		{Key: "debtgraceuntil", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: now.UTC()}}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer debtorSearchResult.Close(ctx)
+
+	penalizedCount := 0
+	for debtorSearchResult.Next(ctx) {
+		var debtor BankAccount
+		if err := debtorSearchResult.Decode(&debtor); err != nil {
+			return penalizedCount, err
+		}
+
+		penalty := roundAmount(float64(debtor.Debt)*config.PenaltyRate, config.RoundingMode)
+		updateResult, err := accountCollection.UpdateOne(ctx, bson.D{
+			{Key: "username", Value: debtor.UserName},
+			{Key: "debt", Value: debtor.Debt},
+		}, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "debt", Value: penalty}, {Key: "version", Value: 1}}},
+			{Key: "$set", Value: bson.D{
+				{Key: "lastpenaltydate", Value: today},
+				{Key: "updatedat", Value: now.UTC()},
+			}},
+		})
+		if err != nil {
+			return penalizedCount, err
+		}
+		accountRepository.Invalidate(debtor.UserName)
+		if updateResult.ModifiedCount == 0 || penalty == 0 {
+			continue
+		}
+
+		debtor.Debt += penalty
+		recordTransaction(transactionCollection, newTransaction(config.Clock, debtor, transactionTypePenalty, penalty))
+		retainHistory(transactionCollection, config, debtor.UserName)
+		penalizedCount++
+	}
+	return penalizedCount, debtorSearchResult.Err()
+}
+
+// startAccrualJob runs the accrual once at startup and then on every tick.
+// Accounts remember the last day they were charged, so restarts and short
+// intervals never apply the same day twice.
+func startAccrualJob(
+	accountRepository *AccountRepository, transactionCollection *mongo.Collection, config *Config,
+) {
+	if config.PenaltyRate == 0 {
+		return
+	}
+
+	accrue := func() {
+		penalizedCount, err := applyDebtPenalties(context.TODO(),
+			accountRepository, transactionCollection, config, config.Clock.Now())
+		if err != nil {
+			log.Printf("debt penalty accrual failed: %v", err)
+			return
+		}
+		log.Printf("debt penalty applied to %d accounts", penalizedCount)
+	}
+
+	go func() {
+		accrue()
+		ticker := time.NewTicker(config.AccrualInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			accrue()
+		}
+	}()
+}
+
+// pendingPenalty is the penalty applyDebtPenalties would charge the account
+// if it ran at now: one day's worth when the account owes money, is past
+// its grace period and has not been charged yet today, zero otherwise.
+func pendingPenalty(account BankAccount, config *Config, now time.Time) int {
+	if config.PenaltyRate == 0 || account.Debt <= 0 ||
+		!account.LastPenaltyDate.Before(startOfDay(now)) || account.DebtGraceUntil.After(now) {
+		return 0
+	}
+	return roundAmount(float64(account.Debt)*config.PenaltyRate, config.RoundingMode)
+}
+
+type PayoffAmount struct {
+	UserName       string `json:"username"`
+	Debt           int    `json:"debt"`
+	PendingPenalty int    `json:"pendingPenalty"`
+	Payoff         int    `json:"payoff"`
+}
+
+// getPayoffAmountHandler reports how much has to be paid in to clear the
+// account's debt: the debt itself plus today's penalty when it is due but
+// the accrual job has not charged it yet. Debt-free accounts get zero.
+func getPayoffAmountHandler(accountRepository *AccountRepository, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		account, err := accountRepository.FindFreshByUsername(ctx.Request.Context(), userName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		payoff := PayoffAmount{UserName: account.UserName}
+		if account.Debt > 0 {
+			payoff.Debt = account.Debt
+			payoff.PendingPenalty = pendingPenalty(account, config, config.Clock.Now())
+			payoff.Payoff = payoff.Debt + payoff.PendingPenalty
+		}
+		respond(ctx, http.StatusOK, payoff)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPendingPenalty(t *testing.T) {
+	config, clock := newTestConfig(t)
+	config.PenaltyRate = 0.1
+	now := clock.Now()
+
+	tests := []struct {
+		name    string
+		account BankAccount
+		penalty int
+	}{
+		{name: "indebted", account: BankAccount{Debt: 100}, penalty: 10},
+		{name: "debt-free", account: BankAccount{Balance: 100}},
+		{name: "charged today", account: BankAccount{Debt: 100, LastPenaltyDate: startOfDay(now)}},
+		{name: "charged yesterday", account: BankAccount{Debt: 100, LastPenaltyDate: startOfDay(now).AddDate(0, 0, -1)},
+			penalty: 10},
+		{name: "in grace period", account: BankAccount{Debt: 100, DebtGraceUntil: now.Add(time.Hour)}},
+		{name: "grace period over", account: BankAccount{Debt: 100, DebtGraceUntil: now.Add(-time.Hour)}, penalty: 10},
+	}
+	for _, test := range tests {
+		if penalty := pendingPenalty(test.account, config, now); penalty != test.penalty {
+			t.Errorf("%s: pendingPenalty = %d, want %d", test.name, penalty, test.penalty)
+		}
+	}
+}
+
+func TestApplyDebtPenalties(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.PenaltyRate = 0.1
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.createAccount("carol")
+	server.withdraw("alice", 100)
+	server.deposit("bob", 100)
+
+	penalizedCount, err := applyDebtPenalties(context.Background(), server.accounts, server.transactions,
+		server.config, server.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if penalizedCount != 1 {
+		t.Fatalf("penalized %d accounts, want 1", penalizedCount)
+	}
+
+	if alice := server.account("alice"); alice.Debt != 110 || !alice.LastPenaltyDate.Equal(startOfDay(server.clock.Now())) {
+		t.Fatalf("alice = %+v, want debt 110 charged today", alice)
+	}
+	history := server.history("alice")
+	if last := history[len(history)-1]; last.Type != transactionTypePenalty || last.Amount != 10 || last.Debt != 110 {
+		t.Fatalf("last alice entry = %+v, want a penalty of 10", last)
+	}
+	for _, userName := range []string{"bob", "carol"} {
+		if account := server.account(userName); account.Debt != 0 || !account.LastPenaltyDate.IsZero() {
+			t.Fatalf("%s was charged: %+v", userName, account)
+		}
+		for _, transaction := range server.history(userName) {
+			if transaction.Type == transactionTypePenalty {
+				t.Fatalf("%s has a penalty entry: %+v", userName, transaction)
+			}
+		}
+	}
+}
+
+func TestDebtGracePeriod(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.PenaltyRate = 0.1
+		config.DebtGracePeriod = 48 * time.Hour
+	})
+	server.createAccount("alice")
+	incurredAt := server.clock.Now()
+	server.withdraw("alice", 100)
+	if alice := server.account("alice"); !alice.DebtGraceUntil.Equal(incurredAt.Add(48 * time.Hour)) {
+		t.Fatalf("grace until %v, want 48 hours after %v", alice.DebtGraceUntil, incurredAt)
+	}
+
+	penalize := func() int {
+		t.Helper()
+		penalizedCount, err := applyDebtPenalties(context.Background(), server.accounts, server.transactions,
+			server.config, server.clock.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return penalizedCount
+	}
+	if penalizedCount := penalize(); penalizedCount != 0 {
+		t.Fatalf("penalized %d accounts on the day the debt appeared, want 0", penalizedCount)
+	}
+
+	// More debt within the window does not push its end back.
+	server.clock.Advance(24 * time.Hour)
+	server.withdraw("alice", 50)
+	if penalizedCount := penalize(); penalizedCount != 0 {
+		t.Fatalf("penalized %d accounts within the grace period, want 0", penalizedCount)
+	}
+	if alice := server.account("alice"); alice.Debt != 150 || !alice.DebtGraceUntil.Equal(incurredAt.Add(48*time.Hour)) {
+		t.Fatalf("alice = %+v, want debt 150 still in the first grace period", alice)
+	}
+
+	server.clock.Advance(25 * time.Hour)
+	if penalizedCount := penalize(); penalizedCount != 1 {
+		t.Fatalf("penalized %d accounts after the grace period, want 1", penalizedCount)
+	}
+	if alice := server.account("alice"); alice.Debt != 165 {
+		t.Fatalf("alice debt = %d, want 165", alice.Debt)
+	}
+
+	// Paying the debt off ends the grace period, so new debt starts another.
+	server.deposit("alice", 165)
+	if alice := server.account("alice"); !alice.DebtGraceUntil.IsZero() {
+		t.Fatalf("grace until %v after repaying, want zero", alice.DebtGraceUntil)
+	}
+	server.withdraw("alice", 10)
+	if alice := server.account("alice"); !alice.DebtGraceUntil.Equal(server.clock.Now().Add(48 * time.Hour)) {
+		t.Fatalf("grace until %v, want a new period from %v", alice.DebtGraceUntil, server.clock.Now())
+	}
+}
+
+// A rate of one half puts odd debts exactly on the half, where the two
+// rounding modes part.
+func TestPendingPenaltyRounding(t *testing.T) {
+	config, clock := newTestConfig(t)
+	config.PenaltyRate = 0.5
+	tests := []struct {
+		debt     int
+		halfEven int
+		halfUp   int
+	}{
+		{debt: 1, halfEven: 0, halfUp: 1},
+		{debt: 3, halfEven: 2, halfUp: 2},
+		{debt: 5, halfEven: 2, halfUp: 3},
+		{debt: 7, halfEven: 4, halfUp: 4},
+		{debt: 8, halfEven: 4, halfUp: 4},
+	}
+	for _, test := range tests {
+		account := BankAccount{Debt: test.debt}
+		config.RoundingMode = roundingHalfEven
+		if penalty := pendingPenalty(account, config, clock.Now()); penalty != test.halfEven {
+			t.Errorf("half-even penalty on %d = %d, want %d", test.debt, penalty, test.halfEven)
+		}
+		config.RoundingMode = roundingHalfUp
+		if penalty := pendingPenalty(account, config, clock.Now()); penalty != test.halfUp {
+			t.Errorf("half-up penalty on %d = %d, want %d", test.debt, penalty, test.halfUp)
+		}
+	}
+}
+
+func TestPayoffAmount(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.PenaltyRate = 0.1
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.withdraw("alice", 100)
+	server.deposit("bob", 50)
+
+	payoff := func(userName string) PayoffAmount {
+		t.Helper()
+		recorder := server.request(http.MethodGet, "/account/"+userName+"/payoff", nil)
+		expectStatus(t, recorder, http.StatusOK)
+		return decodeResponse[PayoffAmount](t, recorder)
+	}
+	applyPenalties := func() {
+		t.Helper()
+		if _, err := applyDebtPenalties(context.Background(), server.accounts, server.transactions,
+			server.config, server.clock.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Today's penalty is due but not charged yet.
+	if got, want := payoff("alice"), (PayoffAmount{UserName: "alice", Debt: 100, PendingPenalty: 10, Payoff: 110}); got != want {
+		t.Fatalf("before accrual: %+v, want %+v", got, want)
+	}
+	applyPenalties()
+	if got, want := payoff("alice"), (PayoffAmount{UserName: "alice", Debt: 110, Payoff: 110}); got != want {
+		t.Fatalf("after accrual: %+v, want %+v", got, want)
+	}
+	server.clock.Advance(24 * time.Hour)
+	if got, want := payoff("alice"), (PayoffAmount{UserName: "alice", Debt: 110, PendingPenalty: 11, Payoff: 121}); got != want {
+		t.Fatalf("next day: %+v, want %+v", got, want)
+	}
+	applyPenalties()
+	server.deposit("alice", 121)
+	if alice := server.account("alice"); alice.Debt != 0 || alice.Balance != 0 {
+		t.Fatalf("after paying the payoff: balance %d, debt %d, want both 0", alice.Balance, alice.Debt)
+	}
+
+	for _, userName := range []string{"alice", "bob"} {
+		if got, want := payoff(userName), (PayoffAmount{UserName: userName}); got != want {
+			t.Fatalf("debt-free %s: %+v, want %+v", userName, got, want)
+		}
+	}
+	recorder := server.request(http.MethodGet, "/account/ghost/payoff", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrNoDocuments")
+}
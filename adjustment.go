@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxIdempotencyKeyLength = 64
+
+type ErrIdempotencyKeyReused struct {
+	Key      string
+	UserName string
+}
+
+func (err *ErrIdempotencyKeyReused) Code() string {
+	return "ErrIdempotencyKeyReused"
+}
+
+func (err *ErrIdempotencyKeyReused) messageArgs() []any {
+	return []any{err.Key, err.UserName}
+}
+
+func (err *ErrIdempotencyKeyReused) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrIdempotencyKeyReused) Status() int {
+	return http.StatusConflict
+}
+
+type LedgerAdjustmentInput struct {
+	UserName       string `json:"username"`
+	Balance        int    `json:"balance"`
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+func (input *LedgerAdjustmentInput) Error() error {
+	var validationErrors MultiError
+	if !isUsernameValid(input.UserName) {
+		validationErrors.Add("username", &ErrInvalidUsername{UserName: input.UserName})
+	}
+	if input.Balance < 0 {
+		validationErrors.Add("balance", &ErrNegativeValue{Name: "balance"})
+	}
+	if input.IdempotencyKey == "" {
+		validationErrors.Add("idempotencyKey", &ErrRequiredField{Name: "idempotencyKey"})
+	} else if utf8.RuneCountInString(input.IdempotencyKey) > maxIdempotencyKeyLength {
+		validationErrors.Add("idempotencyKey", &ErrFieldTooLong{Name: "idempotencyKey", Max: maxIdempotencyKeyLength})
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+func (input *LedgerAdjustmentInput) normalizeUsernames() {
+	input.UserName = normalizeUsername(input.UserName)
+}
+
+// LedgerAdjustmentResult reports the account after the adjustment and the
+// migration entry recording it. Applied is false when the key had already
+// been used and nothing changed.
+type LedgerAdjustmentResult struct {
+	Account     BankAccount `json:"account"`
+	Transaction Transaction `json:"transaction"`
+	Applied     bool        `json:"applied"`
+}
+
+// findAdjustment returns the earlier adjustment made under key, if any.
+func findAdjustment(
+	ctx context.Context, accountCollection, transactionCollection *mongo.Collection, key string,
+) (LedgerAdjustmentResult, bool, error) {
+	var transaction Transaction
+	err := transactionCollection.FindOne(ctx, bson.D{{Key: "idempotencykey", Value: key}}).Decode(&transaction)
+	if err == mongo.ErrNoDocuments {
+		return LedgerAdjustmentResult{}, false, nil
+	}
+	if err != nil {
+		return LedgerAdjustmentResult{}, false, err
+	}
+	var account BankAccount
+	if err := accountCollection.FindOne(ctx, bson.D{{Key: "username", Value: transaction.UserName}}).Decode(&account); err != nil {
+		return LedgerAdjustmentResult{}, false, err
+	}
+	return LedgerAdjustmentResult{Account: account, Transaction: transaction}, true, nil
+}
+
+// adjustLedgerHandler sets an account's balance to a figure imported from
+// another system. The difference to the current balance is recorded as a
+// single migration entry carrying the idempotency key, so rerunning an
+// import with the same key returns the earlier result instead of applying
+// the difference again. Debt is left as it is.
+func adjustLedgerHandler(accountRepository *AccountRepository, transactionCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		adjustmentInput, ok := bindAndValidate[LedgerAdjustmentInput](ctx)
+		if !ok {
+			return
+		}
+
+		accountCollection := accountRepository.Collection()
+		replay := func() {
+			adjustmentResult, found, err := findAdjustment(ctx.Request.Context(), accountCollection,
+				transactionCollection, adjustmentInput.IdempotencyKey)
+			if err == nil && !found {
+				err = &ErrIdempotencyKeyReused{Key: adjustmentInput.IdempotencyKey, UserName: adjustmentInput.UserName}
+			}
+			if err != nil {
+				sendError(ctx, err)
+				return
+			}
+			if adjustmentResult.Transaction.UserName != adjustmentInput.UserName {
+				sendError(ctx, &ErrIdempotencyKeyReused{
+					Key:      adjustmentInput.IdempotencyKey,
+					UserName: adjustmentResult.Transaction.UserName,
+				})
+				return
+			}
+			respond(ctx, http.StatusOK, adjustmentResult)
+		}
+
+		if _, found, err := findAdjustment(ctx.Request.Context(), accountCollection,
+			transactionCollection, adjustmentInput.IdempotencyKey); err != nil {
+			sendError(ctx, err)
+			return
+		} else if found {
+			replay()
+			return
+		}
+
+		adjustmentResult, err := runInTransaction(ctx.Request.Context(), accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+			account, err := findAccountInSession(sessionCtx, accountCollection, adjustmentInput.UserName)
+			if err != nil {
+				return nil, err
+			}
+			if account.Held > adjustmentInput.Balance {
+				return nil, &ErrFundsOnHold{UserName: account.UserName, Held: account.Held}
+			}
+
+			delta := adjustmentInput.Balance - account.Balance
+			account.Balance = adjustmentInput.Balance
+			if err := accountRepository.Replace(sessionCtx, &account); err != nil {
+				return nil, err
+			}
+			migrationTransaction := newTransaction(accountRepository.Clock(), account, transactionTypeMigration, delta)
+			migrationTransaction.IdempotencyKey = adjustmentInput.IdempotencyKey
+			migrationTransaction, err = insertTransaction(sessionCtx, transactionCollection, migrationTransaction)
+			if err != nil {
+				return nil, err
+			}
+			return LedgerAdjustmentResult{Account: account, Transaction: migrationTransaction, Applied: true}, nil
+		})
+		accountRepository.Invalidate(adjustmentInput.UserName)
+		// A concurrent request with the same key won the unique index.
+		if mongo.IsDuplicateKeyError(err) {
+			replay()
+			return
+		}
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		respond(ctx, http.StatusCreated, adjustmentResult)
+	}
+}
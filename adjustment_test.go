@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLedgerAdjustmentInput(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		input LedgerAdjustmentInput
+		valid bool
+	}{
+		{name: "valid", input: LedgerAdjustmentInput{UserName: "alice", Balance: 250, IdempotencyKey: "import-1"}, valid: true},
+		{name: "zero balance", input: LedgerAdjustmentInput{UserName: "alice", IdempotencyKey: "import-1"}, valid: true},
+		{name: "negative balance", input: LedgerAdjustmentInput{UserName: "alice", Balance: -1, IdempotencyKey: "import-1"}},
+		{name: "missing key", input: LedgerAdjustmentInput{UserName: "alice", Balance: 250}},
+		{name: "key too long", input: LedgerAdjustmentInput{
+			UserName: "alice", Balance: 250, IdempotencyKey: string(make([]byte, maxIdempotencyKeyLength+1)),
+		}},
+		{name: "invalid username", input: LedgerAdjustmentInput{UserName: "", Balance: 250, IdempotencyKey: "import-1"}},
+	} {
+		if err := test.input.Error(); (err == nil) != test.valid {
+			t.Errorf("%s: Error() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestAdjustLedger(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 40)
+
+	adjust := func(input LedgerAdjustmentInput) *httptest.ResponseRecorder {
+		t.Helper()
+		return server.request(http.MethodPost, "/admin/ledger-adjustments", input, "Authorization", "Bearer secret")
+	}
+	input := LedgerAdjustmentInput{UserName: "alice", Balance: 250, IdempotencyKey: "import-1"}
+
+	recorder := adjust(input)
+	expectStatus(t, recorder, http.StatusCreated)
+	first := decodeResponse[LedgerAdjustmentResult](t, recorder)
+	if !first.Applied || first.Account.Balance != 250 ||
+		first.Transaction.Type != transactionTypeMigration || first.Transaction.Amount != 210 {
+		t.Fatalf("adjustment = %+v, want a migration of 210 to 250", first)
+	}
+
+	// Rerunning the import changes nothing and reports the earlier entry.
+	recorder = adjust(input)
+	expectStatus(t, recorder, http.StatusOK)
+	if rerun := decodeResponse[LedgerAdjustmentResult](t, recorder); rerun.Applied ||
+		rerun.Transaction.ID != first.Transaction.ID || rerun.Account.Balance != 250 {
+		t.Fatalf("rerun = %+v, want the earlier migration unapplied", rerun)
+	}
+	if alice := server.account("alice"); alice.Balance != 250 {
+		t.Fatalf("alice balance = %d, want 250", alice.Balance)
+	}
+	migrations := 0
+	for _, transaction := range server.history("alice") {
+		if transaction.Type == transactionTypeMigration {
+			migrations++
+		}
+	}
+	if migrations != 1 {
+		t.Fatalf("migration entries = %d, want 1", migrations)
+	}
+
+	// A lower figure is recorded as a negative migration.
+	recorder = adjust(LedgerAdjustmentInput{UserName: "alice", Balance: 100, IdempotencyKey: "import-2"})
+	expectStatus(t, recorder, http.StatusCreated)
+	if lowered := decodeResponse[LedgerAdjustmentResult](t, recorder); lowered.Transaction.Amount != -150 {
+		t.Fatalf("lowering migration amount = %d, want -150", lowered.Transaction.Amount)
+	}
+
+	recorder = adjust(LedgerAdjustmentInput{UserName: "bob", Balance: 250, IdempotencyKey: "import-1"})
+	expectErrorCode(t, recorder, http.StatusConflict, "ErrIdempotencyKeyReused")
+	if bob := server.account("bob"); bob.Balance != 0 {
+		t.Fatalf("bob balance = %d, want 0", bob.Balance)
+	}
+
+	recorder = server.request(http.MethodPost, "/admin/ledger-adjustments", input)
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ErrUnauthorized struct{}
+
+func (err *ErrUnauthorized) Code() string {
+	return "ErrUnauthorized"
+}
+
+func (err *ErrUnauthorized) messageArgs() []any {
+	return nil
+}
+
+func (err *ErrUnauthorized) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrUnauthorized) Status() int {
+	return http.StatusUnauthorized
+}
+
+// adminAuthMiddleware requires "Authorization: Bearer <ADMIN_TOKEN>". With no
+// token configured every admin request is refused.
+func adminAuthMiddleware(config *Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			sendError(ctx, &ErrUnauthorized{})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+type Dashboard struct {
+	TotalAccounts       int64            `json:"totalAccounts"`
+	TotalBalance        int64            `json:"totalBalance"`
+	TotalDebt           int64            `json:"totalDebt"`
+	ClosedAccounts      int64            `json:"closedAccounts"`
+	FrozenAccounts      int64            `json:"frozenAccounts"`
+	TransactionsLast24h map[string]int64 `json:"transactionsLast24h"`
+	GeneratedAt         time.Time        `json:"generatedAt"`
+}
+
+// computeDashboard gets the account totals in one aggregation and the per
+// type transaction counts in a second one.
+func computeDashboard(
+	ctx context.Context, accountCollection, transactionCollection *mongo.Collection, now time.Time,
+) (Dashboard, error) {
+	dashboard := Dashboard{
+		TransactionsLast24h: map[string]int64{},
+		GeneratedAt:         now,
+	}
+
+	accountTotals, err := accountCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "totalaccounts", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "totalbalance", Value: bson.D{{Key: "$sum", Value: "$balance"}}},
+			{Key: "totaldebt", Value: bson.D{{Key: "$sum", Value: "$debt"}}},
+			{Key: "closedaccounts", Value: bson.D{{Key: "$sum", Value: bson.D{
+				{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{"$closed", true}}}, 1, 0}},
+			}}}},
+			{Key: "frozenaccounts", Value: bson.D{{Key: "$sum", Value: bson.D{
+				{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{"$frozen", true}}}, 1, 0}},
+			}}}},
+		}}},
+	})
+	if err != nil {
+		return dashboard, err
+	}
+	var totals []struct {
+		TotalAccounts  int64
+		TotalBalance   int64
+		TotalDebt      int64
+		ClosedAccounts int64
+		FrozenAccounts int64
+	}
+	if err := accountTotals.All(ctx, &totals); err != nil {
+		return dashboard, err
+	}
+	if len(totals) == 1 {
+		dashboard.TotalAccounts = totals[0].TotalAccounts
+		dashboard.TotalBalance = totals[0].TotalBalance
+		dashboard.TotalDebt = totals[0].TotalDebt
+		dashboard.ClosedAccounts = totals[0].ClosedAccounts
+		dashboard.FrozenAccounts = totals[0].FrozenAccounts
+	}
+
+	transactionCounts, err := transactionCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "createdat", Value: bson.D{{Key: "$gte", Value: now.Add(-24 * time.Hour)}}},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$type"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	})
+	if err != nil {
+		return dashboard, err
+	}
+	var counts []struct {
+		Type  string `bson:"_id"`
+		Count int64
+	}
+	if err := transactionCounts.All(ctx, &counts); err != nil {
+		return dashboard, err
+	}
+	for _, count := range counts {
+		dashboard.TransactionsLast24h[count.Type] = count.Count
+	}
+	return dashboard, nil
+}
+
+// getDashboardHandler serves the dashboard from a cache for
+// DashboardCacheTTL after each computation, so frequent polling does not
+// rerun the aggregations; ?refresh=true recomputes it right away. The
+// cached dashboard is shared, so its GeneratedAt tells how fresh it is.
+func getDashboardHandler(accountCollection, transactionCollection *mongo.Collection, config *Config) func(*gin.Context) {
+	var (
+		mutex  sync.Mutex
+		cached *Dashboard
+	)
+	return func(ctx *gin.Context) {
+		now := config.Clock.Now().UTC()
+		mutex.Lock()
+		defer mutex.Unlock()
+		if cached != nil && !queryFlag(ctx, "refresh") && now.Sub(cached.GeneratedAt) < config.DashboardCacheTTL {
+			respond(ctx, http.StatusOK, *cached)
+			return
+		}
+
+		dashboard, err := computeDashboard(ctx.Request.Context(), accountCollection, transactionCollection, now)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		cached = &dashboard
+		respond(ctx, http.StatusOK, dashboard)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		allowed       bool
+	}{
+		{name: "matching token", adminToken: "secret", authorization: "Bearer secret", allowed: true},
+		{name: "wrong token", adminToken: "secret", authorization: "Bearer guess"},
+		{name: "no header", adminToken: "secret"},
+		{name: "no token configured", authorization: "Bearer "},
+	}
+	for _, test := range tests {
+		router := gin.New()
+		router.GET("/admin", adminAuthMiddleware(&Config{AdminToken: test.adminToken}), func(ctx *gin.Context) {
+			ctx.Status(http.StatusNoContent)
+		})
+		recorder := serveRequest(t, router, http.MethodGet, "/admin", nil, "Authorization", test.authorization)
+		if test.allowed {
+			expectStatus(t, recorder, http.StatusNoContent)
+		} else {
+			expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	for _, userName := range []string{"alice", "bob", "carol", "dave"} {
+		server.createAccount(userName)
+	}
+	server.deposit("alice", 100)
+	server.deposit("carol", 10)
+	server.withdraw("bob", 40)
+	recorder := server.request(http.MethodPost, "/account/close-with-transfer",
+		CloseWithTransferInput{UserName: "carol", Beneficiary: "alice"})
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPost, "/account/freeze/batch", FreezeBatchInput{UserNames: []string{"dave"}},
+		"Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	// A deposit from two days ago falls outside the 24 hour window.
+	old := newTransaction(server.clock, BankAccount{UserName: "alice"}, transactionTypeDeposit, 5)
+	old.CreatedAt = server.clock.Now().Add(-48 * time.Hour)
+	if _, err := insertTransaction(context.Background(), server.transactions, old); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder = server.request(http.MethodGet, "/admin/dashboard", nil)
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+
+	recorder = server.request(http.MethodGet, "/admin/dashboard", nil, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	dashboard := decodeResponse[Dashboard](t, recorder)
+	if dashboard.TotalAccounts != 4 || dashboard.TotalBalance != 110 || dashboard.TotalDebt != 40 ||
+		dashboard.ClosedAccounts != 1 || dashboard.FrozenAccounts != 1 {
+		t.Fatalf("dashboard = %+v", dashboard)
+	}
+	for transactionType, count := range map[string]int64{
+		transactionTypeBonus:       4,
+		transactionTypeDeposit:     2,
+		transactionTypeWithdraw:    1,
+		transactionTypeTransferOut: 1,
+		transactionTypeTransferIn:  1,
+	} {
+		if dashboard.TransactionsLast24h[transactionType] != count {
+			t.Errorf("%s transactions = %d, want %d", transactionType,
+				dashboard.TransactionsLast24h[transactionType], count)
+		}
+	}
+}
+
+func TestDashboardCache(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+		config.DashboardCacheTTL = time.Minute
+	})
+	server.createAccount("alice")
+	server.deposit("alice", 100)
+
+	dashboard := func(target string) Dashboard {
+		t.Helper()
+		recorder := server.request(http.MethodGet, target, nil, "Authorization", "Bearer secret")
+		expectStatus(t, recorder, http.StatusOK)
+		return decodeResponse[Dashboard](t, recorder)
+	}
+	first := dashboard("/admin/dashboard")
+	if first.TotalBalance != 100 {
+		t.Fatalf("total balance = %d, want 100", first.TotalBalance)
+	}
+
+	server.deposit("alice", 50)
+	server.clock.Advance(30 * time.Second)
+	if cached := dashboard("/admin/dashboard"); cached.TotalBalance != 100 || !cached.GeneratedAt.Equal(first.GeneratedAt) {
+		t.Fatalf("within the TTL: balance %d generated %v, want the cached 100 from %v",
+			cached.TotalBalance, cached.GeneratedAt, first.GeneratedAt)
+	}
+	if refreshed := dashboard("/admin/dashboard?refresh=true"); refreshed.TotalBalance != 150 ||
+		!refreshed.GeneratedAt.Equal(server.clock.Now()) {
+		t.Fatalf("refresh: balance %d generated %v, want 150 from %v",
+			refreshed.TotalBalance, refreshed.GeneratedAt, server.clock.Now())
+	}
+
+	server.deposit("alice", 25)
+	server.clock.Advance(time.Minute)
+	if expired := dashboard("/admin/dashboard"); expired.TotalBalance != 175 {
+		t.Fatalf("after the TTL: balance %d, want 175", expired.TotalBalance)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	balanceAlertStateLow  = "low"
+	balanceAlertStateHigh = "high"
+
+	eventTypeBalanceLow  = "balance.low"
+	eventTypeBalanceHigh = "balance.high"
+)
+
+type BalanceAlert struct {
+	Balance   int `json:"balance"`
+	Threshold int `json:"threshold"`
+}
+
+type ErrInvalidBalanceThresholds struct {
+	Low  int
+	High int
+}
+
+func (err *ErrInvalidBalanceThresholds) Code() string {
+	return "ErrInvalidBalanceThresholds"
+}
+
+func (err *ErrInvalidBalanceThresholds) messageArgs() []any {
+	return []any{err.Low, err.High}
+}
+
+func (err *ErrInvalidBalanceThresholds) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+// balanceAlertState reports which configured threshold, if any, the
+// balance is currently beyond. A zero threshold is disabled.
+func balanceAlertState(account BankAccount) string {
+	if account.LowBalanceThreshold > 0 && account.Balance < account.LowBalanceThreshold {
+		return balanceAlertStateLow
+	}
+	if account.HighBalanceThreshold > 0 && account.Balance > account.HighBalanceThreshold {
+		return balanceAlertStateHigh
+	}
+	return ""
+}
+
+// updateBalanceAlert records the account's new alert state and returns an
+// event only when a threshold has just been crossed, so staying below or
+// above a threshold does not alert again. The caller stores the account.
+func updateBalanceAlert(clock Clock, account *BankAccount) []Event {
+	state := balanceAlertState(*account)
+	if state == account.BalanceAlertState {
+		return nil
+	}
+	account.BalanceAlertState = state
+
+	switch state {
+	case balanceAlertStateLow:
+		return []Event{newEvent(clock, eventTypeBalanceLow, account.UserName,
+			BalanceAlert{Balance: account.Balance, Threshold: account.LowBalanceThreshold})}
+	case balanceAlertStateHigh:
+		return []Event{newEvent(clock, eventTypeBalanceHigh, account.UserName,
+			BalanceAlert{Balance: account.Balance, Threshold: account.HighBalanceThreshold})}
+	}
+	return nil
+}
+
+type BalanceThresholdsInput struct {
+	LowBalanceThreshold  int `json:"lowBalanceThreshold"`
+	HighBalanceThreshold int `json:"highBalanceThreshold"`
+}
+
+func (input *BalanceThresholdsInput) Error() error {
+	var validationErrors MultiError
+	if input.LowBalanceThreshold < 0 {
+		validationErrors.Add("lowBalanceThreshold", &ErrNegativeValue{Name: "lowBalanceThreshold"})
+	}
+	if input.HighBalanceThreshold < 0 {
+		validationErrors.Add("highBalanceThreshold", &ErrNegativeValue{Name: "highBalanceThreshold"})
+	}
+	if input.LowBalanceThreshold > 0 && input.HighBalanceThreshold > 0 &&
+		input.LowBalanceThreshold >= input.HighBalanceThreshold {
+		validationErrors.Add("highBalanceThreshold", &ErrInvalidBalanceThresholds{
+			Low:  input.LowBalanceThreshold,
+			High: input.HighBalanceThreshold,
+		})
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+// setBalanceThresholdsHandler stores the user's alert thresholds. The alert
+// state is re-evaluated silently so that only later crossings alert.
+func setBalanceThresholdsHandler(accountRepository *AccountRepository, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		thresholdsInput, ok := bindAndValidate[BalanceThresholdsInput](ctx)
+		if !ok {
+			return
+		}
+
+		targetAccount, err := accountRepository.FindFreshByUsername(ctx.Request.Context(), userName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		if sendErrPreconditionFailed(ctx, config, targetAccount) {
+			return
+		}
+
+		if err := checkAccountOpen(targetAccount); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		if targetAccount.LowBalanceThreshold == thresholdsInput.LowBalanceThreshold &&
+			targetAccount.HighBalanceThreshold == thresholdsInput.HighBalanceThreshold {
+			setAccountETag(ctx, targetAccount)
+			respondNoChange(ctx)
+			return
+		}
+
+		targetAccount.LowBalanceThreshold = thresholdsInput.LowBalanceThreshold
+		targetAccount.HighBalanceThreshold = thresholdsInput.HighBalanceThreshold
+		targetAccount.BalanceAlertState = balanceAlertState(targetAccount)
+		if err := accountRepository.Replace(ctx.Request.Context(), &targetAccount); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		setAccountETag(ctx, targetAccount)
+		respond(ctx, http.StatusOK, targetAccount)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateBalanceAlert(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	account := BankAccount{UserName: "alice", Balance: 100, LowBalanceThreshold: 50, HighBalanceThreshold: 200}
+
+	steps := []struct {
+		balance int
+		event   string
+	}{
+		{balance: 40, event: eventTypeBalanceLow},
+		{balance: 30},
+		{balance: 49},
+		{balance: 50},
+		{balance: 10, event: eventTypeBalanceLow},
+		{balance: 250, event: eventTypeBalanceHigh},
+		{balance: 300},
+		{balance: 200},
+	}
+	for i, step := range steps {
+		account.Balance = step.balance
+		events := updateBalanceAlert(clock, &account)
+		switch {
+		case step.event == "" && len(events) != 0:
+			t.Fatalf("step %d (balance %d): events %+v, want none", i, step.balance, events)
+		case step.event != "" && (len(events) != 1 || events[0].Type != step.event):
+			t.Fatalf("step %d (balance %d): events %+v, want %s", i, step.balance, events, step.event)
+		}
+	}
+}
+
+func TestBalanceAlertEvents(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	recorder := server.request(http.MethodPost, "/account/alice/balance-alerts",
+		BalanceThresholdsInput{LowBalanceThreshold: 50, HighBalanceThreshold: 200})
+	expectStatus(t, recorder, http.StatusOK)
+
+	server.deposit("alice", 100)
+	server.withdraw("alice", 60)
+	server.withdraw("alice", 10)
+	server.deposit("alice", 200)
+	server.transfer("alice", "bob", 100)
+	server.transfer("alice", "bob", 100)
+
+	lowEvents, highEvents := server.events.Events(eventTypeBalanceLow), server.events.Events(eventTypeBalanceHigh)
+	if len(lowEvents) != 2 || len(highEvents) != 1 {
+		t.Fatalf("%d low and %d high alerts, want 2 and 1", len(lowEvents), len(highEvents))
+	}
+	if alert, _ := lowEvents[0].Data.(BalanceAlert); lowEvents[0].UserName != "alice" || alert.Balance != 40 ||
+		alert.Threshold != 50 {
+		t.Fatalf("first low alert = %+v", lowEvents[0])
+	}
+	if alert, _ := lowEvents[1].Data.(BalanceAlert); alert.Balance != 30 {
+		t.Fatalf("low alert after the transfer = %+v", lowEvents[1])
+	}
+	if alert, _ := highEvents[0].Data.(BalanceAlert); alert.Balance != 230 || alert.Threshold != 200 {
+		t.Fatalf("high alert = %+v", highEvents[0])
+	}
+
+	recorder = server.request(http.MethodPost, "/account/alice/balance-alerts",
+		BalanceThresholdsInput{LowBalanceThreshold: 200, HighBalanceThreshold: 100})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidBalanceThresholds")
+}
+
+func TestSetBalanceThresholdsPreconditions(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+		config.RequireIfMatch = true
+	})
+	server.createAccount("alice")
+	thresholds := BalanceThresholdsInput{LowBalanceThreshold: 10, HighBalanceThreshold: 500}
+	target := "/account/alice/balance-alerts"
+
+	recorder := server.request(http.MethodPost, target, thresholds)
+	expectErrorCode(t, recorder, http.StatusPreconditionRequired, "ErrPreconditionRequired")
+	recorder = server.request(http.MethodPost, target, thresholds, "If-Match", `"stale"`)
+	expectErrorCode(t, recorder, http.StatusPreconditionFailed, "ErrPreconditionFailed")
+
+	recorder = server.request(http.MethodPost, "/account/alice/lock",
+		LockAccountInput{Reason: "ledger repair", Operator: "ops-7"}, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPost, target, thresholds, "If-Match", accountETag(server.account("alice")))
+	expectErrorCode(t, recorder, http.StatusLocked, "ErrAccountLocked")
+	if alice := server.account("alice"); alice.LowBalanceThreshold != 0 || alice.HighBalanceThreshold != 0 {
+		t.Fatalf("alice = %+v after refused updates", alice)
+	}
+
+	recorder = server.request(http.MethodDelete, "/account/alice/lock", nil, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPost, target, thresholds, "If-Match", accountETag(server.account("alice")))
+	expectStatus(t, recorder, http.StatusOK)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Debt repayment policies decide what an incoming credit does to an
+// indebted account. An empty policy behaves like debtRepaymentAuto.
+const (
+	debtRepaymentAuto         = "auto"
+	debtRepaymentBalanceFirst = "balance-first"
+)
+
+type ErrInvalidDebtRepaymentPolicy struct {
+	Policy string
+}
+
+func (err *ErrInvalidDebtRepaymentPolicy) Code() string {
+	return "ErrInvalidDebtRepaymentPolicy"
+}
+
+func (err *ErrInvalidDebtRepaymentPolicy) messageArgs() []any {
+	return []any{err.Policy, debtRepaymentAuto, debtRepaymentBalanceFirst}
+}
+
+func (err *ErrInvalidDebtRepaymentPolicy) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func isDebtRepaymentPolicyValid(policy string) bool {
+	return policy == "" || policy == debtRepaymentAuto || policy == debtRepaymentBalanceFirst
+}
+
+func effectiveDebtRepaymentPolicy(policy string) string {
+	if policy == "" {
+		return debtRepaymentAuto
+	}
+	return policy
+}
+
+// allocateDeposit applies an incoming amount debt first: it pays down as
+// much outstanding debt as the amount covers and credits the remainder to
+// the balance.
+func allocateDeposit(balance, debt, amount int) (newBalance, newDebt, appliedToDebt int) {
+	appliedToDebt = min(debt, amount)
+	if appliedToDebt < 0 {
+		appliedToDebt = 0
+	}
+	return balance + amount - appliedToDebt, debt - appliedToDebt, appliedToDebt
+}
+
+// allocateCredit applies an incoming amount under policy: balance-first
+// credits all of it to the balance, anything else pays debt first.
+func allocateCredit(policy string, balance, debt, amount int) (newBalance, newDebt, appliedToDebt int) {
+	if policy == debtRepaymentBalanceFirst {
+		return balance + amount, debt, 0
+	}
+	return allocateDeposit(balance, debt, amount)
+}
+
+// creditAccount applies an incoming amount to the account under its own
+// DebtRepaymentPolicy. Every credit to an account goes through it.
+func creditAccount(account *BankAccount, amount int) (appliedToDebt int) {
+	account.Balance, account.Debt, appliedToDebt = allocateCredit(
+		account.DebtRepaymentPolicy, account.Balance, account.Debt, amount)
+	return appliedToDebt
+}
+
+type DebtRepaymentPolicyInput struct {
+	Policy string `json:"policy"`
+}
+
+func (input *DebtRepaymentPolicyInput) Error() error {
+	var validationErrors MultiError
+	if input.Policy == "" {
+		validationErrors.Add("policy", &ErrRequiredField{Name: "policy"})
+	} else if !isDebtRepaymentPolicyValid(input.Policy) {
+		validationErrors.Add("policy", &ErrInvalidDebtRepaymentPolicy{Policy: input.Policy})
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+// setDebtRepaymentPolicyHandler changes how later credits to the account
+// are split between its debt and its balance.
+func setDebtRepaymentPolicyHandler(accountRepository *AccountRepository, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		policyInput, ok := bindAndValidate[DebtRepaymentPolicyInput](ctx)
+		if !ok {
+			return
+		}
+
+		targetAccount, err := accountRepository.FindFreshByUsername(ctx.Request.Context(), userName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		if sendErrPreconditionFailed(ctx, config, targetAccount) {
+			return
+		}
+
+		if err := checkAccountOpen(targetAccount); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		if effectiveDebtRepaymentPolicy(targetAccount.DebtRepaymentPolicy) == policyInput.Policy {
+			setAccountETag(ctx, targetAccount)
+			respondNoChange(ctx)
+			return
+		}
+
+		targetAccount.DebtRepaymentPolicy = policyInput.Policy
+		if err := accountRepository.Replace(ctx.Request.Context(), &targetAccount); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		setAccountETag(ctx, targetAccount)
+		respond(ctx, http.StatusOK, targetAccount)
+	}
+}
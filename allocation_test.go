@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAllocateDeposit(t *testing.T) {
+	tests := []struct {
+		name              string
+		balance, debt     int
+		amount            int
+		wantBalance       int
+		wantDebt          int
+		wantAppliedToDebt int
+	}{
+		{name: "zero amount, no debt", balance: 10, wantBalance: 10},
+		{name: "zero amount with debt", debt: 30, wantDebt: 30},
+		{name: "no debt", balance: 10, amount: 25, wantBalance: 35},
+		{name: "less than the debt", debt: 30, amount: 20, wantDebt: 10, wantAppliedToDebt: 20},
+		{name: "equal to the debt", debt: 30, amount: 30, wantAppliedToDebt: 30},
+		{name: "more than the debt", debt: 30, amount: 50, wantBalance: 20, wantAppliedToDebt: 30},
+		{name: "one more than the debt", debt: 30, amount: 31, wantBalance: 1, wantAppliedToDebt: 30},
+		{name: "one less than the debt", debt: 30, amount: 29, wantDebt: 1, wantAppliedToDebt: 29},
+		{name: "balance and debt both set", balance: 5, debt: 30, amount: 40, wantBalance: 15,
+			wantAppliedToDebt: 30},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			balance, debt, appliedToDebt := allocateDeposit(test.balance, test.debt, test.amount)
+			if balance != test.wantBalance || debt != test.wantDebt || appliedToDebt != test.wantAppliedToDebt {
+				t.Fatalf("allocateDeposit(%d, %d, %d) = %d, %d, %d; want %d, %d, %d",
+					test.balance, test.debt, test.amount, balance, debt, appliedToDebt,
+					test.wantBalance, test.wantDebt, test.wantAppliedToDebt)
+			}
+			if balance+appliedToDebt != test.balance+test.amount {
+				t.Fatalf("allocateDeposit lost part of the amount: %d + %d != %d + %d",
+					balance, appliedToDebt, test.balance, test.amount)
+			}
+		})
+	}
+}
+
+func TestAllocateCredit(t *testing.T) {
+	tests := []struct {
+		policy            string
+		wantBalance       int
+		wantDebt          int
+		wantAppliedToDebt int
+	}{
+		{policy: "", wantBalance: 20, wantAppliedToDebt: 30},
+		{policy: debtRepaymentAuto, wantBalance: 20, wantAppliedToDebt: 30},
+		{policy: debtRepaymentBalanceFirst, wantBalance: 50, wantDebt: 30},
+	}
+	for _, test := range tests {
+		balance, debt, appliedToDebt := allocateCredit(test.policy, 0, 30, 50)
+		if balance != test.wantBalance || debt != test.wantDebt || appliedToDebt != test.wantAppliedToDebt {
+			t.Errorf("policy %q: %d, %d, %d; want %d, %d, %d", test.policy, balance, debt, appliedToDebt,
+				test.wantBalance, test.wantDebt, test.wantAppliedToDebt)
+		}
+	}
+}
+
+func TestDebtRepaymentPolicy(t *testing.T) {
+	tests := []struct {
+		policy          string
+		policyStatus    int
+		depositBalance  int
+		depositDebt     int
+		transferBalance int
+		transferDebt    int
+	}{
+		{policy: debtRepaymentAuto, policyStatus: http.StatusNoContent,
+			depositDebt: 10, transferBalance: 30},
+		{policy: debtRepaymentBalanceFirst, policyStatus: http.StatusOK,
+			depositBalance: 20, depositDebt: 30, transferBalance: 60, transferDebt: 30},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			server := newTestServer(t)
+			server.createAccount("alice")
+			server.createAccount("bob")
+			server.deposit("bob", 100)
+			server.withdraw("alice", 30)
+
+			recorder := server.request(http.MethodPost, "/account/alice/debt-repayment-policy",
+				DebtRepaymentPolicyInput{Policy: test.policy})
+			expectStatus(t, recorder, test.policyStatus)
+
+			server.deposit("alice", 20)
+			if account := server.account("alice"); account.Balance != test.depositBalance ||
+				account.Debt != test.depositDebt {
+				t.Fatalf("after deposit: %+v, want balance %d and debt %d",
+					account, test.depositBalance, test.depositDebt)
+			}
+
+			server.transfer("bob", "alice", 40)
+			if account := server.account("alice"); account.Balance != test.transferBalance ||
+				account.Debt != test.transferDebt {
+				t.Fatalf("after transfer: %+v, want balance %d and debt %d",
+					account, test.transferBalance, test.transferDebt)
+			}
+		})
+	}
+}
+
+func TestSetDebtRepaymentPolicyPreconditions(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+		config.RequireIfMatch = true
+	})
+	server.createAccount("alice")
+	policy := DebtRepaymentPolicyInput{Policy: debtRepaymentBalanceFirst}
+	target := "/account/alice/debt-repayment-policy"
+
+	recorder := server.request(http.MethodPost, target, policy)
+	expectErrorCode(t, recorder, http.StatusPreconditionRequired, "ErrPreconditionRequired")
+	recorder = server.request(http.MethodPost, target, policy, "If-Match", `"stale"`)
+	expectErrorCode(t, recorder, http.StatusPreconditionFailed, "ErrPreconditionFailed")
+
+	recorder = server.request(http.MethodPost, "/account/alice/lock",
+		LockAccountInput{Reason: "ledger repair", Operator: "ops-7"}, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPost, target, policy, "If-Match", accountETag(server.account("alice")))
+	expectErrorCode(t, recorder, http.StatusLocked, "ErrAccountLocked")
+	if alice := server.account("alice"); alice.DebtRepaymentPolicy != "" {
+		t.Fatalf("policy = %q after refused updates", alice.DebtRepaymentPolicy)
+	}
+
+	recorder = server.request(http.MethodDelete, "/account/alice/lock", nil, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPost, target, policy, "If-Match", accountETag(server.account("alice")))
+	expectStatus(t, recorder, http.StatusOK)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxBatchSize bounds batches whose items are processed one by one.
+const maxBatchSize = 100
+
+type ErrBatchSize struct {
+	Max int
+}
+
+func (err *ErrBatchSize) Code() string {
+	return "ErrBatchSize"
+}
+
+func (err *ErrBatchSize) messageArgs() []any {
+	return []any{err.Max}
+}
+
+func (err *ErrBatchSize) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+// MultiStatusItem is the outcome of one batch item, in input order.
+type MultiStatusItem struct {
+	Index  int                 `json:"index"`
+	Status int                 `json:"status"`
+	Data   any                 `json:"data,omitempty"`
+	Error  string              `json:"error,omitempty"`
+	Code   string              `json:"code,omitempty"`
+	Errors []FieldErrorMessage `json:"errors,omitempty"`
+}
+
+// MultiStatusResponse is answered with 207 by batch endpoints whose items
+// succeed or fail independently.
+type MultiStatusResponse struct {
+	Items     []MultiStatusItem `json:"items"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+}
+
+func (response *MultiStatusResponse) addSuccess(status int, data any) {
+	response.Items = append(response.Items, MultiStatusItem{
+		Index:  len(response.Items),
+		Status: status,
+		Data:   data,
+	})
+	response.Succeeded++
+}
+
+func (response *MultiStatusResponse) addFailure(language string, err error) {
+	message, code, fieldMessages := describeError(language, err)
+	response.Items = append(response.Items, MultiStatusItem{
+		Index:  len(response.Items),
+		Status: errorStatus(err),
+		Error:  message,
+		Code:   code,
+		Errors: fieldMessages,
+	})
+	response.Failed++
+}
+
+type CreateAccountsBatchInput struct {
+	Accounts []BankAccount `json:"accounts"`
+}
+
+func (input *CreateAccountsBatchInput) Error() error {
+	if len(input.Accounts) == 0 || len(input.Accounts) > maxBatchSize {
+		return &ErrBatchSize{Max: maxBatchSize}
+	}
+	return nil
+}
+
+func (input *CreateAccountsBatchInput) normalizeUsernames() {
+	for i := range input.Accounts {
+		input.Accounts[i].normalizeUsernames()
+	}
+}
+
+func createAccountsBatchHandler(
+	accountRepository *AccountRepository, transactionCollection *mongo.Collection, config *Config,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		batchInput, ok := bindAndValidate[CreateAccountsBatchInput](ctx)
+		if !ok {
+			return
+		}
+
+		language := requestLanguage(ctx)
+		upsert := queryFlag(ctx, "upsert")
+		batchResponse := MultiStatusResponse{Items: []MultiStatusItem{}}
+		for _, newAccount := range batchInput.Accounts {
+			if err := newAccount.Error(); err != nil {
+				batchResponse.addFailure(language, err)
+				continue
+			}
+			storedAccount, status, err := createAccount(ctx.Request.Context(), accountRepository,
+				transactionCollection, config, newAccount, upsert)
+			if err != nil {
+				batchResponse.addFailure(language, err)
+				continue
+			}
+			batchResponse.addSuccess(status, storedAccount)
+		}
+
+		respond(ctx, http.StatusMultiStatus, batchResponse)
+	}
+}
+
+type TransferBatchInput struct {
+	Transfers []TransferNote `json:"transfers"`
+}
+
+func (input *TransferBatchInput) Error() error {
+	if len(input.Transfers) == 0 || len(input.Transfers) > maxBatchSize {
+		return &ErrBatchSize{Max: maxBatchSize}
+	}
+	return nil
+}
+
+func (input *TransferBatchInput) normalizeUsernames() {
+	for i := range input.Transfers {
+		input.Transfers[i].normalizeUsernames()
+	}
+}
+
+// transferBatchHandler runs each transfer in its own transaction, in input
+// order, so one failing item does not undo the others.
+func transferBatchHandler(
+	accountRepository *AccountRepository, transactionCollection, executedCollection *mongo.Collection,
+	config *Config, publisher EventPublisher,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		batchInput, ok := bindAndValidate[TransferBatchInput](ctx)
+		if !ok {
+			return
+		}
+
+		language := requestLanguage(ctx)
+		batchResponse := MultiStatusResponse{Items: []MultiStatusItem{}}
+		for _, transferNote := range batchInput.Transfers {
+			if err := transferNote.Error(); err != nil {
+				batchResponse.addFailure(language, err)
+				continue
+			}
+			transferResult, _, err := executeTransferOnce(ctx.Request.Context(), accountRepository,
+				transactionCollection, executedCollection, config, publisher, transferNote,
+				transferOptions{strict: isStrictRequest(ctx)})
+			if err != nil {
+				batchResponse.addFailure(language, err)
+				continue
+			}
+			batchResponse.addSuccess(http.StatusOK, transferResult.Accounts)
+		}
+
+		respond(ctx, http.StatusMultiStatus, batchResponse)
+	}
+}
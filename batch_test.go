@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+type expectedItem struct {
+	status int
+	code   string
+}
+
+func expectMultiStatus(t *testing.T, batch MultiStatusResponse, want []expectedItem) {
+	t.Helper()
+	if len(batch.Items) != len(want) {
+		t.Fatalf("batch has %d items, want %d: %+v", len(batch.Items), len(want), batch)
+	}
+	succeeded := 0
+	for i, item := range batch.Items {
+		if item.Index != i || item.Status != want[i].status || item.Code != want[i].code {
+			t.Errorf("item %d = %+v, want status %d code %q", i, item, want[i].status, want[i].code)
+		}
+		if want[i].code == "" {
+			succeeded++
+			if item.Data == nil {
+				t.Errorf("item %d succeeded without data", i)
+			}
+		} else if item.Error == "" {
+			t.Errorf("item %d failed without a message", i)
+		}
+	}
+	if batch.Succeeded != succeeded || batch.Failed != len(want)-succeeded {
+		t.Errorf("succeeded %d, failed %d, want %d and %d", batch.Succeeded, batch.Failed,
+			succeeded, len(want)-succeeded)
+	}
+}
+
+func TestCreateAccountsBatch(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("bob")
+
+	recorder := server.request(http.MethodPost, "/account/create/batch", CreateAccountsBatchInput{
+		Accounts: []BankAccount{{UserName: "alice"}, {UserName: "no spaces"}, {UserName: "bob"}, {UserName: "carol"}},
+	})
+	expectStatus(t, recorder, http.StatusMultiStatus)
+	expectMultiStatus(t, decodeResponse[MultiStatusResponse](t, recorder), []expectedItem{
+		{status: http.StatusCreated},
+		{status: http.StatusBadRequest, code: "ErrUsername"},
+		{status: http.StatusBadRequest, code: "ErrUserAlreadyExist"},
+		{status: http.StatusCreated},
+	})
+	server.account("alice")
+	server.account("carol")
+
+	recorder = server.request(http.MethodPost, "/account/create/batch", CreateAccountsBatchInput{})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrBatchSize")
+}
+
+func TestTransferBatch(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	recorder := server.request(http.MethodPost, "/transfer/batch?strict=true", TransferBatchInput{
+		Transfers: []TransferNote{
+			{FromUser: "alice", ToUser: "bob", Amount: 60},
+			{FromUser: "alice", ToUser: "alice", Amount: 10},
+			{FromUser: "alice", ToUser: "bob", Amount: 60},
+			{FromUser: "bob", ToUser: "alice", Amount: 20},
+		},
+	})
+	expectStatus(t, recorder, http.StatusMultiStatus)
+	expectMultiStatus(t, decodeResponse[MultiStatusResponse](t, recorder), []expectedItem{
+		{status: http.StatusOK},
+		{status: http.StatusBadRequest, code: "ErrSameSourceAndTarget"},
+		{status: http.StatusBadRequest, code: "ErrInsufficientFunds"},
+		{status: http.StatusOK},
+	})
+	if alice, bob := server.account("alice"), server.account("bob"); alice.Balance != 60 || bob.Balance != 40 {
+		t.Fatalf("balances alice %d, bob %d, want 60 and 40", alice.Balance, bob.Balance)
+	}
+}
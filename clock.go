@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const clockKey = "clock"
+
+// Clock tells the current time. Time-based features read it from
+// Config.Clock instead of calling time.Now, so they can be driven by a
+// controlled clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the wall clock used outside tests.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockMiddleware hands Config.Clock to the response helpers, which only see
+// the request context.
+func clockMiddleware(config *Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(clockKey, config.Clock)
+		ctx.Next()
+	}
+}
+
+// requestClock is the clock set by clockMiddleware, or the system clock for
+// requests that did not pass through it.
+func requestClock(ctx *gin.Context) Clock {
+	if clock, ok := ctx.Value(clockKey).(Clock); ok {
+		return clock
+	}
+	return systemClock{}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondUsesConfigClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	router := gin.New()
+	router.Use(clockMiddleware(&Config{Clock: clock}))
+	router.GET("/", func(ctx *gin.Context) {
+		respond(ctx, http.StatusOK, "ok")
+	})
+
+	recorder := serveRequest(t, router, http.MethodGet, "/", nil, apiVersionHeader, strconv.Itoa(envelopeAPIVersion))
+	expectStatus(t, recorder, http.StatusOK)
+	if envelope := decodeResponse[ResponseEnvelope](t, recorder); !envelope.Timestamp.Equal(clock.Now()) {
+		t.Fatalf("envelope timestamp = %s, want %s", envelope.Timestamp, clock.Now())
+	}
+}
+
+func TestDailyPenaltyResetsAtMidnight(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.PenaltyRate = 0.1
+	})
+	server.createAccount("alice")
+	server.withdraw("alice", 100)
+	ctx := context.Background()
+
+	applyPenalties := func() int {
+		t.Helper()
+		penalizedCount, err := applyDebtPenalties(ctx, server.accounts, server.transactions, server.config,
+			server.clock.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return penalizedCount
+	}
+
+	if penalizedCount := applyPenalties(); penalizedCount != 1 {
+		t.Fatalf("first run penalized %d accounts, want 1", penalizedCount)
+	}
+	// 23:59 on the same day: the day's penalty was already charged.
+	server.clock.Advance(11*time.Hour + 59*time.Minute)
+	if penalizedCount := applyPenalties(); penalizedCount != 0 {
+		t.Fatalf("same-day run penalized %d accounts, want 0", penalizedCount)
+	}
+	server.clock.Advance(2 * time.Minute)
+	if penalizedCount := applyPenalties(); penalizedCount != 1 {
+		t.Fatalf("run after midnight penalized %d accounts, want 1", penalizedCount)
+	}
+
+	if account := server.account("alice"); account.Debt != 121 {
+		t.Fatalf("debt = %d, want 121 after two daily penalties", account.Debt)
+	}
+	history := server.history("alice")
+	if last := history[len(history)-1]; last.Type != transactionTypePenalty || !last.CreatedAt.Equal(server.clock.Now()) {
+		t.Fatalf("last entry = %+v, want a penalty stamped by the fake clock", last)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ErrAccountClosed struct {
+	UserName string
+}
+
+func (err *ErrAccountClosed) Code() string {
+	return "ErrAccountClosed"
+}
+
+func (err *ErrAccountClosed) messageArgs() []any {
+	return []any{err.UserName}
+}
+
+func (err *ErrAccountClosed) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrOutstandingDebt struct {
+	UserName string
+	Debt     int
+}
+
+func (err *ErrOutstandingDebt) Code() string {
+	return "ErrOutstandingDebt"
+}
+
+func (err *ErrOutstandingDebt) messageArgs() []any {
+	return []any{err.UserName, err.Debt}
+}
+
+func (err *ErrOutstandingDebt) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+// checkAccountOpen rejects mutations on accounts that are closed, frozen
+// or under a maintenance lock.
+func checkAccountOpen(account BankAccount) error {
+	if account.Closed {
+		return &ErrAccountClosed{UserName: account.UserName}
+	}
+	if account.Frozen {
+		return &ErrAccountFrozen{UserName: account.UserName}
+	}
+	if account.Locked {
+		return &ErrAccountLocked{UserName: account.UserName, Reason: account.LockReason}
+	}
+	return nil
+}
+
+// closureBlockers lists every reason the account cannot be closed, in the
+// order closeWithTransferHandler reports them.
+func closureBlockers(account BankAccount) []catalogError {
+	var blockers []catalogError
+	if account.Closed {
+		blockers = append(blockers, &ErrAccountClosed{UserName: account.UserName})
+	}
+	if account.Frozen {
+		blockers = append(blockers, &ErrAccountFrozen{UserName: account.UserName})
+	}
+	if account.Locked {
+		blockers = append(blockers, &ErrAccountLocked{UserName: account.UserName, Reason: account.LockReason})
+	}
+	if account.Debt > 0 {
+		blockers = append(blockers, &ErrOutstandingDebt{UserName: account.UserName, Debt: account.Debt})
+	}
+	if account.Held > 0 {
+		blockers = append(blockers, &ErrFundsOnHold{UserName: account.UserName, Held: account.Held})
+	}
+	if account.Balance < 0 {
+		blockers = append(blockers, &ErrNegativeBalance{UserName: account.UserName, Balance: account.Balance})
+	}
+	return blockers
+}
+
+type ClosureBlocker struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ClosePreview struct {
+	UserName        string           `json:"username"`
+	Eligible        bool             `json:"eligible"`
+	Disbursement    int              `json:"disbursement"`
+	BlockingReasons []ClosureBlocker `json:"blockingReasons"`
+}
+
+// closePreviewHandler reports whether the account could be closed right now
+// and how much would be paid out to the beneficiary, without closing it.
+func closePreviewHandler(accountRepository *AccountRepository) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		account, err := accountRepository.FindFreshByUsername(ctx.Request.Context(), userName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		preview := ClosePreview{
+			UserName:        account.UserName,
+			Disbursement:    account.Balance,
+			BlockingReasons: []ClosureBlocker{},
+		}
+		language := requestLanguage(ctx)
+		for _, blocker := range closureBlockers(account) {
+			preview.BlockingReasons = append(preview.BlockingReasons, ClosureBlocker{
+				Code:    blocker.Code(),
+				Message: localizeError(language, blocker),
+			})
+		}
+		preview.Eligible = len(preview.BlockingReasons) == 0
+		respond(ctx, http.StatusOK, preview)
+	}
+}
+
+type CloseWithTransferInput struct {
+	UserName    string `json:"username"`
+	Beneficiary string `json:"beneficiary"`
+}
+
+func (input *CloseWithTransferInput) Error() error {
+	if !isUsernameValid(input.UserName) {
+		return &ErrInvalidUsername{UserName: input.UserName}
+	}
+	if !isUsernameValid(input.Beneficiary) {
+		return &ErrInvalidUsername{UserName: input.Beneficiary}
+	}
+	if input.UserName == input.Beneficiary {
+		return &ErrSameSourceAndTarget{}
+	}
+	return nil
+}
+
+func (input *CloseWithTransferInput) normalizeUsernames() {
+	input.UserName = normalizeUsername(input.UserName)
+	input.Beneficiary = normalizeUsername(input.Beneficiary)
+}
+
+func findAccountInSession(
+	sessionCtx mongo.SessionContext, accountCollection *mongo.Collection, userName string,
+) (BankAccount, error) {
+	var account BankAccount
+	err := accountCollection.FindOne(sessionCtx, bson.D{{Key: "username", Value: userName}}).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return account, &ErrUserNotFound{UserName: userName}
+	}
+	return account, err
+}
+
+// findAccountPairInSession reads both accounts in username order, so
+// transactions touching the same pair always reach the documents in the
+// same sequence whichever direction the money moves.
+func findAccountPairInSession(
+	sessionCtx mongo.SessionContext, accountCollection *mongo.Collection, firstUser, secondUser string,
+) (BankAccount, BankAccount, error) {
+	if firstUser > secondUser {
+		secondAccount, firstAccount, err := findAccountPairInSession(sessionCtx, accountCollection, secondUser, firstUser)
+		return firstAccount, secondAccount, err
+	}
+	firstAccount, err := findAccountInSession(sessionCtx, accountCollection, firstUser)
+	if err != nil {
+		return firstAccount, BankAccount{}, err
+	}
+	secondAccount, err := findAccountInSession(sessionCtx, accountCollection, secondUser)
+	return firstAccount, secondAccount, err
+}
+
+// replaceInOrder writes the accounts sorted by username for the same reason
+// findAccountPairInSession reads them that way.
+func replaceInOrder(
+	sessionCtx mongo.SessionContext, accountRepository *AccountRepository, accounts ...*BankAccount,
+) error {
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].UserName < accounts[j].UserName
+	})
+	for _, account := range accounts {
+		if err := accountRepository.Replace(sessionCtx, account); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func closeWithTransferHandler(
+	accountRepository *AccountRepository, transactionCollection *mongo.Collection, config *Config,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		closeInput, ok := bindAndValidate[CloseWithTransferInput](ctx)
+		if !ok {
+			return
+		}
+
+		accountCollection := accountRepository.Collection()
+		finalAccounts, err := runInTransaction(ctx.Request.Context(), accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+			closingAccount, beneficiaryAccount, err := findAccountPairInSession(
+				sessionCtx, accountCollection, closeInput.UserName, closeInput.Beneficiary)
+			if err != nil {
+				return nil, err
+			}
+			if blockers := closureBlockers(closingAccount); len(blockers) > 0 {
+				return nil, blockers[0]
+			}
+			if err := checkAccountOpen(beneficiaryAccount); err != nil {
+				return nil, err
+			}
+
+			disbursedAmount := closingAccount.Balance
+			creditAccount(&beneficiaryAccount, disbursedAmount)
+			closingAccount.Balance = 0
+			closingAccount.Closed = true
+
+			if err := replaceInOrder(sessionCtx, accountRepository, &closingAccount, &beneficiaryAccount); err != nil {
+				return nil, err
+			}
+
+			if disbursedAmount > 0 {
+				debitTransaction := newTransaction(config.Clock, closingAccount, transactionTypeTransferOut, disbursedAmount)
+				debitTransaction.Counterparty = beneficiaryAccount.UserName
+				if _, err := insertTransaction(sessionCtx, transactionCollection, debitTransaction); err != nil {
+					return nil, err
+				}
+				creditTransaction := newTransaction(config.Clock, beneficiaryAccount, transactionTypeTransferIn, disbursedAmount)
+				creditTransaction.Counterparty = closingAccount.UserName
+				if _, err := insertTransaction(sessionCtx, transactionCollection, creditTransaction); err != nil {
+					return nil, err
+				}
+			}
+
+			return []BankAccount{closingAccount, beneficiaryAccount}, nil
+		})
+		accountRepository.Invalidate(closeInput.UserName, closeInput.Beneficiary)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		retainHistory(transactionCollection, config, closeInput.UserName, closeInput.Beneficiary)
+
+		respond(ctx, http.StatusOK, finalAccounts)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClosureBlockers(t *testing.T) {
+	tests := []struct {
+		name    string
+		account BankAccount
+		codes   string
+	}{
+		{name: "eligible", account: BankAccount{Balance: 10}},
+		{name: "debt", account: BankAccount{Debt: 5}, codes: "ErrOutstandingDebt"},
+		{name: "held", account: BankAccount{Balance: 10, Held: 10}, codes: "ErrFundsOnHold"},
+		{name: "frozen with debt", account: BankAccount{Frozen: true, Debt: 5},
+			codes: "ErrAccountFrozen,ErrOutstandingDebt"},
+		{name: "already closed", account: BankAccount{Closed: true}, codes: "ErrAccountClosed"},
+	}
+	for _, test := range tests {
+		var codes []string
+		for _, blocker := range closureBlockers(test.account) {
+			codes = append(codes, blocker.Code())
+		}
+		if joined := strings.Join(codes, ","); joined != test.codes {
+			t.Errorf("%s: blockers = %q, want %q", test.name, joined, test.codes)
+		}
+	}
+}
+
+func TestCloseWithTransfer(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.createAccount("carol")
+	server.deposit("alice", 70)
+	server.deposit("bob", 5)
+	server.withdraw("carol", 20)
+
+	recorder := server.request(http.MethodPost, "/account/close-with-transfer",
+		CloseWithTransferInput{UserName: "alice", Beneficiary: "bob"})
+	expectStatus(t, recorder, http.StatusOK)
+	finalAccounts := decodeResponse[[]BankAccount](t, recorder)
+	if len(finalAccounts) != 2 || finalAccounts[0].UserName != "alice" || !finalAccounts[0].Closed ||
+		finalAccounts[0].Balance != 0 || finalAccounts[1].UserName != "bob" || finalAccounts[1].Balance != 75 {
+		t.Fatalf("final accounts = %+v", finalAccounts)
+	}
+	if alice, bob := server.account("alice"), server.account("bob"); !alice.Closed || alice.Balance != 0 ||
+		bob.Balance != 75 {
+		t.Fatalf("stored accounts: alice %+v, bob %+v", alice, bob)
+	}
+	if history := server.history("alice"); history[len(history)-1].Type != transactionTypeTransferOut ||
+		history[len(history)-1].Amount != 70 || history[len(history)-1].Counterparty != "bob" {
+		t.Fatalf("alice history = %+v", history)
+	}
+
+	recorder = server.request(http.MethodPost, "/account/close-with-transfer",
+		CloseWithTransferInput{UserName: "carol", Beneficiary: "bob"})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrOutstandingDebt")
+	if carol, bob := server.account("carol"), server.account("bob"); carol.Closed || carol.Debt != 20 ||
+		bob.Balance != 75 {
+		t.Fatalf("after refused closure: carol %+v, bob %+v", carol, bob)
+	}
+
+	// A closed account can no longer receive the balance of another.
+	recorder = server.request(http.MethodPost, "/account/close-with-transfer",
+		CloseWithTransferInput{UserName: "bob", Beneficiary: "alice"})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrAccountClosed")
+}
+
+func TestClosePreview(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	for _, userName := range []string{"alice", "bob", "carol"} {
+		server.createAccount(userName)
+	}
+	server.deposit("alice", 70)
+	server.withdraw("bob", 20)
+	server.deposit("carol", 15)
+	recorder := server.request(http.MethodPost, "/account/carol/lock",
+		LockAccountInput{Reason: "ledger repair", Operator: "ops-7"}, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+
+	tests := []struct {
+		userName     string
+		eligible     bool
+		disbursement int
+		codes        string
+	}{
+		{userName: "alice", eligible: true, disbursement: 70},
+		{userName: "bob", codes: "ErrOutstandingDebt"},
+		{userName: "carol", disbursement: 15, codes: "ErrAccountLocked"},
+	}
+	for _, test := range tests {
+		recorder := server.request(http.MethodGet, "/account/"+test.userName+"/close-preview", nil)
+		expectStatus(t, recorder, http.StatusOK)
+		preview := decodeResponse[ClosePreview](t, recorder)
+		var codes []string
+		for _, blocker := range preview.BlockingReasons {
+			if blocker.Message == "" {
+				t.Errorf("%s: blocker %s has no message", test.userName, blocker.Code)
+			}
+			codes = append(codes, blocker.Code)
+		}
+		if preview.UserName != test.userName || preview.Eligible != test.eligible ||
+			preview.Disbursement != test.disbursement || strings.Join(codes, ",") != test.codes {
+			t.Errorf("%s: preview = %+v, want eligible %v, disbursement %d, blockers %q",
+				test.userName, preview, test.eligible, test.disbursement, test.codes)
+		}
+		if account := server.account(test.userName); account.Closed {
+			t.Errorf("%s: preview closed the account", test.userName)
+		}
+	}
+
+	recorder = server.request(http.MethodGet, "/account/ghost/close-preview", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrNoDocuments")
+}
+
+// Opposite closures of the same pair read and write the accounts in the
+// same order, so one of them wins and the other finds its account closed
+// instead of failing on a write conflict.
+func TestConcurrentOppositeClosures(t *testing.T) {
+	server := newTestServer(t)
+	const pairs = 10
+	inputs := make([]CloseWithTransferInput, 0, 2*pairs)
+	for i := 0; i < pairs; i++ {
+		first, second := fmt.Sprintf("alice%d", i), fmt.Sprintf("bob%d", i)
+		server.createAccount(first)
+		server.createAccount(second)
+		server.deposit(first, 30)
+		server.deposit(second, 20)
+		inputs = append(inputs,
+			CloseWithTransferInput{UserName: first, Beneficiary: second},
+			CloseWithTransferInput{UserName: second, Beneficiary: first})
+	}
+
+	recorders := make([]*httptest.ResponseRecorder, len(inputs))
+	var wait sync.WaitGroup
+	for i := range inputs {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			recorders[i] = server.request(http.MethodPost, "/account/close-with-transfer", inputs[i])
+		}(i)
+	}
+	wait.Wait()
+
+	for i := 0; i < len(inputs); i += 2 {
+		first, second := recorders[i], recorders[i+1]
+		winner, loser := first, second
+		if second.Code == http.StatusOK {
+			winner, loser = second, first
+		}
+		if winner.Code != http.StatusOK {
+			t.Fatalf("pair %d: neither closure succeeded: %s, %s", i/2, first.Body.String(), second.Body.String())
+		}
+		expectErrorCode(t, loser, http.StatusBadRequest, "ErrAccountClosed")
+
+		closing, beneficiary := server.account(inputs[i].UserName), server.account(inputs[i].Beneficiary)
+		if winner == second {
+			closing, beneficiary = beneficiary, closing
+		}
+		if !closing.Closed || closing.Balance != 0 || beneficiary.Closed || beneficiary.Balance != 50 {
+			t.Fatalf("pair %d: closed %+v, beneficiary %+v", i/2, closing, beneficiary)
+		}
+	}
+}
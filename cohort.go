@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// getAccountsByCreationHandler lists the accounts created between ?from=
+// and ?to=, both inclusive and either optional, oldest first and paginated
+// with ?page= and ?limit=. Accounts created before CreatedAt was recorded
+// have none and are never listed.
+func getAccountsByCreationHandler(accountCollection *mongo.Collection, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		from, err := parseTimeQuery(ctx, "from")
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		to, err := parseTimeQuery(ctx, "to")
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if from != nil && to != nil && from.After(*to) {
+			sendError(ctx, &ErrInvalidDateRange{From: *from, To: *to})
+			return
+		}
+		pagination, err := parsePagination(ctx, int64(config.MaxPageSize))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		fields, err := parseAccountFields(ctx, config)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		createdAtRange := bson.D{{Key: "$type", Value: "date"}}
+		if from != nil {
+			createdAtRange = append(createdAtRange, bson.E{Key: "$gte", Value: from.UTC()})
+		}
+		if to != nil {
+			createdAtRange = append(createdAtRange, bson.E{Key: "$lte", Value: to.UTC()})
+		}
+		cohortFilter := bson.D{{Key: "createdat", Value: createdAtRange}}
+		total, err := accountCollection.CountDocuments(ctx.Request.Context(), cohortFilter)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		findOptions := options.Find().
+			SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "username", Value: 1}}).
+			SetSkip(pagination.Skip()).
+			SetLimit(pagination.Limit)
+		if projection := accountProjection(fields); projection != nil {
+			findOptions.SetProjection(projection)
+		}
+		cohortSearchResult, err := accountCollection.Find(ctx.Request.Context(), cohortFilter, findOptions)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		cohortList := []BankAccount{}
+		if err := cohortSearchResult.All(ctx.Request.Context(), &cohortList); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		respond(ctx, http.StatusOK, AccountPage{
+			Accounts:   cohortList,
+			Total:      total,
+			Pagination: pagination,
+		})
+	}
+}
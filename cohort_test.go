@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAccountsByCreation(t *testing.T) {
+	server := newTestServer(t)
+	start := server.clock.Now()
+	for _, userName := range []string{"alice", "bob", "carol", "dave"} {
+		server.createAccount(userName)
+		server.clock.Advance(time.Hour)
+	}
+	// An account from before CreatedAt was recorded.
+	if _, err := server.accounts.Collection().InsertOne(context.Background(),
+		bson.D{{Key: "username", Value: "legacy"}, {Key: "balance", Value: 0}}); err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(offset time.Duration) string {
+		return start.Add(offset).Format(time.RFC3339)
+	}
+	for _, test := range []struct {
+		query string
+		total int64
+		want  string
+	}{
+		{query: "", total: 4, want: "alice,bob,carol,dave"},
+		{query: "?from=" + at(time.Hour) + "&to=" + at(2*time.Hour), total: 2, want: "bob,carol"},
+		{query: "?from=" + at(time.Hour+time.Second) + "&to=" + at(2*time.Hour), total: 1, want: "carol"},
+		{query: "?from=" + at(time.Hour) + "&to=" + at(2*time.Hour-time.Second), total: 1, want: "bob"},
+		{query: "?from=" + at(3*time.Hour), total: 1, want: "dave"},
+		{query: "?to=" + at(0), total: 1, want: "alice"},
+		{query: "?to=" + at(-time.Second), total: 0, want: ""},
+		{query: "?page=2&limit=3", total: 4, want: "dave"},
+	} {
+		recorder := server.request(http.MethodGet, "/account/created"+test.query, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		page := decodeResponse[AccountPage](t, recorder)
+		var userNames []string
+		for _, account := range page.Accounts {
+			userNames = append(userNames, account.UserName)
+		}
+		if listed := strings.Join(userNames, ","); page.Total != test.total || listed != test.want {
+			t.Errorf("%q: listed %q of %d, want %q of %d", test.query, listed, page.Total, test.want, test.total)
+		}
+	}
+
+	recorder := server.request(http.MethodGet, "/account/created?from="+at(2*time.Hour)+"&to="+at(time.Hour), nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidDateRange")
+	recorder = server.request(http.MethodGet, "/account/created?from=last-week", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidQueryParam")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// envReadPreference parses READ_PREFERENCE-style values such as "primary",
+// "secondaryPreferred" or "nearest".
+func envReadPreference(name string) (*readpref.ReadPref, error) {
+	rawValue := envString(name, "primary")
+	mode, err := readpref.ModeFromString(rawValue)
+	if err != nil {
+		return nil, &ErrInvalidConfig{Name: name, Value: rawValue}
+	}
+	readPreference, err := readpref.New(mode)
+	if err != nil {
+		return nil, &ErrInvalidConfig{Name: name, Value: rawValue}
+	}
+	return readPreference, nil
+}
+
+// readCollection returns a handle on the same collection that reads with
+// the configured read preference. It is meant for list and aggregate
+// handlers only; writes and single-account reads keep using the primary.
+func readCollection(collection *mongo.Collection, config *Config) *mongo.Collection {
+	if config.ReadPreference == nil {
+		return collection
+	}
+	return collection.Database().Collection(collection.Name(),
+		options.Collection().SetReadPreference(config.ReadPreference))
+}
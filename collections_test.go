@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestEnvReadPreference(t *testing.T) {
+	tests := []struct {
+		value string
+		mode  readpref.Mode
+		valid bool
+	}{
+		{value: "", mode: readpref.PrimaryMode, valid: true},
+		{value: "primary", mode: readpref.PrimaryMode, valid: true},
+		{value: "secondaryPreferred", mode: readpref.SecondaryPreferredMode, valid: true},
+		{value: "nearest", mode: readpref.NearestMode, valid: true},
+		{value: "fastest"},
+	}
+	for _, test := range tests {
+		t.Setenv("READ_PREFERENCE", test.value)
+		readPreference, err := envReadPreference("READ_PREFERENCE")
+		var configError *ErrInvalidConfig
+		switch {
+		case test.valid && (err != nil || readPreference.Mode() != test.mode):
+			t.Errorf("READ_PREFERENCE=%q: %v, %v, want mode %v", test.value, readPreference, err, test.mode)
+		case !test.valid && !errors.As(err, &configError):
+			t.Errorf("READ_PREFERENCE=%q: error = %v, want ErrInvalidConfig", test.value, err)
+		}
+	}
+}
+
+// The test replica set has no secondary, so a list endpoint that honours a
+// secondary-only preference cannot be served while single-account reads,
+// which stay on the primary, still are.
+func TestListReadPreference(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.ReadPreference = readpref.Secondary()
+		config.RequestTimeout = time.Second
+	})
+	server.createAccount("alice")
+
+	recorder := server.request(http.MethodGet, "/account", BankAccount{UserName: "alice"})
+	expectStatus(t, recorder, http.StatusOK)
+	if recorder := server.request(http.MethodGet, "/account/all", nil); recorder.Code == http.StatusOK {
+		t.Fatalf("list served from the primary despite the secondary preference: %s", recorder.Body.String())
+	}
+	if recorder := server.request(http.MethodGet, "/account/debtors", nil); recorder.Code == http.StatusOK {
+		t.Fatalf("debtors served from the primary despite the secondary preference: %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// acceptsGzip reports whether the Accept-Encoding header lists gzip, or a
+// wildcard, without a zero quality value.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, parameters, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		quality := strings.ReplaceAll(strings.ToLower(parameters), " ", "")
+		if strings.HasPrefix(quality, "q=") {
+			if weight, err := strconv.ParseFloat(quality[2:], 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the body until it reaches minSize bytes.
+// Bodies that get there are sent gzip-compressed; smaller ones are sent as
+// they are when the request ends.
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	minSize    int
+	buffer     []byte
+	gzipWriter *gzip.Writer
+	plain      bool
+}
+
+func (writer *gzipResponseWriter) Write(data []byte) (int, error) {
+	switch {
+	case writer.gzipWriter != nil:
+		return writer.gzipWriter.Write(data)
+	case writer.plain:
+		return writer.ResponseWriter.Write(data)
+	}
+	writer.buffer = append(writer.buffer, data...)
+	if len(writer.buffer) >= writer.minSize {
+		if err := writer.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (writer *gzipResponseWriter) WriteString(data string) (int, error) {
+	return writer.Write([]byte(data))
+}
+
+// startCompression commits to a gzip body, unless the handler already
+// encoded the body itself or the status carries none.
+func (writer *gzipResponseWriter) startCompression() error {
+	status := writer.Status()
+	if writer.Header().Get("Content-Encoding") != "" ||
+		status == http.StatusNoContent || status == http.StatusNotModified {
+		return writer.sendPlain()
+	}
+	writer.Header().Set("Content-Encoding", "gzip")
+	writer.Header().Del("Content-Length")
+	writer.gzipWriter = gzip.NewWriter(writer.ResponseWriter)
+	buffered := writer.buffer
+	writer.buffer = nil
+	_, err := writer.gzipWriter.Write(buffered)
+	return err
+}
+
+func (writer *gzipResponseWriter) sendPlain() error {
+	writer.plain = true
+	buffered := writer.buffer
+	writer.buffer = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	_, err := writer.ResponseWriter.Write(buffered)
+	return err
+}
+
+// Flush is called by streamed responses, which are large by nature, so it
+// commits to compression even below minSize.
+func (writer *gzipResponseWriter) Flush() {
+	if writer.gzipWriter == nil && !writer.plain {
+		if err := writer.startCompression(); err != nil {
+			return
+		}
+	}
+	if writer.gzipWriter != nil {
+		writer.gzipWriter.Flush()
+	}
+	writer.ResponseWriter.Flush()
+}
+
+func (writer *gzipResponseWriter) finish() error {
+	if writer.gzipWriter != nil {
+		return writer.gzipWriter.Close()
+	}
+	return writer.sendPlain()
+}
+
+// compressionMiddleware gzips response bodies of at least
+// CompressionMinSize bytes for clients that accept it. Every response says
+// it varies by Accept-Encoding, so caches keep both forms apart.
+func compressionMiddleware(config *Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !config.ResponseCompression {
+			ctx.Next()
+			return
+		}
+		ctx.Writer.Header().Add("Vary", "Accept-Encoding")
+		if ctx.Request.Method == http.MethodHead || !acceptsGzip(ctx.GetHeader("Accept-Encoding")) {
+			ctx.Next()
+			return
+		}
+
+		originalWriter := ctx.Writer
+		writer := &gzipResponseWriter{ResponseWriter: originalWriter, minSize: config.CompressionMinSize}
+		ctx.Writer = writer
+		defer func() {
+			ctx.Writer = originalWriter
+		}()
+		ctx.Next()
+		writer.finish()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, test := range []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{acceptEncoding: "", want: false},
+		{acceptEncoding: "gzip", want: true},
+		{acceptEncoding: "deflate, GZIP;q=0.5", want: true},
+		{acceptEncoding: "*", want: true},
+		{acceptEncoding: "gzip;q=0", want: false},
+		{acceptEncoding: "gzip; q=0.0, br", want: false},
+		{acceptEncoding: "br, deflate", want: false},
+	} {
+		if got := acceptsGzip(test.acceptEncoding); got != test.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", test.acceptEncoding, got, test.want)
+		}
+	}
+}
+
+// gunzipBody decodes a gzip response body.
+func gunzipBody(t *testing.T, recorder *httptest.ResponseRecorder) string {
+	t.Helper()
+	reader, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat("a", 2048)
+	small := "tiny"
+	for _, test := range []struct {
+		name           string
+		disabled       bool
+		target         string
+		acceptEncoding string
+		compressed     bool
+		body           string
+	}{
+		{name: "large body", target: "/large", acceptEncoding: "gzip", compressed: true, body: large},
+		{name: "small body", target: "/small", acceptEncoding: "gzip", body: small},
+		{name: "not accepted", target: "/large", body: large},
+		{name: "refused", target: "/large", acceptEncoding: "gzip;q=0", body: large},
+		{name: "disabled", disabled: true, target: "/large", acceptEncoding: "gzip", body: large},
+		{name: "no content", target: "/empty", acceptEncoding: "gzip"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(compressionMiddleware(&Config{ResponseCompression: !test.disabled, CompressionMinSize: 1024}))
+			router.GET("/large", func(ctx *gin.Context) { ctx.String(http.StatusOK, large) })
+			router.GET("/small", func(ctx *gin.Context) { ctx.String(http.StatusOK, small) })
+			router.GET("/empty", func(ctx *gin.Context) { ctx.Status(http.StatusNoContent) })
+
+			var header []string
+			if test.acceptEncoding != "" {
+				header = []string{"Accept-Encoding", test.acceptEncoding}
+			}
+			recorder := serveRequest(t, router, http.MethodGet, test.target, nil, header...)
+
+			if vary := recorder.Header().Get("Vary"); (vary == "Accept-Encoding") == test.disabled {
+				t.Errorf("Vary = %q", vary)
+			}
+			if encoding := recorder.Header().Get("Content-Encoding"); (encoding == "gzip") != test.compressed {
+				t.Fatalf("Content-Encoding = %q, want compressed %v", encoding, test.compressed)
+			}
+			body := recorder.Body.String()
+			if test.compressed {
+				body = gunzipBody(t, recorder)
+			}
+			if body != test.body {
+				t.Fatalf("body has %d bytes, want %d", len(body), len(test.body))
+			}
+		})
+	}
+}
+
+func TestCompressedAccountList(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.ResponseCompression = true
+		config.CompressionMinSize = 1024
+	})
+	insertAccounts(t, server, 50)
+
+	recorder := server.request(http.MethodGet, "/account/all?limit=50", nil, "Accept-Encoding", "gzip")
+	expectStatus(t, recorder, http.StatusOK)
+	if encoding := recorder.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", encoding)
+	}
+	compressedSize := recorder.Body.Len()
+	body := gunzipBody(t, recorder)
+	if compressedSize >= len(body) {
+		t.Fatalf("compressed body has %d bytes, plain %d", compressedSize, len(body))
+	}
+	recorder.Body.Reset()
+	recorder.Body.WriteString(body)
+	if accounts := decodeResponse[[]BankAccount](t, recorder); len(accounts) != 50 {
+		t.Fatalf("decoded %d accounts, want 50", len(accounts))
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type Config struct {
+	// LedgerMode is "money", where overdrafts turn into debt, or "points",
+	// where they are refused and debt is hidden from responses.
+	LedgerMode string
+	// ListenAddr is the host:port the API listens on. With TLSCertFile and
+	// TLSKeyFile set it serves HTTPS.
+	ListenAddr  string
+	TLSCertFile string
+	TLSKeyFile  string
+	// UnverifiedLimit caps single withdrawals and transfers from accounts
+	// whose email is not verified. Zero disables the cap.
+	UnverifiedLimit int
+	// SignupBonus is the initial balance of every new account.
+	SignupBonus int
+	// AccountCacheSize bounds the in-process account read cache. Zero
+	// disables caching.
+	AccountCacheSize int
+	AccountCacheTTL  time.Duration
+	// SlowOperationThreshold is the duration above which account database
+	// calls are logged. Zero disables the logging.
+	SlowOperationThreshold time.Duration
+	// EnforceInvariants refuses account writes with a negative balance or
+	// debt.
+	EnforceInvariants bool
+	// PenaltyRate is the daily rate charged on outstanding debt, e.g. 0.001
+	// for 0.1% a day. Zero disables the accrual job.
+	PenaltyRate     float64
+	AccrualInterval time.Duration
+	// DebtGracePeriod delays penalties on debt that appears on an account
+	// without any. Zero charges from the first accrual.
+	DebtGracePeriod time.Duration
+	// TransferFee is charged to TransferFeePayer ("source" or "target") on
+	// every transfer and credited to the FeeAccount user.
+	TransferFee      TransferFee
+	TransferFeePayer string
+	FeeAccount       string
+	// SchedulerInterval is how often due scheduled transfers are executed.
+	SchedulerInterval time.Duration
+	// MaxAccounts caps how many accounts may exist. Zero means unlimited.
+	MaxAccounts int
+	// MaxPageSize caps how many items a list endpoint returns at once,
+	// whatever limit the client asks for.
+	MaxPageSize int
+	// ReadPreference applies to list and aggregate queries, which may be
+	// served by secondaries. Writes always go to the primary.
+	ReadPreference *readpref.ReadPref
+	// CORSAllowedOrigins lists the origins browsers may call the API from,
+	// "*" allowing any. Empty denies all cross-origin requests.
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	// RequestTimeout bounds the database work of a single request. Clients
+	// may ask for a different timeout up to MaxRequestTimeout.
+	RequestTimeout    time.Duration
+	MaxRequestTimeout time.Duration
+	// RouteTimeouts replaces RequestTimeout for the routes it lists, keyed
+	// by method and route pattern. It starts from defaultRouteTimeouts.
+	RouteTimeouts map[string]time.Duration
+	// AdminToken is the bearer token required by /admin routes. Empty
+	// disables them.
+	AdminToken string
+	// OperationsAdmin exposes /admin/operations for listing and killing
+	// long-running database operations.
+	OperationsAdmin bool
+	// NormalizeUsernames lowercases usernames and puts them in Unicode NFC
+	// wherever they enter the API, making them case-insensitive. Stored
+	// usernames are rewritten at startup unless another account already
+	// folds to the same name; such conflicts are logged and left alone.
+	NormalizeUsernames bool
+	// HistoryRetention keeps only the most recent transactions of each
+	// account, pruning older ones after every write. Zero keeps everything.
+	// Pruned entries are folded into one opening-balance entry, so rebuilds
+	// and reconciliation still replay to the stored state, but as-of queries
+	// cannot look back past it.
+	HistoryRetention int
+	// MaxInFlightRequests caps how many requests are served concurrently;
+	// the rest get 503. Zero means unlimited.
+	MaxInFlightRequests int
+	// JSONFieldNaming is "default" to keep the historical field names,
+	// "camelCase" or "snake_case". Clients may override it per request
+	// with X-Field-Naming.
+	JSONFieldNaming string
+	// PendingTransferTTL is how long an initiated transfer waits for
+	// confirmation before it is cancelled and its funds released.
+	PendingTransferTTL time.Duration
+	// WebhookMaxAttempts bounds how often a webhook delivery is tried. The
+	// wait between attempts starts at WebhookRetryBackoff and doubles.
+	WebhookMaxAttempts  int
+	WebhookRetryBackoff time.Duration
+	// RejectUnsafeAmounts refuses amounts above 2^53 - 1, which clients
+	// that treat JSON numbers as doubles cannot send exactly.
+	RejectUnsafeAmounts bool
+	// MaintenanceMode starts the API refusing every write with 503. Admins
+	// can switch it at runtime through /admin/maintenance.
+	MaintenanceMode bool
+	// StrictQueryParams rejects query parameters a route does not read.
+	// Clients may override it per request with X-Strict-Query.
+	StrictQueryParams bool
+	// DashboardCacheTTL is how long the admin dashboard is served from
+	// cache before it is recomputed. Zero recomputes it on every request.
+	DashboardCacheTTL time.Duration
+	// ResponseCompression gzips responses of at least CompressionMinSize
+	// bytes for clients that send Accept-Encoding: gzip.
+	ResponseCompression bool
+	CompressionMinSize  int
+	// RequestSigningSecret verifies writes signed with X-Request-Signature;
+	// their X-Request-Timestamp may be off by at most RequestMaxSkew and
+	// their nonce is refused a second time. Empty disables the check.
+	RequestSigningSecret string
+	RequestMaxSkew       time.Duration
+	// SignedRoutes are the routes, in router syntax such as
+	// "/account/:username/lock", whose writes must be signed; "*" covers
+	// every write. Other writes are only checked when they carry a
+	// signature. Requires RequestSigningSecret.
+	SignedRoutes []string
+	// DefaultAccountFields are the account fields returned by reads that do
+	// not pick their own with ?fields=. Empty returns whole accounts.
+	DefaultAccountFields []string
+	// ShutdownTimeout bounds how long shutdown waits for requests in
+	// progress before disconnecting from MongoDB.
+	ShutdownTimeout time.Duration
+	// PagedAccountList answers /account/all with an AccountPage carrying
+	// the total and paging, like /account/debtors, instead of a bare array.
+	PagedAccountList bool
+	// RoundingMode rounds interest and penalties to whole units: "half-even"
+	// (banker's rounding) or "half-up".
+	RoundingMode string
+	// TransferIDRetention is how long a client transfer ID is remembered;
+	// a retry after that executes the transfer again.
+	TransferIDRetention time.Duration
+	// FieldEncryptionKeys are the AES-256 keys, by version, that encrypt
+	// sensitive account fields at rest. New values use
+	// FieldEncryptionKeyVersion, the highest version unless set; older
+	// versions are kept to read values written before a rotation. No keys
+	// leaves those fields in plain text.
+	FieldEncryptionKeys       map[uint32][]byte
+	FieldEncryptionKeyVersion uint32
+	// MongoURI is the connection string of the database. With AppEnv
+	// "production" it must use TLS and not point at the local machine;
+	// MongoURICheck "refuse" stops startup when it does not, "warn" only
+	// logs it.
+	MongoURI      string
+	AppEnv        string
+	MongoURICheck string
+	// AccountNumberPrefix and AccountNumberLength shape the numbers given
+	// to new accounts: the prefix, random digits, then a Luhn check digit,
+	// AccountNumberLength digits in all.
+	AccountNumberPrefix string
+	AccountNumberLength int
+	// RequireIfMatch makes deposits, withdrawals and transfers answer 428
+	// unless they carry an If-Match header with the ETag of the account
+	// they debit or credit. Without it If-Match is honoured when sent.
+	RequireIfMatch bool
+	// Clock is the time source of accrual, scheduling, expiry and account
+	// timestamps. loadConfig sets the system clock.
+	Clock Clock
+	// VerificationSender delivers email verification tokens. loadConfig
+	// sets a stand-in that only logs that a token was issued.
+	VerificationSender VerificationSender
+}
+
+type ErrInvalidConfig struct {
+	Name  string
+	Value string
+}
+
+func (err *ErrInvalidConfig) Error() string {
+	return fmt.Sprintf("ErrInvalidConfig: environment variable \"%s\" has invalid value \"%s\".", err.Name, err.Value)
+}
+
+func envString(name, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// envList reads a comma separated list, dropping empty items.
+func envList(name string, defaultValue []string) []string {
+	rawValue, ok := os.LookupEnv(name)
+	if !ok || rawValue == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, value := range strings.Split(rawValue, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func envBool(name string, defaultValue bool) (bool, error) {
+	rawValue, ok := os.LookupEnv(name)
+	if !ok || rawValue == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseBool(rawValue)
+	if err != nil {
+		return false, &ErrInvalidConfig{Name: name, Value: rawValue}
+	}
+	return value, nil
+}
+
+func envNonNegativeInt(name string, defaultValue int) (int, error) {
+	rawValue, ok := os.LookupEnv(name)
+	if !ok || rawValue == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(rawValue)
+	if err != nil || value < 0 {
+		return 0, &ErrInvalidConfig{Name: name, Value: rawValue}
+	}
+	return value, nil
+}
+
+func envNonNegativeFloat(name string, defaultValue float64) (float64, error) {
+	rawValue, ok := os.LookupEnv(name)
+	if !ok || rawValue == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil || value < 0 {
+		return 0, &ErrInvalidConfig{Name: name, Value: rawValue}
+	}
+	return value, nil
+}
+
+func envDuration(name string, defaultValue time.Duration) (time.Duration, error) {
+	rawValue, ok := os.LookupEnv(name)
+	if !ok || rawValue == "" {
+		return defaultValue, nil
+	}
+	value, err := time.ParseDuration(rawValue)
+	if err != nil || value < 0 {
+		return 0, &ErrInvalidConfig{Name: name, Value: rawValue}
+	}
+	return value, nil
+}
+
+// parseRouteTimeouts reads "METHOD /route=duration" entries, such as
+// "POST /transfer/batch=45s", on top of defaultRouteTimeouts.
+func parseRouteTimeouts(rawTimeouts []string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(defaultRouteTimeouts)+len(rawTimeouts))
+	for route, timeout := range defaultRouteTimeouts {
+		timeouts[route] = timeout
+	}
+	for _, rawTimeout := range rawTimeouts {
+		route, rawDuration, found := strings.Cut(rawTimeout, "=")
+		method, path, hasPath := strings.Cut(strings.TrimSpace(route), " ")
+		timeout, err := time.ParseDuration(strings.TrimSpace(rawDuration))
+		if !found || !hasPath || method == "" || !strings.HasPrefix(path, "/") || err != nil || timeout <= 0 {
+			return nil, &ErrInvalidConfig{Name: "ROUTE_TIMEOUTS", Value: rawTimeout}
+		}
+		timeouts[method+" "+path] = timeout
+	}
+	return timeouts, nil
+}
+
+func loadConfig() (*Config, error) {
+	config := Config{Clock: systemClock{}, VerificationSender: logVerificationSender{}}
+	var err error
+
+	config.ListenAddr = envString("LISTEN_ADDR", "localhost:8080")
+	if _, _, err := net.SplitHostPort(config.ListenAddr); err != nil {
+		return nil, &ErrInvalidConfig{Name: "LISTEN_ADDR", Value: config.ListenAddr}
+	}
+	config.TLSCertFile = envString("TLS_CERT", "")
+	config.TLSKeyFile = envString("TLS_KEY", "")
+	if config.TLSCertFile == "" && config.TLSKeyFile != "" {
+		return nil, &ErrInvalidConfig{Name: "TLS_CERT", Value: ""}
+	}
+	if config.TLSCertFile != "" && config.TLSKeyFile == "" {
+		return nil, &ErrInvalidConfig{Name: "TLS_KEY", Value: ""}
+	}
+
+	config.LedgerMode = envString("LEDGER_MODE", ledgerModeMoney)
+	if config.LedgerMode != ledgerModeMoney && config.LedgerMode != ledgerModePoints {
+		return nil, &ErrInvalidConfig{Name: "LEDGER_MODE", Value: config.LedgerMode}
+	}
+
+	if config.UnverifiedLimit, err = envNonNegativeInt("UNVERIFIED_LIMIT", 0); err != nil {
+		return nil, err
+	}
+
+	if config.SignupBonus, err = envNonNegativeInt("SIGNUP_BONUS", 0); err != nil {
+		return nil, err
+	}
+
+	if config.AccountCacheSize, err = envNonNegativeInt("ACCOUNT_CACHE_SIZE", 0); err != nil {
+		return nil, err
+	}
+	if config.AccountCacheTTL, err = envDuration("ACCOUNT_CACHE_TTL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.SlowOperationThreshold, err = envDuration("SLOW_OPERATION_THRESHOLD", 200*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if config.EnforceInvariants, err = envBool("ENFORCE_INVARIANTS", true); err != nil {
+		return nil, err
+	}
+
+	if config.PenaltyRate, err = envNonNegativeFloat("PENALTY_RATE", 0); err != nil {
+		return nil, err
+	}
+	if config.AccrualInterval, err = envDuration("ACCRUAL_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if config.AccrualInterval == 0 {
+		return nil, &ErrInvalidConfig{Name: "ACCRUAL_INTERVAL", Value: "0"}
+	}
+	if config.DebtGracePeriod, err = envDuration("DEBT_GRACE_PERIOD", 0); err != nil {
+		return nil, err
+	}
+	if config.TransferFee, err = envTransferFee("TRANSFER_FEE"); err != nil {
+		return nil, err
+	}
+	config.TransferFeePayer = envString("TRANSFER_FEE_PAYER", feePayerSource)
+	if config.TransferFeePayer != feePayerSource && config.TransferFeePayer != feePayerTarget {
+		return nil, &ErrInvalidConfig{Name: "TRANSFER_FEE_PAYER", Value: config.TransferFeePayer}
+	}
+	config.FeeAccount = envString("FEE_ACCOUNT", "")
+	if !config.TransferFee.IsZero() && !isUsernameValid(config.FeeAccount) {
+		return nil, &ErrInvalidConfig{Name: "FEE_ACCOUNT", Value: config.FeeAccount}
+	}
+
+	if config.SchedulerInterval, err = envDuration("SCHEDULER_INTERVAL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.SchedulerInterval == 0 {
+		return nil, &ErrInvalidConfig{Name: "SCHEDULER_INTERVAL", Value: "0"}
+	}
+
+	if config.MaxAccounts, err = envNonNegativeInt("MAX_ACCOUNTS", 0); err != nil {
+		return nil, err
+	}
+	if config.MaxPageSize, err = envNonNegativeInt("MAX_PAGE_SIZE", 100); err != nil {
+		return nil, err
+	}
+	if config.MaxPageSize == 0 {
+		return nil, &ErrInvalidConfig{Name: "MAX_PAGE_SIZE", Value: "0"}
+	}
+	if config.ReadPreference, err = envReadPreference("READ_PREFERENCE"); err != nil {
+		return nil, err
+	}
+
+	if config.RequestTimeout, err = envDuration("REQUEST_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if config.RequestTimeout == 0 {
+		return nil, &ErrInvalidConfig{Name: "REQUEST_TIMEOUT", Value: "0"}
+	}
+	if config.MaxRequestTimeout, err = envDuration("MAX_REQUEST_TIMEOUT", time.Minute); err != nil {
+		return nil, err
+	}
+	if config.MaxRequestTimeout < config.RequestTimeout {
+		return nil, &ErrInvalidConfig{Name: "MAX_REQUEST_TIMEOUT", Value: config.MaxRequestTimeout.String()}
+	}
+	if config.RouteTimeouts, err = parseRouteTimeouts(envList("ROUTE_TIMEOUTS", nil)); err != nil {
+		return nil, err
+	}
+
+	config.AdminToken = envString("ADMIN_TOKEN", "")
+	if config.OperationsAdmin, err = envBool("OPERATIONS_ADMIN", false); err != nil {
+		return nil, err
+	}
+	if config.NormalizeUsernames, err = envBool("NORMALIZE_USERNAMES", false); err != nil {
+		return nil, err
+	}
+	if config.NormalizeUsernames {
+		config.FeeAccount = foldUsername(config.FeeAccount)
+	}
+	if config.HistoryRetention, err = envNonNegativeInt("HISTORY_RETENTION", 0); err != nil {
+		return nil, err
+	}
+	if config.MaxInFlightRequests, err = envNonNegativeInt("MAX_IN_FLIGHT_REQUESTS", 0); err != nil {
+		return nil, err
+	}
+	config.JSONFieldNaming = envString("JSON_FIELD_NAMING", fieldNamingDefault)
+	if !isFieldNamingValid(config.JSONFieldNaming) {
+		return nil, &ErrInvalidConfig{Name: "JSON_FIELD_NAMING", Value: config.JSONFieldNaming}
+	}
+
+	if config.PendingTransferTTL, err = envDuration("PENDING_TRANSFER_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.PendingTransferTTL == 0 {
+		return nil, &ErrInvalidConfig{Name: "PENDING_TRANSFER_TTL", Value: "0"}
+	}
+
+	if config.WebhookMaxAttempts, err = envNonNegativeInt("WEBHOOK_MAX_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	if config.WebhookMaxAttempts == 0 {
+		return nil, &ErrInvalidConfig{Name: "WEBHOOK_MAX_ATTEMPTS", Value: "0"}
+	}
+	if config.WebhookRetryBackoff, err = envDuration("WEBHOOK_RETRY_BACKOFF", 2*time.Second); err != nil {
+		return nil, err
+	}
+
+	if config.RejectUnsafeAmounts, err = envBool("REJECT_UNSAFE_AMOUNTS", true); err != nil {
+		return nil, err
+	}
+
+	if config.MaintenanceMode, err = envBool("MAINTENANCE_MODE", false); err != nil {
+		return nil, err
+	}
+
+	if config.StrictQueryParams, err = envBool("STRICT_QUERY_PARAMS", false); err != nil {
+		return nil, err
+	}
+
+	if config.DashboardCacheTTL, err = envDuration("DASHBOARD_CACHE_TTL", 10*time.Second); err != nil {
+		return nil, err
+	}
+
+	if config.ResponseCompression, err = envBool("RESPONSE_COMPRESSION", true); err != nil {
+		return nil, err
+	}
+	if config.CompressionMinSize, err = envNonNegativeInt("COMPRESSION_MIN_SIZE", 1024); err != nil {
+		return nil, err
+	}
+
+	config.RequestSigningSecret = envString("REQUEST_SIGNING_SECRET", "")
+	if config.RequestMaxSkew, err = envDuration("REQUEST_MAX_SKEW", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if config.RequestMaxSkew == 0 {
+		return nil, &ErrInvalidConfig{Name: "REQUEST_MAX_SKEW", Value: "0"}
+	}
+	config.SignedRoutes = envList("SIGNED_ROUTES", nil)
+	for _, route := range config.SignedRoutes {
+		if route != "*" && !strings.HasPrefix(route, "/") {
+			return nil, &ErrInvalidConfig{Name: "SIGNED_ROUTES", Value: route}
+		}
+	}
+	if len(config.SignedRoutes) > 0 && config.RequestSigningSecret == "" {
+		return nil, &ErrInvalidConfig{Name: "REQUEST_SIGNING_SECRET", Value: ""}
+	}
+
+	var invalidField string
+	config.DefaultAccountFields, invalidField = parseFieldList(envString("ACCOUNT_DEFAULT_FIELDS", ""))
+	if invalidField != "" {
+		return nil, &ErrInvalidConfig{Name: "ACCOUNT_DEFAULT_FIELDS", Value: invalidField}
+	}
+
+	if config.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+
+	if config.PagedAccountList, err = envBool("PAGED_ACCOUNT_LIST", false); err != nil {
+		return nil, err
+	}
+
+	config.RoundingMode = envString("ROUNDING_MODE", roundingHalfEven)
+	if !isRoundingModeValid(config.RoundingMode) {
+		return nil, &ErrInvalidConfig{Name: "ROUNDING_MODE", Value: config.RoundingMode}
+	}
+
+	if config.TransferIDRetention, err = envDuration("TRANSFER_ID_RETENTION", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.TransferIDRetention < time.Second {
+		return nil, &ErrInvalidConfig{Name: "TRANSFER_ID_RETENTION", Value: config.TransferIDRetention.String()}
+	}
+
+	if config.FieldEncryptionKeys, config.FieldEncryptionKeyVersion, err = parseFieldEncryptionKeys(
+		envList("FIELD_ENCRYPTION_KEYS", nil)); err != nil {
+		return nil, err
+	}
+	if rawVersion, ok := os.LookupEnv("FIELD_ENCRYPTION_KEY_VERSION"); ok {
+		version, err := strconv.ParseUint(rawVersion, 10, 32)
+		if _, known := config.FieldEncryptionKeys[uint32(version)]; err != nil || !known {
+			return nil, &ErrInvalidConfig{Name: "FIELD_ENCRYPTION_KEY_VERSION", Value: rawVersion}
+		}
+		config.FieldEncryptionKeyVersion = uint32(version)
+	}
+
+	config.MongoURI = envString("MONGO_URI", "mongodb://localhost:27017")
+	config.AppEnv = envString("APP_ENV", "development")
+	config.MongoURICheck = envString("MONGO_URI_CHECK", mongoURICheckRefuse)
+	if !isMongoURICheckValid(config.MongoURICheck) {
+		return nil, &ErrInvalidConfig{Name: "MONGO_URI_CHECK", Value: config.MongoURICheck}
+	}
+
+	config.AccountNumberPrefix = envString("ACCOUNT_NUMBER_PREFIX", "")
+	if !isDigits(config.AccountNumberPrefix) {
+		return nil, &ErrInvalidConfig{Name: "ACCOUNT_NUMBER_PREFIX", Value: config.AccountNumberPrefix}
+	}
+	if config.AccountNumberLength, err = envNonNegativeInt("ACCOUNT_NUMBER_LENGTH", 10); err != nil {
+		return nil, err
+	}
+	if config.AccountNumberLength > maxAccountNumberLength ||
+		config.AccountNumberLength < len(config.AccountNumberPrefix)+2 {
+		return nil, &ErrInvalidConfig{
+			Name: "ACCOUNT_NUMBER_LENGTH", Value: strconv.Itoa(config.AccountNumberLength),
+		}
+	}
+
+	if config.RequireIfMatch, err = envBool("REQUIRE_IF_MATCH", false); err != nil {
+		return nil, err
+	}
+
+	config.CORSAllowedOrigins = envList("CORS_ALLOWED_ORIGINS", nil)
+	config.CORSAllowedMethods = envList("CORS_ALLOWED_METHODS", []string{
+		http.MethodGet, http.MethodPost, http.MethodOptions,
+	})
+	config.CORSAllowedHeaders = envList("CORS_ALLOWED_HEADERS", []string{
+		"Content-Type", "Accept-Language", "If-Match", apiVersionHeader, requestIDHeader, fieldNamingHeader,
+		strictQueryHeader,
+	})
+
+	return &config, nil
+}
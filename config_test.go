@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigSignupBonus(t *testing.T) {
+	tests := []struct {
+		value string
+		bonus int
+		valid bool
+	}{
+		{value: "", bonus: 0, valid: true},
+		{value: "25", bonus: 25, valid: true},
+		{value: "-5"},
+		{value: "ten"},
+	}
+	for _, test := range tests {
+		t.Setenv("SIGNUP_BONUS", test.value)
+		config, err := loadConfig()
+		var configError *ErrInvalidConfig
+		switch {
+		case test.valid && err != nil:
+			t.Fatalf("SIGNUP_BONUS=%q: %v", test.value, err)
+		case test.valid && config.SignupBonus != test.bonus:
+			t.Fatalf("SIGNUP_BONUS=%q: bonus = %d, want %d", test.value, config.SignupBonus, test.bonus)
+		case !test.valid && (!errors.As(err, &configError) || configError.Name != "SIGNUP_BONUS"):
+			t.Fatalf("SIGNUP_BONUS=%q: error = %v, want ErrInvalidConfig", test.value, err)
+		}
+	}
+}
+
+func TestLoadConfigRoundingMode(t *testing.T) {
+	tests := []struct {
+		value string
+		mode  string
+		valid bool
+	}{
+		{value: "", mode: roundingHalfEven, valid: true},
+		{value: "half-even", mode: roundingHalfEven, valid: true},
+		{value: "half-up", mode: roundingHalfUp, valid: true},
+		{value: "truncate"},
+	}
+	for _, test := range tests {
+		t.Setenv("ROUNDING_MODE", test.value)
+		config, err := loadConfig()
+		var configError *ErrInvalidConfig
+		switch {
+		case test.valid && err != nil:
+			t.Fatalf("ROUNDING_MODE=%q: %v", test.value, err)
+		case test.valid && config.RoundingMode != test.mode:
+			t.Fatalf("ROUNDING_MODE=%q: mode = %q, want %q", test.value, config.RoundingMode, test.mode)
+		case !test.valid && (!errors.As(err, &configError) || configError.Name != "ROUNDING_MODE"):
+			t.Fatalf("ROUNDING_MODE=%q: error = %v, want ErrInvalidConfig", test.value, err)
+		}
+	}
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	timeouts, err := parseRouteTimeouts(nil)
+	if err != nil || len(timeouts) != len(defaultRouteTimeouts) ||
+		timeouts["POST /transfer/batch"] != 30*time.Second || timeouts["GET /transactions/export"] != 5*time.Minute {
+		t.Fatalf("defaults = %v, %v, want defaultRouteTimeouts", timeouts, err)
+	}
+
+	timeouts, err = parseRouteTimeouts([]string{"POST /transfer/batch=45s", " GET /account/all = 2s "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeouts["POST /transfer/batch"] != 45*time.Second || timeouts["GET /account/all"] != 2*time.Second ||
+		timeouts["GET /transactions/export"] != 5*time.Minute {
+		t.Fatalf("timeouts = %v, want the entries on top of the defaults", timeouts)
+	}
+	if defaultRouteTimeouts["POST /transfer/batch"] != 30*time.Second {
+		t.Fatal("parsing changed defaultRouteTimeouts")
+	}
+
+	for _, rawTimeout := range []string{
+		"POST /transfer/batch", "/transfer/batch=45s", "POST transfer/batch=45s",
+		"POST /transfer/batch=soon", "POST /transfer/batch=0s", "POST /transfer/batch=-1s",
+	} {
+		var configError *ErrInvalidConfig
+		if _, err := parseRouteTimeouts([]string{rawTimeout}); !errors.As(err, &configError) ||
+			configError.Name != "ROUTE_TIMEOUTS" {
+			t.Errorf("%q: error = %v, want ErrInvalidConfig", rawTimeout, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsMiddleware answers cross-origin requests only for the configured
+// origins. With no origins configured no CORS headers are ever sent, so
+// browsers keep blocking cross-origin calls.
+func corsMiddleware(config *Config) gin.HandlerFunc {
+	allowedOrigins := make(map[string]bool, len(config.CORSAllowedOrigins))
+	for _, origin := range config.CORSAllowedOrigins {
+		allowedOrigins[origin] = true
+	}
+	allowedMethods := strings.Join(config.CORSAllowedMethods, ", ")
+	allowedHeaders := strings.Join(config.CORSAllowedHeaders, ", ")
+
+	return func(ctx *gin.Context) {
+		origin := ctx.GetHeader("Origin")
+		if origin == "" {
+			ctx.Next()
+			return
+		}
+		ctx.Writer.Header().Add("Vary", "Origin")
+
+		allowed := allowedOrigins["*"] || allowedOrigins[origin]
+		preflight := ctx.Request.Method == http.MethodOptions &&
+			ctx.GetHeader("Access-Control-Request-Method") != ""
+
+		if !allowed {
+			if preflight {
+				ctx.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		ctx.Header("Access-Control-Allow-Origin", origin)
+		ctx.Header("Access-Control-Expose-Headers", strings.Join([]string{
+			requestIDHeader, "ETag", appliedToDebtHeader, appliedToBalanceHeader, remainingDebtHeader,
+		}, ", "))
+		if preflight {
+			ctx.Header("Access-Control-Allow-Methods", allowedMethods)
+			ctx.Header("Access-Control-Allow-Headers", allowedHeaders)
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func corsRouter(allowedOrigins ...string) *gin.Engine {
+	router := gin.New()
+	router.Use(corsMiddleware(&Config{
+		CORSAllowedOrigins: allowedOrigins,
+		CORSAllowedMethods: []string{http.MethodGet, http.MethodPost},
+		CORSAllowedHeaders: []string{"Content-Type"},
+	}))
+	router.GET("/account", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		allowed        bool
+	}{
+		{name: "allowed origin", allowedOrigins: []string{"https://app.example.com"},
+			origin: "https://app.example.com", allowed: true},
+		{name: "disallowed origin", allowedOrigins: []string{"https://app.example.com"},
+			origin: "https://evil.example.com"},
+		{name: "wildcard", allowedOrigins: []string{"*"}, origin: "https://other.example.com", allowed: true},
+		{name: "unconfigured", origin: "https://app.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := corsRouter(test.allowedOrigins...)
+
+			recorder := serveRequest(t, router, http.MethodGet, "/account", nil, "Origin", test.origin)
+			expectStatus(t, recorder, http.StatusOK)
+			allowOrigin := recorder.Header().Get("Access-Control-Allow-Origin")
+			if test.allowed && allowOrigin != test.origin || !test.allowed && allowOrigin != "" {
+				t.Fatalf("Access-Control-Allow-Origin = %q", allowOrigin)
+			}
+
+			preflight := serveRequest(t, router, http.MethodOptions, "/account", nil,
+				"Origin", test.origin, "Access-Control-Request-Method", http.MethodPost)
+			if test.allowed {
+				expectStatus(t, preflight, http.StatusNoContent)
+				if methods := preflight.Header().Get("Access-Control-Allow-Methods"); methods != "GET, POST" {
+					t.Fatalf("Access-Control-Allow-Methods = %q", methods)
+				}
+			} else {
+				expectStatus(t, preflight, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestCORSMiddlewareSameOrigin(t *testing.T) {
+	recorder := serveRequest(t, corsRouter("https://app.example.com"), http.MethodGet, "/account", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if header := recorder.Header(); header.Get("Access-Control-Allow-Origin") != "" || header.Get("Vary") != "" {
+		t.Fatalf("headers on a request without Origin: %v", header)
+	}
+}
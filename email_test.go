@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingVerificationSender keeps the tokens it was asked to deliver.
+type recordingVerificationSender struct {
+	mutex  sync.Mutex
+	tokens map[string]string
+}
+
+func (sender *recordingVerificationSender) SendVerificationToken(account BankAccount) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	if sender.tokens == nil {
+		sender.tokens = make(map[string]string)
+	}
+	sender.tokens[account.UserName] = account.VerificationToken
+	return nil
+}
+
+func (sender *recordingVerificationSender) token(userName string) string {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	return sender.tokens[userName]
+}
+
+func TestIsEmailValid(t *testing.T) {
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{email: "alice@example.com", valid: true},
+		{email: "alice.smith+bank@mail.example.org", valid: true},
+		{email: "alice"},
+		{email: "alice@"},
+		{email: "Alice <alice@example.com>"},
+		{email: " alice@example.com"},
+	}
+	for _, test := range tests {
+		if valid := isEmailValid(test.email); valid != test.valid {
+			t.Errorf("isEmailValid(%q) = %v, want %v", test.email, valid, test.valid)
+		}
+	}
+}
+
+func TestCheckUnverifiedLimit(t *testing.T) {
+	unverified := BankAccount{UserName: "alice"}
+	verified := BankAccount{UserName: "bob", EmailVerified: true}
+
+	tests := []struct {
+		name    string
+		limit   int
+		account BankAccount
+		amount  int
+		refused bool
+	}{
+		{name: "no limit", limit: 0, account: unverified, amount: 1000},
+		{name: "at the limit", limit: 100, account: unverified, amount: 100},
+		{name: "over the limit", limit: 100, account: unverified, amount: 101, refused: true},
+		{name: "verified over the limit", limit: 100, account: verified, amount: 101},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkUnverifiedLimit(&Config{UnverifiedLimit: test.limit}, test.account, test.amount)
+			var limitError *ErrUnverifiedLimitExceeded
+			if refused := errors.As(err, &limitError); refused != test.refused {
+				t.Fatalf("checkUnverifiedLimit = %v, refused want %v", err, test.refused)
+			}
+		})
+	}
+}
+
+func TestLogVerificationSenderOmitsToken(t *testing.T) {
+	var logBuffer bytes.Buffer
+	previousOutput := log.Writer()
+	log.SetOutput(&logBuffer)
+	t.Cleanup(func() {
+		log.SetOutput(previousOutput)
+	})
+
+	account := BankAccount{UserName: "alice", Email: "alice@example.com", VerificationToken: "0123456789abcdef"}
+	if err := (logVerificationSender{}).SendVerificationToken(account); err != nil {
+		t.Fatal(err)
+	}
+	if logged := logBuffer.String(); strings.Contains(logged, account.VerificationToken) ||
+		strings.Contains(logged, string(account.Email)) || !strings.Contains(logged, "alice") {
+		t.Fatalf("logged %q", logged)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	sender := &recordingVerificationSender{}
+	server := newTestServer(t, func(config *Config) {
+		config.VerificationSender = sender
+	})
+
+	recorder := server.request(http.MethodPost, "/account/create", BankAccount{UserName: "alice", Email: "not-an-email"})
+	expectStatus(t, recorder, http.StatusBadRequest)
+
+	recorder = server.request(http.MethodPost, "/account/create",
+		BankAccount{UserName: "alice", Email: "alice@example.com"})
+	expectStatus(t, recorder, http.StatusCreated)
+	token := sender.token("alice")
+	if token == "" {
+		t.Fatal("no verification token was sent")
+	}
+
+	recorder = server.request(http.MethodPost, "/account/verify-email",
+		EmailVerificationInput{UserName: "alice", Token: "wrong"})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidVerificationToken")
+
+	recorder = server.request(http.MethodPost, "/account/verify-email",
+		EmailVerificationInput{UserName: "alice", Token: token})
+	expectStatus(t, recorder, http.StatusOK)
+	if account := server.account("alice"); !account.EmailVerified || account.VerificationToken != "" {
+		t.Fatalf("after verification: %+v", account)
+	}
+}
+
+func TestUnverifiedLimitRejection(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.UnverifiedLimit = 50
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 200)
+
+	recorder := server.request(http.MethodPost, "/withdraw", TransactionInput{UserName: "alice", Amount: 51})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrUnverifiedLimitExceeded")
+	recorder = server.request(http.MethodPost, "/transfer", TransferNote{FromUser: "alice", ToUser: "bob", Amount: 51})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrUnverifiedLimitExceeded")
+	server.withdraw("alice", 50)
+
+	if account := server.account("alice"); account.Balance != 150 {
+		t.Fatalf("balance = %d, want 150", account.Balance)
+	}
+}
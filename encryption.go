@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sealedSubtype marks BSON binary values holding a sealed field: a 4 byte
+// big-endian key version, the GCM nonce, then the ciphertext.
+const sealedSubtype byte = 0x80
+
+// fieldEncryption seals the account fields declared as sealedString or
+// sealedMetadata before they reach MongoDB and opens them again on decode,
+// so handlers only ever see plain text. main sets it from
+// FIELD_ENCRYPTION_KEYS; nil stores new values in plain text. Values
+// written in plain text before encryption was enabled are still read.
+var fieldEncryption *fieldCipher
+
+type fieldCipher struct {
+	activeVersion uint32
+	keys          map[uint32]cipher.AEAD
+}
+
+// newFieldCipher builds AES-GCM ciphers for every key version. New values
+// are sealed with activeVersion; the older keys only open existing values,
+// which are re-sealed with the active key whenever the account is written,
+// so a key can be retired once every account has been rewritten.
+func newFieldCipher(keys map[uint32][]byte, activeVersion uint32) (*fieldCipher, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	fieldCipher := &fieldCipher{activeVersion: activeVersion, keys: make(map[uint32]cipher.AEAD, len(keys))}
+	for version, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("field encryption key %d: %w", version, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("field encryption key %d: %w", version, err)
+		}
+		fieldCipher.keys[version] = aead
+	}
+	if _, ok := fieldCipher.keys[activeVersion]; !ok {
+		return nil, fmt.Errorf("field encryption key version %d is not configured", activeVersion)
+	}
+	return fieldCipher, nil
+}
+
+func (fieldCipher *fieldCipher) seal(plaintext []byte) ([]byte, error) {
+	aead := fieldCipher.keys[fieldCipher.activeVersion]
+	sealed := make([]byte, 4, 4+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint32(sealed, fieldCipher.activeVersion)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, plaintext, nil), nil
+}
+
+func (fieldCipher *fieldCipher) open(sealed []byte) ([]byte, error) {
+	if len(sealed) < 4 {
+		return nil, errors.New("sealed field is truncated")
+	}
+	version := binary.BigEndian.Uint32(sealed)
+	aead, ok := fieldCipher.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("sealed field uses unknown key version %d", version)
+	}
+	if len(sealed) < 4+aead.NonceSize() {
+		return nil, errors.New("sealed field is truncated")
+	}
+	nonce, ciphertext := sealed[4:4+aead.NonceSize()], sealed[4+aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// marshalSealed stores plaintext as a sealed binary value, or plain when
+// encryption is off.
+func marshalSealed(plaintext []byte, plain any) (bsontype.Type, []byte, error) {
+	if fieldEncryption == nil {
+		return bson.MarshalValue(plain)
+	}
+	sealed, err := fieldEncryption.seal(plaintext)
+	if err != nil {
+		return 0, nil, err
+	}
+	return bson.MarshalValue(primitive.Binary{Subtype: sealedSubtype, Data: sealed})
+}
+
+// openSealed returns the plain text of a sealed binary value, and false for
+// any other value, which was stored in plain text.
+func openSealed(valueType bsontype.Type, data []byte) ([]byte, bool, error) {
+	subtype, sealed, ok := bson.RawValue{Type: valueType, Value: data}.BinaryOK()
+	if !ok || subtype != sealedSubtype {
+		return nil, false, nil
+	}
+	if fieldEncryption == nil {
+		return nil, true, errors.New("sealed field found but FIELD_ENCRYPTION_KEYS is not set")
+	}
+	plaintext, err := fieldEncryption.open(sealed)
+	return plaintext, true, err
+}
+
+// sealedString is a string encrypted at rest.
+type sealedString string
+
+func (value sealedString) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if value == "" {
+		return bson.MarshalValue("")
+	}
+	return marshalSealed([]byte(value), string(value))
+}
+
+func (value *sealedString) UnmarshalBSONValue(valueType bsontype.Type, data []byte) error {
+	plaintext, sealed, err := openSealed(valueType, data)
+	if err != nil {
+		return err
+	}
+	if sealed {
+		*value = sealedString(plaintext)
+		return nil
+	}
+	rawValue := bson.RawValue{Type: valueType, Value: data}
+	if stringValue, ok := rawValue.StringValueOK(); ok {
+		*value = sealedString(stringValue)
+		return nil
+	}
+	if valueType == bsontype.Null || valueType == bsontype.Undefined {
+		*value = ""
+		return nil
+	}
+	return fmt.Errorf("cannot decode %s into a sealed string", valueType)
+}
+
+// sealedMetadata is a string map encrypted at rest as a single value, so
+// neither its keys nor its values are visible in the stored document.
+type sealedMetadata map[string]string
+
+func (metadata sealedMetadata) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if len(metadata) == 0 {
+		return bsontype.Null, nil, nil
+	}
+	plaintext, err := json.Marshal(map[string]string(metadata))
+	if err != nil {
+		return 0, nil, err
+	}
+	return marshalSealed(plaintext, map[string]string(metadata))
+}
+
+func (metadata *sealedMetadata) UnmarshalBSONValue(valueType bsontype.Type, data []byte) error {
+	plaintext, sealed, err := openSealed(valueType, data)
+	if err != nil {
+		return err
+	}
+	var plain map[string]string
+	switch {
+	case sealed:
+		err = json.Unmarshal(plaintext, &plain)
+	case valueType == bsontype.Null || valueType == bsontype.Undefined:
+	default:
+		err = bson.RawValue{Type: valueType, Value: data}.Unmarshal(&plain)
+	}
+	*metadata = plain
+	return err
+}
+
+// parseFieldEncryptionKeys reads "version:base64key" pairs, such as
+// "1:...,2:...", and returns them with the highest version.
+func parseFieldEncryptionKeys(rawKeys []string) (map[uint32][]byte, uint32, error) {
+	keys := make(map[uint32][]byte, len(rawKeys))
+	var highestVersion uint32
+	for _, rawKey := range rawKeys {
+		rawVersion, encodedKey, found := strings.Cut(rawKey, ":")
+		version, err := strconv.ParseUint(rawVersion, 10, 32)
+		if !found || err != nil || version == 0 {
+			return nil, 0, &ErrInvalidConfig{Name: "FIELD_ENCRYPTION_KEYS", Value: rawVersion}
+		}
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != 32 {
+			return nil, 0, &ErrInvalidConfig{Name: "FIELD_ENCRYPTION_KEYS", Value: rawVersion + ":<key>"}
+		}
+		keys[uint32(version)] = key
+		if uint32(version) > highestVersion {
+			highestVersion = uint32(version)
+		}
+	}
+	return keys, highestVersion, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testEncryptionKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, 32)
+}
+
+func TestFieldCipherRoundTrip(t *testing.T) {
+	fieldCipher, err := newFieldCipher(map[uint32][]byte{1: testEncryptionKey(1)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("alice@example.com")
+	sealed, err := fieldCipher.seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("sealed value contains the plain text")
+	}
+	resealed, err := fieldCipher.seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed, resealed) {
+		t.Fatal("sealing twice gave the same bytes, want a fresh nonce each time")
+	}
+	if opened, err := fieldCipher.open(sealed); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("open = %q, %v, want %q", opened, err, plaintext)
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	for name, value := range map[string][]byte{"tampered": tampered, "truncated": sealed[:3], "nonce only": sealed[:10]} {
+		if _, err := fieldCipher.open(value); err == nil {
+			t.Errorf("%s value opened", name)
+		}
+	}
+
+	if fieldCipher, err := newFieldCipher(nil, 0); fieldCipher != nil || err != nil {
+		t.Fatalf("no keys = %v, %v, want encryption off", fieldCipher, err)
+	}
+	if _, err := newFieldCipher(map[uint32][]byte{1: testEncryptionKey(1)}, 2); err == nil {
+		t.Fatal("accepted an active version with no key")
+	}
+	if _, err := newFieldCipher(map[uint32][]byte{1: []byte("short")}, 1); err == nil {
+		t.Fatal("accepted a short key")
+	}
+}
+
+func TestFieldCipherRotation(t *testing.T) {
+	oldCipher, err := newFieldCipher(map[uint32][]byte{1: testEncryptionKey(1)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedWithOld, err := oldCipher.seal([]byte("eu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := newFieldCipher(map[uint32][]byte{1: testEncryptionKey(1), 2: testEncryptionKey(2)}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := rotated.open(sealedWithOld); err != nil || string(opened) != "eu" {
+		t.Fatalf("rotated open of a version 1 value = %q, %v", opened, err)
+	}
+	sealedWithNew, err := rotated.seal([]byte("eu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sealedWithNew[:4], []byte{0, 0, 0, 2}) {
+		t.Fatalf("new value has key version %v, want 2", sealedWithNew[:4])
+	}
+
+	retired, err := newFieldCipher(map[uint32][]byte{2: testEncryptionKey(2)}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.open(sealedWithOld); err == nil {
+		t.Fatal("opened a version 1 value after its key was retired")
+	}
+	if _, err := oldCipher.open(sealedWithNew); err == nil {
+		t.Fatal("opened a version 2 value with only the version 1 key")
+	}
+}
+
+func TestParseFieldEncryptionKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testEncryptionKey(1))
+	keys, version, err := parseFieldEncryptionKeys([]string{"1:" + key, "3:" + key, "2:" + key})
+	if err != nil || len(keys) != 3 || version != 3 {
+		t.Fatalf("parsed %d keys, version %d, %v; want 3 keys, version 3", len(keys), version, err)
+	}
+	for _, rawKeys := range [][]string{
+		{key},
+		{"0:" + key},
+		{"one:" + key},
+		{"1:not base64"},
+		{"1:" + base64.StdEncoding.EncodeToString([]byte("too short"))},
+	} {
+		var configError *ErrInvalidConfig
+		if _, _, err := parseFieldEncryptionKeys(rawKeys); !errors.As(err, &configError) {
+			t.Errorf("%q: error = %v, want ErrInvalidConfig", rawKeys, err)
+		}
+	}
+}
+
+// useFieldEncryption turns on encryption with a single key for the test.
+func useFieldEncryption(t *testing.T) {
+	t.Helper()
+	config, _ := newTestConfig(t)
+	config.FieldEncryptionKeys = map[uint32][]byte{1: testEncryptionKey(1)}
+	config.FieldEncryptionKeyVersion = 1
+	useProcessConfig(t, config)
+}
+
+func TestSealedAccountFields(t *testing.T) {
+	plainAccount := BankAccount{UserName: "alice", Email: "alice@example.com", Metadata: sealedMetadata{"region": "europe-west"}}
+	plainDocument, err := bson.Marshal(plainAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useFieldEncryption(t)
+	document, err := bson.Marshal(plainAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := bson.Raw(document)
+	for _, field := range []string{"email", "metadata"} {
+		if subtype, _, ok := raw.Lookup(field).BinaryOK(); !ok || subtype != sealedSubtype {
+			t.Fatalf("%s stored as %s, want a sealed binary", field, raw.Lookup(field).Type)
+		}
+	}
+	for _, plaintext := range []string{"alice@example.com", "region", "europe-west"} {
+		if bytes.Contains(document, []byte(plaintext)) {
+			t.Fatalf("stored document contains %q", plaintext)
+		}
+	}
+	if username := raw.Lookup("username").StringValue(); username != "alice" {
+		t.Fatalf("username stored as %q, want it left in plain text", username)
+	}
+
+	for name, stored := range map[string][]byte{"sealed": document, "written before encryption": plainDocument} {
+		var account BankAccount
+		if err := bson.Unmarshal(stored, &account); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if account.Email != plainAccount.Email || !reflect.DeepEqual(account.Metadata, plainAccount.Metadata) {
+			t.Fatalf("%s: read %q, %v", name, account.Email, account.Metadata)
+		}
+	}
+
+	fieldEncryption = nil
+	var account BankAccount
+	if err := bson.Unmarshal(document, &account); err == nil {
+		t.Fatal("read sealed fields with encryption off")
+	}
+}
+
+func TestEncryptedAccountAtRest(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.FieldEncryptionKeys = map[uint32][]byte{1: testEncryptionKey(1)}
+		config.FieldEncryptionKeyVersion = 1
+	})
+	recorder := server.request(http.MethodPost, "/account/create",
+		BankAccount{UserName: "alice", Email: "alice@example.com", Metadata: sealedMetadata{"region": "europe-west"}})
+	expectStatus(t, recorder, http.StatusCreated)
+
+	var stored bson.Raw
+	if err := server.database.Collection("BankAccount").FindOne(context.Background(),
+		bson.D{{Key: "username", Value: "alice"}}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("alice@example.com")) || bytes.Contains(stored, []byte("region")) {
+		t.Fatalf("stored document holds plain text: %s", stored)
+	}
+
+	recorder = server.request(http.MethodGet, "/account", BankAccount{UserName: "alice"})
+	expectStatus(t, recorder, http.StatusOK)
+	if account := decodeResponse[BankAccount](t, recorder); account.Email != "alice@example.com" ||
+		account.Metadata["region"] != "europe-west" {
+		t.Fatalf("read %q, %v, want the plain email and metadata", account.Email, account.Metadata)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ErrPreconditionFailed struct {
+	UserName string
+}
+
+func (err *ErrPreconditionFailed) Code() string {
+	return "ErrPreconditionFailed"
+}
+
+func (err *ErrPreconditionFailed) messageArgs() []any {
+	return []any{err.UserName}
+}
+
+func (err *ErrPreconditionFailed) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrPreconditionFailed) Status() int {
+	return http.StatusPreconditionFailed
+}
+
+type ErrPreconditionRequired struct {
+	UserName string
+}
+
+func (err *ErrPreconditionRequired) Code() string {
+	return "ErrPreconditionRequired"
+}
+
+func (err *ErrPreconditionRequired) messageArgs() []any {
+	return []any{err.UserName}
+}
+
+func (err *ErrPreconditionRequired) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrPreconditionRequired) Status() int {
+	return http.StatusPreconditionRequired
+}
+
+func accountETag(account BankAccount) string {
+	document, _ := json.Marshal(account)
+	hash := sha256.Sum256(document)
+	return fmt.Sprintf("\"%x\"", hash[:16])
+}
+
+func setAccountETag(ctx *gin.Context, account BankAccount) {
+	ctx.Header("ETag", accountETag(account))
+}
+
+func ifMatchSatisfied(ifMatch string, account BankAccount) bool {
+	if ifMatch == "*" {
+		return true
+	}
+	etag := accountETag(account)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch refuses the mutation when If-Match no longer matches the
+// stored account. A request without If-Match passes unless RequireIfMatch
+// is set, in which case it is refused with 428.
+func checkIfMatch(ctx *gin.Context, config *Config, account BankAccount) error {
+	ifMatch := ctx.GetHeader("If-Match")
+	if ifMatch == "" {
+		if config.RequireIfMatch {
+			return &ErrPreconditionRequired{UserName: account.UserName}
+		}
+		return nil
+	}
+	if !ifMatchSatisfied(ifMatch, account) {
+		return &ErrPreconditionFailed{UserName: account.UserName}
+	}
+	return nil
+}
+
+// sendErrPreconditionFailed answers with 412 when the client sent an If-Match
+// header that no longer matches the stored account, or with 428 when one is
+// required and missing.
+func sendErrPreconditionFailed(ctx *gin.Context, config *Config, account BankAccount) bool {
+	if err := checkIfMatch(ctx, config, account); err != nil {
+		sendError(ctx, err)
+		return true
+	}
+	return false
+}
+
+// setLastModified sends the account's UpdatedAt as Last-Modified and
+// reports whether the client's If-Modified-Since copy is still current,
+// in which case the caller answers 304 without a body. HTTP dates have
+// whole seconds, so UpdatedAt is compared truncated. Accounts without an
+// UpdatedAt are always sent in full.
+func setLastModified(ctx *gin.Context, account BankAccount) bool {
+	if account.UpdatedAt.IsZero() {
+		return false
+	}
+	lastModified := account.UpdatedAt.UTC().Truncate(time.Second)
+	ctx.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	rawSince := ctx.GetHeader("If-Modified-Since")
+	if rawSince == "" {
+		return false
+	}
+	since, err := http.ParseTime(rawSince)
+	return err == nil && !lastModified.After(since)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ifMatchContext(ifMatch string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/deposit", nil)
+	if ifMatch != "" {
+		ctx.Request.Header.Set("If-Match", ifMatch)
+	}
+	return ctx
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	account := BankAccount{UserName: "alice", Balance: 10}
+	stale := BankAccount{UserName: "alice", Balance: 5}
+
+	tests := []struct {
+		name           string
+		ifMatch        string
+		requireIfMatch bool
+		want           error
+	}{
+		{name: "absent", ifMatch: ""},
+		{name: "matching", ifMatch: accountETag(account)},
+		{name: "any", ifMatch: "*"},
+		{name: "one of several", ifMatch: accountETag(stale) + ", " + accountETag(account)},
+		{name: "stale", ifMatch: accountETag(stale), want: &ErrPreconditionFailed{}},
+		{name: "absent but required", requireIfMatch: true, want: &ErrPreconditionRequired{}},
+		{name: "matching and required", ifMatch: accountETag(account), requireIfMatch: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkIfMatch(ifMatchContext(test.ifMatch), &Config{RequireIfMatch: test.requireIfMatch}, account)
+			switch want := test.want.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("checkIfMatch = %v, want nil", err)
+				}
+			case *ErrPreconditionFailed:
+				if !errors.As(err, &want) {
+					t.Fatalf("checkIfMatch = %v, want ErrPreconditionFailed", err)
+				}
+			case *ErrPreconditionRequired:
+				if !errors.As(err, &want) || errorStatus(err) != http.StatusPreconditionRequired {
+					t.Fatalf("checkIfMatch = %v, want ErrPreconditionRequired", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDepositIfMatch(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	etag := accountETag(server.account("alice"))
+
+	recorder := server.request(http.MethodPost, "/deposit", TransactionInput{UserName: "alice", Amount: 10},
+		"If-Match", etag)
+	expectStatus(t, recorder, http.StatusOK)
+
+	// The first deposit changed the account, so the same ETag is now stale.
+	recorder = server.request(http.MethodPost, "/deposit", TransactionInput{UserName: "alice", Amount: 10},
+		"If-Match", etag)
+	expectErrorCode(t, recorder, http.StatusPreconditionFailed, "ErrPreconditionFailed")
+	if account := server.account("alice"); account.Balance != 10 {
+		t.Fatalf("balance after refused deposit = %d, want 10", account.Balance)
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.RequireIfMatch = true
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+
+	recorder := server.request(http.MethodPost, "/deposit", TransactionInput{UserName: "alice", Amount: 10})
+	expectErrorCode(t, recorder, http.StatusPreconditionRequired, "ErrPreconditionRequired")
+	recorder = server.request(http.MethodPost, "/transfer", TransferNote{FromUser: "alice", ToUser: "bob", Amount: 1})
+	expectErrorCode(t, recorder, http.StatusPreconditionRequired, "ErrPreconditionRequired")
+
+	recorder = server.request(http.MethodPost, "/deposit", TransactionInput{UserName: "alice", Amount: 10},
+		"If-Match", accountETag(server.account("alice")))
+	expectStatus(t, recorder, http.StatusOK)
+}
+
+func TestSetLastModified(t *testing.T) {
+	updatedAt := time.Date(2024, time.March, 1, 12, 0, 0, 400*int(time.Millisecond), time.UTC)
+	httpDate := func(at time.Time) string {
+		return at.Format(http.TimeFormat)
+	}
+	tests := []struct {
+		name            string
+		updatedAt       time.Time
+		ifModifiedSince string
+		lastModified    string
+		notModified     bool
+	}{
+		{name: "never updated", ifModifiedSince: httpDate(updatedAt)},
+		{name: "no condition", updatedAt: updatedAt, lastModified: httpDate(updatedAt)},
+		{name: "same second", updatedAt: updatedAt, ifModifiedSince: httpDate(updatedAt),
+			lastModified: httpDate(updatedAt), notModified: true},
+		{name: "copy is newer", updatedAt: updatedAt, ifModifiedSince: httpDate(updatedAt.Add(time.Hour)),
+			lastModified: httpDate(updatedAt), notModified: true},
+		{name: "copy is older", updatedAt: updatedAt, ifModifiedSince: httpDate(updatedAt.Add(-time.Second)),
+			lastModified: httpDate(updatedAt)},
+		{name: "unparsable", updatedAt: updatedAt, ifModifiedSince: "yesterday", lastModified: httpDate(updatedAt)},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/account", nil)
+		if test.ifModifiedSince != "" {
+			ctx.Request.Header.Set("If-Modified-Since", test.ifModifiedSince)
+		}
+		notModified := setLastModified(ctx, BankAccount{UserName: "alice", UpdatedAt: test.updatedAt})
+		if notModified != test.notModified {
+			t.Errorf("%s: not modified = %v, want %v", test.name, notModified, test.notModified)
+		}
+		if lastModified := recorder.Header().Get("Last-Modified"); lastModified != test.lastModified {
+			t.Errorf("%s: Last-Modified = %q, want %q", test.name, lastModified, test.lastModified)
+		}
+	}
+}
+
+func TestConditionalGetAccount(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+
+	recorder := server.request(http.MethodGet, "/account", BankAccount{UserName: "alice"})
+	expectStatus(t, recorder, http.StatusOK)
+	lastModified := recorder.Header().Get("Last-Modified")
+	if lastModified != server.clock.Now().Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified = %q, want %q", lastModified, server.clock.Now().Format(http.TimeFormat))
+	}
+
+	recorder = server.request(http.MethodGet, "/account", BankAccount{UserName: "alice"}, "If-Modified-Since", lastModified)
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Fatalf("unchanged account = %d %q, want an empty 304", recorder.Code, recorder.Body.String())
+	}
+
+	server.clock.Advance(time.Minute)
+	server.deposit("alice", 10)
+	recorder = server.request(http.MethodGet, "/account", BankAccount{UserName: "alice"}, "If-Modified-Since", lastModified)
+	expectStatus(t, recorder, http.StatusOK)
+	if account := decodeResponse[BankAccount](t, recorder); account.Balance != 10 {
+		t.Fatalf("balance = %d, want 10", account.Balance)
+	}
+	if modified := recorder.Header().Get("Last-Modified"); modified != server.clock.Now().Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified after the deposit = %q, want %q", modified, server.clock.Now().Format(http.TimeFormat))
+	}
+}
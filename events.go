@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Event is a notable change to an account, handed to an EventPublisher
+// after the change has been stored.
+type Event struct {
+	Type       string    `json:"type"`
+	UserName   string    `json:"username"`
+	Data       any       `json:"data,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func newEvent(clock Clock, eventType, userName string, data any) Event {
+	return Event{
+		Type:       eventType,
+		UserName:   userName,
+		Data:       data,
+		OccurredAt: clock.Now().UTC(),
+	}
+}
+
+// EventPublisher delivers events. Publish must not block the request that
+// caused the event for long.
+type EventPublisher interface {
+	Publish(event Event)
+}
+
+// logEventPublisher writes events to the standard logger; it stands in until
+// a real delivery channel exists.
+type logEventPublisher struct{}
+
+func (logEventPublisher) Publish(event Event) {
+	document, _ := json.Marshal(event)
+	log.Printf("event: %s", document)
+}
+
+func publishEvents(publisher EventPublisher, events []Event) {
+	for _, event := range events {
+		publisher.Publish(event)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+
+	// exportFlushInterval is how many rows are written between flushes.
+	exportFlushInterval = 500
+)
+
+var exportCSVHeader = []string{
+	"id", "username", "type", "amount", "counterparty", "category", "memo", "balance", "debt", "createdAt",
+}
+
+func exportCSVRow(transaction Transaction) []string {
+	return []string{
+		transaction.ID.Hex(),
+		transaction.UserName,
+		transaction.Type,
+		strconv.Itoa(transaction.Amount),
+		transaction.Counterparty,
+		transaction.Category,
+		transaction.Memo,
+		strconv.Itoa(transaction.Balance),
+		strconv.Itoa(transaction.Debt),
+		transaction.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// parseTimeQuery reads an optional RFC 3339 timestamp from the query.
+func parseTimeQuery(ctx *gin.Context, name string) (*time.Time, error) {
+	rawValue, ok := ctx.GetQuery(name)
+	if !ok {
+		return nil, nil
+	}
+	value, err := time.Parse(time.RFC3339, rawValue)
+	if err != nil {
+		return nil, &ErrInvalidQueryParam{Name: name, Value: rawValue}
+	}
+	return &value, nil
+}
+
+// exportTransactionsHandler streams the transaction log, oldest first, as
+// a CSV or NDJSON attachment. ?from= and ?to= bound createdAt inclusively,
+// ?type= may repeat and ?username= narrows it to one account. Rows are
+// written as the cursor yields them, so memory stays flat however long
+// the log is; a failure midway is logged and cuts the file short.
+func exportTransactionsHandler(transactionCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		format := ctx.DefaultQuery("format", exportFormatCSV)
+		if format != exportFormatCSV && format != exportFormatNDJSON {
+			sendError(ctx, &ErrInvalidQueryParam{Name: "format", Value: format})
+			return
+		}
+		from, err := parseTimeQuery(ctx, "from")
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		to, err := parseTimeQuery(ctx, "to")
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if from != nil && to != nil && from.After(*to) {
+			sendError(ctx, &ErrInvalidDateRange{From: *from, To: *to})
+			return
+		}
+		types := ctx.QueryArray("type")
+		for _, transactionType := range types {
+			if !isTransactionTypeValid(transactionType) {
+				sendError(ctx, &ErrInvalidTransactionType{Type: transactionType})
+				return
+			}
+		}
+
+		exportFilter := bson.D{}
+		if rawUserName, ok := ctx.GetQuery("username"); ok {
+			userName := normalizeUsername(rawUserName)
+			if !isUsernameValid(userName) {
+				sendError(ctx, &ErrInvalidUsername{UserName: userName})
+				return
+			}
+			exportFilter = append(exportFilter, bson.E{Key: "username", Value: userName})
+		}
+		exportFilter = appendHistoryRange(exportFilter, from, to, types)
+
+		exportCursor, err := transactionCollection.Find(ctx.Request.Context(), exportFilter,
+			options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		defer exportCursor.Close(ctx.Request.Context())
+
+		fileName := "transactions." + format
+		contentType := "text/csv; charset=utf-8"
+		if format == exportFormatNDJSON {
+			contentType = "application/x-ndjson"
+		}
+		ctx.Header("Content-Type", contentType)
+		ctx.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+		ctx.Status(http.StatusOK)
+
+		csvWriter := csv.NewWriter(ctx.Writer)
+		jsonEncoder := json.NewEncoder(ctx.Writer)
+		if format == exportFormatCSV {
+			csvWriter.Write(exportCSVHeader)
+		}
+		for rowCount := 1; exportCursor.Next(ctx.Request.Context()); rowCount++ {
+			var transaction Transaction
+			if err := exportCursor.Decode(&transaction); err != nil {
+				log.Printf("aborting transaction export: %v", err)
+				return
+			}
+			if format == exportFormatCSV {
+				err = csvWriter.Write(exportCSVRow(transaction))
+			} else {
+				err = jsonEncoder.Encode(transaction)
+			}
+			if err != nil {
+				log.Printf("aborting transaction export: %v", err)
+				return
+			}
+			if rowCount%exportFlushInterval == 0 {
+				csvWriter.Flush()
+				ctx.Writer.Flush()
+			}
+		}
+		csvWriter.Flush()
+		if err := exportCursor.Err(); err != nil {
+			log.Printf("aborting transaction export: %v", err)
+		}
+	}
+}
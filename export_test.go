@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExportCSVRow(t *testing.T) {
+	id := primitive.NewObjectID()
+	transaction := Transaction{
+		ID: id, UserName: "alice", Type: transactionTypeTransferOut, Amount: 25, Counterparty: "bob",
+		Category: "rent", Memo: "march, flat 2", Balance: 75, Debt: 0,
+		CreatedAt: time.Date(2024, time.March, 1, 12, 0, 0, 500, time.FixedZone("CET", 3600)),
+	}
+	want := []string{
+		id.Hex(), "alice", "transfer-out", "25", "bob", "rent", "march, flat 2", "75", "0",
+		"2024-03-01T11:00:00.0000005Z",
+	}
+	row := exportCSVRow(transaction)
+	if len(row) != len(exportCSVHeader) {
+		t.Fatalf("row has %d columns, header %d", len(row), len(exportCSVHeader))
+	}
+	for i := range want {
+		if row[i] != want[i] {
+			t.Errorf("%s = %q, want %q", exportCSVHeader[i], row[i], want[i])
+		}
+	}
+}
+
+func TestExportTransactions(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.clock.Advance(time.Hour)
+	from := server.clock.Now()
+	server.deposit("alice", 100)
+	server.deposit("bob", 40)
+	server.withdraw("alice", 30)
+	server.clock.Advance(time.Hour)
+	server.deposit("alice", 5)
+
+	export := func(query string) *strings.Reader {
+		t.Helper()
+		recorder := server.request(http.MethodGet, "/transactions/export"+query, nil, "Authorization", "Bearer secret")
+		expectStatus(t, recorder, http.StatusOK)
+		if disposition := recorder.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment;") {
+			t.Fatalf("Content-Disposition = %q, want an attachment", disposition)
+		}
+		return strings.NewReader(recorder.Body.String())
+	}
+
+	to := from.Add(30 * time.Minute)
+	rows, err := csv.NewReader(export("?type=deposit&from=" + from.Format(time.RFC3339) +
+		"&to=" + to.Format(time.RFC3339))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(exportCSVHeader, ",") {
+		t.Fatalf("rows = %q, want the header and two deposits", rows)
+	}
+	for i, want := range [][2]string{{"alice", "100"}, {"bob", "40"}} {
+		if row := rows[i+1]; row[1] != want[0] || row[2] != transactionTypeDeposit || row[3] != want[1] {
+			t.Errorf("row %d = %q, want %s depositing %s", i+1, row, want[0], want[1])
+		}
+	}
+
+	var exported []Transaction
+	scanner := bufio.NewScanner(export("?format=ndjson&username=alice&from=" + from.Format(time.RFC3339)))
+	for scanner.Scan() {
+		var transaction Transaction
+		if err := json.Unmarshal(scanner.Bytes(), &transaction); err != nil {
+			t.Fatalf("decoding %s: %v", scanner.Text(), err)
+		}
+		exported = append(exported, transaction)
+	}
+	if len(exported) != 3 || exported[0].Amount != 100 || exported[1].Type != transactionTypeWithdraw ||
+		exported[2].Amount != 5 {
+		t.Fatalf("ndjson export = %+v, want alice's deposit, withdrawal and deposit", exported)
+	}
+
+	for _, query := range []string{"?format=xml", "?type=gift", "?from=yesterday"} {
+		recorder := server.request(http.MethodGet, "/transactions/export"+query, nil, "Authorization", "Bearer secret")
+		expectStatus(t, recorder, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BalanceExtreme is one end of the balance range: a representative account
+// and how many accounts share its balance.
+type BalanceExtreme struct {
+	Account BankAccount `json:"account"`
+	Count   int64       `json:"count"`
+}
+
+type Extremes struct {
+	TotalAccounts int64           `json:"totalAccounts"`
+	Highest       *BalanceExtreme `json:"highest,omitempty"`
+	Lowest        *BalanceExtreme `json:"lowest,omitempty"`
+}
+
+// findBalanceExtreme reads the first account in the given balance order.
+// The sort walks the balance index, forwards or backwards, so only one
+// document is examined.
+func findBalanceExtreme(ctx context.Context, accountCollection *mongo.Collection, direction int) (*BalanceExtreme, error) {
+	var account BankAccount
+	err := accountCollection.FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{
+		{Key: "balance", Value: direction}, {Key: "username", Value: direction},
+	})).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	count, err := accountCollection.CountDocuments(ctx, bson.D{{Key: "balance", Value: account.Balance}})
+	if err != nil {
+		return nil, err
+	}
+	return &BalanceExtreme{Account: account, Count: count}, nil
+}
+
+func getExtremesHandler(accountCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		var extremes Extremes
+		var err error
+		if extremes.TotalAccounts, err = accountCollection.EstimatedDocumentCount(ctx.Request.Context()); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if extremes.Highest, err = findBalanceExtreme(ctx.Request.Context(), accountCollection, -1); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if extremes.Lowest, err = findBalanceExtreme(ctx.Request.Context(), accountCollection, 1); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		respond(ctx, http.StatusOK, extremes)
+	}
+}
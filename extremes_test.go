@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetExtremes(t *testing.T) {
+	server := newTestServer(t)
+
+	recorder := server.request(http.MethodGet, "/system/extremes", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if extremes := decodeResponse[Extremes](t, recorder); extremes.TotalAccounts != 0 ||
+		extremes.Highest != nil || extremes.Lowest != nil {
+		t.Fatalf("extremes of no accounts = %+v", extremes)
+	}
+
+	for _, userName := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		server.createAccount(userName)
+	}
+	server.deposit("alice", 100)
+	server.deposit("bob", 100)
+	server.deposit("carol", 5)
+	server.withdraw("dave", 20)
+
+	recorder = server.request(http.MethodGet, "/system/extremes", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	extremes := decodeResponse[Extremes](t, recorder)
+	if extremes.TotalAccounts != 5 || extremes.Highest == nil || extremes.Lowest == nil {
+		t.Fatalf("extremes = %+v", extremes)
+	}
+	// Ties go to the last username at the top and the first at the bottom,
+	// the order the balance index is walked in.
+	if highest := extremes.Highest; highest.Account.UserName != "bob" || highest.Account.Balance != 100 ||
+		highest.Count != 2 {
+		t.Fatalf("highest = %+v, want bob with 100 shared by 2 accounts", highest)
+	}
+	if lowest := extremes.Lowest; lowest.Account.UserName != "dave" || lowest.Account.Balance != 0 ||
+		lowest.Count != 2 {
+		t.Fatalf("lowest = %+v, want dave with 0 shared by 2 accounts", lowest)
+	}
+}
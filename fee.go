@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	feePayerSource = "source"
+	feePayerTarget = "target"
+)
+
+// TransferFee is either a flat amount or a percentage of the transferred
+// amount, configured as e.g. TRANSFER_FEE=5 or TRANSFER_FEE=1.5%.
+type TransferFee struct {
+	Flat    int
+	Percent float64
+}
+
+func (fee TransferFee) IsZero() bool {
+	return fee.Flat == 0 && fee.Percent == 0
+}
+
+func (fee TransferFee) For(amount int) int {
+	if fee.Percent > 0 {
+		return int(math.Round(float64(amount) * fee.Percent / 100))
+	}
+	return fee.Flat
+}
+
+func envTransferFee(name string) (TransferFee, error) {
+	rawValue := strings.TrimSpace(envString(name, ""))
+	if rawValue == "" {
+		return TransferFee{}, nil
+	}
+	if rawPercent := strings.TrimSuffix(rawValue, "%"); rawPercent != rawValue {
+		percent, err := strconv.ParseFloat(rawPercent, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return TransferFee{}, &ErrInvalidConfig{Name: name, Value: rawValue}
+		}
+		return TransferFee{Percent: percent}, nil
+	}
+	flat, err := strconv.Atoi(rawValue)
+	if err != nil || flat < 0 {
+		return TransferFee{}, &ErrInvalidConfig{Name: name, Value: rawValue}
+	}
+	return TransferFee{Flat: flat}, nil
+}
+
+type ErrFeeExceedsAmount struct {
+	Fee    int
+	Amount int
+}
+
+func (err *ErrFeeExceedsAmount) Code() string {
+	return "ErrFeeExceedsAmount"
+}
+
+func (err *ErrFeeExceedsAmount) messageArgs() []any {
+	return []any{err.Fee, err.Amount}
+}
+
+func (err *ErrFeeExceedsAmount) Error() string {
+	return localizeError(defaultLanguage, err)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestTransferFeeFor(t *testing.T) {
+	tests := []struct {
+		fee    TransferFee
+		amount int
+		want   int
+	}{
+		{fee: TransferFee{}, amount: 100, want: 0},
+		{fee: TransferFee{Flat: 5}, amount: 100, want: 5},
+		{fee: TransferFee{Flat: 5}, amount: 1, want: 5},
+		{fee: TransferFee{Percent: 1.5}, amount: 200, want: 3},
+		{fee: TransferFee{Percent: 1.5}, amount: 30, want: 0},
+		{fee: TransferFee{Percent: 1.5}, amount: 100, want: 2},
+		{fee: TransferFee{Percent: 100}, amount: 40, want: 40},
+	}
+	for _, test := range tests {
+		if fee := test.fee.For(test.amount); fee != test.want {
+			t.Errorf("%+v.For(%d) = %d, want %d", test.fee, test.amount, fee, test.want)
+		}
+	}
+}
+
+func TestEnvTransferFee(t *testing.T) {
+	tests := []struct {
+		value string
+		fee   TransferFee
+		valid bool
+	}{
+		{value: "", valid: true},
+		{value: "5", fee: TransferFee{Flat: 5}, valid: true},
+		{value: " 1.5% ", fee: TransferFee{Percent: 1.5}, valid: true},
+		{value: "100%", fee: TransferFee{Percent: 100}, valid: true},
+		{value: "-1"},
+		{value: "101%"},
+		{value: "1.5"},
+		{value: "five%"},
+	}
+	for _, test := range tests {
+		t.Setenv("TRANSFER_FEE", test.value)
+		fee, err := envTransferFee("TRANSFER_FEE")
+		var configError *ErrInvalidConfig
+		switch {
+		case test.valid && (err != nil || fee != test.fee):
+			t.Errorf("TRANSFER_FEE=%q: %+v, %v, want %+v", test.value, fee, err, test.fee)
+		case !test.valid && !errors.As(err, &configError):
+			t.Errorf("TRANSFER_FEE=%q: error = %v, want ErrInvalidConfig", test.value, err)
+		}
+	}
+}
+
+func feeServer(t *testing.T, payer string) *testServer {
+	server := newTestServer(t, func(config *Config) {
+		config.TransferFee = TransferFee{Flat: 5}
+		config.TransferFeePayer = payer
+		config.FeeAccount = "bank"
+	})
+	server.createAccount("bank")
+	server.createAccount("alice")
+	server.createAccount("bob")
+	return server
+}
+
+func TestTransferFee(t *testing.T) {
+	server := feeServer(t, feePayerSource)
+	server.deposit("alice", 100)
+	server.transfer("alice", "bob", 50)
+
+	alice, bob, bank := server.account("alice"), server.account("bob"), server.account("bank")
+	if alice.Balance != 45 || bob.Balance != 50 || bank.Balance != 5 {
+		t.Fatalf("balances alice %d, bob %d, bank %d; want 45, 50 and 5", alice.Balance, bob.Balance, bank.Balance)
+	}
+	aliceHistory, bankHistory := server.history("alice"), server.history("bank")
+	if fee := aliceHistory[len(aliceHistory)-1]; fee.Type != transactionTypeFee || fee.Amount != 5 ||
+		fee.Counterparty != "bank" {
+		t.Fatalf("last alice entry = %+v, want a fee of 5", fee)
+	}
+	if income := bankHistory[len(bankHistory)-1]; income.Type != transactionTypeFeeIncome || income.Amount != 5 ||
+		income.Counterparty != "alice" {
+		t.Fatalf("last bank entry = %+v, want fee income of 5", income)
+	}
+
+	// 42 is covered but 42 plus the fee is not.
+	recorder := server.request(http.MethodPost, "/transfer?strict=true",
+		TransferNote{FromUser: "alice", ToUser: "bob", Amount: 42})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInsufficientFunds")
+	if alice, bank := server.account("alice"), server.account("bank"); alice.Balance != 45 || bank.Balance != 5 {
+		t.Fatalf("after refused transfer: alice %+v, bank %+v", alice, bank)
+	}
+}
+
+func TestTransferFeePaidByTarget(t *testing.T) {
+	server := feeServer(t, feePayerTarget)
+	server.deposit("alice", 100)
+	server.transfer("alice", "bob", 50)
+
+	alice, bob, bank := server.account("alice"), server.account("bob"), server.account("bank")
+	if alice.Balance != 50 || bob.Balance != 45 || bank.Balance != 5 {
+		t.Fatalf("balances alice %d, bob %d, bank %d; want 50, 45 and 5", alice.Balance, bob.Balance, bank.Balance)
+	}
+
+	recorder := server.request(http.MethodPost, "/transfer", TransferNote{FromUser: "alice", ToUser: "bob", Amount: 4})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrFeeExceedsAmount")
+}
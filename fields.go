@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const accountFieldsKey = "accountFields"
+
+// accountFieldNames are the JSON names of the BankAccount fields a read may
+// select with ?fields=. Their stored names are the same in lowercase.
+var accountFieldNames = map[string]bool{
+	"username":             true,
+	"balance":              true,
+	"debt":                 true,
+	"held":                 true,
+	"email":                true,
+	"emailVerified":        true,
+	"closed":               true,
+	"frozen":               true,
+	"locked":               true,
+	"lockReason":           true,
+	"lockedBy":             true,
+	"updatedAt":            true,
+	"createdAt":            true,
+	"accountNumber":        true,
+	"lowBalanceThreshold":  true,
+	"highBalanceThreshold": true,
+	"balanceAlertState":    true,
+	"debtRepaymentPolicy":  true,
+	"allowNegative":        true,
+	"metadata":             true,
+}
+
+// parseFieldList splits a comma separated list of account fields, accepting
+// them in any naming style. The first unknown name is returned as invalid.
+func parseFieldList(rawFields string) ([]string, string) {
+	var fields []string
+	for _, rawField := range strings.Split(rawFields, ",") {
+		rawField = strings.TrimSpace(rawField)
+		if rawField == "" {
+			continue
+		}
+		field := camelFieldName(camelFromSnake(rawField))
+		if !accountFieldNames[field] {
+			return nil, rawField
+		}
+		fields = append(fields, field)
+	}
+	return fields, ""
+}
+
+// parseAccountFields reads ?fields=, falling back to ACCOUNT_DEFAULT_FIELDS,
+// and marks the request so respond keeps only those fields of each account.
+// No fields means whole accounts.
+func parseAccountFields(ctx *gin.Context, config *Config) ([]string, error) {
+	fields := config.DefaultAccountFields
+	if rawFields, ok := ctx.GetQuery("fields"); ok {
+		var invalidField string
+		if fields, invalidField = parseFieldList(rawFields); invalidField != "" {
+			return nil, &ErrInvalidQueryParam{Name: "fields", Value: invalidField}
+		}
+	}
+	if len(fields) > 0 {
+		ctx.Set(accountFieldsKey, fields)
+	}
+	return fields, nil
+}
+
+// accountProjection is the MongoDB projection loading only fields, or nil
+// to load whole documents.
+func accountProjection(fields []string) bson.D {
+	if len(fields) == 0 {
+		return nil
+	}
+	projection := bson.D{}
+	for _, field := range fields {
+		projection = append(projection, bson.E{Key: strings.ToLower(field), Value: 1})
+	}
+	return projection
+}
+
+// withAccountFields returns the JSON form of data keeping only the selected
+// fields of every account in it. Accounts are the objects with a username;
+// the page around them is kept whole.
+func withAccountFields(data any, fields []string) any {
+	document, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("failed to select account fields: %v", err)
+		return data
+	}
+	generic, err := decodeGeneric(document)
+	if err != nil {
+		log.Printf("failed to select account fields: %v", err)
+		return data
+	}
+	selected := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		selected[field] = true
+	}
+	keepAccountFields(generic, selected)
+	return generic
+}
+
+func keepAccountFields(value any, selected map[string]bool) {
+	switch typedValue := value.(type) {
+	case map[string]any:
+		if _, isAccount := typedValue["username"]; isAccount {
+			for name := range typedValue {
+				if !selected[name] {
+					delete(typedValue, name)
+				}
+			}
+			return
+		}
+		for _, nested := range typedValue {
+			keepAccountFields(nested, selected)
+		}
+	case []any:
+		for _, nested := range typedValue {
+			keepAccountFields(nested, selected)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseFieldList(t *testing.T) {
+	for _, test := range []struct {
+		rawFields string
+		fields    []string
+		invalid   string
+	}{
+		{rawFields: "", fields: nil},
+		{rawFields: "username,balance", fields: []string{"username", "balance"}},
+		{rawFields: " username , email_verified,", fields: []string{"username", "emailVerified"}},
+		{rawFields: "username,password", invalid: "password"},
+		{rawFields: "id", invalid: "id"},
+	} {
+		fields, invalid := parseFieldList(test.rawFields)
+		if invalid != test.invalid || !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("parseFieldList(%q) = %q, %q, want %q, %q",
+				test.rawFields, fields, invalid, test.fields, test.invalid)
+		}
+	}
+}
+
+func TestAccountProjection(t *testing.T) {
+	if projection := accountProjection(nil); projection != nil {
+		t.Fatalf("projection of no fields = %v, want nil", projection)
+	}
+	want := bson.D{{Key: "username", Value: 1}, {Key: "emailverified", Value: 1}}
+	if projection := accountProjection([]string{"username", "emailVerified"}); !reflect.DeepEqual(projection, want) {
+		t.Fatalf("projection = %v, want %v", projection, want)
+	}
+}
+
+// objectKeys returns the sorted keys of a decoded JSON object.
+func objectKeys(object map[string]any) []string {
+	var keys []string
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestWithAccountFields(t *testing.T) {
+	page := AccountPage{
+		Accounts:   []BankAccount{{UserName: "alice", Balance: 10}, {UserName: "bob", Debt: 5}},
+		Total:      2,
+		Pagination: Pagination{Page: 1, Limit: 10},
+	}
+	selected := withAccountFields(page, []string{"username", "balance"}).(map[string]any)
+	if keys := objectKeys(selected); !reflect.DeepEqual(keys, []string{"accounts", "limit", "page", "total"}) {
+		t.Fatalf("page keys = %v, want the page kept whole", keys)
+	}
+	for _, account := range selected["accounts"].([]any) {
+		if keys := objectKeys(account.(map[string]any)); !reflect.DeepEqual(keys, []string{"balance", "username"}) {
+			t.Fatalf("account keys = %v, want [balance username]", keys)
+		}
+	}
+}
+
+func TestAccountFields(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.deposit("alice", 40)
+
+	recorder := server.request(http.MethodGet, "/account?fields=username,balance", BankAccount{UserName: "alice"})
+	expectStatus(t, recorder, http.StatusOK)
+	account := decodeResponse[map[string]any](t, recorder)
+	if keys := objectKeys(account); !reflect.DeepEqual(keys, []string{"balance", "username"}) {
+		t.Fatalf("account keys = %v, want [balance username]", keys)
+	}
+	if account["username"] != "alice" || account["balance"] != float64(40) {
+		t.Fatalf("account = %v", account)
+	}
+
+	recorder = server.request(http.MethodGet, "/account/all?fields=username", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	for _, listed := range decodeResponse[[]map[string]any](t, recorder) {
+		if keys := objectKeys(listed); !reflect.DeepEqual(keys, []string{"username"}) {
+			t.Fatalf("listed account keys = %v, want [username]", keys)
+		}
+	}
+
+	recorder = server.request(http.MethodGet, "/account/all?fields=username,password", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidQueryParam")
+}
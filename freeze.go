@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxFreezeBatchSize = 1000
+
+type ErrAccountFrozen struct {
+	UserName string
+}
+
+func (err *ErrAccountFrozen) Code() string {
+	return "ErrAccountFrozen"
+}
+
+func (err *ErrAccountFrozen) messageArgs() []any {
+	return []any{err.UserName}
+}
+
+func (err *ErrAccountFrozen) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrAccountFrozen) Status() int {
+	return http.StatusForbidden
+}
+
+type FreezeBatchInput struct {
+	UserNames []string `json:"usernames"`
+}
+
+func (input *FreezeBatchInput) Error() error {
+	var validationErrors MultiError
+	if len(input.UserNames) == 0 || len(input.UserNames) > maxFreezeBatchSize {
+		validationErrors.Add("usernames", &ErrBatchSize{Max: maxFreezeBatchSize})
+	}
+	for _, userName := range input.UserNames {
+		if !isUsernameValid(userName) {
+			validationErrors.Add("usernames", &ErrInvalidUsername{UserName: userName})
+		}
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+func (input *FreezeBatchInput) normalizeUsernames() {
+	for i := range input.UserNames {
+		input.UserNames[i] = normalizeUsername(input.UserNames[i])
+	}
+}
+
+type FreezeBatchResult struct {
+	ModifiedCount int64    `json:"modifiedCount"`
+	NotFound      []string `json:"notFound"`
+}
+
+// freezeAccountsBatchHandler freezes every listed account with one
+// UpdateMany. Already frozen accounts are not counted as modified.
+func freezeAccountsBatchHandler(accountRepository *AccountRepository) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		freezeInput, ok := bindAndValidate[FreezeBatchInput](ctx)
+		if !ok {
+			return
+		}
+
+		accountCollection := accountRepository.Collection()
+		userNameFilter := bson.D{{Key: "username", Value: bson.D{{Key: "$in", Value: freezeInput.UserNames}}}}
+
+		existingSearchResult, err := accountCollection.Find(ctx.Request.Context(), userNameFilter,
+			options.Find().SetProjection(bson.D{{Key: "username", Value: 1}}))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		var existingAccounts []BankAccount
+		if err := existingSearchResult.All(ctx.Request.Context(), &existingAccounts); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		existingUserNames := make(map[string]bool, len(existingAccounts))
+		for _, account := range existingAccounts {
+			existingUserNames[account.UserName] = true
+		}
+
+		updateResult, err := accountCollection.UpdateMany(ctx.Request.Context(), append(userNameFilter,
+			bson.E{Key: "frozen", Value: bson.D{{Key: "$ne", Value: true}}},
+		), bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "frozen", Value: true},
+				{Key: "updatedat", Value: accountRepository.Clock().Now().UTC()},
+			}},
+			incrementVersion,
+		})
+		accountRepository.Invalidate(freezeInput.UserNames...)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		freezeResult := FreezeBatchResult{ModifiedCount: updateResult.ModifiedCount, NotFound: []string{}}
+		for _, userName := range freezeInput.UserNames {
+			if !existingUserNames[userName] {
+				freezeResult.NotFound = append(freezeResult.NotFound, userName)
+				existingUserNames[userName] = true
+			}
+		}
+
+		respond(ctx, http.StatusOK, freezeResult)
+	}
+}
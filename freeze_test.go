@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFreezeAccountsBatch(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	for _, userName := range []string{"alice", "bob", "carol", "dave"} {
+		server.createAccount(userName)
+	}
+	recorder := server.request(http.MethodPost, "/account/freeze/batch", FreezeBatchInput{UserNames: []string{"bob"}},
+		"Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+
+	recorder = server.request(http.MethodPost, "/account/freeze/batch", FreezeBatchInput{UserNames: []string{"alice"}})
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+	if server.account("alice").Frozen {
+		t.Fatal("unauthorized batch froze alice")
+	}
+
+	// bob is already frozen and so is not counted; ghost is reported once
+	// even though it is listed twice.
+	recorder = server.request(http.MethodPost, "/account/freeze/batch",
+		FreezeBatchInput{UserNames: []string{"alice", "ghost", "bob", "ghost", "carol", "nobody"}},
+		"Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	result := decodeResponse[FreezeBatchResult](t, recorder)
+	if result.ModifiedCount != 2 || !reflect.DeepEqual(result.NotFound, []string{"ghost", "nobody"}) {
+		t.Fatalf("result = %+v, want 2 modified and [ghost nobody] not found", result)
+	}
+	for userName, wantFrozen := range map[string]bool{"alice": true, "bob": true, "carol": true, "dave": false} {
+		if frozen := server.account(userName).Frozen; frozen != wantFrozen {
+			t.Errorf("%s frozen = %v, want %v", userName, frozen, wantFrozen)
+		}
+	}
+
+	recorder = server.request(http.MethodPost, "/account/freeze/batch", FreezeBatchInput{UserNames: []string{"dave"}},
+		"Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	if result := decodeResponse[FreezeBatchResult](t, recorder); result.ModifiedCount != 1 || len(result.NotFound) != 0 {
+		t.Fatalf("result = %+v, want 1 modified and none missing", result)
+	}
+
+	recorder = server.request(http.MethodPost, "/account/freeze/batch", FreezeBatchInput{},
+		"Authorization", "Bearer secret")
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrBatchSize")
+}
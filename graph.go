@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultGraphDepth = 2
+	maxGraphDepth     = 4
+	maxGraphNodes     = 200
+)
+
+type TransferGraphNode struct {
+	UserName string `json:"username"`
+	// Distance is the number of transfer hops from the seed account.
+	Distance int `json:"distance"`
+}
+
+// TransferGraphEdge aggregates every transfer from one account to another.
+type TransferGraphEdge struct {
+	FromUser string `json:"fromUser"`
+	ToUser   string `json:"toUser"`
+	Count    int64  `json:"count"`
+	Total    int64  `json:"total"`
+}
+
+type TransferGraph struct {
+	UserName string              `json:"username"`
+	Depth    int64               `json:"depth"`
+	Nodes    []TransferGraphNode `json:"nodes"`
+	Edges    []TransferGraphEdge `json:"edges"`
+	// Truncated is set when the graph reached maxGraphNodes and accounts
+	// further out were left off.
+	Truncated bool `json:"truncated"`
+}
+
+// findTransferEdges aggregates the transfers sent or received by any of the
+// given accounts. Only the sending side is read, since every transfer
+// records one transfer-out entry naming its counterparty.
+func findTransferEdges(
+	ctx context.Context, transactionCollection *mongo.Collection, userNames []string,
+) ([]TransferGraphEdge, error) {
+	edgeTotals, err := transactionCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "type", Value: transactionTypeTransferOut},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "username", Value: bson.D{{Key: "$in", Value: userNames}}}},
+				bson.D{{Key: "counterparty", Value: bson.D{{Key: "$in", Value: userNames}}}},
+			}},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "from", Value: "$username"}, {Key: "to", Value: "$counterparty"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "total", Value: bson.D{{Key: "$sum", Value: "$amount"}}},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var totals []struct {
+		ID struct {
+			From string
+			To   string
+		} `bson:"_id"`
+		Count int64
+		Total int64
+	}
+	if err := edgeTotals.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+	edges := make([]TransferGraphEdge, 0, len(totals))
+	for _, total := range totals {
+		edges = append(edges, TransferGraphEdge{
+			FromUser: total.ID.From,
+			ToUser:   total.ID.To,
+			Count:    total.Count,
+			Total:    total.Total,
+		})
+	}
+	return edges, nil
+}
+
+// getTransferGraphHandler walks the accounts connected to ?username= by
+// transfers in either direction, one hop per query, up to ?depth= hops
+// (default 2, at most 4). The walk stops adding accounts at
+// maxGraphNodes, so a hub account cannot blow it up.
+func getTransferGraphHandler(transactionCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameQuery(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+		depth, err := parsePositiveQuery(ctx, "depth", defaultGraphDepth)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if depth > maxGraphDepth {
+			depth = maxGraphDepth
+		}
+
+		graph := TransferGraph{UserName: userName, Depth: depth}
+		distances := map[string]int{userName: 0}
+		edges := map[[2]string]TransferGraphEdge{}
+		frontier := []string{userName}
+		for distance := 1; distance <= int(depth) && len(frontier) > 0; distance++ {
+			foundEdges, err := findTransferEdges(ctx.Request.Context(), transactionCollection, frontier)
+			if err != nil {
+				sendError(ctx, err)
+				return
+			}
+			var nextFrontier []string
+			for _, edge := range foundEdges {
+				for _, neighbour := range []string{edge.FromUser, edge.ToUser} {
+					if _, seen := distances[neighbour]; seen {
+						continue
+					}
+					if len(distances) >= maxGraphNodes {
+						graph.Truncated = true
+						continue
+					}
+					distances[neighbour] = distance
+					nextFrontier = append(nextFrontier, neighbour)
+				}
+				edges[[2]string{edge.FromUser, edge.ToUser}] = edge
+			}
+			frontier = nextFrontier
+		}
+
+		graph.Nodes = make([]TransferGraphNode, 0, len(distances))
+		for nodeName, distance := range distances {
+			graph.Nodes = append(graph.Nodes, TransferGraphNode{UserName: nodeName, Distance: distance})
+		}
+		sort.Slice(graph.Nodes, func(i, j int) bool {
+			if graph.Nodes[i].Distance != graph.Nodes[j].Distance {
+				return graph.Nodes[i].Distance < graph.Nodes[j].Distance
+			}
+			return graph.Nodes[i].UserName < graph.Nodes[j].UserName
+		})
+		graph.Edges = make([]TransferGraphEdge, 0, len(edges))
+		for _, edge := range edges {
+			_, fromKept := distances[edge.FromUser]
+			_, toKept := distances[edge.ToUser]
+			if fromKept && toKept {
+				graph.Edges = append(graph.Edges, edge)
+			}
+		}
+		sort.Slice(graph.Edges, func(i, j int) bool {
+			if graph.Edges[i].FromUser != graph.Edges[j].FromUser {
+				return graph.Edges[i].FromUser < graph.Edges[j].FromUser
+			}
+			return graph.Edges[i].ToUser < graph.Edges[j].ToUser
+		})
+
+		respond(ctx, http.StatusOK, graph)
+	}
+}
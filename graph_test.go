@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestTransferGraph(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	for _, userName := range []string{"alice", "bob", "carol", "dave", "erin", "frank", "gina"} {
+		server.createAccount(userName)
+		server.deposit(userName, 100)
+	}
+	server.transfer("alice", "bob", 30)
+	server.transfer("alice", "bob", 20)
+	server.transfer("carol", "alice", 10)
+	server.transfer("bob", "dave", 5)
+	server.transfer("dave", "erin", 5)
+	server.transfer("frank", "gina", 5)
+
+	graph := func(query string) TransferGraph {
+		t.Helper()
+		recorder := server.request(http.MethodGet, "/admin/transfer-graph"+query, nil, "Authorization", "Bearer secret")
+		expectStatus(t, recorder, http.StatusOK)
+		return decodeResponse[TransferGraph](t, recorder)
+	}
+	aliceToBob := TransferGraphEdge{FromUser: "alice", ToUser: "bob", Count: 2, Total: 50}
+	carolToAlice := TransferGraphEdge{FromUser: "carol", ToUser: "alice", Count: 1, Total: 10}
+	bobToDave := TransferGraphEdge{FromUser: "bob", ToUser: "dave", Count: 1, Total: 5}
+	daveToErin := TransferGraphEdge{FromUser: "dave", ToUser: "erin", Count: 1, Total: 5}
+	fullNodes := []TransferGraphNode{
+		{UserName: "alice"}, {UserName: "bob", Distance: 1}, {UserName: "carol", Distance: 1},
+		{UserName: "dave", Distance: 2}, {UserName: "erin", Distance: 3},
+	}
+	for _, test := range []struct {
+		query string
+		depth int64
+		nodes []TransferGraphNode
+		edges []TransferGraphEdge
+	}{
+		{query: "?username=alice&depth=1", depth: 1, nodes: fullNodes[:3],
+			edges: []TransferGraphEdge{aliceToBob, carolToAlice}},
+		{query: "?username=alice", depth: defaultGraphDepth, nodes: fullNodes[:4],
+			edges: []TransferGraphEdge{aliceToBob, bobToDave, carolToAlice}},
+		{query: "?username=alice&depth=100", depth: maxGraphDepth, nodes: fullNodes,
+			edges: []TransferGraphEdge{aliceToBob, bobToDave, carolToAlice, daveToErin}},
+		{query: "?username=gina", depth: defaultGraphDepth,
+			nodes: []TransferGraphNode{{UserName: "gina"}, {UserName: "frank", Distance: 1}},
+			edges: []TransferGraphEdge{{FromUser: "frank", ToUser: "gina", Count: 1, Total: 5}}},
+	} {
+		found := graph(test.query)
+		if found.Depth != test.depth || found.Truncated {
+			t.Errorf("%s: depth %d, truncated %v, want depth %d", test.query, found.Depth, found.Truncated, test.depth)
+		}
+		if !reflect.DeepEqual(found.Nodes, test.nodes) {
+			t.Errorf("%s: nodes = %+v, want %+v", test.query, found.Nodes, test.nodes)
+		}
+		if !reflect.DeepEqual(found.Edges, test.edges) {
+			t.Errorf("%s: edges = %+v, want %+v", test.query, found.Edges, test.edges)
+		}
+	}
+
+	recorder := server.request(http.MethodGet, "/admin/transfer-graph?username=alice&depth=0", nil,
+		"Authorization", "Bearer secret")
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidQueryParam")
+}
+
+func TestTransferGraphNodeCap(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	for i := 0; i < maxGraphNodes+10; i++ {
+		transaction := newTransaction(server.clock, BankAccount{UserName: "hub"}, transactionTypeTransferOut, 1)
+		transaction.Counterparty = fmt.Sprintf("spoke%03d", i)
+		if _, err := insertTransaction(context.Background(), server.transactions, transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := server.request(http.MethodGet, "/admin/transfer-graph?username=hub", nil, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	graph := decodeResponse[TransferGraph](t, recorder)
+	if !graph.Truncated || len(graph.Nodes) != maxGraphNodes || len(graph.Edges) != maxGraphNodes-1 {
+		t.Fatalf("graph has %d nodes and %d edges, truncated %v; want %d nodes, %d edges, truncated",
+			len(graph.Nodes), len(graph.Edges), graph.Truncated, maxGraphNodes, maxGraphNodes-1)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The integration tests drive the real router against a throwaway MongoDB
+// replica set; transactions need one. MONGODB_URI points them at an
+// existing deployment, otherwise a container is started with Docker. When
+// neither is available the tests that need a database are skipped and the
+// pure unit tests still run.
+
+const (
+	testMongoImage      = "mongo:6.0"
+	testMongoStartup    = 2 * time.Minute
+	testReplicaSetName  = "rs0"
+	errAlreadyInitiated = 23
+)
+
+var (
+	testClient        *mongo.Client
+	testDatabaseCount atomic.Int64
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	uri, stop, err := startTestMongo()
+	if err != nil {
+		log.Printf("integration tests will be skipped: %v", err)
+		return m.Run()
+	}
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testMongoStartup)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err == nil {
+		err = waitForPrimary(ctx, client)
+	}
+	if err != nil {
+		log.Printf("integration tests will be skipped: %v", err)
+		return m.Run()
+	}
+	defer client.Disconnect(context.Background())
+
+	testClient = client
+	return m.Run()
+}
+
+// startTestMongo returns the URI of the database to test against and a
+// function that disposes of it.
+func startTestMongo() (string, func(), error) {
+	if uri := os.Getenv("MONGODB_URI"); uri != "" {
+		return uri, func() {}, nil
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", nil, errors.New("MONGODB_URI is not set and docker is not installed")
+	}
+
+	output, err := exec.Command("docker", "run", "--detach", "--rm", "--publish", "127.0.0.1::27017",
+		testMongoImage, "--replSet", testReplicaSetName, "--bind_ip_all").Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("starting %s: %w", testMongoImage, err)
+	}
+	containerID := strings.TrimSpace(string(output))
+	stop := func() {
+		if err := exec.Command("docker", "rm", "--force", containerID).Run(); err != nil {
+			log.Printf("removing MongoDB container %s: %v", containerID, err)
+		}
+	}
+
+	output, err = exec.Command("docker", "port", containerID, "27017/tcp").Output()
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("reading the MongoDB container port: %w", err)
+	}
+	address := strings.TrimSpace(strings.Split(string(output), "\n")[0])
+	uri := "mongodb://" + address + "/?directConnection=true"
+	if err := initiateReplicaSet(uri); err != nil {
+		stop()
+		return "", nil, err
+	}
+	return uri, stop, nil
+}
+
+// initiateReplicaSet turns the fresh container into a single-member replica
+// set, retrying until mongod accepts connections.
+func initiateReplicaSet(uri string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), testMongoStartup)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	initiate := bson.D{{Key: "replSetInitiate", Value: bson.D{
+		{Key: "_id", Value: testReplicaSetName},
+		{Key: "members", Value: bson.A{bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: "localhost:27017"}}}},
+	}}}
+	for {
+		err := client.Database("admin").RunCommand(ctx, initiate).Err()
+		var commandError mongo.CommandError
+		if err == nil || errors.As(err, &commandError) && commandError.Code == errAlreadyInitiated {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("initiating the test replica set: %w", err)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func waitForPrimary(ctx context.Context, client *mongo.Client) error {
+	for {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err == nil && hello.IsWritablePrimary {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for a writable primary: %w", ctx.Err())
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(duration)
+}
+
+// recordingPublisher keeps every published event for assertions.
+type recordingPublisher struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (publisher *recordingPublisher) Publish(event Event) {
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+	publisher.events = append(publisher.events, event)
+}
+
+func (publisher *recordingPublisher) Events(eventType string) []Event {
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+	var events []Event
+	for _, event := range publisher.events {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// newTestConfig loads the default configuration with a fake clock set to a
+// fixed instant.
+func newTestConfig(t *testing.T) (*Config, *fakeClock) {
+	t.Helper()
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	config.Clock = clock
+	return config, clock
+}
+
+// useProcessConfig applies the settings main copies into package variables
+// and restores the previous values when the test ends.
+func useProcessConfig(t *testing.T, config *Config) {
+	t.Helper()
+	previousNormalization, previousPrecisionGuard, previousEncryption :=
+		usernameNormalization, amountPrecisionGuard, fieldEncryption
+	t.Cleanup(func() {
+		usernameNormalization, amountPrecisionGuard, fieldEncryption =
+			previousNormalization, previousPrecisionGuard, previousEncryption
+	})
+	if err := applyProcessConfig(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testServer struct {
+	t            *testing.T
+	config       *Config
+	clock        *fakeClock
+	database     *mongo.Database
+	accounts     *AccountRepository
+	transactions *mongo.Collection
+	scheduled    *mongo.Collection
+	holds        *mongo.Collection
+	pending      *mongo.Collection
+	executed     *mongo.Collection
+	webhooks     *mongo.Collection
+	deliveries   *mongo.Collection
+	events       *recordingPublisher
+	router       *gin.Engine
+}
+
+// newTestServer wires the real router against a fresh database, which is
+// dropped when the test ends. configure adjusts the configuration first.
+func newTestServer(t *testing.T, configure ...func(config *Config)) *testServer {
+	t.Helper()
+	if testClient == nil {
+		t.Skip("no MongoDB available: set MONGODB_URI or install Docker")
+	}
+	config, clock := newTestConfig(t)
+	for _, apply := range configure {
+		apply(config)
+	}
+	useProcessConfig(t, config)
+
+	database := testClient.Database(fmt.Sprintf("goDatabaseTest%d_%d", os.Getpid(), testDatabaseCount.Add(1)))
+	t.Cleanup(func() {
+		if err := database.Drop(context.Background()); err != nil {
+			t.Logf("dropping %s: %v", database.Name(), err)
+		}
+	})
+
+	server := &testServer{
+		t:            t,
+		config:       config,
+		clock:        clock,
+		database:     database,
+		transactions: database.Collection("Transactions"),
+		scheduled:    database.Collection("ScheduledTransfers"),
+		holds:        database.Collection("Holds"),
+		pending:      database.Collection("PendingTransfers"),
+		executed:     database.Collection("ExecutedTransfers"),
+		webhooks:     database.Collection("Webhooks"),
+		deliveries:   database.Collection("WebhookDeliveries"),
+		events:       &recordingPublisher{},
+	}
+	accountCollection := database.Collection("BankAccount")
+	for _, err := range []error{
+		ensureAccountIndexes(accountCollection),
+		ensureTransactionIndexes(server.transactions),
+		ensureScheduledTransferIndexes(server.scheduled),
+		ensurePendingTransferIndexes(server.pending),
+		ensureExecutedTransferIndexes(server.executed, config),
+		ensureWebhookIndexes(server.webhooks, server.deliveries),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.accounts = newAccountRepository(accountCollection, config)
+	server.router = newRouter(server.accounts, server.transactions, server.scheduled, server.holds,
+		server.pending, server.executed, config, server.events, nil, newRequestDrain())
+	return server
+}
+
+// request sends body as JSON, followed by header name and value pairs, and
+// returns the recorded response.
+func (server *testServer) request(method, target string, body any, header ...string) *httptest.ResponseRecorder {
+	server.t.Helper()
+	return serveRequest(server.t, server.router, method, target, body, header...)
+}
+
+func serveRequest(
+	t *testing.T, handler http.Handler, method, target string, body any, header ...string,
+) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		document, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(document)
+	}
+	request := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		request.Header.Set(header[i], header[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// expectStatus fails the test unless the response has the given status.
+func expectStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if recorder.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", recorder.Code, status, recorder.Body.String())
+	}
+}
+
+func decodeResponse[T any](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	if err := json.Unmarshal(recorder.Body.Bytes(), &value); err != nil {
+		t.Fatalf("decoding %s: %v", recorder.Body.String(), err)
+	}
+	return value
+}
+
+// expectErrorCode fails the test unless the response is an error with the
+// given status and catalog code.
+func expectErrorCode(t *testing.T, recorder *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	expectStatus(t, recorder, status)
+	if errorMessage := decodeResponse[JsonMessage](t, recorder); errorMessage.Code != code {
+		t.Fatalf("code = %q, want %q; body: %s", errorMessage.Code, code, recorder.Body.String())
+	}
+}
+
+func (server *testServer) createAccount(userName string) BankAccount {
+	server.t.Helper()
+	recorder := server.request(http.MethodPost, "/account/create", BankAccount{UserName: userName})
+	expectStatus(server.t, recorder, http.StatusCreated)
+	return decodeResponse[BankAccount](server.t, recorder)
+}
+
+func (server *testServer) deposit(userName string, amount int) {
+	server.t.Helper()
+	recorder := server.request(http.MethodPost, "/deposit", TransactionInput{UserName: userName, Amount: amount})
+	expectStatus(server.t, recorder, http.StatusOK)
+}
+
+func (server *testServer) withdraw(userName string, amount int) {
+	server.t.Helper()
+	recorder := server.request(http.MethodPost, "/withdraw", TransactionInput{UserName: userName, Amount: amount})
+	expectStatus(server.t, recorder, http.StatusOK)
+}
+
+func (server *testServer) transfer(fromUser, toUser string, amount int) {
+	server.t.Helper()
+	recorder := server.request(http.MethodPost, "/transfer",
+		TransferNote{FromUser: fromUser, ToUser: toUser, Amount: amount})
+	expectStatus(server.t, recorder, http.StatusOK)
+}
+
+// account reads the stored account, bypassing the cache.
+func (server *testServer) account(userName string) BankAccount {
+	server.t.Helper()
+	account, err := server.accounts.FindFreshByUsername(context.Background(), userName)
+	if err != nil {
+		server.t.Fatalf("reading account %s: %v", userName, err)
+	}
+	return account
+}
+
+// history returns the user's transactions, oldest first.
+func (server *testServer) history(userName string) []Transaction {
+	server.t.Helper()
+	cursor, err := server.transactions.Find(context.Background(), bson.D{{Key: "username", Value: userName}},
+		options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		server.t.Fatal(err)
+	}
+	var transactions []Transaction
+	if err := cursor.All(context.Background(), &transactions); err != nil {
+		server.t.Fatal(err)
+	}
+	return transactions
+}
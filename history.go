@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ErrAccountNotOpenedYet struct {
+	UserName string
+	AsOf     time.Time
+}
+
+func (err *ErrAccountNotOpenedYet) Code() string {
+	return "ErrAccountNotOpenedYet"
+}
+
+func (err *ErrAccountNotOpenedYet) messageArgs() []any {
+	return []any{err.UserName, err.AsOf.Format(time.RFC3339)}
+}
+
+func (err *ErrAccountNotOpenedYet) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrAccountNotOpenedYet) Status() int {
+	return http.StatusNotFound
+}
+
+type ErrHistoryPruned struct {
+	UserName string
+	AsOf     time.Time
+}
+
+func (err *ErrHistoryPruned) Code() string {
+	return "ErrHistoryPruned"
+}
+
+func (err *ErrHistoryPruned) messageArgs() []any {
+	return []any{err.UserName, err.AsOf.Format(time.RFC3339)}
+}
+
+func (err *ErrHistoryPruned) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrHistoryPruned) Status() int {
+	return http.StatusGone
+}
+
+// historyStartError explains why no history of userName exists up to asOf:
+// either the account did not exist yet or its history was pruned.
+func historyStartError(ctx context.Context, transactionCollection *mongo.Collection, userName string, asOf time.Time) error {
+	err := transactionCollection.FindOne(ctx, bson.D{
+		{Key: "username", Value: userName},
+		{Key: "type", Value: transactionTypeOpeningBalance},
+	}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &ErrAccountNotOpenedYet{UserName: userName, AsOf: asOf}
+	}
+	if err != nil {
+		return err
+	}
+	return &ErrHistoryPruned{UserName: userName, AsOf: asOf}
+}
+
+type AccountAsOf struct {
+	UserName string    `json:"username"`
+	Balance  int       `json:"balance"`
+	Debt     int       `json:"debt"`
+	AsOf     time.Time `json:"asOf"`
+}
+
+// applyTransaction replays a single history entry on top of the account,
+// following the same rules as the mutation handlers, including the debt
+// repayment policy recorded with each credit.
+func applyTransaction(account *BankAccount, transaction Transaction) {
+	if transaction.MergedFrom != "" {
+		return
+	}
+	switch transaction.Type {
+	case transactionTypeBonus, transactionTypeDeposit, transactionTypeTransferIn, transactionTypeFeeIncome:
+		account.Balance, account.Debt, _ = allocateCredit(
+			transaction.DebtRepaymentPolicy, account.Balance, account.Debt, transaction.Amount)
+	case transactionTypeWithdraw, transactionTypeTransferOut, transactionTypeFee:
+		account.AllowNegative = transaction.AllowNegative
+		debitAccount(account, transaction.Amount)
+	case transactionTypePenalty:
+		account.Debt += transaction.Amount
+	case transactionTypeHold:
+		account.Held += transaction.Amount
+	case transactionTypeHoldCapture:
+		account.Held -= transaction.Amount
+		account.Balance -= transaction.Amount
+	case transactionTypeHoldRelease:
+		account.Held -= transaction.Amount
+	case transactionTypeMigration:
+		account.Balance += transaction.Amount
+	case transactionTypeMergeIn:
+		mergeInto(account, transaction.Amount, transaction.MergedDebt)
+	case transactionTypeOpeningBalance:
+		account.Balance, account.Debt, account.Held = transaction.Balance, transaction.Debt, transaction.Held
+	}
+}
+
+func getAccountAsOfHandler(transactionCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameQuery(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		rawAsOf := ctx.Query("at")
+		asOf, err := time.Parse(time.RFC3339, rawAsOf)
+		if err != nil {
+			sendError(ctx, &ErrInvalidQueryParam{Name: "at", Value: rawAsOf})
+			return
+		}
+
+		historySearchResult, err := transactionCollection.Find(ctx.Request.Context(), bson.D{
+			{Key: "username", Value: userName},
+			{Key: "createdat", Value: bson.D{{Key: "$lte", Value: asOf}}},
+		}, options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		defer historySearchResult.Close(ctx.Request.Context())
+
+		replayedAccount := BankAccount{UserName: userName}
+		replayedCount := 0
+		for historySearchResult.Next(ctx.Request.Context()) {
+			var transaction Transaction
+			if err := historySearchResult.Decode(&transaction); err != nil {
+				sendError(ctx, err)
+				return
+			}
+			applyTransaction(&replayedAccount, transaction)
+			replayedCount++
+		}
+		if err := historySearchResult.Err(); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		if replayedCount == 0 {
+			sendError(ctx, historyStartError(ctx.Request.Context(), transactionCollection, userName, asOf))
+			return
+		}
+
+		respond(ctx, http.StatusOK, AccountAsOf{
+			UserName: replayedAccount.UserName,
+			Balance:  replayedAccount.Balance,
+			Debt:     replayedAccount.Debt,
+			AsOf:     asOf,
+		})
+	}
+}
+
+type AverageDailyBalance struct {
+	UserName            string    `json:"username"`
+	Month               string    `json:"month"`
+	AverageDailyBalance float64   `json:"averageDailyBalance"`
+	DaysCovered         float64   `json:"daysCovered"`
+	From                time.Time `json:"from"`
+	To                  time.Time `json:"to"`
+}
+
+// balanceIntegral accumulates balance × time over a period while history
+// entries are replayed in order.
+type balanceIntegral struct {
+	from, to      time.Time
+	account       BankAccount
+	opened        bool
+	coveredFrom   time.Time
+	lastChange    time.Time
+	balanceByTime float64
+}
+
+// apply replays the transaction, first crediting the balance held since the
+// previous change for the part of that stretch inside the period.
+func (integral *balanceIntegral) apply(transaction Transaction) {
+	integral.advance(transaction.CreatedAt)
+	if !integral.opened {
+		integral.opened = true
+		integral.coveredFrom = transaction.CreatedAt
+		if integral.coveredFrom.Before(integral.from) {
+			integral.coveredFrom = integral.from
+		}
+		integral.lastChange = integral.coveredFrom
+	}
+	applyTransaction(&integral.account, transaction)
+}
+
+func (integral *balanceIntegral) advance(until time.Time) {
+	if !integral.opened {
+		return
+	}
+	if until.After(integral.to) {
+		until = integral.to
+	}
+	if until.After(integral.lastChange) {
+		integral.balanceByTime += float64(integral.account.Balance) * until.Sub(integral.lastChange).Seconds()
+		integral.lastChange = until
+	}
+}
+
+// getAverageDailyBalanceHandler computes the time-weighted average balance
+// of ?username= over the calendar ?month= (YYYY-MM, UTC) by replaying the
+// history. The period starts when the account opened if that happened
+// during the month and ends now for the current month; daysCovered is its
+// length in days.
+func getAverageDailyBalanceHandler(transactionCollection *mongo.Collection, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameQuery(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		rawMonth := ctx.Query("month")
+		monthStart, err := time.Parse("2006-01", rawMonth)
+		now := config.Clock.Now().UTC()
+		if err != nil || !monthStart.Before(now) {
+			sendError(ctx, &ErrInvalidQueryParam{Name: "month", Value: rawMonth})
+			return
+		}
+		monthEnd := monthStart.AddDate(0, 1, 0)
+		if monthEnd.After(now) {
+			monthEnd = now
+		}
+
+		historySearchResult, err := transactionCollection.Find(ctx.Request.Context(), bson.D{
+			{Key: "username", Value: userName},
+			{Key: "createdat", Value: bson.D{{Key: "$lt", Value: monthEnd}}},
+		}, options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		defer historySearchResult.Close(ctx.Request.Context())
+
+		integral := balanceIntegral{from: monthStart, to: monthEnd, account: BankAccount{UserName: userName}}
+		for historySearchResult.Next(ctx.Request.Context()) {
+			var transaction Transaction
+			if err := historySearchResult.Decode(&transaction); err != nil {
+				sendError(ctx, err)
+				return
+			}
+			integral.apply(transaction)
+		}
+		if err := historySearchResult.Err(); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if !integral.opened {
+			sendError(ctx, historyStartError(ctx.Request.Context(), transactionCollection, userName, monthEnd))
+			return
+		}
+		integral.advance(monthEnd)
+
+		coveredDuration := monthEnd.Sub(integral.coveredFrom)
+		average := 0.0
+		if coveredDuration > 0 {
+			average = integral.balanceByTime / coveredDuration.Seconds()
+		}
+		respond(ctx, http.StatusOK, AverageDailyBalance{
+			UserName:            userName,
+			Month:               monthStart.Format("2006-01"),
+			AverageDailyBalance: math.Round(average*100) / 100,
+			DaysCovered:         math.Round(coveredDuration.Hours()/24*100) / 100,
+			From:                integral.coveredFrom,
+			To:                  monthEnd,
+		})
+	}
+}
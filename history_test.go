@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGetAccountAsOf(t *testing.T) {
+	server := newTestServer(t)
+	openedAt := server.clock.Now()
+	server.createAccount("alice")
+	server.clock.Advance(time.Hour)
+	server.deposit("alice", 100)
+	server.clock.Advance(time.Hour)
+	server.withdraw("alice", 150)
+	server.clock.Advance(time.Hour)
+	server.deposit("alice", 80)
+
+	asOf := func(at time.Time) *httptest.ResponseRecorder {
+		return server.request(http.MethodGet,
+			"/account/as-of?username=alice&at="+url.QueryEscape(at.Format(time.RFC3339)), nil)
+	}
+
+	expectErrorCode(t, asOf(openedAt.Add(-time.Hour)), http.StatusNotFound, "ErrAccountNotOpenedYet")
+
+	tests := []struct {
+		name    string
+		at      time.Time
+		balance int
+		debt    int
+	}{
+		{name: "just opened", at: openedAt.Add(30 * time.Minute)},
+		{name: "at the first deposit", at: openedAt.Add(time.Hour), balance: 100},
+		{name: "overdrawn", at: openedAt.Add(150 * time.Minute), debt: 50},
+		{name: "repaid", at: openedAt.Add(4 * time.Hour), balance: 30},
+	}
+	for _, test := range tests {
+		recorder := asOf(test.at)
+		expectStatus(t, recorder, http.StatusOK)
+		account := decodeResponse[AccountAsOf](t, recorder)
+		if account.Balance != test.balance || account.Debt != test.debt || !account.AsOf.Equal(test.at) {
+			t.Fatalf("%s: as of %s = %+v, want balance %d and debt %d",
+				test.name, test.at, account, test.balance, test.debt)
+		}
+	}
+
+	recorder := server.request(http.MethodGet, "/account/as-of?username=alice&at=yesterday", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidQueryParam")
+}
+
+func TestBalanceIntegral(t *testing.T) {
+	march := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	integral := balanceIntegral{from: march, to: march.AddDate(0, 1, 0)}
+	for _, transaction := range []Transaction{
+		{Type: transactionTypeDeposit, Amount: 100, CreatedAt: march.AddDate(0, 0, -10)},
+		{Type: transactionTypeWithdraw, Amount: 150, CreatedAt: march.AddDate(0, 0, 15)},
+		{Type: transactionTypeDeposit, Amount: 80, CreatedAt: march.AddDate(0, 0, 25)},
+		// Past the end of the period, so it never counts.
+		{Type: transactionTypeDeposit, Amount: 1000, CreatedAt: march.AddDate(0, 1, 2)},
+	} {
+		integral.apply(transaction)
+	}
+	integral.advance(march.AddDate(0, 1, 0))
+
+	// 15 days at 100, 10 overdrawn days at 0 and 6 days at 30.
+	if want := float64(15*100+6*30) * 24 * 60 * 60; integral.balanceByTime != want {
+		t.Fatalf("balance × seconds = %v, want %v", integral.balanceByTime, want)
+	}
+	if !integral.coveredFrom.Equal(march) {
+		t.Fatalf("covered from %s, want the start of the month", integral.coveredFrom)
+	}
+}
+
+func TestGetAverageDailyBalance(t *testing.T) {
+	server := newTestServer(t)
+	// Opened on 1 March at noon.
+	server.createAccount("alice")
+	server.clock.Advance(10 * 24 * time.Hour)
+	server.deposit("alice", 310)
+	server.clock.Advance(10 * 24 * time.Hour)
+	server.withdraw("alice", 100)
+	server.clock.Advance(15*24*time.Hour + 12*time.Hour)
+
+	averageDailyBalance := func(month string) *httptest.ResponseRecorder {
+		return server.request(http.MethodGet, "/account/average-daily-balance?username=alice&month="+month, nil)
+	}
+
+	tests := []struct {
+		month       string
+		average     float64
+		daysCovered float64
+	}{
+		// 10 days at 0, 10 days at 310 and 10.5 days at 210 over 30.5 days.
+		{month: "2024-03", average: 173.93, daysCovered: 30.5},
+		// The current month ends now, on 6 April at midnight.
+		{month: "2024-04", average: 210, daysCovered: 5},
+	}
+	for _, test := range tests {
+		recorder := averageDailyBalance(test.month)
+		expectStatus(t, recorder, http.StatusOK)
+		result := decodeResponse[AverageDailyBalance](t, recorder)
+		if result.Month != test.month || result.AverageDailyBalance != test.average ||
+			result.DaysCovered != test.daysCovered {
+			t.Fatalf("%s: %+v, want average %v over %v days", test.month, result, test.average, test.daysCovered)
+		}
+	}
+
+	expectErrorCode(t, averageDailyBalance("2024-02"), http.StatusNotFound, "ErrAccountNotOpenedYet")
+	expectErrorCode(t, averageDailyBalance("2024-05"), http.StatusBadRequest, "ErrInvalidQueryParam")
+	expectErrorCode(t, averageDailyBalance("march"), http.StatusBadRequest, "ErrInvalidQueryParam")
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	holdStatusActive   = "active"
+	holdStatusCaptured = "captured"
+	holdStatusReleased = "released"
+)
+
+// HoldRecord reserves Amount of an account's balance until it is captured,
+// which withdraws the money, or released, which frees it again.
+type HoldRecord struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserName  string             `json:"username"`
+	Amount    int                `json:"amount"`
+	Status    string             `json:"status"`
+	CreatedAt time.Time          `json:"createdAt"`
+	SettledAt *time.Time         `json:"settledAt,omitempty"`
+}
+
+type HoldResult struct {
+	Hold    HoldRecord  `json:"hold"`
+	Account BankAccount `json:"account"`
+}
+
+type ErrHoldNotFound struct {
+	ID string
+}
+
+func (err *ErrHoldNotFound) Code() string {
+	return "ErrHoldNotFound"
+}
+
+func (err *ErrHoldNotFound) messageArgs() []any {
+	return []any{err.ID}
+}
+
+func (err *ErrHoldNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrHoldNotFound) Status() int {
+	return http.StatusNotFound
+}
+
+type ErrHoldNotActive struct {
+	ID     string
+	Status string
+}
+
+func (err *ErrHoldNotActive) Code() string {
+	return "ErrHoldNotActive"
+}
+
+func (err *ErrHoldNotActive) messageArgs() []any {
+	return []any{err.ID, err.Status}
+}
+
+func (err *ErrHoldNotActive) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrFundsOnHold struct {
+	UserName string
+	Held     int
+}
+
+func (err *ErrFundsOnHold) Code() string {
+	return "ErrFundsOnHold"
+}
+
+func (err *ErrFundsOnHold) messageArgs() []any {
+	return []any{err.UserName, err.Held}
+}
+
+func (err *ErrFundsOnHold) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+// availableBalance is the part of the balance not reserved by holds.
+// Withdrawals and transfers may only take money from it.
+func availableBalance(account BankAccount) int {
+	return account.Balance - account.Held
+}
+
+// coversDebit reports whether amount can be debited without going into
+// debt, which accounts allowed to go negative always can.
+func coversDebit(account BankAccount, amount int) bool {
+	return account.AllowNegative || availableBalance(account) >= amount
+}
+
+// debitAccount takes amount from the available balance and books whatever
+// it does not cover as debt, leaving held funds untouched. Accounts allowed
+// to go negative take the whole amount from the balance.
+func debitAccount(account *BankAccount, amount int) {
+	if account.AllowNegative {
+		account.Balance -= amount
+		return
+	}
+	debitedAmount := min(amount, availableBalance(*account))
+	if debitedAmount < 0 {
+		debitedAmount = 0
+	}
+	account.Balance -= debitedAmount
+	account.Debt += amount - debitedAmount
+}
+
+type HoldInput struct {
+	UserName string `json:"username"`
+	Amount   int    `json:"amount"`
+}
+
+func (input *HoldInput) Error() error {
+	var validationErrors MultiError
+	if !isUsernameValid(input.UserName) {
+		validationErrors.Add("username", &ErrInvalidUsername{UserName: input.UserName})
+	}
+	if err := amountError("amount", input.Amount); err != nil {
+		validationErrors.Add("amount", err)
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+func (input *HoldInput) normalizeUsernames() {
+	input.UserName = normalizeUsername(input.UserName)
+}
+
+type SettleHoldInput struct {
+	ID string `json:"id"`
+}
+
+// placeHoldHandler reserves part of the available balance. Nothing leaves
+// the account until the hold is captured.
+func placeHoldHandler(
+	accountRepository *AccountRepository, transactionCollection, holdCollection *mongo.Collection, config *Config,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		holdInput, ok := bindAndValidate[HoldInput](ctx)
+		if !ok {
+			return
+		}
+
+		accountCollection := accountRepository.Collection()
+		holdResult, err := runInTransaction(ctx.Request.Context(), accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+			account, err := findAccountInSession(sessionCtx, accountCollection, holdInput.UserName)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkAccountOpen(account); err != nil {
+				return nil, err
+			}
+			if err := checkUnverifiedLimit(config, account, holdInput.Amount); err != nil {
+				return nil, err
+			}
+			if availableBalance(account) < holdInput.Amount {
+				return nil, &ErrInsufficientFunds{
+					UserName: account.UserName,
+					Balance:  availableBalance(account),
+					Amount:   holdInput.Amount,
+				}
+			}
+
+			account.Held += holdInput.Amount
+			if err := accountRepository.Replace(sessionCtx, &account); err != nil {
+				return nil, err
+			}
+
+			hold := HoldRecord{
+				UserName:  account.UserName,
+				Amount:    holdInput.Amount,
+				Status:    holdStatusActive,
+				CreatedAt: config.Clock.Now().UTC(),
+			}
+			insertResult, err := holdCollection.InsertOne(sessionCtx, hold)
+			if err != nil {
+				return nil, err
+			}
+			hold.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+			if _, err := insertTransaction(sessionCtx, transactionCollection,
+				newTransaction(config.Clock, account, transactionTypeHold, hold.Amount)); err != nil {
+				return nil, err
+			}
+			return HoldResult{Hold: hold, Account: account}, nil
+		})
+		accountRepository.Invalidate(holdInput.UserName)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		retainHistory(transactionCollection, config, holdInput.UserName)
+
+		respond(ctx, http.StatusCreated, holdResult)
+	}
+}
+
+// settleHoldHandler captures or releases an active hold. A capture
+// withdraws the held amount from the balance; a release only frees it.
+func settleHoldHandler(
+	accountRepository *AccountRepository, transactionCollection, holdCollection *mongo.Collection, config *Config,
+	publisher EventPublisher, capture bool,
+) func(*gin.Context) {
+	settledStatus, transactionType := holdStatusReleased, transactionTypeHoldRelease
+	if capture {
+		settledStatus, transactionType = holdStatusCaptured, transactionTypeHoldCapture
+	}
+
+	return func(ctx *gin.Context) {
+		var settleInput SettleHoldInput
+		if err := ctx.BindJSON(&settleInput); err != nil {
+			sendError(ctx, &ErrInputRead{InputError: err})
+			return
+		}
+
+		holdID, err := parseObjectID(settleInput.ID)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		var alertEvents []Event
+		accountCollection := accountRepository.Collection()
+		holdResult, err := runInTransaction(ctx.Request.Context(), accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+			alertEvents = nil
+
+			var hold HoldRecord
+			err := holdCollection.FindOne(sessionCtx, bson.D{{Key: "_id", Value: holdID}}).Decode(&hold)
+			if err == mongo.ErrNoDocuments {
+				return nil, &ErrHoldNotFound{ID: settleInput.ID}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if hold.Status != holdStatusActive {
+				return nil, &ErrHoldNotActive{ID: settleInput.ID, Status: hold.Status}
+			}
+
+			account, err := findAccountInSession(sessionCtx, accountCollection, hold.UserName)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkAccountOpen(account); err != nil {
+				return nil, err
+			}
+			account.Held -= hold.Amount
+			if capture {
+				account.Balance -= hold.Amount
+				alertEvents = updateBalanceAlert(config.Clock, &account)
+			}
+			if err := accountRepository.Replace(sessionCtx, &account); err != nil {
+				return nil, err
+			}
+
+			settledAt := config.Clock.Now().UTC()
+			updateResult, err := holdCollection.UpdateOne(sessionCtx, bson.D{
+				{Key: "_id", Value: hold.ID},
+				{Key: "status", Value: holdStatusActive},
+			}, bson.D{{Key: "$set", Value: bson.D{
+				{Key: "status", Value: settledStatus},
+				{Key: "settledat", Value: settledAt},
+			}}})
+			if err != nil {
+				return nil, err
+			}
+			if updateResult.ModifiedCount == 0 {
+				return nil, &ErrHoldNotActive{ID: settleInput.ID, Status: hold.Status}
+			}
+			hold.Status, hold.SettledAt = settledStatus, &settledAt
+
+			if _, err := insertTransaction(sessionCtx, transactionCollection,
+				newTransaction(config.Clock, account, transactionType, hold.Amount)); err != nil {
+				return nil, err
+			}
+			return HoldResult{Hold: hold, Account: account}, nil
+		})
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		userName := holdResult.(HoldResult).Account.UserName
+		accountRepository.Invalidate(userName)
+		retainHistory(transactionCollection, config, userName)
+		publishEvents(publisher, alertEvents)
+
+		respond(ctx, http.StatusOK, holdResult)
+	}
+}
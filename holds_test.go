@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDebitAccount(t *testing.T) {
+	tests := []struct {
+		name        string
+		account     BankAccount
+		amount      int
+		wantBalance int
+		wantDebt    int
+		covered     bool
+	}{
+		{name: "covered by the available balance", account: BankAccount{Balance: 100, Held: 60}, amount: 40,
+			wantBalance: 60, covered: true},
+		{name: "held funds are not taken", account: BankAccount{Balance: 100, Held: 60}, amount: 50,
+			wantBalance: 60, wantDebt: 10},
+		{name: "everything held", account: BankAccount{Balance: 100, Held: 100}, amount: 5,
+			wantBalance: 100, wantDebt: 5},
+		{name: "allowed negative", account: BankAccount{Balance: 100, Held: 60, AllowNegative: true}, amount: 150,
+			wantBalance: -50, covered: true},
+	}
+	for _, test := range tests {
+		if covered := coversDebit(test.account, test.amount); covered != test.covered {
+			t.Errorf("%s: coversDebit = %v, want %v", test.name, covered, test.covered)
+		}
+		account := test.account
+		debitAccount(&account, test.amount)
+		if account.Balance != test.wantBalance || account.Debt != test.wantDebt || account.Held != test.account.Held {
+			t.Errorf("%s: debited account %+v, want balance %d and debt %d", test.name, account,
+				test.wantBalance, test.wantDebt)
+		}
+	}
+}
+
+func TestHolds(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	placeHold := func(amount int) HoldRecord {
+		t.Helper()
+		recorder := server.request(http.MethodPost, "/account/hold", HoldInput{UserName: "alice", Amount: amount})
+		expectStatus(t, recorder, http.StatusCreated)
+		result := decodeResponse[HoldResult](t, recorder)
+		if result.Hold.Status != holdStatusActive || result.Hold.Amount != amount || result.Hold.ID.IsZero() {
+			t.Fatalf("placed hold = %+v", result.Hold)
+		}
+		return result.Hold
+	}
+	expectAlice := func(balance, held int) {
+		t.Helper()
+		if alice := server.account("alice"); alice.Balance != balance || alice.Held != held || alice.Debt != 0 {
+			t.Fatalf("alice = %+v, want balance %d and held %d", alice, balance, held)
+		}
+	}
+
+	captured := placeHold(60)
+	expectAlice(100, 60)
+
+	// Only the 40 left available can be reserved, withdrawn or sent.
+	recorder := server.request(http.MethodPost, "/account/hold", HoldInput{UserName: "alice", Amount: 50})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInsufficientFunds")
+	recorder = server.request(http.MethodPost, "/withdraw?strict=true", TransactionInput{UserName: "alice", Amount: 50})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInsufficientFunds")
+	recorder = server.request(http.MethodPost, "/transfer?strict=true",
+		TransferNote{FromUser: "alice", ToUser: "bob", Amount: 50})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInsufficientFunds")
+	server.transfer("alice", "bob", 10)
+	expectAlice(90, 60)
+
+	recorder = server.request(http.MethodPost, "/account/capture", SettleHoldInput{ID: captured.ID.Hex()})
+	expectStatus(t, recorder, http.StatusOK)
+	if result := decodeResponse[HoldResult](t, recorder); result.Hold.Status != holdStatusCaptured ||
+		result.Hold.SettledAt == nil || result.Account.Balance != 30 || result.Account.Held != 0 {
+		t.Fatalf("capture = %+v", result)
+	}
+	expectAlice(30, 0)
+	recorder = server.request(http.MethodPost, "/account/release", SettleHoldInput{ID: captured.ID.Hex()})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrHoldNotActive")
+
+	released := placeHold(20)
+	expectAlice(30, 20)
+	recorder = server.request(http.MethodPost, "/account/release", SettleHoldInput{ID: released.ID.Hex()})
+	expectStatus(t, recorder, http.StatusOK)
+	if result := decodeResponse[HoldResult](t, recorder); result.Hold.Status != holdStatusReleased {
+		t.Fatalf("release = %+v", result)
+	}
+	expectAlice(30, 0)
+	recorder = server.request(http.MethodPost, "/account/capture", SettleHoldInput{ID: released.ID.Hex()})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrHoldNotActive")
+
+	recorder = server.request(http.MethodPost, "/account/capture", SettleHoldInput{ID: primitive.NewObjectID().Hex()})
+	expectErrorCode(t, recorder, http.StatusNotFound, "ErrHoldNotFound")
+
+	var types []string
+	for _, transaction := range server.history("alice") {
+		types = append(types, transaction.Type)
+	}
+	want := []string{transactionTypeBonus, transactionTypeDeposit, transactionTypeHold, transactionTypeTransferOut,
+		transactionTypeHoldCapture, transactionTypeHold, transactionTypeHoldRelease}
+	if len(types) != len(want) {
+		t.Fatalf("history types = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("history types = %v, want %v", types, want)
+		}
+	}
+}
+
+func TestSettleHoldOnLockedAccount(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	server.createAccount("alice")
+	server.deposit("alice", 100)
+	adminAuth := []string{"Authorization", "Bearer secret"}
+
+	recorder := server.request(http.MethodPost, "/account/hold", HoldInput{UserName: "alice", Amount: 60})
+	expectStatus(t, recorder, http.StatusCreated)
+	hold := decodeResponse[HoldResult](t, recorder).Hold
+
+	recorder = server.request(http.MethodPost, "/account/alice/lock",
+		LockAccountInput{Reason: "ledger repair", Operator: "ops-7"}, adminAuth...)
+	expectStatus(t, recorder, http.StatusOK)
+
+	for _, target := range []string{"/account/capture", "/account/release"} {
+		recorder := server.request(http.MethodPost, target, SettleHoldInput{ID: hold.ID.Hex()})
+		expectErrorCode(t, recorder, http.StatusLocked, "ErrAccountLocked")
+	}
+	if alice := server.account("alice"); alice.Balance != 100 || alice.Held != 60 {
+		t.Fatalf("alice = %+v while locked, want balance 100 and held 60", alice)
+	}
+
+	recorder = server.request(http.MethodDelete, "/account/alice/lock", nil, adminAuth...)
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPost, "/account/capture", SettleHoldInput{ID: hold.ID.Hex()})
+	expectStatus(t, recorder, http.StatusOK)
+	if alice := server.account("alice"); alice.Balance != 40 || alice.Held != 0 {
+		t.Fatalf("alice = %+v after capture, want balance 40 and held 0", alice)
+	}
+}
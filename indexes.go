@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ensureAccountIndexes builds the account indexes. If stored usernames are
+// already duplicated the unique username index cannot be built; the
+// duplicates are logged and the server starts without it.
+func ensureAccountIndexes(accountCollection *mongo.Collection) error {
+	// Usernames are stored normalized, so uniqueness is case-insensitive
+	// when NORMALIZE_USERNAMES is on.
+	_, err := accountCollection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		duplicates, findErr := findDuplicateUsernames(context.TODO(), accountCollection)
+		if findErr != nil {
+			return findErr
+		}
+		log.Printf("unique username index not built, duplicated usernames: %q", duplicates)
+	} else if err != nil {
+		return err
+	}
+
+	_, err = accountCollection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "debt", Value: -1}, {Key: "username", Value: 1}}},
+		{Keys: bson.D{{Key: "balance", Value: 1}, {Key: "username", Value: 1}}},
+		{Keys: bson.D{{Key: "updatedat", Value: 1}, {Key: "username", Value: 1}}},
+		{Keys: bson.D{{Key: "createdat", Value: 1}, {Key: "username", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "accountnumber", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{
+				{Key: "accountnumber", Value: bson.D{{Key: "$gt", Value: ""}}},
+			}),
+		},
+	})
+	return err
+}
+
+// findDuplicateUsernames lists the usernames stored on more than one account.
+func findDuplicateUsernames(ctx context.Context, accountCollection *mongo.Collection) ([]string, error) {
+	duplicateSearchResult, err := accountCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$username"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var duplicates []struct {
+		UserName string `bson:"_id"`
+	}
+	if err := duplicateSearchResult.All(ctx, &duplicates); err != nil {
+		return nil, err
+	}
+	userNames := make([]string, 0, len(duplicates))
+	for _, duplicate := range duplicates {
+		userNames = append(userNames, duplicate.UserName)
+	}
+	return userNames, nil
+}
+
+func ensureScheduledTransferIndexes(scheduledCollection *mongo.Collection) error {
+	_, err := scheduledCollection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "executeat", Value: 1}}},
+	})
+	return err
+}
+
+func ensurePendingTransferIndexes(pendingCollection *mongo.Collection) error {
+	_, err := pendingCollection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expiresat", Value: 1}}},
+	})
+	return err
+}
+
+// ensureExecutedTransferIndexes keeps transfer IDs unique and lets MongoDB
+// drop their records once TransferIDRetention has passed.
+func ensureExecutedTransferIndexes(executedCollection *mongo.Collection, config *Config) error {
+	_, err := executedCollection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "transferid", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{
+			Keys:    bson.D{{Key: "createdat", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(config.TransferIDRetention / time.Second)),
+		},
+	})
+	return err
+}
+
+func ensureWebhookIndexes(webhookCollection, deliveryCollection *mongo.Collection) error {
+	if _, err := webhookCollection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "eventtypes", Value: 1}}},
+	}); err != nil {
+		return err
+	}
+	_, err := deliveryCollection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "subscriptionid", Value: 1}, {Key: "createdat", Value: -1}}},
+	})
+	return err
+}
+
+func ensureTransactionIndexes(transactionCollection *mongo.Collection) error {
+	_, err := transactionCollection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "createdat", Value: 1}}},
+		// Serves the incoming side of the transfer graph.
+		{Keys: bson.D{{Key: "counterparty", Value: 1}, {Key: "type", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "idempotencykey", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{
+				{Key: "idempotencykey", Value: bson.D{{Key: "$gt", Value: ""}}},
+			}),
+		},
+	})
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	roundingHalfEven = "half-even"
+	roundingHalfUp   = "half-up"
+)
+
+func isRoundingModeValid(mode string) bool {
+	return mode == roundingHalfEven || mode == roundingHalfUp
+}
+
+// roundAmount turns a computed interest or penalty into whole units.
+// Half-even sends exact halves to the even neighbour, so 2.5 becomes 2 and
+// 3.5 becomes 4; half-up sends them up, making both 3 and 4. The fraction
+// left over is dropped, not carried into the next accrual, so every day is
+// rounded on its own.
+func roundAmount(value float64, mode string) int {
+	if mode == roundingHalfUp {
+		return int(math.Floor(value + 0.5))
+	}
+	return int(math.RoundToEven(value))
+}
+
+type InterestProjection struct {
+	UserName          string  `json:"username"`
+	Balance           int     `json:"balance"`
+	Rate              float64 `json:"rate"`
+	Days              int64   `json:"days"`
+	ProjectedBalance  int     `json:"projectedBalance"`
+	ProjectedInterest int     `json:"projectedInterest"`
+}
+
+// projectBalance compounds the balance once per day at the given daily rate
+// and rounds the result with mode.
+func projectBalance(balance int, dailyRate float64, days int64, mode string) int {
+	return roundAmount(float64(balance)*math.Pow(1+dailyRate, float64(days)), mode)
+}
+
+func projectInterestHandler(accountRepository *AccountRepository, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameQuery(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		rawRate := ctx.Query("rate")
+		rate, err := strconv.ParseFloat(rawRate, 64)
+		if err != nil || rate < 0 || math.IsInf(rate, 0) {
+			sendError(ctx, &ErrInvalidQueryParam{Name: "rate", Value: rawRate})
+			return
+		}
+
+		if _, ok := ctx.GetQuery("days"); !ok {
+			sendError(ctx, &ErrInvalidQueryParam{Name: "days", Value: ""})
+			return
+		}
+		days, err := parsePositiveQuery(ctx, "days", 0)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		account, err := accountRepository.FindByUsername(ctx.Request.Context(), userName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		projectedBalance := projectBalance(account.Balance, rate, days, config.RoundingMode)
+		respond(ctx, http.StatusOK, InterestProjection{
+			UserName:          account.UserName,
+			Balance:           account.Balance,
+			Rate:              rate,
+			Days:              days,
+			ProjectedBalance:  projectedBalance,
+			ProjectedInterest: projectedBalance - account.Balance,
+		})
+	}
+}
+
+// InterestSummary totals what an account earned in interest and was charged
+// in debt penalties between From and To, either of which may be open.
+type InterestSummary struct {
+	UserName         string     `json:"username"`
+	From             *time.Time `json:"from,omitempty"`
+	To               *time.Time `json:"to,omitempty"`
+	InterestEarned   int64      `json:"interestEarned"`
+	PenaltiesCharged int64      `json:"penaltiesCharged"`
+}
+
+// getInterestSummaryHandler sums the interest and penalty entries of an
+// account's history for ?username=, optionally bounded by ?from= and ?to=.
+// Nothing records interest entries yet, so InterestEarned stays zero until
+// interest is credited.
+func getInterestSummaryHandler(transactionCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameQuery(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+		from, err := parseTimeQuery(ctx, "from")
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		to, err := parseTimeQuery(ctx, "to")
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if from != nil && to != nil && from.After(*to) {
+			sendError(ctx, &ErrInvalidDateRange{From: *from, To: *to})
+			return
+		}
+
+		summaryFilter := appendHistoryRange(bson.D{{Key: "username", Value: userName}},
+			from, to, []string{transactionTypeInterest, transactionTypePenalty})
+		summaryTotals, err := transactionCollection.Aggregate(ctx.Request.Context(), mongo.Pipeline{
+			{{Key: "$match", Value: summaryFilter}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$type"},
+				{Key: "total", Value: bson.D{{Key: "$sum", Value: "$amount"}}},
+			}}},
+		})
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		var totals []struct {
+			Type  string `bson:"_id"`
+			Total int64
+		}
+		if err := summaryTotals.All(ctx.Request.Context(), &totals); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		summary := InterestSummary{UserName: userName, From: from, To: to}
+		for _, total := range totals {
+			switch total.Type {
+			case transactionTypeInterest:
+				summary.InterestEarned = total.Total
+			case transactionTypePenalty:
+				summary.PenaltiesCharged = total.Total
+			}
+		}
+		respond(ctx, http.StatusOK, summary)
+	}
+}
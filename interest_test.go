@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRoundAmount(t *testing.T) {
+	tests := []struct {
+		value    float64
+		halfEven int
+		halfUp   int
+	}{
+		{value: 2.5, halfEven: 2, halfUp: 3},
+		{value: 3.5, halfEven: 4, halfUp: 4},
+		{value: 2.49, halfEven: 2, halfUp: 2},
+		{value: 2.51, halfEven: 3, halfUp: 3},
+		{value: 0, halfEven: 0, halfUp: 0},
+	}
+	for _, test := range tests {
+		if rounded := roundAmount(test.value, roundingHalfEven); rounded != test.halfEven {
+			t.Errorf("half-even %v = %d, want %d", test.value, rounded, test.halfEven)
+		}
+		if rounded := roundAmount(test.value, roundingHalfUp); rounded != test.halfUp {
+			t.Errorf("half-up %v = %d, want %d", test.value, rounded, test.halfUp)
+		}
+	}
+}
+
+// The expected balances are balance × (1 + rate)^days worked out by hand.
+func TestProjectBalance(t *testing.T) {
+	tests := []struct {
+		balance int
+		rate    float64
+		days    int64
+		want    int
+	}{
+		{balance: 1000, rate: 0, days: 30, want: 1000},
+		{balance: 100, rate: 0.5, days: 1, want: 150},
+		{balance: 1000, rate: 0.05, days: 3, want: 1158},   // 1157.625
+		{balance: 1000, rate: 0.01, days: 10, want: 1105},  // 1104.622
+		{balance: 2500, rate: 0.002, days: 30, want: 2654}, // 2654.432
+		{balance: 10000, rate: 0.0001, days: 365, want: 10372},
+	}
+	for _, test := range tests {
+		if projected := projectBalance(test.balance, test.rate, test.days, roundingHalfEven); projected != test.want {
+			t.Errorf("projectBalance(%d, %v, %d) = %d, want %d",
+				test.balance, test.rate, test.days, projected, test.want)
+		}
+	}
+}
+
+func TestProjectBalanceRounding(t *testing.T) {
+	tests := []struct {
+		balance  int
+		rate     float64
+		days     int64
+		halfEven int
+		halfUp   int
+	}{
+		{balance: 1, rate: 1.5, days: 1, halfEven: 2, halfUp: 3},     // 2.5
+		{balance: 7, rate: 0, days: 1, halfEven: 7, halfUp: 7},       // 7
+		{balance: 10, rate: 0.25, days: 1, halfEven: 12, halfUp: 13}, // 12.5
+		{balance: 6, rate: 0.25, days: 1, halfEven: 8, halfUp: 8},    // 7.5
+		{balance: 4, rate: 0.25, days: 2, halfEven: 6, halfUp: 6},    // 6.25
+	}
+	for _, test := range tests {
+		if projected := projectBalance(test.balance, test.rate, test.days, roundingHalfEven); projected != test.halfEven {
+			t.Errorf("half-even projectBalance(%d, %v, %d) = %d, want %d",
+				test.balance, test.rate, test.days, projected, test.halfEven)
+		}
+		if projected := projectBalance(test.balance, test.rate, test.days, roundingHalfUp); projected != test.halfUp {
+			t.Errorf("half-up projectBalance(%d, %v, %d) = %d, want %d",
+				test.balance, test.rate, test.days, projected, test.halfUp)
+		}
+	}
+}
+
+func TestProjectInterest(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.deposit("alice", 1000)
+
+	recorder := server.request(http.MethodGet, "/account/interest/projection?username=alice&rate=0.01&days=10", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	projection := decodeResponse[InterestProjection](t, recorder)
+	if projection.Balance != 1000 || projection.ProjectedBalance != 1105 || projection.ProjectedInterest != 105 {
+		t.Fatalf("projection = %+v", projection)
+	}
+	if account := server.account("alice"); account.Balance != 1000 {
+		t.Fatalf("balance = %d after a projection, want 1000", account.Balance)
+	}
+
+	for _, query := range []string{"rate=-0.01&days=10", "rate=0.01&days=0", "rate=0.01", "rate=abc&days=1"} {
+		recorder := server.request(http.MethodGet, "/account/interest/projection?username=alice&"+query, nil)
+		expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidQueryParam")
+	}
+}
+
+func TestGetInterestSummary(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	start := server.clock.Now()
+	for _, seeded := range []struct {
+		userName        string
+		transactionType string
+		amount          int
+		daysAgo         int
+	}{
+		{userName: "alice", transactionType: transactionTypeInterest, amount: 12, daysAgo: 40},
+		{userName: "alice", transactionType: transactionTypeInterest, amount: 7, daysAgo: 10},
+		{userName: "alice", transactionType: transactionTypeInterest, amount: 3, daysAgo: 2},
+		{userName: "alice", transactionType: transactionTypePenalty, amount: 20, daysAgo: 35},
+		{userName: "alice", transactionType: transactionTypePenalty, amount: 5, daysAgo: 5},
+		{userName: "alice", transactionType: transactionTypeDeposit, amount: 500, daysAgo: 5},
+		{userName: "bob", transactionType: transactionTypeInterest, amount: 100, daysAgo: 5},
+		{userName: "bob", transactionType: transactionTypePenalty, amount: 100, daysAgo: 5},
+	} {
+		transaction := newTransaction(server.clock, BankAccount{UserName: seeded.userName},
+			seeded.transactionType, seeded.amount)
+		transaction.CreatedAt = start.AddDate(0, 0, -seeded.daysAgo)
+		if _, err := insertTransaction(context.Background(), server.transactions, transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	monthAgo := start.AddDate(0, 0, -30).Format(time.RFC3339)
+	weekAgo := start.AddDate(0, 0, -7).Format(time.RFC3339)
+	for _, test := range []struct {
+		query     string
+		interest  int64
+		penalties int64
+	}{
+		{query: "?username=alice", interest: 22, penalties: 25},
+		{query: "?username=alice&from=" + monthAgo, interest: 10, penalties: 5},
+		{query: "?username=alice&to=" + weekAgo, interest: 19, penalties: 20},
+		{query: "?username=alice&from=" + monthAgo + "&to=" + weekAgo, interest: 7, penalties: 0},
+		{query: "?username=carol", interest: 0, penalties: 0},
+	} {
+		recorder := server.request(http.MethodGet, "/account/interest/summary"+test.query, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		summary := decodeResponse[InterestSummary](t, recorder)
+		if summary.InterestEarned != test.interest || summary.PenaltiesCharged != test.penalties {
+			t.Errorf("%s: interest %d, penalties %d, want %d, %d", test.query,
+				summary.InterestEarned, summary.PenaltiesCharged, test.interest, test.penalties)
+		}
+	}
+
+	recorder := server.request(http.MethodGet, "/account/interest/summary?username=alice&from="+weekAgo+"&to="+monthAgo, nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidDateRange")
+}
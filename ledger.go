@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ledgerModeMoney  = "money"
+	ledgerModePoints = "points"
+
+	hideDebtKey = "hideDebt"
+)
+
+// ledgerModeMiddleware marks requests whose responses must not expose debt,
+// which is meaningless for a points ledger.
+func ledgerModeMiddleware(config *Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if config.LedgerMode == ledgerModePoints {
+			ctx.Set(hideDebtKey, true)
+		}
+		ctx.Next()
+	}
+}
+
+// withoutDebt returns the JSON form of data with every "debt" field
+// removed. Numbers are kept as json.Number so balances stay exact.
+func withoutDebt(data any) any {
+	document, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("failed to strip debt from response: %v", err)
+		return data
+	}
+	generic, err := decodeGeneric(document)
+	if err != nil {
+		log.Printf("failed to strip debt from response: %v", err)
+		return data
+	}
+	removeDebtFields(generic)
+	return generic
+}
+
+func removeDebtFields(value any) {
+	switch typedValue := value.(type) {
+	case map[string]any:
+		delete(typedValue, "debt")
+		for _, nested := range typedValue {
+			removeDebtFields(nested)
+		}
+	case []any:
+		for _, nested := range typedValue {
+			removeDebtFields(nested)
+		}
+	}
+}
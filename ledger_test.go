@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestWithoutDebt(t *testing.T) {
+	data := map[string]any{
+		"balance":  9007199254740993,
+		"debt":     5,
+		"accounts": []map[string]int{{"balance": 10, "debt": 3}},
+	}
+	document, err := json.Marshal(withoutDebt(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"accounts":[{"balance":10}],"balance":9007199254740993}`; string(document) != want {
+		t.Fatalf("withoutDebt = %s, want %s", document, want)
+	}
+	if unchanged := data["debt"]; unchanged != 5 {
+		t.Fatalf("withoutDebt modified its input: debt = %v", unchanged)
+	}
+}
+
+func TestLedgerMode(t *testing.T) {
+	tests := []struct {
+		mode       string
+		withdrawal int
+		debtShown  bool
+	}{
+		{mode: ledgerModeMoney, withdrawal: http.StatusOK, debtShown: true},
+		{mode: ledgerModePoints, withdrawal: http.StatusBadRequest, debtShown: false},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.LedgerMode = test.mode
+			})
+			server.createAccount("alice")
+			server.deposit("alice", 30)
+
+			recorder := server.request(http.MethodPost, "/withdraw", TransactionInput{UserName: "alice", Amount: 50})
+			if test.withdrawal == http.StatusOK {
+				expectStatus(t, recorder, http.StatusOK)
+			} else {
+				expectErrorCode(t, recorder, test.withdrawal, "ErrInsufficientFunds")
+				if account := server.account("alice"); account.Balance != 30 || account.Debt != 0 {
+					t.Fatalf("refused withdrawal left %+v", account)
+				}
+			}
+
+			recorder = server.request(http.MethodGet, "/account", BankAccount{UserName: "alice"})
+			expectStatus(t, recorder, http.StatusOK)
+			account := decodeResponse[map[string]any](t, recorder)
+			if _, shown := account["debt"]; shown != test.debtShown {
+				t.Fatalf("account response %v, want debt shown %v", account, test.debtShown)
+			}
+
+			recorder = server.request(http.MethodGet, "/account/all", nil)
+			expectStatus(t, recorder, http.StatusOK)
+			for _, listed := range decodeResponse[[]map[string]any](t, recorder) {
+				if _, shown := listed["debt"]; shown != test.debtShown {
+					t.Fatalf("listed account %v, want debt shown %v", listed, test.debtShown)
+				}
+			}
+		})
+	}
+}
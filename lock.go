@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxLockReasonLength = 200
+
+type ErrAccountLocked struct {
+	UserName string
+	Reason   string
+}
+
+func (err *ErrAccountLocked) Code() string {
+	return "ErrAccountLocked"
+}
+
+func (err *ErrAccountLocked) messageArgs() []any {
+	return []any{err.UserName, err.Reason}
+}
+
+func (err *ErrAccountLocked) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrAccountLocked) Status() int {
+	return http.StatusLocked
+}
+
+type ErrRequiredField struct {
+	Name string
+}
+
+func (err *ErrRequiredField) Code() string {
+	return "ErrRequiredField"
+}
+
+func (err *ErrRequiredField) messageArgs() []any {
+	return []any{err.Name}
+}
+
+func (err *ErrRequiredField) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrFieldTooLong struct {
+	Name string
+	Max  int
+}
+
+func (err *ErrFieldTooLong) Code() string {
+	return "ErrFieldTooLong"
+}
+
+func (err *ErrFieldTooLong) messageArgs() []any {
+	return []any{err.Name, err.Max}
+}
+
+func (err *ErrFieldTooLong) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type LockAccountInput struct {
+	Reason   string `json:"reason"`
+	Operator string `json:"operator"`
+}
+
+func (input *LockAccountInput) Error() error {
+	var validationErrors MultiError
+	if input.Reason == "" {
+		validationErrors.Add("reason", &ErrRequiredField{Name: "reason"})
+	} else if len(input.Reason) > maxLockReasonLength {
+		validationErrors.Add("reason", &ErrFieldTooLong{Name: "reason", Max: maxLockReasonLength})
+	}
+	if input.Operator == "" {
+		validationErrors.Add("operator", &ErrRequiredField{Name: "operator"})
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+// lockAccountHandler puts a maintenance lock on the account. Unlike closing,
+// the lock is temporary and recorded with who set it and why.
+func lockAccountHandler(accountRepository *AccountRepository) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		lockInput, ok := bindAndValidate[LockAccountInput](ctx)
+		if !ok {
+			return
+		}
+
+		lockedAccount, err := accountRepository.SetLock(ctx.Request.Context(), userName, lockInput.Reason, lockInput.Operator)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		respond(ctx, http.StatusOK, lockedAccount)
+	}
+}
+
+func unlockAccountHandler(accountRepository *AccountRepository) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		unlockedAccount, err := accountRepository.ClearLock(ctx.Request.Context(), userName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		respond(ctx, http.StatusOK, unlockedAccount)
+	}
+}
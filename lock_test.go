@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAccountLock(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+	adminAuth := []string{"Authorization", "Bearer secret"}
+
+	recorder := server.request(http.MethodPost, "/account/alice/lock", LockAccountInput{}, adminAuth...)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrValidation")
+	recorder = server.request(http.MethodPost, "/account/alice/lock",
+		LockAccountInput{Reason: "ledger repair", Operator: "ops-7"})
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+
+	recorder = server.request(http.MethodPost, "/account/alice/lock",
+		LockAccountInput{Reason: "ledger repair", Operator: "ops-7"}, adminAuth...)
+	expectStatus(t, recorder, http.StatusOK)
+	if alice := server.account("alice"); !alice.Locked || alice.LockReason != "ledger repair" || alice.LockedBy != "ops-7" {
+		t.Fatalf("locked account = %+v", alice)
+	}
+
+	for _, mutation := range []struct {
+		target string
+		body   any
+	}{
+		{target: "/deposit", body: TransactionInput{UserName: "alice", Amount: 10}},
+		{target: "/withdraw", body: TransactionInput{UserName: "alice", Amount: 10}},
+		{target: "/transfer", body: TransferNote{FromUser: "alice", ToUser: "bob", Amount: 10}},
+		{target: "/transfer", body: TransferNote{FromUser: "bob", ToUser: "alice", Amount: 10}},
+	} {
+		recorder := server.request(http.MethodPost, mutation.target, mutation.body)
+		expectErrorCode(t, recorder, http.StatusLocked, "ErrAccountLocked")
+		if message := decodeResponse[JsonMessage](t, recorder).Message; !strings.Contains(message, "ledger repair") {
+			t.Fatalf("%s: message %q does not give the reason", mutation.target, message)
+		}
+	}
+	if alice := server.account("alice"); alice.Balance != 100 {
+		t.Fatalf("balance = %d while locked, want 100", alice.Balance)
+	}
+
+	recorder = server.request(http.MethodDelete, "/account/alice/lock", nil, adminAuth...)
+	expectStatus(t, recorder, http.StatusOK)
+	if alice := server.account("alice"); alice.Locked || alice.LockReason != "" || alice.LockedBy != "" {
+		t.Fatalf("unlocked account = %+v", alice)
+	}
+	server.deposit("alice", 10)
+	server.transfer("alice", "bob", 10)
+}
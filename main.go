@@ -2,22 +2,43 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type JsonMessage struct {
-	Message string `json:"message"`
+	Message string              `json:"message"`
+	Code    string              `json:"code,omitempty"`
+	Errors  []FieldErrorMessage `json:"errors,omitempty"`
 }
 
+// isUsernameValid accepts word characters only. Control and formatting
+// characters, such as zero-width joiners, are rejected on their own as
+// well, so that loosening the pattern or normalizing usernames differently
+// can never let invisible characters into a username.
 func isUsernameValid(userName string) bool {
+	if strings.TrimSpace(userName) == "" {
+		return false
+	}
+	for _, character := range userName {
+		if unicode.IsControl(character) || unicode.Is(unicode.Cf, character) {
+			return false
+		}
+	}
 	return regexp.MustCompile(`^[\w]+$`).MatchString(userName)
 }
 
@@ -25,88 +46,415 @@ type ErrInvalidUsername struct {
 	UserName string
 }
 
+func (err *ErrInvalidUsername) Code() string {
+	return "ErrUsername"
+}
+
+func (err *ErrInvalidUsername) messageArgs() []any {
+	return []any{err.UserName}
+}
+
 func (err *ErrInvalidUsername) Error() string {
-	return fmt.Sprintf("ErrUsername: username \"%s\" is invalid.", err.UserName)
+	return localizeError(defaultLanguage, err)
 }
 
 type ErrSameSourceAndTarget struct{}
 
+func (err *ErrSameSourceAndTarget) Code() string {
+	return "ErrSameSourceAndTarget"
+}
+
+func (err *ErrSameSourceAndTarget) messageArgs() []any {
+	return nil
+}
+
 func (err *ErrSameSourceAndTarget) Error() string {
-	return "ErrSameSourceAndTarget: source and target account cannot be the same."
+	return localizeError(defaultLanguage, err)
 }
 
 type ErrInputRead struct {
 	InputError error
 }
 
+func (err *ErrInputRead) Code() string {
+	return "ErrInputRead"
+}
+
+func (err *ErrInputRead) messageArgs() []any {
+	return []any{err.InputError}
+}
+
 func (err *ErrInputRead) Error() string {
-	return fmt.Sprintf("Failed to read input: %v.", err.InputError)
+	return localizeError(defaultLanguage, err)
 }
 
 type ErrLessThanEqualZero struct {
 	Name string
 }
 
+func (err *ErrLessThanEqualZero) Code() string {
+	return "ErrLessThanEqualZero"
+}
+
+func (err *ErrLessThanEqualZero) messageArgs() []any {
+	return []any{err.Name}
+}
+
 func (err *ErrLessThanEqualZero) Error() string {
-	return fmt.Sprintf("ErrLessThanEqualZero: Value \"%s\" must be greater than zero.", err.Name)
+	return localizeError(defaultLanguage, err)
+}
+
+// maxSafeAmount is the largest integer a client that handles JSON numbers
+// as doubles, like JavaScript, can send without rounding: 2^53 - 1.
+const maxSafeAmount = 1<<53 - 1
+
+// amountPrecisionGuard mirrors Config.RejectUnsafeAmounts for the input
+// validators, like usernameNormalization.
+var amountPrecisionGuard bool
+
+type ErrAmountPrecisionLoss struct {
+	Name   string
+	Amount int
+}
+
+func (err *ErrAmountPrecisionLoss) Code() string {
+	return "ErrAmountPrecisionLoss"
+}
+
+func (err *ErrAmountPrecisionLoss) messageArgs() []any {
+	return []any{err.Name, err.Amount, maxSafeAmount}
+}
+
+func (err *ErrAmountPrecisionLoss) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+// amountError validates an amount: it must be positive and, with the
+// precision guard on, no larger than maxSafeAmount, because a bigger value
+// may already have been rounded by the client.
+func amountError(name string, amount int) error {
+	if amount <= 0 {
+		return &ErrLessThanEqualZero{Name: name}
+	}
+	if amountPrecisionGuard && amount > maxSafeAmount {
+		return &ErrAmountPrecisionLoss{Name: name, Amount: amount}
+	}
+	return nil
+}
+
+type ErrNegativeValue struct {
+	Name string
+}
+
+func (err *ErrNegativeValue) Code() string {
+	return "ErrNegativeValue"
+}
+
+func (err *ErrNegativeValue) messageArgs() []any {
+	return []any{err.Name}
+}
+
+func (err *ErrNegativeValue) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrInsufficientFunds struct {
+	UserName string
+	Balance  int
+	Amount   int
+}
+
+func (err *ErrInsufficientFunds) Code() string {
+	return "ErrInsufficientFunds"
+}
+
+func (err *ErrInsufficientFunds) messageArgs() []any {
+	return []any{err.UserName, err.Balance, err.Amount}
+}
+
+func (err *ErrInsufficientFunds) Error() string {
+	return localizeError(defaultLanguage, err)
 }
 
 type TransferNote struct {
 	FromUser string `json:"fromuser"`
 	ToUser   string `json:"touser"`
 	Amount   int    `json:"amount"`
+	Category string `json:"category,omitempty"`
+	Memo     string `json:"memo,omitempty"`
+	// TransferID is an optional client generated ID that makes retries of
+	// POST /transfer and /transfer/batch safe: a repeated ID returns the
+	// original result.
+	TransferID string `json:"transferId,omitempty"`
 }
 
 func (note *TransferNote) Error() error {
-	if note.Amount <= 0 {
-		return &ErrLessThanEqualZero{Name: "Amount"}
+	var validationErrors MultiError
+	if err := amountError("Amount", note.Amount); err != nil {
+		validationErrors.Add("amount", err)
 	}
 	if !isUsernameValid(note.FromUser) {
-		return &ErrInvalidUsername{UserName: note.FromUser}
+		validationErrors.Add("fromuser", &ErrInvalidUsername{UserName: note.FromUser})
 	}
 	if !isUsernameValid(note.ToUser) {
-		return &ErrInvalidUsername{UserName: note.ToUser}
+		validationErrors.Add("touser", &ErrInvalidUsername{UserName: note.ToUser})
+	} else if note.FromUser == note.ToUser {
+		validationErrors.Add("touser", &ErrSameSourceAndTarget{})
 	}
-	if note.FromUser == note.ToUser {
-		return &ErrSameSourceAndTarget{}
+	if !isCategoryValid(note.Category) {
+		validationErrors.Add("category", &ErrCategoryTooLong{Max: maxCategoryLength})
 	}
-	return nil
+	if utf8.RuneCountInString(note.Memo) > maxMemoLength {
+		validationErrors.Add("memo", &ErrFieldTooLong{Name: "memo", Max: maxMemoLength})
+	}
+	if utf8.RuneCountInString(note.TransferID) > maxIdempotencyKeyLength {
+		validationErrors.Add("transferId", &ErrFieldTooLong{Name: "transferId", Max: maxIdempotencyKeyLength})
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+func (note *TransferNote) normalizeUsernames() {
+	note.FromUser = normalizeUsername(note.FromUser)
+	note.ToUser = normalizeUsername(note.ToUser)
 }
 
 type BankAccount struct {
-	UserName string `json:"username"`
-	Balance  int    `json:"balance"`
-	Debt     int    `json:"debt"`
+	UserName          string       `json:"username"`
+	Balance           int          `json:"balance"`
+	Debt              int          `json:"debt"`
+	Held              int          `json:"held"`
+	Email             sealedString `json:"email,omitempty"`
+	EmailVerified     bool         `json:"emailVerified"`
+	VerificationToken string       `json:"-"`
+	Closed            bool         `json:"closed"`
+	Frozen            bool         `json:"frozen"`
+	Locked            bool         `json:"locked"`
+	LockReason        string       `json:"lockReason,omitempty"`
+	LockedBy          string       `json:"lockedBy,omitempty"`
+	LastPenaltyDate   time.Time    `json:"-"`
+	UpdatedAt         time.Time    `json:"updatedAt"`
+	// CreatedAt is zero for accounts opened before it was recorded.
+	CreatedAt time.Time `json:"createdAt"`
+	// AccountNumber is empty for accounts opened before numbers were issued.
+	AccountNumber string `json:"accountNumber,omitempty"`
+	// LowBalanceThreshold and HighBalanceThreshold trigger balance alerts
+	// when crossed; zero disables either. BalanceAlertState remembers the
+	// last alert so it is not repeated.
+	LowBalanceThreshold  int    `json:"lowBalanceThreshold,omitempty"`
+	HighBalanceThreshold int    `json:"highBalanceThreshold,omitempty"`
+	BalanceAlertState    string `json:"balanceAlertState,omitempty"`
+	// DebtRepaymentPolicy is "auto" (the default) to pay debt before
+	// crediting the balance, or "balance-first" to leave debt untouched.
+	DebtRepaymentPolicy string `json:"debtRepaymentPolicy,omitempty"`
+	// AllowNegative marks an internal account whose balance may go below
+	// zero; overdrafts lower the balance instead of adding debt.
+	AllowNegative bool `json:"allowNegative"`
+	// Metadata holds free-form labels attached by integrators, such as a
+	// region or tier.
+	Metadata sealedMetadata `json:"metadata,omitempty"`
+	// DebtGraceUntil is when debt incurred from zero starts accruing
+	// penalties. It is cleared once the debt is paid off.
+	DebtGraceUntil time.Time `json:"-"`
+	// Version counts the writes to the account. Whole-document writes only
+	// land while it still matches the version that was read.
+	Version int64 `json:"-"`
+}
+
+type ErrAccountLimitReached struct {
+	Limit int
+}
+
+func (err *ErrAccountLimitReached) Code() string {
+	return "ErrAccountLimitReached"
+}
+
+func (err *ErrAccountLimitReached) messageArgs() []any {
+	return []any{err.Limit}
+}
+
+func (err *ErrAccountLimitReached) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func checkAccountLimit(ctx context.Context, accountCollection *mongo.Collection, config *Config) error {
+	if config.MaxAccounts == 0 {
+		return nil
+	}
+	accountCount, err := accountCollection.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	if accountCount >= int64(config.MaxAccounts) {
+		return &ErrAccountLimitReached{Limit: config.MaxAccounts}
+	}
+	return nil
 }
 
 type ErrUserAlreadyExist struct {
 	Account BankAccount
 }
 
+func (err *ErrUserAlreadyExist) Code() string {
+	return "ErrUserAlreadyExist"
+}
+
+func (err *ErrUserAlreadyExist) messageArgs() []any {
+	return []any{err.Account.UserName}
+}
+
 func (err *ErrUserAlreadyExist) Error() string {
-	return fmt.Sprintf("ErrUserAlreadyExist: user \"%s\" already exist.", err.Account.UserName)
+	return localizeError(defaultLanguage, err)
 }
 
 func (account *BankAccount) Error() error {
+	var validationErrors MultiError
 	if !isUsernameValid(account.UserName) {
-		return &ErrInvalidUsername{UserName: account.UserName}
+		validationErrors.Add("username", &ErrInvalidUsername{UserName: account.UserName})
 	}
-	return nil
+	if account.Email != "" && !isEmailValid(string(account.Email)) {
+		validationErrors.Add("email", &ErrInvalidEmail{Email: string(account.Email)})
+	}
+	if !isDebtRepaymentPolicyValid(account.DebtRepaymentPolicy) {
+		validationErrors.Add("debtRepaymentPolicy", &ErrInvalidDebtRepaymentPolicy{Policy: account.DebtRepaymentPolicy})
+	}
+	if len(account.Metadata) > maxMetadataEntries {
+		validationErrors.Add("metadata", &ErrTooManyMetadataEntries{Max: maxMetadataEntries})
+	}
+	for key, value := range account.Metadata {
+		addMetadataEntryErrors(&validationErrors, key, &value)
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+func (account *BankAccount) normalizeUsernames() {
+	account.UserName = normalizeUsername(account.UserName)
 }
 
 type TransactionInput struct {
 	UserName string `json:"username"`
 	Amount   int    `json:"amount"`
+	Category string `json:"category,omitempty"`
 }
 
 func (deposit *TransactionInput) Error() error {
+	var validationErrors MultiError
 	if !isUsernameValid(deposit.UserName) {
-		return &ErrInvalidUsername{UserName: deposit.UserName}
+		validationErrors.Add("username", &ErrInvalidUsername{UserName: deposit.UserName})
 	}
-	if deposit.Amount <= 0 {
-		return &ErrLessThanEqualZero{Name: "amount"}
+	if err := amountError("amount", deposit.Amount); err != nil {
+		validationErrors.Add("amount", err)
 	}
-	return nil
+	if !isCategoryValid(deposit.Category) {
+		validationErrors.Add("category", &ErrCategoryTooLong{Max: maxCategoryLength})
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+func (deposit *TransactionInput) normalizeUsernames() {
+	deposit.UserName = normalizeUsername(deposit.UserName)
+}
+
+type DepositBreakdown struct {
+	AppliedToDebt    int `json:"appliedToDebt"`
+	AppliedToBalance int `json:"appliedToBalance"`
+	RemainingDebt    int `json:"remainingDebt"`
+}
+
+const (
+	appliedToDebtHeader    = "X-Applied-To-Debt"
+	appliedToBalanceHeader = "X-Applied-To-Balance"
+	remainingDebtHeader    = "X-Remaining-Debt"
+)
+
+// setDepositBreakdownHeaders repeats the breakdown of a deposit response in
+// headers, for integrators that do not read the body.
+func setDepositBreakdownHeaders(ctx *gin.Context, breakdown DepositBreakdown) {
+	ctx.Header(appliedToDebtHeader, strconv.Itoa(breakdown.AppliedToDebt))
+	ctx.Header(appliedToBalanceHeader, strconv.Itoa(breakdown.AppliedToBalance))
+	ctx.Header(remainingDebtHeader, strconv.Itoa(breakdown.RemainingDebt))
+}
+
+type DepositResult struct {
+	BankAccount
+	Applied   bool             `json:"applied"`
+	Message   string           `json:"message,omitempty"`
+	Breakdown DepositBreakdown `json:"breakdown"`
+}
+
+type ErrMaxBalanceExceeded struct {
+	UserName         string
+	MaxBalance       int
+	ResultingBalance int
+}
+
+func (err *ErrMaxBalanceExceeded) Code() string {
+	return "ErrMaxBalanceExceeded"
+}
+
+func (err *ErrMaxBalanceExceeded) messageArgs() []any {
+	return []any{err.UserName, err.ResultingBalance, err.MaxBalance}
+}
+
+func (err *ErrMaxBalanceExceeded) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrConcurrentModification struct {
+	UserName string
+}
+
+func (err *ErrConcurrentModification) Code() string {
+	return "ErrConcurrentModification"
+}
+
+func (err *ErrConcurrentModification) messageArgs() []any {
+	return []any{err.UserName}
+}
+
+func (err *ErrConcurrentModification) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrConcurrentModification) Status() int {
+	return http.StatusConflict
+}
+
+type ErrInvariantViolation struct {
+	UserName string
+	Balance  int
+	Debt     int
+}
+
+func (err *ErrInvariantViolation) Code() string {
+	return "ErrInvariantViolation"
+}
+
+func (err *ErrInvariantViolation) messageArgs() []any {
+	return []any{err.UserName}
+}
+
+func (err *ErrInvariantViolation) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrInvariantViolation) Status() int {
+	return http.StatusInternalServerError
+}
+
+// assertAccountInvariants guards persisted state against arithmetic bugs
+// such as overflow: balance and debt may never be negative, and holds may
+// never reserve more than the balance. A violation is a server fault, so it
+// is logged and reported as a 500.
+func assertAccountInvariants(account BankAccount) error {
+	if account.AllowNegative && account.Debt >= 0 && account.Held >= 0 {
+		return nil
+	}
+	if account.Balance >= 0 && account.Debt >= 0 && account.Held >= 0 && account.Held <= account.Balance {
+		return nil
+	}
+	log.Printf("refusing to store account %s: balance %d, debt %d, held %d violate invariants",
+		account.UserName, account.Balance, account.Debt, account.Held)
+	return &ErrInvariantViolation{UserName: account.UserName, Balance: account.Balance, Debt: account.Debt}
 }
 
 func min(firstValue, secondValue int) int {
@@ -116,84 +464,386 @@ func min(firstValue, secondValue int) int {
 	return secondValue
 }
 
-func sendErrorJSON(ctx *gin.Context, message string) {
-	ctx.JSON(http.StatusBadRequest, JsonMessage{Message: message})
+// apiVersionHeader lets clients opt into the enveloped response format by
+// sending a version of at least envelopeAPIVersion. Older clients keep
+// receiving bare objects.
+const (
+	apiVersionHeader   = "X-Api-Version"
+	envelopeAPIVersion = 2
+)
+
+type ResponseEnvelope struct {
+	Success   bool                `json:"success"`
+	Data      any                 `json:"data"`
+	Error     *string             `json:"error"`
+	Code      string              `json:"code,omitempty"`
+	Errors    []FieldErrorMessage `json:"errors,omitempty"`
+	Timestamp time.Time           `json:"timestamp"`
+}
+
+func wantsEnvelope(ctx *gin.Context) bool {
+	version, err := strconv.Atoi(ctx.GetHeader(apiVersionHeader))
+	return err == nil && version >= envelopeAPIVersion
+}
+
+// respondNoChange answers 204 No Content to a request that would store
+// exactly what is already stored: setting an account option, or the
+// maintenance switch, to its current value. Nothing is written. Deposits,
+// withdrawals and transfers always change state and keep answering 200, as
+// does a deposit refused by ?maxBalance=, whose body explains the refusal.
+func respondNoChange(ctx *gin.Context) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func respond(ctx *gin.Context, status int, data any) {
+	if ctx.GetBool(hideDebtKey) {
+		data = withoutDebt(data)
+	}
+	if fields := ctx.GetStringSlice(accountFieldsKey); len(fields) > 0 {
+		data = withAccountFields(data, fields)
+	}
+	data = withFieldNaming(ctx, data)
+	if !wantsEnvelope(ctx) {
+		ctx.JSON(status, data)
+		return
+	}
+	ctx.JSON(status, ResponseEnvelope{
+		Success:   true,
+		Data:      data,
+		Timestamp: requestClock(ctx).Now().UTC(),
+	})
 }
 
-func createErrorMessage(errorType, message string) string {
-	return fmt.Sprintf("%v: %v.", errorType, message)
+// describeError returns the localized message, catalog code and per-field
+// messages reported to clients for err.
+func describeError(language string, err error) (string, string, []FieldErrorMessage) {
+	message, code := err.Error(), ""
+	var knownError catalogError
+	if errors.As(err, &knownError) {
+		message, code = localizeError(language, knownError), knownError.Code()
+	}
+	var fieldMessages []FieldErrorMessage
+	var multiError *MultiError
+	if errors.As(err, &multiError) {
+		fieldMessages = fieldErrorMessages(language, multiError)
+	}
+	return message, code, fieldMessages
+}
+
+// respondError localizes catalog errors to the request language and exposes
+// their code. Any other error is passed through with its own message.
+func respondError(ctx *gin.Context, status int, err error) {
+	message, code, fieldMessages := describeError(requestLanguage(ctx), err)
+	for i := range fieldMessages {
+		fieldMessages[i].Field = requestFieldName(ctx, fieldMessages[i].Field)
+	}
+
+	if !wantsEnvelope(ctx) {
+		ctx.JSON(status, JsonMessage{Message: message, Code: code, Errors: fieldMessages})
+		return
+	}
+	ctx.JSON(status, ResponseEnvelope{
+		Success:   false,
+		Error:     &message,
+		Code:      code,
+		Errors:    fieldMessages,
+		Timestamp: requestClock(ctx).Now().UTC(),
+	})
+}
+
+func isStrictRequest(ctx *gin.Context) bool {
+	return queryFlag(ctx, "strict")
+}
+
+func queryFlag(ctx *gin.Context, name string) bool {
+	flag, err := strconv.ParseBool(ctx.Query(name))
+	return err == nil && flag
+}
+
+// statusError is implemented by errors that should not be answered with the
+// default 400 Bad Request.
+type statusError interface {
+	error
+	Status() int
+}
+
+// errorStatus is the HTTP status for err, 400 unless it says otherwise.
+func errorStatus(err error) int {
+	var errWithStatus statusError
+	if errors.As(err, &errWithStatus) {
+		return errWithStatus.Status()
+	}
+	return http.StatusBadRequest
 }
 
 func sendError(ctx *gin.Context, err error) {
-	sendErrorJSON(ctx, err.Error())
+	respondError(ctx, errorStatus(err), err)
+}
+
+type ErrUserNotFound struct {
+	UserName string
+}
+
+func (err *ErrUserNotFound) Code() string {
+	return "ErrNoDocuments"
+}
+
+func (err *ErrUserNotFound) messageArgs() []any {
+	return []any{err.UserName}
+}
+
+func (err *ErrUserNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
 }
 
 func sendErrUserNotFound(ctx *gin.Context, err error, userName string) bool {
 	if err == mongo.ErrNoDocuments {
-		sendErrorJSON(ctx, createErrorMessage("ErrNoDocuments",
-			fmt.Sprintf("User %s not found", userName),
-		))
+		sendError(ctx, &ErrUserNotFound{UserName: userName})
 		return true
 	}
 	return false
 }
 
-func getAllAccountHandler(accountCollection *mongo.Collection) func(*gin.Context) {
+// getAllAccountHandler returns at most MaxPageSize accounts; a smaller
+// ?limit= may be requested. The applied limit is sent in X-Page-Limit.
+// ?modifiedSince= restricts the list to accounts changed after that time.
+// With ?stream=true the accounts are streamed straight from the cursor and
+// MaxPageSize does not apply; an explicit ?limit= still does.
+func getAllAccountHandler(accountCollection *mongo.Collection, config *Config) func(*gin.Context) {
 	return func(ctx *gin.Context) {
-		accountSearchResult, err := accountCollection.Find(context.TODO(), bson.D{})
+		stream := queryFlag(ctx, "stream")
+		defaultLimit := int64(config.MaxPageSize)
+		if stream {
+			defaultLimit = 0
+		}
+		limit, err := parsePositiveQuery(ctx, "limit", defaultLimit)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if !stream && limit > int64(config.MaxPageSize) {
+			limit = int64(config.MaxPageSize)
+		}
+		if limit > 0 {
+			ctx.Header(pageLimitHeader, strconv.FormatInt(limit, 10))
+		}
+		paged := config.PagedAccountList && !stream
+		page := int64(defaultPage)
+		if paged {
+			if page, err = parsePositiveQuery(ctx, "page", defaultPage); err != nil {
+				sendError(ctx, err)
+				return
+			}
+		}
+		fields, err := parseAccountFields(ctx, config)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		// Without a sort the server returns natural order, which changes as
+		// documents move; username order is stable and served by its index.
+		accountFilter := bson.D{}
+		findOptions := options.Find().SetLimit(limit).SetSort(bson.D{{Key: "username", Value: 1}})
+		if projection := accountProjection(fields); projection != nil {
+			findOptions.SetProjection(projection)
+		}
+		if rawModifiedSince, ok := ctx.GetQuery("modifiedSince"); ok {
+			modifiedSince, err := time.Parse(time.RFC3339, rawModifiedSince)
+			if err != nil {
+				sendError(ctx, &ErrInvalidQueryParam{Name: "modifiedSince", Value: rawModifiedSince})
+				return
+			}
+			// Oldest changes first, so a client can resume from the last
+			// updatedAt it received.
+			accountFilter = bson.D{{Key: "updatedat", Value: bson.D{{Key: "$gt", Value: modifiedSince}}}}
+			findOptions.SetSort(bson.D{{Key: "updatedat", Value: 1}, {Key: "username", Value: 1}})
+		}
+
+		pagination := Pagination{Page: page, Limit: limit}
+		findOptions.SetSkip(pagination.Skip())
+
+		accountSearchResult, err := accountCollection.Find(ctx.Request.Context(), accountFilter, findOptions)
 		if err != nil {
 			sendError(ctx, &ErrInputRead{InputError: err})
 			return
 		}
-		accountList := make([]BankAccount, accountSearchResult.RemainingBatchLength())
-		accountSearchResult.All(context.TODO(), &accountList)
-		ctx.JSON(http.StatusOK, accountList)
+		if stream {
+			streamCursor[BankAccount](ctx, accountSearchResult)
+			return
+		}
+		// Start from a non-nil slice so an empty result is [] and never null.
+		accountList := []BankAccount{}
+		if err := accountSearchResult.All(ctx.Request.Context(), &accountList); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if !paged {
+			respond(ctx, http.StatusOK, accountList)
+			return
+		}
+
+		total, err := accountCollection.CountDocuments(ctx.Request.Context(), accountFilter)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		respond(ctx, http.StatusOK, AccountPage{
+			Accounts:   accountList,
+			Total:      total,
+			Pagination: pagination,
+		})
 	}
 }
 
-func createAccountHandler(accountCollection *mongo.Collection) func(*gin.Context) {
+func getDebtorsHandler(accountCollection *mongo.Collection, config *Config) func(*gin.Context) {
 	return func(ctx *gin.Context) {
-		var newAccount BankAccount
-		if err := ctx.BindJSON(&newAccount); err != nil {
-			sendError(ctx, &ErrInputRead{InputError: err})
+		pagination, err := parsePagination(ctx, int64(config.MaxPageSize))
+		if err != nil {
+			sendError(ctx, err)
 			return
 		}
 
-		if err := newAccount.Error(); err != nil {
+		fields, err := parseAccountFields(ctx, config)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		debtorFilter := bson.D{{Key: "debt", Value: bson.D{{Key: "$gt", Value: 0}}}}
+		total, err := accountCollection.CountDocuments(ctx.Request.Context(), debtorFilter)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		findOptions := options.Find().
+			SetSort(bson.D{{Key: "debt", Value: -1}, {Key: "username", Value: 1}}).
+			SetSkip(pagination.Skip()).
+			SetLimit(pagination.Limit)
+		if projection := accountProjection(fields); projection != nil {
+			findOptions.SetProjection(projection)
+		}
+		debtorSearchResult, err := accountCollection.Find(ctx.Request.Context(), debtorFilter, findOptions)
+		if err != nil {
 			sendError(ctx, err)
 			return
 		}
+		debtorList := []BankAccount{}
+		if err := debtorSearchResult.All(ctx.Request.Context(), &debtorList); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		respond(ctx, http.StatusOK, AccountPage{
+			Accounts:   debtorList,
+			Total:      total,
+			Pagination: pagination,
+		})
+	}
+}
+
+// createAccount stores newAccount with the server-managed fields reset.
+// With upsert an existing account is returned untouched with 200 instead
+// of failing, so provisioning systems can retry safely; otherwise the
+// status is 201.
+func createAccount(
+	ctx context.Context, accountRepository *AccountRepository, transactionCollection *mongo.Collection,
+	config *Config, newAccount BankAccount, upsert bool,
+) (BankAccount, int, error) {
+	newAccount.Balance = config.SignupBonus
+	newAccount.Debt, newAccount.Held = 0, 0
+	newAccount.EmailVerified = false
+	newAccount.Closed, newAccount.Frozen = false, false
+	newAccount.Locked, newAccount.LockReason, newAccount.LockedBy = false, "", ""
+	newAccount.AllowNegative = false
+	newAccount.BalanceAlertState = balanceAlertState(newAccount)
+	newAccount.UpdatedAt = config.Clock.Now().UTC()
+	newAccount.CreatedAt = newAccount.UpdatedAt
+	if newAccount.Email != "" {
+		newAccount.VerificationToken = randomHex(16)
+	}
+
+	if existingAccount, err := accountRepository.FindFreshByUsername(ctx, newAccount.UserName); err == nil {
+		if upsert {
+			return existingAccount, http.StatusOK, nil
+		}
+		return newAccount, 0, &ErrUserAlreadyExist{Account: newAccount}
+	}
+
+	if err := checkAccountLimit(ctx, accountRepository.Collection(), config); err != nil {
+		return newAccount, 0, err
+	}
+	accountNumber, err := assignAccountNumber(ctx, accountRepository.Collection(), config)
+	if err != nil {
+		return newAccount, 0, err
+	}
+	newAccount.AccountNumber = accountNumber
+
+	if upsert {
+		storedAccount, inserted, err := accountRepository.InsertIfAbsent(ctx, newAccount)
+		if err != nil {
+			return newAccount, 0, err
+		}
+		if !inserted {
+			return storedAccount, http.StatusOK, nil
+		}
+	} else if _, err := accountRepository.Collection().InsertOne(ctx, newAccount); err != nil {
+		// A concurrent create for the same username won the unique index;
+		// the account number index can also collide, so look before saying
+		// which.
+		if mongo.IsDuplicateKeyError(err) {
+			if _, findErr := accountRepository.FindFreshByUsername(ctx, newAccount.UserName); findErr == nil {
+				return newAccount, 0, &ErrUserAlreadyExist{Account: newAccount}
+			}
+		}
+		return newAccount, 0, err
+	}
+
+	recordTransaction(transactionCollection,
+		newTransaction(config.Clock, newAccount, transactionTypeBonus, config.SignupBonus))
 
-		newAccount.Balance = 0
+	if newAccount.VerificationToken != "" {
+		sendVerificationToken(config, newAccount)
+	}
+	return newAccount, http.StatusCreated, nil
+}
 
-		if err := accountCollection.FindOne(context.TODO(), bson.D{{
-			Key: "username", Value: newAccount.UserName,
-		}}).Err(); err == nil {
-			sendError(ctx, &ErrUserAlreadyExist{Account: newAccount})
+func createAccountHandler(
+	accountRepository *AccountRepository, transactionCollection *mongo.Collection, config *Config,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		newAccount, ok := bindAndValidate[BankAccount](ctx)
+		if !ok {
 			return
 		}
 
-		if _, err := accountCollection.InsertOne(ctx, newAccount); err != nil {
+		storedAccount, status, err := createAccount(ctx.Request.Context(), accountRepository,
+			transactionCollection, config, newAccount, queryFlag(ctx, "upsert"))
+		if err != nil {
 			sendError(ctx, err)
 			return
 		}
 
-		ctx.JSON(http.StatusCreated, newAccount)
+		respond(ctx, status, storedAccount)
 	}
 }
 
-func getAccountHandler(accountCollection *mongo.Collection) func(*gin.Context) {
+func getAccountHandler(accountRepository *AccountRepository, config *Config) func(*gin.Context) {
 	return func(ctx *gin.Context) {
 		var accountInput BankAccount
 		if err := ctx.BindJSON(&accountInput); err != nil {
 			sendError(ctx, &ErrInputRead{InputError: err})
 			return
 		}
+		accountInput.normalizeUsernames()
+		if _, err := parseAccountFields(ctx, config); err != nil {
+			sendError(ctx, err)
+			return
+		}
 
-		var accountSearch BankAccount
-		if err := accountCollection.FindOne(context.TODO(), bson.D{{
-			Key: "username", Value: accountInput.UserName,
-		}}).Decode(&accountSearch); err != nil {
+		accountSearch, err := accountRepository.FindByUsername(ctx.Request.Context(), accountInput.UserName)
+		if err != nil {
 			if sendErrUserNotFound(ctx, err, accountInput.UserName) {
 				return
 			}
@@ -201,66 +851,121 @@ func getAccountHandler(accountCollection *mongo.Collection) func(*gin.Context) {
 			return
 		}
 
-		ctx.JSON(http.StatusOK, accountSearch)
+		setAccountETag(ctx, accountSearch)
+		if setLastModified(ctx, accountSearch) {
+			ctx.Status(http.StatusNotModified)
+			return
+		}
+		respond(ctx, http.StatusOK, accountSearch)
 	}
 }
 
-func depositToAccountHandler(accountCollection *mongo.Collection) func(*gin.Context) {
+func depositToAccountHandler(
+	accountRepository *AccountRepository, transactionCollection *mongo.Collection, config *Config,
+	publisher EventPublisher,
+) func(*gin.Context) {
 	return func(ctx *gin.Context) {
-		var depositInput TransactionInput
-		if err := ctx.BindJSON(&depositInput); err != nil {
-			sendError(ctx, &ErrInputRead{InputError: err})
+		depositInput, ok := bindAndValidate[TransactionInput](ctx)
+		if !ok {
 			return
 		}
 
-		if err := depositInput.Error(); err != nil {
+		targetAccount, err := accountRepository.FindFreshByUsername(ctx.Request.Context(), depositInput.UserName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, depositInput.UserName) {
+				return
+			}
 			sendError(ctx, err)
 			return
 		}
 
-		var targetAccount BankAccount
-		searchFilter := bson.D{{Key: "username", Value: depositInput.UserName}}
-		if err := accountCollection.FindOne(
-			context.TODO(), searchFilter,
-		).Decode(&targetAccount); err != nil {
-			if sendErrUserNotFound(ctx, err, depositInput.UserName) {
-				return
-			}
+		if sendErrPreconditionFailed(ctx, config, targetAccount) {
+			return
+		}
+
+		if err := checkAccountOpen(targetAccount); err != nil {
 			sendError(ctx, err)
 			return
 		}
 
-		if targetAccount.Debt > 0 {
-			payedAmount := min(targetAccount.Debt, depositInput.Amount)
-			targetAccount.Debt -= payedAmount
-			depositInput.Amount -= payedAmount
+		rawMaxBalance, hasMaxBalance := ctx.GetQuery("maxBalance")
+		maxBalance, err := strconv.Atoi(rawMaxBalance)
+		if hasMaxBalance && (err != nil || maxBalance < 0) {
+			sendError(ctx, &ErrInvalidQueryParam{Name: "maxBalance", Value: rawMaxBalance})
+			return
 		}
 
-		targetAccount.Balance += depositInput.Amount
-		accountCollection.ReplaceOne(context.TODO(), searchFilter, targetAccount)
+		originalAccount := targetAccount
+		payedAmount := creditAccount(&targetAccount, depositInput.Amount)
+		alertEvents := updateBalanceAlert(config.Clock, &targetAccount)
+
+		if hasMaxBalance {
+			if targetAccount.Balance > maxBalance {
+				breakdown := DepositBreakdown{RemainingDebt: originalAccount.Debt}
+				setAccountETag(ctx, originalAccount)
+				setDepositBreakdownHeaders(ctx, breakdown)
+				respond(ctx, http.StatusOK, DepositResult{
+					BankAccount: originalAccount,
+					Applied:     false,
+					Message: localizeError(requestLanguage(ctx), &ErrMaxBalanceExceeded{
+						UserName:         originalAccount.UserName,
+						MaxBalance:       maxBalance,
+						ResultingBalance: targetAccount.Balance,
+					}),
+					Breakdown: breakdown,
+				})
+				return
+			}
 
-		ctx.JSON(http.StatusOK, targetAccount)
+			// The guard was evaluated against originalAccount, so the write
+			// only lands if nobody changed the account in between.
+			replaced, err := accountRepository.ReplaceIfUnchanged(ctx.Request.Context(), originalAccount, &targetAccount)
+			if err != nil {
+				sendError(ctx, err)
+				return
+			}
+			if !replaced {
+				sendError(ctx, &ErrConcurrentModification{UserName: targetAccount.UserName})
+				return
+			}
+		} else if err := accountRepository.Replace(ctx.Request.Context(), &targetAccount); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		depositTransaction := newTransaction(config.Clock, targetAccount, transactionTypeDeposit, depositInput.Amount)
+		depositTransaction.Category = depositInput.Category
+		recordTransaction(transactionCollection, depositTransaction)
+		retainHistory(transactionCollection, config, targetAccount.UserName)
+		publishEvents(publisher, append(alertEvents,
+			newEvent(config.Clock, eventTypeDeposit, targetAccount.UserName, depositTransaction)))
+
+		breakdown := DepositBreakdown{
+			AppliedToDebt:    payedAmount,
+			AppliedToBalance: depositInput.Amount - payedAmount,
+			RemainingDebt:    targetAccount.Debt,
+		}
+		setAccountETag(ctx, targetAccount)
+		setDepositBreakdownHeaders(ctx, breakdown)
+		respond(ctx, http.StatusOK, DepositResult{
+			BankAccount: targetAccount,
+			Applied:     true,
+			Breakdown:   breakdown,
+		})
 	}
 }
 
-func withdrawFromAccountHandler(accountCollection *mongo.Collection) func(*gin.Context) {
+func withdrawFromAccountHandler(
+	accountRepository *AccountRepository, transactionCollection *mongo.Collection, config *Config,
+	publisher EventPublisher,
+) func(*gin.Context) {
 	return func(ctx *gin.Context) {
-		var withdrawInput TransactionInput
-		if err := ctx.BindJSON(&withdrawInput); err != nil {
-			sendError(ctx, &ErrInputRead{InputError: err})
-			return
-		}
-
-		if err := withdrawInput.Error(); err != nil {
-			sendError(ctx, err)
+		withdrawInput, ok := bindAndValidate[TransactionInput](ctx)
+		if !ok {
 			return
 		}
 
-		var targetAccount BankAccount
-		searchFilter := bson.D{{Key: "username", Value: withdrawInput.UserName}}
-		if err := accountCollection.FindOne(
-			context.TODO(), searchFilter,
-		).Decode(&targetAccount); err != nil {
+		targetAccount, err := accountRepository.FindFreshByUsername(ctx.Request.Context(), withdrawInput.UserName)
+		if err != nil {
 			if sendErrUserNotFound(ctx, err, withdrawInput.UserName) {
 				return
 			}
@@ -268,68 +973,416 @@ func withdrawFromAccountHandler(accountCollection *mongo.Collection) func(*gin.C
 			return
 		}
 
-		withdrawnAmount := min(targetAccount.Balance, withdrawInput.Amount)
-		targetAccount.Balance -= withdrawnAmount
-		targetAccount.Debt += withdrawInput.Amount - withdrawnAmount
-		accountCollection.ReplaceOne(context.TODO(), searchFilter, targetAccount)
-
-		ctx.JSON(http.StatusOK, targetAccount)
-	}
-}
-
-func transferHandler(accountCollection *mongo.Collection) func(*gin.Context) {
-	return func(ctx *gin.Context) {
-		var transferNote TransferNote
-		if err := ctx.BindJSON(&transferNote); err != nil {
-			sendError(ctx, &ErrInputRead{InputError: err})
+		if sendErrPreconditionFailed(ctx, config, targetAccount) {
 			return
 		}
 
-		if err := transferNote.Error(); err != nil {
+		if err := checkAccountOpen(targetAccount); err != nil {
 			sendError(ctx, err)
 			return
 		}
 
-		var sourceAccount BankAccount
-		sourceFilter := bson.D{{Key: "username", Value: transferNote.FromUser}}
-		if err := accountCollection.FindOne(
-			context.TODO(), sourceFilter,
-		).Decode(&sourceAccount); err != nil {
-			if sendErrUserNotFound(ctx, err, transferNote.FromUser) {
-				return
-			}
+		if err := checkUnverifiedLimit(config, targetAccount, withdrawInput.Amount); err != nil {
 			sendError(ctx, err)
 			return
 		}
 
-		var targetAccount BankAccount
-		targetFilter := bson.D{{Key: "username", Value: transferNote.ToUser}}
-		if err := accountCollection.FindOne(
-			context.TODO(), targetFilter,
-		).Decode(&targetAccount); err != nil {
-			if sendErrUserNotFound(ctx, err, transferNote.ToUser) {
+		var alertEvents []Event
+		// A points ledger has no debt, so every withdrawal is strict there.
+		if isStrictRequest(ctx) || config.LedgerMode == ledgerModePoints {
+			// The balance check happens in the update filter so a concurrent
+			// debit cannot push the account into debt.
+			debitedAccount, err := accountRepository.DebitIfCovered(
+				ctx.Request.Context(), targetAccount.UserName, withdrawInput.Amount)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				sendError(ctx, &ErrInsufficientFunds{
+					UserName: targetAccount.UserName,
+					Balance:  availableBalance(targetAccount),
+					Amount:   withdrawInput.Amount,
+				})
 				return
 			}
-			sendError(ctx, err)
+			if err != nil {
+				sendError(ctx, err)
+				return
+			}
+			targetAccount = debitedAccount
+			previousAlertState := targetAccount.BalanceAlertState
+			alertEvents = updateBalanceAlert(config.Clock, &targetAccount)
+			if targetAccount.BalanceAlertState != previousAlertState {
+				if err := accountRepository.SetBalanceAlertState(ctx.Request.Context(),
+					targetAccount.UserName, targetAccount.BalanceAlertState); err != nil {
+					log.Printf("failed to store balance alert state for user %s: %v", targetAccount.UserName, err)
+				}
+			}
+		} else {
+			debitAccount(&targetAccount, withdrawInput.Amount)
+			alertEvents = updateBalanceAlert(config.Clock, &targetAccount)
+			if err := accountRepository.Replace(ctx.Request.Context(), &targetAccount); err != nil {
+				sendError(ctx, err)
+				return
+			}
+		}
+		withdrawTransaction := newTransaction(config.Clock, targetAccount, transactionTypeWithdraw, withdrawInput.Amount)
+		withdrawTransaction.Category = withdrawInput.Category
+		recordTransaction(transactionCollection, withdrawTransaction)
+		retainHistory(transactionCollection, config, targetAccount.UserName)
+		publishEvents(publisher, append(alertEvents,
+			newEvent(config.Clock, eventTypeWithdraw, targetAccount.UserName, withdrawTransaction)))
+
+		setAccountETag(ctx, targetAccount)
+		respond(ctx, http.StatusOK, targetAccount)
+	}
+}
+
+type transferOptions struct {
+	// strict rejects the transfer instead of letting the source go into debt.
+	strict bool
+	// checkSource, when set, can veto the transfer after the source is read.
+	checkSource func(sourceAccount BankAccount) error
+	// inTransaction, when set, runs inside the same transaction right before
+	// it commits.
+	inTransaction func(sessionCtx mongo.SessionContext) error
+	// releaseHold frees that much of the source's held funds before the
+	// debit, for transfers that settle an earlier reservation.
+	releaseHold int
+	// recordResult, when set, receives the result inside the transaction,
+	// after inTransaction.
+	recordResult func(sessionCtx mongo.SessionContext, transferResult TransferResult) error
+}
+
+// TransferResult holds the final source and target accounts of a transfer
+// and the history entries that debited the source and credited the target.
+type TransferResult struct {
+	Accounts            []BankAccount      `json:"accounts"`
+	DebitTransactionID  primitive.ObjectID `json:"debitTransactionId"`
+	CreditTransactionID primitive.ObjectID `json:"creditTransactionId"`
+}
+
+// executeTransfer moves the amount, and any configured fee, in a single
+// transaction and returns the final source and target accounts along with
+// the debit and credit history entries.
+func executeTransfer(
+	ctx context.Context, accountRepository *AccountRepository, transactionCollection *mongo.Collection,
+	config *Config, publisher EventPublisher, transferNote TransferNote, transferOptions transferOptions,
+) (TransferResult, error) {
+	accountCollection := accountRepository.Collection()
+	var alertEvents []Event
+	// Opposite transfers between the same two accounts would otherwise take
+	// the documents in opposite order and keep aborting each other, so both
+	// reads and writes go in username order. A write conflict that still
+	// happens surfaces as a TransientTransactionError, on which
+	// WithTransaction reruns the whole callback.
+	transferResult, err := runInTransaction(ctx, accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+		// The callback may be retried, so only the last attempt's events count.
+		alertEvents = nil
+
+		sourceAccount, targetAccount, err := findAccountPairInSession(
+			sessionCtx, accountCollection, transferNote.FromUser, transferNote.ToUser)
+		if err != nil {
+			return nil, err
+		}
+		// Compare the stored accounts rather than the request, so however
+		// the two sides were addressed they cannot be the same account.
+		if sourceAccount.UserName == targetAccount.UserName {
+			return nil, &ErrSameSourceAndTarget{}
+		}
+		// Every eligibility check on either side runs before any balance
+		// changes, so a refused transfer leaves both accounts untouched.
+		if transferOptions.checkSource != nil {
+			if err := transferOptions.checkSource(sourceAccount); err != nil {
+				return nil, err
+			}
+		}
+		if err := checkAccountOpen(sourceAccount); err != nil {
+			return nil, err
+		}
+		if err := checkAccountOpen(targetAccount); err != nil {
+			return nil, err
+		}
+		if err := checkUnverifiedLimit(config, sourceAccount, transferNote.Amount); err != nil {
+			return nil, err
+		}
+
+		fee := config.TransferFee.For(transferNote.Amount)
+		if sourceAccount.UserName == config.FeeAccount || targetAccount.UserName == config.FeeAccount {
+			fee = 0
+		}
+		debitedAmount := transferNote.Amount
+		feePayer := &sourceAccount
+		if config.TransferFeePayer == feePayerTarget {
+			if fee > transferNote.Amount {
+				return nil, &ErrFeeExceedsAmount{Fee: fee, Amount: transferNote.Amount}
+			}
+			feePayer = &targetAccount
+		} else {
+			debitedAmount += fee
+		}
+		var feeAccount BankAccount
+		if fee > 0 {
+			feeAccount, err = findAccountInSession(sessionCtx, accountCollection, config.FeeAccount)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var historyEntries []Transaction
+		if transferOptions.releaseHold > 0 {
+			sourceAccount.Held -= transferOptions.releaseHold
+			historyEntries = append(historyEntries,
+				newTransaction(config.Clock, sourceAccount, transactionTypeHoldRelease, transferOptions.releaseHold))
+		}
+
+		strict := transferOptions.strict || config.LedgerMode == ledgerModePoints
+		if strict && !coversDebit(sourceAccount, debitedAmount) {
+			return nil, &ErrInsufficientFunds{
+				UserName: sourceAccount.UserName,
+				Balance:  availableBalance(sourceAccount),
+				Amount:   debitedAmount,
+			}
+		}
+
+		creditAccount(&targetAccount, transferNote.Amount)
+		creditTransaction := newTransaction(config.Clock, targetAccount, transactionTypeTransferIn, transferNote.Amount)
+		creditTransaction.Counterparty = sourceAccount.UserName
+		creditTransaction.Category = transferNote.Category
+		creditTransaction.Memo = transferNote.Memo
+
+		debitAccount(&sourceAccount, transferNote.Amount)
+		debitTransaction := newTransaction(config.Clock, sourceAccount, transactionTypeTransferOut, transferNote.Amount)
+		debitTransaction.Counterparty = targetAccount.UserName
+		debitTransaction.Category = transferNote.Category
+		debitTransaction.Memo = transferNote.Memo
+
+		debitIndex := len(historyEntries)
+		historyEntries = append(historyEntries, debitTransaction, creditTransaction)
+		changedAccounts := []*BankAccount{&sourceAccount, &targetAccount}
+		if fee > 0 {
+			debitAccount(feePayer, fee)
+			feeTransaction := newTransaction(config.Clock, *feePayer, transactionTypeFee, fee)
+			feeTransaction.Counterparty = feeAccount.UserName
+
+			creditAccount(&feeAccount, fee)
+			feeIncomeTransaction := newTransaction(config.Clock, feeAccount, transactionTypeFeeIncome, fee)
+			feeIncomeTransaction.Counterparty = feePayer.UserName
+
+			alertEvents = append(alertEvents, updateBalanceAlert(config.Clock, &feeAccount)...)
+			changedAccounts = append(changedAccounts, &feeAccount)
+			historyEntries = append(historyEntries, feeTransaction, feeIncomeTransaction)
+		}
+
+		alertEvents = append(alertEvents, updateBalanceAlert(config.Clock, &targetAccount)...)
+		alertEvents = append(alertEvents, updateBalanceAlert(config.Clock, &sourceAccount)...)
+		if err := replaceInOrder(sessionCtx, accountRepository, changedAccounts...); err != nil {
+			return nil, err
+		}
+		for i, transaction := range historyEntries {
+			if historyEntries[i], err = insertTransaction(sessionCtx, transactionCollection, transaction); err != nil {
+				return nil, err
+			}
+		}
+
+		if transferOptions.inTransaction != nil {
+			if err := transferOptions.inTransaction(sessionCtx); err != nil {
+				return nil, err
+			}
+		}
+		transferResult := TransferResult{
+			Accounts:            []BankAccount{sourceAccount, targetAccount},
+			DebitTransactionID:  historyEntries[debitIndex].ID,
+			CreditTransactionID: historyEntries[debitIndex+1].ID,
+		}
+		if transferOptions.recordResult != nil {
+			if err := transferOptions.recordResult(sessionCtx, transferResult); err != nil {
+				return nil, err
+			}
+		}
+
+		return transferResult, nil
+	})
+	accountRepository.Invalidate(transferNote.FromUser, transferNote.ToUser, config.FeeAccount)
+	if err != nil {
+		return TransferResult{}, err
+	}
+	retainHistory(transactionCollection, config, transferNote.FromUser, transferNote.ToUser, config.FeeAccount)
+	publishEvents(publisher, append(alertEvents,
+		newEvent(config.Clock, eventTypeTransfer, transferNote.FromUser, transferNote)))
+	return transferResult.(TransferResult), nil
+}
+
+// transferHandler answers with the final source and target accounts. With
+// ?transactionIds=true it wraps them in a TransferResult, so the debit and
+// credit entries can be looked up later under
+// /account/:username/transactions/:id.
+func transferHandler(
+	accountRepository *AccountRepository, transactionCollection, executedCollection *mongo.Collection,
+	config *Config, publisher EventPublisher,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		transferNote, ok := bindAndValidate[TransferNote](ctx)
+		if !ok {
 			return
 		}
 
-		payedAmount := min(transferNote.Amount, targetAccount.Debt)
-		targetAccount.Debt -= payedAmount
-		targetAccount.Balance += transferNote.Amount - payedAmount
-		accountCollection.ReplaceOne(context.TODO(), targetFilter, targetAccount)
+		transferResult, replayed, err := executeTransferOnce(ctx.Request.Context(), accountRepository,
+			transactionCollection, executedCollection, config, publisher, transferNote, transferOptions{
+				strict: isStrictRequest(ctx),
+				checkSource: func(sourceAccount BankAccount) error {
+					return checkIfMatch(ctx, config, sourceAccount)
+				},
+			},
+		)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if replayed {
+			ctx.Header(transferReplayedHeader, "true")
+		}
 
-		transferredAmount := min(transferNote.Amount, sourceAccount.Balance)
-		sourceAccount.Balance -= transferredAmount
-		sourceAccount.Debt += transferNote.Amount - transferredAmount
-		accountCollection.ReplaceOne(context.TODO(), sourceFilter, sourceAccount)
+		if queryFlag(ctx, "transactionIds") {
+			respond(ctx, http.StatusOK, transferResult)
+			return
+		}
+		respond(ctx, http.StatusOK, transferResult.Accounts)
+	}
+}
 
-		ctx.JSON(http.StatusOK, []BankAccount{sourceAccount, targetAccount})
+// newRouter wires every handler against the given storage, so the same
+// routing can be served from main or driven through httptest.
+func newRouter(
+	accountRepository *AccountRepository,
+	transactionCollection, scheduledCollection, holdCollection, pendingCollection, executedCollection *mongo.Collection,
+	config *Config, publisher EventPublisher, webhookDispatcher *WebhookDispatcher, drain *requestDrain,
+) *gin.Engine {
+	accountCollection := accountRepository.Collection()
+	listAccountCollection := readCollection(accountCollection, config)
+	listTransactionCollection := readCollection(transactionCollection, config)
+	maintenance := newMaintenanceSwitch(config.MaintenanceMode)
+
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(noRouteHandler)
+	router.NoMethod(noMethodHandler)
+	router.Use(clockMiddleware(config), requestLogMiddleware(), compressionMiddleware(config), requestIDMiddleware(),
+		tracingMiddleware(),
+		recoveryMiddleware(), corsMiddleware(config), maintenanceMiddleware(maintenance),
+		signedRequestMiddleware(config), drainMiddleware(drain), inFlightLimitMiddleware(config),
+		requestTimeoutMiddleware(config), ledgerModeMiddleware(config), fieldNamingMiddleware(config),
+		strictQueryMiddleware(config))
+
+	router.GET("/version", versionHandler)
+	router.GET("/system/extremes", getExtremesHandler(listAccountCollection))
+
+	router.GET("/account", getAccountHandler(accountRepository, config))
+	router.GET("/account/all", getAllAccountHandler(listAccountCollection, config))
+	router.GET("/account/debtors", getDebtorsHandler(listAccountCollection, config))
+	router.GET("/account/created", getAccountsByCreationHandler(listAccountCollection, config))
+	router.GET("/account/by-number/:number", getAccountByNumberHandler(accountCollection))
+	router.GET("/account/as-of", getAccountAsOfHandler(listTransactionCollection))
+	router.GET("/account/average-daily-balance", getAverageDailyBalanceHandler(listTransactionCollection, config))
+	router.GET("/account/interest/projection", projectInterestHandler(accountRepository, config))
+	router.GET("/account/interest/summary", getInterestSummaryHandler(listTransactionCollection))
+	router.GET("/account/:username/transactions", getTransactionsHandler(listTransactionCollection, config))
+	router.GET("/account/:username/transactions/:id", getTransactionHandler(transactionCollection))
+	router.POST("/transactions/search", searchTransactionsHandler(listTransactionCollection, config))
+	router.GET("/transactions/export", adminAuthMiddleware(config), exportTransactionsHandler(listTransactionCollection))
+	router.GET("/account/:username/close-preview", closePreviewHandler(accountRepository))
+	router.GET("/account/:username/payoff", getPayoffAmountHandler(accountRepository, config))
+	router.POST("/account/create", createAccountHandler(accountRepository, transactionCollection, config))
+	router.POST("/account/create/batch", createAccountsBatchHandler(accountRepository, transactionCollection, config))
+	router.POST("/account/verify-email", verifyEmailHandler(accountRepository))
+	router.POST("/account/close-with-transfer", closeWithTransferHandler(accountRepository, transactionCollection, config))
+	router.POST("/account/merge", mergeAccountsHandler(accountRepository, transactionCollection, config))
+
+	router.POST("/deposit", depositToAccountHandler(accountRepository, transactionCollection, config, publisher))
+	router.POST("/withdraw", withdrawFromAccountHandler(accountRepository, transactionCollection, config, publisher))
+	router.POST("/transfer", transferHandler(accountRepository, transactionCollection, executedCollection,
+		config, publisher))
+	router.POST("/transfer/batch", transferBatchHandler(accountRepository, transactionCollection, executedCollection,
+		config, publisher))
+	router.POST("/account/hold", placeHoldHandler(accountRepository, transactionCollection, holdCollection, config))
+	router.POST("/account/capture", settleHoldHandler(accountRepository, transactionCollection, holdCollection,
+		config, publisher, true))
+	router.POST("/account/release", settleHoldHandler(accountRepository, transactionCollection, holdCollection,
+		config, publisher, false))
+	router.POST("/transfer/multi-source", multiSourceTransferHandler(accountRepository, transactionCollection,
+		config, publisher))
+	router.POST("/transfer/initiate", initiateTransferHandler(accountRepository, transactionCollection,
+		pendingCollection, config))
+	router.POST("/transfer/confirm", confirmTransferHandler(accountRepository, transactionCollection,
+		pendingCollection, config, publisher))
+	router.POST("/transfer/cancel", cancelTransferHandler(accountRepository, transactionCollection,
+		pendingCollection, config))
+	router.POST("/transfer/schedule", scheduleTransferHandler(scheduledCollection, config))
+	router.POST("/transfer/schedule/cancel", cancelScheduledTransferHandler(scheduledCollection))
+
+	router.POST("/account/:username/lock", adminAuthMiddleware(config), lockAccountHandler(accountRepository))
+	router.DELETE("/account/:username/lock", adminAuthMiddleware(config), unlockAccountHandler(accountRepository))
+	router.POST("/account/:username/balance-alerts", setBalanceThresholdsHandler(accountRepository, config))
+	router.POST("/account/:username/debt-repayment-policy", setDebtRepaymentPolicyHandler(accountRepository, config))
+	router.PATCH("/account/:username/metadata", patchMetadataHandler(accountRepository, config))
+	router.POST("/account/:username/allow-negative", adminAuthMiddleware(config), setAllowNegativeHandler(accountRepository))
+
+	admin := router.Group("/admin", adminAuthMiddleware(config))
+	admin.GET("/dashboard", getDashboardHandler(listAccountCollection, listTransactionCollection, config))
+	router.POST("/account/freeze/batch", adminAuthMiddleware(config), freezeAccountsBatchHandler(accountRepository))
+
+	admin.POST("/rebuild-accounts", rebuildAccountsHandler(accountRepository, transactionCollection))
+	admin.GET("/transfer-graph", getTransferGraphHandler(listTransactionCollection))
+	admin.POST("/ledger-adjustments", adjustLedgerHandler(accountRepository, transactionCollection))
+	admin.GET("/reconciliation", reconcileAllHandler(listAccountCollection, listTransactionCollection, config))
+	admin.GET("/maintenance", getMaintenanceHandler(maintenance))
+	admin.POST("/maintenance", setMaintenanceHandler(maintenance))
+	admin.POST("/webhooks", registerWebhookHandler(webhookDispatcher))
+	admin.DELETE("/webhooks/:id", unregisterWebhookHandler(webhookDispatcher))
+	admin.GET("/webhooks/:id/deliveries", getWebhookDeliveriesHandler(webhookDispatcher, config))
+	if config.OperationsAdmin {
+		mongoClient := accountRepository.Collection().Database().Client()
+		admin.GET("/operations", listOperationsHandler(mongoClient))
+		admin.POST("/operations/:opid/kill", killOperationHandler(mongoClient))
 	}
+
+	return router
+}
+
+// applyProcessConfig copies the settings that input validators and BSON
+// codecs read from package variables, since they have no access to the
+// config.
+func applyProcessConfig(config *Config) error {
+	usernameNormalization = config.NormalizeUsernames
+	amountPrecisionGuard = config.RejectUnsafeAmounts
+	var err error
+	fieldEncryption, err = newFieldCipher(config.FieldEncryptionKeys, config.FieldEncryptionKeyVersion)
+	return err
 }
 
 func main() {
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := applyProcessConfig(config); err != nil {
+		log.Fatal(err)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if err := validateMongoURI(config.MongoURI, config.AppEnv); err != nil {
+		if config.MongoURICheck == mongoURICheckRefuse {
+			log.Fatal(err)
+		}
+		log.Printf("warning: %v", err)
+	}
+	clientOptions := options.Client().ApplyURI(config.MongoURI)
 
 	// Connect to MongoDB
 	client, err := mongo.Connect(context.TODO(), clientOptions)
@@ -349,18 +1402,66 @@ func main() {
 
 	goDatabase := client.Database("goDatabase")
 	accountCollection := goDatabase.Collection("BankAccount")
+	transactionCollection := goDatabase.Collection("Transactions")
+	scheduledCollection := goDatabase.Collection("ScheduledTransfers")
+	holdCollection := goDatabase.Collection("Holds")
+	pendingCollection := goDatabase.Collection("PendingTransfers")
+	executedCollection := goDatabase.Collection("ExecutedTransfers")
+	webhookCollection := goDatabase.Collection("Webhooks")
+	deliveryCollection := goDatabase.Collection("WebhookDeliveries")
+
+	if config.NormalizeUsernames {
+		renamedCount, err := migrateUsernames(context.TODO(), accountCollection, []usernameReference{
+			{collection: transactionCollection, field: "username"},
+			{collection: transactionCollection, field: "counterparty"},
+			{collection: transactionCollection, field: "mergedfrom"},
+			{collection: holdCollection, field: "username"},
+			{collection: pendingCollection, field: "fromuser"},
+			{collection: pendingCollection, field: "touser"},
+			{collection: scheduledCollection, field: "fromuser"},
+			{collection: scheduledCollection, field: "touser"},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if renamedCount > 0 {
+			log.Printf("normalized %d stored usernames", renamedCount)
+		}
+	}
+	if err := ensureAccountIndexes(accountCollection); err != nil {
+		log.Fatal(err)
+	}
+	if err := ensureTransactionIndexes(transactionCollection); err != nil {
+		log.Fatal(err)
+	}
+	if err := ensureScheduledTransferIndexes(scheduledCollection); err != nil {
+		log.Fatal(err)
+	}
+	if err := ensurePendingTransferIndexes(pendingCollection); err != nil {
+		log.Fatal(err)
+	}
+	if err := ensureExecutedTransferIndexes(executedCollection, config); err != nil {
+		log.Fatal(err)
+	}
+	if err := ensureWebhookIndexes(webhookCollection, deliveryCollection); err != nil {
+		log.Fatal(err)
+	}
 
-	router := gin.Default()
-
-	router.GET("/account", getAccountHandler(accountCollection))
-	router.GET("/account/all", getAllAccountHandler(accountCollection))
-	router.POST("/account/create", createAccountHandler(accountCollection))
-
-	router.POST("/deposit", depositToAccountHandler(accountCollection))
-	router.POST("/withdraw", withdrawFromAccountHandler(accountCollection))
-	router.POST("/transfer", transferHandler(accountCollection))
-
-	router.Run("localhost:8080")
+	accountRepository := newAccountRepository(accountCollection, config)
+	startAccrualJob(accountRepository, transactionCollection, config)
+	webhookDispatcher := newWebhookDispatcher(webhookCollection, deliveryCollection, config, logEventPublisher{})
+	publisher := webhookDispatcher
+	startTransferScheduler(accountRepository, transactionCollection, scheduledCollection, config, publisher)
+	startPendingTransferExpiry(accountRepository, transactionCollection, pendingCollection, config)
+
+	drain := newRequestDrain()
+	router := newRouter(accountRepository, transactionCollection, scheduledCollection, holdCollection,
+		pendingCollection, executedCollection, config, publisher, webhookDispatcher, drain)
+	server, err := newServer(config, router)
+	if err != nil {
+		log.Fatal(err)
+	}
+	serveUntilSignalled(server, drain, config)
 
 	err = client.Disconnect(context.TODO())
 
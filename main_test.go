@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCreateAndGetAccount(t *testing.T) {
+	server := newTestServer(t)
+
+	created := server.createAccount("alice")
+	if created.UserName != "alice" || created.Balance != 0 || created.Debt != 0 {
+		t.Fatalf("created account = %+v", created)
+	}
+
+	recorder := server.request(http.MethodGet, "/account", BankAccount{UserName: "alice"})
+	expectStatus(t, recorder, http.StatusOK)
+	if account := decodeResponse[BankAccount](t, recorder); account.UserName != "alice" {
+		t.Fatalf("read account = %+v", account)
+	}
+
+	recorder = server.request(http.MethodPost, "/account/create", BankAccount{UserName: "alice"})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrUserAlreadyExist")
+
+	recorder = server.request(http.MethodGet, "/account", BankAccount{UserName: "nobody"})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrNoDocuments")
+}
+
+func TestConcurrentCreateAccount(t *testing.T) {
+	server := newTestServer(t)
+
+	const attempts = 8
+	recorders := make([]*httptest.ResponseRecorder, attempts)
+	var wait sync.WaitGroup
+	for i := range recorders {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			recorders[i] = server.request(http.MethodPost, "/account/create", BankAccount{UserName: "alice"})
+		}(i)
+	}
+	wait.Wait()
+
+	created := 0
+	for _, recorder := range recorders {
+		if recorder.Code == http.StatusCreated {
+			created++
+			continue
+		}
+		expectErrorCode(t, recorder, http.StatusBadRequest, "ErrUserAlreadyExist")
+	}
+	if created != 1 {
+		t.Fatalf("%d creates succeeded, want 1", created)
+	}
+}
+
+func TestDepositAndWithdraw(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+
+	server.deposit("alice", 100)
+	server.withdraw("alice", 30)
+	if account := server.account("alice"); account.Balance != 70 || account.Debt != 0 {
+		t.Fatalf("after deposit and withdrawal: %+v", account)
+	}
+
+	// Overdrawing turns the shortfall into debt.
+	server.withdraw("alice", 100)
+	if account := server.account("alice"); account.Balance != 0 || account.Debt != 30 {
+		t.Fatalf("after overdraft: %+v", account)
+	}
+
+	var types []string
+	for _, transaction := range server.history("alice") {
+		types = append(types, transaction.Type)
+	}
+	want := []string{transactionTypeBonus, transactionTypeDeposit, transactionTypeWithdraw, transactionTypeWithdraw}
+	if len(types) != len(want) {
+		t.Fatalf("history types = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("history types = %v, want %v", types, want)
+		}
+	}
+}
+
+func TestDepositRejectsInvalidInput(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+
+	recorder := server.request(http.MethodPost, "/deposit", TransactionInput{UserName: "alice", Amount: -5})
+	expectStatus(t, recorder, http.StatusBadRequest)
+	recorder = server.request(http.MethodPost, "/deposit", TransactionInput{UserName: "ghost", Amount: 5})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrNoDocuments")
+}
+
+func TestTransfer(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	recorder := server.request(http.MethodPost, "/transfer", TransferNote{FromUser: "alice", ToUser: "bob", Amount: 40})
+	expectStatus(t, recorder, http.StatusOK)
+	accounts := decodeResponse[[]BankAccount](t, recorder)
+	if len(accounts) != 2 || accounts[0].Balance != 60 || accounts[1].Balance != 40 {
+		t.Fatalf("transfer response = %+v", accounts)
+	}
+	if alice, bob := server.account("alice"), server.account("bob"); alice.Balance != 60 || bob.Balance != 40 {
+		t.Fatalf("stored balances = %d and %d", alice.Balance, bob.Balance)
+	}
+
+	recorder = server.request(http.MethodPost, "/transfer", TransferNote{FromUser: "alice", ToUser: "alice", Amount: 1})
+	expectStatus(t, recorder, http.StatusBadRequest)
+}
+
+// expectBreakdownHeaders fails the test unless the deposit headers repeat
+// the breakdown in the body.
+func expectBreakdownHeaders(t *testing.T, recorder *httptest.ResponseRecorder, breakdown DepositBreakdown) {
+	t.Helper()
+	for header, want := range map[string]int{
+		appliedToDebtHeader:    breakdown.AppliedToDebt,
+		appliedToBalanceHeader: breakdown.AppliedToBalance,
+		remainingDebtHeader:    breakdown.RemainingDebt,
+	} {
+		if value := recorder.Header().Get(header); value != strconv.Itoa(want) {
+			t.Fatalf("%s = %q, want %d", header, value, want)
+		}
+	}
+}
+
+func TestDepositBreakdown(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name        string
+		debt        int
+		policy      string
+		amount      int
+		want        DepositBreakdown
+		wantBalance int
+	}{
+		{name: "no debt", amount: 20, want: DepositBreakdown{AppliedToBalance: 20}, wantBalance: 20},
+		{name: "less than the debt", debt: 50, amount: 20, want: DepositBreakdown{AppliedToDebt: 20, RemainingDebt: 30}},
+		{name: "equal to the debt", debt: 50, amount: 50, want: DepositBreakdown{AppliedToDebt: 50}},
+		{name: "more than the debt", debt: 50, amount: 80,
+			want: DepositBreakdown{AppliedToDebt: 50, AppliedToBalance: 30}, wantBalance: 30},
+		{name: "balance first", debt: 50, policy: debtRepaymentBalanceFirst, amount: 20,
+			want: DepositBreakdown{AppliedToBalance: 20, RemainingDebt: 50}, wantBalance: 20},
+	}
+	// The cases share one server, so they run in the parent test.
+	for i, test := range tests {
+		userName := fmt.Sprintf("debtor%d", i)
+		server.createAccount(userName)
+		if test.debt > 0 {
+			server.withdraw(userName, test.debt)
+		}
+		if test.policy != "" {
+			recorder := server.request(http.MethodPost, "/account/"+userName+"/debt-repayment-policy",
+				DebtRepaymentPolicyInput{Policy: test.policy})
+			expectStatus(t, recorder, http.StatusOK)
+		}
+
+		recorder := server.request(http.MethodPost, "/deposit", TransactionInput{UserName: userName, Amount: test.amount})
+		expectStatus(t, recorder, http.StatusOK)
+		result := decodeResponse[DepositResult](t, recorder)
+		if !result.Applied || result.Breakdown != test.want {
+			t.Fatalf("%s: breakdown = %+v, want %+v", test.name, result.Breakdown, test.want)
+		}
+		if result.Balance != test.wantBalance || result.Debt != test.want.RemainingDebt {
+			t.Fatalf("%s: account = %+v", test.name, result.BankAccount)
+		}
+		expectBreakdownHeaders(t, recorder, result.Breakdown)
+	}
+}
+
+func TestGetDebtors(t *testing.T) {
+	server := newTestServer(t)
+	for userName, debt := range map[string]int{"alice": 30, "bob": 70, "carol": 0, "dave": 30} {
+		server.createAccount(userName)
+		if debt > 0 {
+			server.withdraw(userName, debt)
+		}
+	}
+	server.deposit("carol", 10)
+
+	recorder := server.request(http.MethodGet, "/account/debtors", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	page := decodeResponse[AccountPage](t, recorder)
+	var userNames []string
+	for _, account := range page.Accounts {
+		userNames = append(userNames, account.UserName)
+	}
+	// Largest debt first, ties broken by username.
+	if page.Total != 3 || strings.Join(userNames, ",") != "bob,alice,dave" {
+		t.Fatalf("debtors = %v (total %d), want bob, alice, dave", userNames, page.Total)
+	}
+}
+
+// Opposite transfers between the same two accounts take the documents in
+// the same order, so none of them fails on a write conflict and none of
+// their updates is lost.
+func TestConcurrentOppositeTransfers(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 1000)
+	server.deposit("bob", 1000)
+	aliceHistory, bobHistory := len(server.history("alice")), len(server.history("bob"))
+
+	const transfersPerDirection = 20
+	notes := make([]TransferNote, 0, 2*transfersPerDirection)
+	for i := 0; i < transfersPerDirection; i++ {
+		notes = append(notes,
+			TransferNote{FromUser: "alice", ToUser: "bob", Amount: 3},
+			TransferNote{FromUser: "bob", ToUser: "alice", Amount: 2})
+	}
+	recorders := make([]*httptest.ResponseRecorder, len(notes))
+	var wait sync.WaitGroup
+	for i := range notes {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			recorders[i] = server.request(http.MethodPost, "/transfer", notes[i])
+		}(i)
+	}
+	wait.Wait()
+
+	for i, recorder := range recorders {
+		if recorder.Code != http.StatusOK {
+			t.Errorf("transfer %d: status %d: %s", i, recorder.Code, recorder.Body.String())
+		}
+	}
+	alice, bob := server.account("alice"), server.account("bob")
+	if alice.Balance != 980 || bob.Balance != 1020 || alice.Debt != 0 || bob.Debt != 0 {
+		t.Fatalf("alice %+v, bob %+v, want balances 980 and 1020", alice, bob)
+	}
+	if length := len(server.history("alice")); length != aliceHistory+len(notes) {
+		t.Fatalf("alice has %d history entries, want %d", length, aliceHistory+len(notes))
+	}
+	if length := len(server.history("bob")); length != bobHistory+len(notes) {
+		t.Fatalf("bob has %d history entries, want %d", length, bobHistory+len(notes))
+	}
+}
+
+func TestTransferStrictVersusLenient(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 30)
+	overdraft := TransferNote{FromUser: "alice", ToUser: "bob", Amount: 50}
+
+	recorder := server.request(http.MethodPost, "/transfer?strict=true", overdraft)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInsufficientFunds")
+	if alice, bob := server.account("alice"), server.account("bob"); alice.Balance != 30 || alice.Debt != 0 ||
+		bob.Balance != 0 {
+		t.Fatalf("after strict refusal: alice %+v, bob %+v", alice, bob)
+	}
+
+	// Without ?strict the same transfer goes through and the shortfall
+	// becomes debt.
+	recorder = server.request(http.MethodPost, "/transfer", overdraft)
+	expectStatus(t, recorder, http.StatusOK)
+	if alice, bob := server.account("alice"), server.account("bob"); alice.Balance != 0 || alice.Debt != 20 ||
+		bob.Balance != 50 {
+		t.Fatalf("after lenient transfer: alice %+v, bob %+v", alice, bob)
+	}
+}
+
+func TestSignupBonus(t *testing.T) {
+	for _, bonus := range []int{0, 25} {
+		t.Run(fmt.Sprintf("bonus %d", bonus), func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.SignupBonus = bonus
+			})
+			if created := server.createAccount("alice"); created.Balance != bonus {
+				t.Fatalf("created balance = %d, want %d", created.Balance, bonus)
+			}
+			if account := server.account("alice"); account.Balance != bonus || account.Debt != 0 {
+				t.Fatalf("stored account = %+v", account)
+			}
+			history := server.history("alice")
+			if len(history) != 1 || history[0].Type != transactionTypeBonus || history[0].Amount != bonus ||
+				history[0].Balance != bonus {
+				t.Fatalf("history = %+v, want one %d bonus entry", history, bonus)
+			}
+		})
+	}
+}
+
+func TestMaxAccounts(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.MaxAccounts = 3
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+
+	recorder := server.request(http.MethodPost, "/account/create/batch", CreateAccountsBatchInput{
+		Accounts: []BankAccount{{UserName: "carol"}, {UserName: "dave"}},
+	})
+	expectStatus(t, recorder, http.StatusMultiStatus)
+	batch := decodeResponse[MultiStatusResponse](t, recorder)
+	if batch.Succeeded != 1 || batch.Items[0].Status != http.StatusCreated ||
+		batch.Items[1].Code != "ErrAccountLimitReached" {
+		t.Fatalf("batch at the cap = %+v", batch)
+	}
+
+	recorder = server.request(http.MethodPost, "/account/create", BankAccount{UserName: "erin"})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrAccountLimitReached")
+	if accountCount, err := server.accounts.Collection().CountDocuments(context.Background(), bson.D{}); err != nil ||
+		accountCount != 3 {
+		t.Fatalf("stored %d accounts (%v), want 3", accountCount, err)
+	}
+}
+
+func TestDepositMaxBalance(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.deposit("alice", 60)
+
+	tests := []struct {
+		name    string
+		amount  int
+		applied bool
+		balance int
+	}{
+		{name: "stays under", amount: 30, applied: true, balance: 90},
+		{name: "reaches the threshold", amount: 10, applied: true, balance: 100},
+		{name: "would exceed", amount: 1, balance: 100},
+	}
+	for _, test := range tests {
+		recorder := server.request(http.MethodPost, "/deposit?maxBalance=100",
+			TransactionInput{UserName: "alice", Amount: test.amount})
+		expectStatus(t, recorder, http.StatusOK)
+		result := decodeResponse[DepositResult](t, recorder)
+		if result.Applied != test.applied || result.Balance != test.balance ||
+			(test.applied == (result.Message != "")) {
+			t.Fatalf("%s: result = %+v", test.name, result)
+		}
+		if account := server.account("alice"); account.Balance != test.balance {
+			t.Fatalf("%s: stored balance = %d, want %d", test.name, account.Balance, test.balance)
+		}
+		expectBreakdownHeaders(t, recorder, result.Breakdown)
+	}
+
+	recorder := server.request(http.MethodPost, "/deposit?maxBalance=-1", TransactionInput{UserName: "alice", Amount: 1})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidQueryParam")
+}
+
+func TestWithdrawStrictVersusLenient(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.deposit("alice", 30)
+	overdraft := TransactionInput{UserName: "alice", Amount: 50}
+	historyLength := len(server.history("alice"))
+
+	recorder := server.request(http.MethodPost, "/withdraw?strict=true", overdraft)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInsufficientFunds")
+	if alice := server.account("alice"); alice.Balance != 30 || alice.Debt != 0 {
+		t.Fatalf("after strict refusal: %+v", alice)
+	}
+
+	recorder = server.request(http.MethodPost, "/withdraw?strict=true", TransactionInput{UserName: "alice", Amount: 30})
+	expectStatus(t, recorder, http.StatusOK)
+	if alice := decodeResponse[BankAccount](t, recorder); alice.Balance != 0 || alice.Debt != 0 {
+		t.Fatalf("strict withdrawal of the whole balance: %+v", alice)
+	}
+	server.deposit("alice", 30)
+
+	recorder = server.request(http.MethodPost, "/withdraw", overdraft)
+	expectStatus(t, recorder, http.StatusOK)
+	if alice := server.account("alice"); alice.Balance != 0 || alice.Debt != 20 {
+		t.Fatalf("after lenient withdrawal: %+v", alice)
+	}
+	if history := server.history("alice"); len(history) != historyLength+3 {
+		t.Fatalf("history has %d entries, want %d: the refused withdrawal must not be recorded",
+			len(history), historyLength+3)
+	}
+}
+
+func TestUpsertAccount(t *testing.T) {
+	server := newTestServer(t)
+
+	recorder := server.request(http.MethodPost, "/account/create?upsert=true",
+		BankAccount{UserName: "alice", Email: "alice@example.com"})
+	expectStatus(t, recorder, http.StatusCreated)
+	created := decodeResponse[BankAccount](t, recorder)
+	server.deposit("alice", 75)
+
+	// A retry, even one asking for a different balance, returns the
+	// stored account untouched.
+	recorder = server.request(http.MethodPost, "/account/create?upsert=true",
+		BankAccount{UserName: "alice", Balance: 0, Email: "other@example.com"})
+	expectStatus(t, recorder, http.StatusOK)
+	existing := decodeResponse[BankAccount](t, recorder)
+	if existing.Balance != 75 || existing.Email != created.Email || existing.AccountNumber != created.AccountNumber {
+		t.Fatalf("repeat create = %+v, want the stored account", existing)
+	}
+	if alice := server.account("alice"); alice.Balance != 75 || alice.Email != "alice@example.com" {
+		t.Fatalf("stored account = %+v", alice)
+	}
+	if history := server.history("alice"); len(history) != 2 {
+		t.Fatalf("history = %+v, want the bonus and the deposit only", history)
+	}
+
+	recorder = server.request(http.MethodPost, "/account/create", BankAccount{UserName: "alice"})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrUserAlreadyExist")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maintenanceRoute      = "/admin/maintenance"
+	maintenanceRetryAfter = time.Minute
+)
+
+type ErrMaintenanceMode struct{}
+
+func (err *ErrMaintenanceMode) Code() string {
+	return "ErrMaintenanceMode"
+}
+
+func (err *ErrMaintenanceMode) messageArgs() []any {
+	return nil
+}
+
+func (err *ErrMaintenanceMode) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrMaintenanceMode) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+// maintenanceSwitch holds whether maintenance mode is on. It starts from
+// MAINTENANCE_MODE and can be flipped at runtime by an admin.
+type maintenanceSwitch struct {
+	enabled atomic.Bool
+}
+
+func newMaintenanceSwitch(enabled bool) *maintenanceSwitch {
+	maintenance := &maintenanceSwitch{}
+	maintenance.enabled.Store(enabled)
+	return maintenance
+}
+
+type MaintenanceState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// maintenanceMiddleware refuses every mutating request with 503 while
+// maintenance mode is on. Reads, preflights and the switch itself keep
+// working.
+func maintenanceMiddleware(maintenance *maintenanceSwitch) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !maintenance.enabled.Load() || ctx.FullPath() == maintenanceRoute {
+			ctx.Next()
+			return
+		}
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+		default:
+			ctx.Header("Retry-After", strconv.Itoa(int(maintenanceRetryAfter/time.Second)))
+			sendError(ctx, &ErrMaintenanceMode{})
+			ctx.Abort()
+		}
+	}
+}
+
+func getMaintenanceHandler(maintenance *maintenanceSwitch) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		respond(ctx, http.StatusOK, MaintenanceState{Enabled: maintenance.enabled.Load()})
+	}
+}
+
+func setMaintenanceHandler(maintenance *maintenanceSwitch) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		var maintenanceInput MaintenanceState
+		if err := ctx.BindJSON(&maintenanceInput); err != nil {
+			sendError(ctx, &ErrInputRead{InputError: err})
+			return
+		}
+		if maintenance.enabled.Swap(maintenanceInput.Enabled) == maintenanceInput.Enabled {
+			respondNoChange(ctx)
+			return
+		}
+		respond(ctx, http.StatusOK, maintenanceInput)
+	}
+}
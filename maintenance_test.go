@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	maintenance := newMaintenanceSwitch(true)
+	router := gin.New()
+	router.Use(maintenanceMiddleware(maintenance))
+	ok := func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPost,
+		http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		router.Handle(method, "/resource", ok)
+	}
+	router.POST(maintenanceRoute, ok)
+
+	tests := []struct {
+		method, target string
+		allowed        bool
+	}{
+		{method: http.MethodGet, target: "/resource", allowed: true},
+		{method: http.MethodHead, target: "/resource", allowed: true},
+		{method: http.MethodOptions, target: "/resource", allowed: true},
+		{method: http.MethodPost, target: "/resource"},
+		{method: http.MethodPut, target: "/resource"},
+		{method: http.MethodPatch, target: "/resource"},
+		{method: http.MethodDelete, target: "/resource"},
+		{method: http.MethodPost, target: maintenanceRoute, allowed: true},
+	}
+	for _, test := range tests {
+		recorder := serveRequest(t, router, test.method, test.target, nil)
+		if test.allowed {
+			expectStatus(t, recorder, http.StatusOK)
+			continue
+		}
+		expectErrorCode(t, recorder, http.StatusServiceUnavailable, "ErrMaintenanceMode")
+		if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "60" {
+			t.Fatalf("%s %s: Retry-After = %q", test.method, test.target, retryAfter)
+		}
+	}
+
+	maintenance.enabled.Store(false)
+	expectStatus(t, serveRequest(t, router, http.MethodPost, "/resource", nil), http.StatusOK)
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+		config.MaintenanceMode = true
+	})
+	adminAuth := []string{"Authorization", "Bearer secret"}
+
+	recorder := server.request(http.MethodPost, "/account/create", BankAccount{UserName: "alice"})
+	expectErrorCode(t, recorder, http.StatusServiceUnavailable, "ErrMaintenanceMode")
+	recorder = server.request(http.MethodGet, "/admin/maintenance", nil, adminAuth...)
+	expectStatus(t, recorder, http.StatusOK)
+	if state := decodeResponse[MaintenanceState](t, recorder); !state.Enabled {
+		t.Fatal("maintenance mode from the config is off")
+	}
+
+	recorder = server.request(http.MethodPost, "/admin/maintenance", MaintenanceState{Enabled: false}, adminAuth...)
+	expectStatus(t, recorder, http.StatusOK)
+	server.createAccount("alice")
+	server.deposit("alice", 100)
+
+	recorder = server.request(http.MethodPost, "/admin/maintenance", MaintenanceState{Enabled: true})
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+	recorder = server.request(http.MethodPost, "/admin/maintenance", MaintenanceState{Enabled: true}, adminAuth...)
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPost, "/admin/maintenance", MaintenanceState{Enabled: true}, adminAuth...)
+	expectStatus(t, recorder, http.StatusNoContent)
+
+	for _, read := range []struct {
+		target string
+		body   any
+	}{
+		{target: "/account", body: BankAccount{UserName: "alice"}},
+		{target: "/account/all"},
+		{target: "/version"},
+	} {
+		recorder := server.request(http.MethodGet, read.target, read.body)
+		expectStatus(t, recorder, http.StatusOK)
+	}
+	for _, write := range []struct {
+		target string
+		body   any
+	}{
+		{target: "/deposit", body: TransactionInput{UserName: "alice", Amount: 10}},
+		{target: "/withdraw", body: TransactionInput{UserName: "alice", Amount: 10}},
+		{target: "/account/create", body: BankAccount{UserName: "bob"}},
+	} {
+		recorder := server.request(http.MethodPost, write.target, write.body)
+		expectErrorCode(t, recorder, http.StatusServiceUnavailable, "ErrMaintenanceMode")
+		if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "60" {
+			t.Fatalf("%s: Retry-After = %q", write.target, retryAfter)
+		}
+	}
+	if alice := server.account("alice"); alice.Balance != 100 {
+		t.Fatalf("alice balance = %d, want the writes refused", alice.Balance)
+	}
+}
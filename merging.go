@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MergeAccountsInput struct {
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+}
+
+func (input *MergeAccountsInput) Error() error {
+	if !isUsernameValid(input.Primary) {
+		return &ErrInvalidUsername{UserName: input.Primary}
+	}
+	if !isUsernameValid(input.Secondary) {
+		return &ErrInvalidUsername{UserName: input.Secondary}
+	}
+	if input.Primary == input.Secondary {
+		return &ErrSameSourceAndTarget{}
+	}
+	return nil
+}
+
+func (input *MergeAccountsInput) normalizeUsernames() {
+	input.Primary = normalizeUsername(input.Primary)
+	input.Secondary = normalizeUsername(input.Secondary)
+}
+
+// mergeInto adds a merged account's balance and debt to account and nets
+// them against each other as far as the available balance allows.
+func mergeInto(account *BankAccount, balance, debt int) {
+	account.Balance += balance
+	account.Debt += debt
+	settledAmount := min(availableBalance(*account), account.Debt)
+	account.Balance -= settledAmount
+	account.Debt -= settledAmount
+}
+
+// mergeAccountsHandler folds the secondary account into the primary: the
+// balance and debt are added (and netted against each other), the
+// secondary's history is reassigned to the primary and the secondary is
+// closed, all in one transaction. A merge-in entry on the primary records
+// what was brought over, so replays need not re-apply the reassigned
+// entries.
+func mergeAccountsHandler(
+	accountRepository *AccountRepository, transactionCollection *mongo.Collection, config *Config,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		mergeInput, ok := bindAndValidate[MergeAccountsInput](ctx)
+		if !ok {
+			return
+		}
+
+		accountCollection := accountRepository.Collection()
+		finalAccounts, err := runInTransaction(ctx.Request.Context(), accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+			primaryAccount, secondaryAccount, err := findAccountPairInSession(
+				sessionCtx, accountCollection, mergeInput.Primary, mergeInput.Secondary)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkAccountOpen(primaryAccount); err != nil {
+				return nil, err
+			}
+			if err := checkAccountOpen(secondaryAccount); err != nil {
+				return nil, err
+			}
+			if secondaryAccount.Held > 0 {
+				return nil, &ErrFundsOnHold{UserName: secondaryAccount.UserName, Held: secondaryAccount.Held}
+			}
+			if secondaryAccount.Balance < 0 {
+				return nil, &ErrNegativeBalance{UserName: secondaryAccount.UserName, Balance: secondaryAccount.Balance}
+			}
+
+			mergedBalance, mergedDebt := secondaryAccount.Balance, secondaryAccount.Debt
+			mergeInto(&primaryAccount, mergedBalance, mergedDebt)
+
+			secondaryAccount.Balance = 0
+			secondaryAccount.Debt = 0
+			secondaryAccount.Closed = true
+
+			if err := replaceInOrder(sessionCtx, accountRepository, &primaryAccount, &secondaryAccount); err != nil {
+				return nil, err
+			}
+
+			if _, err := transactionCollection.UpdateMany(sessionCtx, bson.D{{
+				Key: "username", Value: secondaryAccount.UserName,
+			}}, bson.D{{Key: "$set", Value: bson.D{
+				{Key: "username", Value: primaryAccount.UserName},
+				{Key: "mergedfrom", Value: secondaryAccount.UserName},
+			}}}); err != nil {
+				return nil, err
+			}
+
+			mergeTransaction := newTransaction(config.Clock, primaryAccount, transactionTypeMergeIn, mergedBalance)
+			mergeTransaction.MergedDebt = mergedDebt
+			mergeTransaction.Counterparty = secondaryAccount.UserName
+			if _, err := insertTransaction(sessionCtx, transactionCollection, mergeTransaction); err != nil {
+				return nil, err
+			}
+
+			return []BankAccount{primaryAccount, secondaryAccount}, nil
+		})
+		accountRepository.Invalidate(mergeInput.Primary, mergeInput.Secondary)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		retainHistory(transactionCollection, config, mergeInput.Primary)
+
+		respond(ctx, http.StatusOK, finalAccounts)
+	}
+}
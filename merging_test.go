@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestMergeInto(t *testing.T) {
+	tests := []struct {
+		name                  string
+		account               BankAccount
+		balance, debt         int
+		wantBalance, wantDebt int
+	}{
+		{name: "balances add up", account: BankAccount{Balance: 50}, balance: 30, wantBalance: 80},
+		{name: "merged debt is settled", account: BankAccount{Balance: 50}, debt: 20, wantBalance: 30},
+		{name: "merged balance settles debt", account: BankAccount{Debt: 40}, balance: 25, wantDebt: 15},
+		{name: "held funds are not used", account: BankAccount{Balance: 50, Held: 40}, debt: 20,
+			wantBalance: 40, wantDebt: 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			account := test.account
+			mergeInto(&account, test.balance, test.debt)
+			if account.Balance != test.wantBalance || account.Debt != test.wantDebt {
+				t.Fatalf("balance %d debt %d, want %d and %d",
+					account.Balance, account.Debt, test.wantBalance, test.wantDebt)
+			}
+		})
+	}
+}
+
+func TestMergeAccountsReplays(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+	server.deposit("bob", 10)
+	server.withdraw("bob", 40)
+
+	recorder := server.request(http.MethodPost, "/account/merge", MergeAccountsInput{Primary: "alice", Secondary: "bob"})
+	expectStatus(t, recorder, http.StatusOK)
+	alice, bob := server.account("alice"), server.account("bob")
+	if alice.Balance != 70 || alice.Debt != 0 || !bob.Closed || bob.Balance != 0 || bob.Debt != 0 {
+		t.Fatalf("after merge: alice %+v, bob %+v", alice, bob)
+	}
+
+	history := server.history("alice")
+	if last := history[len(history)-1]; last.Type != transactionTypeMergeIn || last.MergedDebt != 30 ||
+		last.Counterparty != "bob" {
+		t.Fatalf("last entry = %+v, want a merge-in from bob", last)
+	}
+	replayed := BankAccount{UserName: "alice"}
+	for _, transaction := range history {
+		applyTransaction(&replayed, transaction)
+	}
+	if replayed.Balance != alice.Balance || replayed.Debt != alice.Debt {
+		t.Fatalf("replayed balance %d debt %d, stored %d and %d",
+			replayed.Balance, replayed.Debt, alice.Balance, alice.Debt)
+	}
+
+	if _, err := rebuildAccounts(context.Background(), server.accounts.Collection(), server.transactions,
+		server.clock); err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt := server.account("alice"); rebuilt.Balance != 70 || rebuilt.Debt != 0 {
+		t.Fatalf("after rebuild: %+v", rebuilt)
+	}
+}
+
+// Accounts carry no currency, so the merge has no currency mismatch to
+// reject; these are the mismatches it does refuse.
+func TestMergeAccountsRejections(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.createAccount("carol")
+	server.deposit("bob", 50)
+	recorder := server.request(http.MethodPost, "/account/hold", HoldInput{UserName: "bob", Amount: 20})
+	expectStatus(t, recorder, http.StatusCreated)
+	recorder = server.request(http.MethodPost, "/account/merge", MergeAccountsInput{Primary: "alice", Secondary: "carol"})
+	expectStatus(t, recorder, http.StatusOK)
+
+	tests := []struct {
+		name  string
+		input MergeAccountsInput
+		code  string
+	}{
+		{name: "same account", input: MergeAccountsInput{Primary: "alice", Secondary: "alice"},
+			code: "ErrSameSourceAndTarget"},
+		{name: "secondary with funds on hold", input: MergeAccountsInput{Primary: "alice", Secondary: "bob"},
+			code: "ErrFundsOnHold"},
+		{name: "closed secondary", input: MergeAccountsInput{Primary: "bob", Secondary: "carol"},
+			code: "ErrAccountClosed"},
+	}
+	for _, test := range tests {
+		recorder := server.request(http.MethodPost, "/account/merge", test.input)
+		if recorder.Code == http.StatusOK {
+			t.Fatalf("%s: merge succeeded: %s", test.name, recorder.Body.String())
+		}
+		if code := decodeResponse[JsonMessage](t, recorder).Code; code != test.code {
+			t.Fatalf("%s: code = %q, want %q", test.name, code, test.code)
+		}
+	}
+	if bob := server.account("bob"); bob.Closed || bob.Balance != 50 || bob.Held != 20 {
+		t.Fatalf("bob after refused merges: %+v", bob)
+	}
+}
+
+// Opposite merges of the same pair read and write the accounts in the same
+// order, so one of them wins and the other finds its primary closed instead
+// of failing on a write conflict.
+func TestConcurrentOppositeMerges(t *testing.T) {
+	server := newTestServer(t)
+	const pairs = 10
+	inputs := make([]MergeAccountsInput, 0, 2*pairs)
+	for i := 0; i < pairs; i++ {
+		first, second := fmt.Sprintf("alice%d", i), fmt.Sprintf("bob%d", i)
+		server.createAccount(first)
+		server.createAccount(second)
+		server.deposit(first, 30)
+		server.deposit(second, 20)
+		inputs = append(inputs,
+			MergeAccountsInput{Primary: first, Secondary: second},
+			MergeAccountsInput{Primary: second, Secondary: first})
+	}
+
+	recorders := make([]*httptest.ResponseRecorder, len(inputs))
+	var wait sync.WaitGroup
+	for i := range inputs {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			recorders[i] = server.request(http.MethodPost, "/account/merge", inputs[i])
+		}(i)
+	}
+	wait.Wait()
+
+	for i := 0; i < len(inputs); i += 2 {
+		first, second := recorders[i], recorders[i+1]
+		winner, loser := first, second
+		if second.Code == http.StatusOK {
+			winner, loser = second, first
+		}
+		if winner.Code != http.StatusOK {
+			t.Fatalf("pair %d: neither merge succeeded: %s, %s", i/2, first.Body.String(), second.Body.String())
+		}
+		expectErrorCode(t, loser, http.StatusBadRequest, "ErrAccountClosed")
+
+		primary, secondary := server.account(inputs[i].Primary), server.account(inputs[i].Secondary)
+		if winner == second {
+			primary, secondary = secondary, primary
+		}
+		if primary.Closed || primary.Balance != 50 || !secondary.Closed || secondary.Balance != 0 {
+			t.Fatalf("pair %d: primary %+v, secondary %+v", i/2, primary, secondary)
+		}
+	}
+}
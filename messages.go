@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultLanguage = "en"
+
+// catalogError is implemented by every error that is reported to clients.
+// Code is stable and machine-readable; the message is looked up in
+// messageCatalog by code and formatted with messageArgs.
+type catalogError interface {
+	error
+	Code() string
+	messageArgs() []any
+}
+
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		"ErrUsername":                    "ErrUsername: username \"%s\" is invalid.",
+		"ErrSameSourceAndTarget":         "ErrSameSourceAndTarget: source and target account cannot be the same.",
+		"ErrInputRead":                   "Failed to read input: %v.",
+		"ErrLessThanEqualZero":           "ErrLessThanEqualZero: Value \"%s\" must be greater than zero.",
+		"ErrInsufficientFunds":           "ErrInsufficientFunds: user \"%s\" has balance %d, cannot cover %d.",
+		"ErrUserAlreadyExist":            "ErrUserAlreadyExist: user \"%s\" already exist.",
+		"ErrNoDocuments":                 "ErrNoDocuments: User %s not found.",
+		"ErrPreconditionFailed":          "ErrPreconditionFailed: account \"%s\" was modified, If-Match does not match.",
+		"ErrInvalidQueryParam":           "ErrInvalidQueryParam: query parameter \"%s\" has invalid value \"%s\".",
+		"ErrInvalidEmail":                "ErrInvalidEmail: email \"%s\" is invalid.",
+		"ErrInvalidVerificationToken":    "ErrInvalidVerificationToken: verification token for user \"%s\" is invalid.",
+		"ErrUnverifiedLimitExceeded":     "ErrUnverifiedLimitExceeded: user \"%s\" has no verified email, amount %d exceeds limit %d.",
+		"ErrAccountNotOpenedYet":         "ErrAccountNotOpenedYet: user \"%s\" did not exist at %s.",
+		"ErrAccountClosed":               "ErrAccountClosed: account \"%s\" is closed.",
+		"ErrOutstandingDebt":             "ErrOutstandingDebt: user \"%s\" still has debt %d.",
+		"ErrValidation":                  "ErrValidation: %d fields are invalid.",
+		"ErrFeeExceedsAmount":            "ErrFeeExceedsAmount: fee %d exceeds transferred amount %d.",
+		"ErrExecuteAtNotInFuture":        "ErrExecuteAtNotInFuture: execution time %s is not in the future.",
+		"ErrScheduledTransferNotFound":   "ErrScheduledTransferNotFound: scheduled transfer \"%s\" not found.",
+		"ErrScheduledTransferNotPending": "ErrScheduledTransferNotPending: scheduled transfer \"%s\" is already %s.",
+		"ErrAccountLimitReached":         "ErrAccountLimitReached: the limit of %d accounts has been reached.",
+		"ErrMaxBalanceExceeded":          "ErrMaxBalanceExceeded: deposit not applied, balance of user \"%s\" would be %d, above the maximum %d.",
+		"ErrConcurrentModification":      "ErrConcurrentModification: account \"%s\" was modified concurrently, retry the request.",
+		"ErrTransactionNotFound":         "ErrTransactionNotFound: transaction \"%s\" not found.",
+		"ErrTransactionForbidden":        "ErrTransactionForbidden: transaction \"%s\" does not belong to user \"%s\".",
+		"ErrInvalidHeader":               "ErrInvalidHeader: header \"%s\" has invalid value \"%s\".",
+		"ErrInvalidID":                   "ErrInvalidID: \"%s\" is not a valid id.",
+		"ErrUnauthorized":                "ErrUnauthorized: missing or invalid admin credentials.",
+		"ErrCategoryTooLong":             "ErrCategoryTooLong: category must be at most %d characters.",
+		"ErrAccountLocked":               "ErrAccountLocked: account \"%s\" is locked for maintenance: %s.",
+		"ErrRequiredField":               "ErrRequiredField: field \"%s\" is required.",
+		"ErrFieldTooLong":                "ErrFieldTooLong: field \"%s\" must be at most %d characters.",
+		"ErrNegativeValue":               "ErrNegativeValue: Value \"%s\" must not be negative.",
+		"ErrInvalidBalanceThresholds":    "ErrInvalidBalanceThresholds: low threshold %d must be below high threshold %d.",
+		"ErrRouteNotFound":               "ErrRouteNotFound: no route for %s %s.",
+		"ErrMethodNotAllowed":            "ErrMethodNotAllowed: method %s is not allowed on %s.",
+		"ErrAccountFrozen":               "ErrAccountFrozen: account \"%s\" is frozen.",
+		"ErrBatchSize":                   "ErrBatchSize: a batch must contain between 1 and %d items.",
+		"ErrInvariantViolation":          "ErrInvariantViolation: refusing to store an invalid state for account \"%s\".",
+		"ErrOperationNotFound":           "ErrOperationNotFound: no active operation with opid \"%s\".",
+		"ErrInvalidDebtRepaymentPolicy":  "ErrInvalidDebtRepaymentPolicy: debt repayment policy \"%s\" must be \"%s\" or \"%s\".",
+		"ErrHoldNotFound":                "ErrHoldNotFound: hold \"%s\" not found.",
+		"ErrHoldNotActive":               "ErrHoldNotActive: hold \"%s\" is %s and can no longer be settled.",
+		"ErrFundsOnHold":                 "ErrFundsOnHold: account \"%s\" has %d on hold.",
+		"ErrServerBusy":                  "ErrServerBusy: the server is handling too many requests, try again shortly.",
+		"ErrDuplicateSource":             "ErrDuplicateSource: source \"%s\" is listed more than once.",
+		"ErrPendingTransferNotFound":     "ErrPendingTransferNotFound: pending transfer \"%s\" not found.",
+		"ErrPendingTransferNotPending":   "ErrPendingTransferNotPending: pending transfer \"%s\" is already %s.",
+		"ErrInvalidWebhookURL":           "ErrInvalidWebhookURL: \"%s\" is not an absolute http or https URL.",
+		"ErrInvalidEventType":            "ErrInvalidEventType: \"%s\" is not an event type that can be subscribed to.",
+		"ErrWebhookNotFound":             "ErrWebhookNotFound: webhook \"%s\" not found.",
+		"ErrAmountPrecisionLoss":         "ErrAmountPrecisionLoss: %s %d is above %d and may have lost precision in transit.",
+		"ErrMaintenanceMode":             "ErrMaintenanceMode: the service is under maintenance and accepts reads only, try again later.",
+		"ErrUnknownQueryParams":          "ErrUnknownQueryParams: unknown query parameters: %s.",
+		"ErrNegativeBalance":             "ErrNegativeBalance: account \"%s\" has a negative balance of %d.",
+		"ErrInvalidTransactionType":      "ErrInvalidTransactionType: \"%s\" is not a transaction type.",
+		"ErrInvalidDateRange":            "ErrInvalidDateRange: from %s is after to %s.",
+		"ErrIdempotencyKeyReused":        "ErrIdempotencyKeyReused: idempotency key \"%s\" was already used for account \"%s\".",
+		"ErrInvalidSignature":            "ErrInvalidSignature: the request signature is missing or invalid.",
+		"ErrRequestExpired":              "ErrRequestExpired: the request timestamp is more than %s away from the server time.",
+		"ErrNonceReused":                 "ErrNonceReused: nonce \"%s\" was already used.",
+		"ErrShuttingDown":                "ErrShuttingDown: the server is shutting down, try again shortly.",
+		"ErrTooManyMetadataEntries":      "ErrTooManyMetadataEntries: an account may hold at most %d metadata entries.",
+		"ErrInvalidMetadataKey":          "ErrInvalidMetadataKey: \"%s\" is empty or reserved and cannot be a metadata key.",
+		"ErrTransferIDReused":            "ErrTransferIDReused: transfer ID \"%s\" was already used for a different transfer.",
+		"ErrInvalidAccountNumber":        "ErrInvalidAccountNumber: \"%s\" is not a valid account number.",
+		"ErrAccountNumberNotFound":       "ErrAccountNumberNotFound: no account has number \"%s\".",
+		"ErrPreconditionRequired":        "ErrPreconditionRequired: an If-Match header with the ETag of account \"%s\" is required.",
+		"ErrHistoryPruned":               "ErrHistoryPruned: the history of user \"%s\" before %s has been pruned.",
+		"ErrSignatureRequired":           "ErrSignatureRequired: writes to %s must be signed with X-Request-Signature.",
+		"ErrSignedBodyTooLarge":          "ErrSignedBodyTooLarge: a signed request body may be at most %d bytes.",
+	},
+	"id": {
+		"ErrUsername":                    "ErrUsername: nama pengguna \"%s\" tidak valid.",
+		"ErrSameSourceAndTarget":         "ErrSameSourceAndTarget: akun sumber dan tujuan tidak boleh sama.",
+		"ErrInputRead":                   "Gagal membaca input: %v.",
+		"ErrLessThanEqualZero":           "ErrLessThanEqualZero: Nilai \"%s\" harus lebih besar dari nol.",
+		"ErrInsufficientFunds":           "ErrInsufficientFunds: saldo pengguna \"%s\" sebesar %d tidak cukup untuk %d.",
+		"ErrUserAlreadyExist":            "ErrUserAlreadyExist: pengguna \"%s\" sudah ada.",
+		"ErrNoDocuments":                 "ErrNoDocuments: Pengguna %s tidak ditemukan.",
+		"ErrPreconditionFailed":          "ErrPreconditionFailed: akun \"%s\" telah berubah, If-Match tidak cocok.",
+		"ErrInvalidQueryParam":           "ErrInvalidQueryParam: parameter query \"%s\" memiliki nilai tidak valid \"%s\".",
+		"ErrInvalidEmail":                "ErrInvalidEmail: email \"%s\" tidak valid.",
+		"ErrInvalidVerificationToken":    "ErrInvalidVerificationToken: token verifikasi untuk pengguna \"%s\" tidak valid.",
+		"ErrUnverifiedLimitExceeded":     "ErrUnverifiedLimitExceeded: email pengguna \"%s\" belum terverifikasi, jumlah %d melebihi batas %d.",
+		"ErrAccountNotOpenedYet":         "ErrAccountNotOpenedYet: pengguna \"%s\" belum ada pada %s.",
+		"ErrAccountClosed":               "ErrAccountClosed: akun \"%s\" sudah ditutup.",
+		"ErrOutstandingDebt":             "ErrOutstandingDebt: pengguna \"%s\" masih memiliki utang %d.",
+		"ErrValidation":                  "ErrValidation: %d kolom tidak valid.",
+		"ErrFeeExceedsAmount":            "ErrFeeExceedsAmount: biaya %d melebihi jumlah transfer %d.",
+		"ErrExecuteAtNotInFuture":        "ErrExecuteAtNotInFuture: waktu eksekusi %s tidak berada di masa depan.",
+		"ErrScheduledTransferNotFound":   "ErrScheduledTransferNotFound: transfer terjadwal \"%s\" tidak ditemukan.",
+		"ErrScheduledTransferNotPending": "ErrScheduledTransferNotPending: transfer terjadwal \"%s\" sudah berstatus %s.",
+		"ErrAccountLimitReached":         "ErrAccountLimitReached: batas %d akun telah tercapai.",
+		"ErrMaxBalanceExceeded":          "ErrMaxBalanceExceeded: setoran tidak diterapkan, saldo pengguna \"%s\" akan menjadi %d, melebihi batas %d.",
+		"ErrConcurrentModification":      "ErrConcurrentModification: akun \"%s\" diubah secara bersamaan, ulangi permintaan.",
+		"ErrTransactionNotFound":         "ErrTransactionNotFound: transaksi \"%s\" tidak ditemukan.",
+		"ErrTransactionForbidden":        "ErrTransactionForbidden: transaksi \"%s\" bukan milik pengguna \"%s\".",
+		"ErrInvalidHeader":               "ErrInvalidHeader: header \"%s\" memiliki nilai tidak valid \"%s\".",
+		"ErrInvalidID":                   "ErrInvalidID: \"%s\" bukan id yang valid.",
+		"ErrUnauthorized":                "ErrUnauthorized: kredensial admin tidak ada atau tidak valid.",
+		"ErrCategoryTooLong":             "ErrCategoryTooLong: kategori paling banyak %d karakter.",
+		"ErrAccountLocked":               "ErrAccountLocked: akun \"%s\" dikunci untuk pemeliharaan: %s.",
+		"ErrRequiredField":               "ErrRequiredField: kolom \"%s\" wajib diisi.",
+		"ErrFieldTooLong":                "ErrFieldTooLong: kolom \"%s\" paling banyak %d karakter.",
+		"ErrNegativeValue":               "ErrNegativeValue: Nilai \"%s\" tidak boleh negatif.",
+		"ErrInvalidBalanceThresholds":    "ErrInvalidBalanceThresholds: batas bawah %d harus lebih kecil dari batas atas %d.",
+		"ErrRouteNotFound":               "ErrRouteNotFound: tidak ada rute untuk %s %s.",
+		"ErrMethodNotAllowed":            "ErrMethodNotAllowed: metode %s tidak diizinkan pada %s.",
+		"ErrAccountFrozen":               "ErrAccountFrozen: akun \"%s\" dibekukan.",
+		"ErrBatchSize":                   "ErrBatchSize: batch harus berisi antara 1 dan %d item.",
+		"ErrInvariantViolation":          "ErrInvariantViolation: menolak menyimpan status tidak valid untuk akun \"%s\".",
+		"ErrOperationNotFound":           "ErrOperationNotFound: tidak ada operasi aktif dengan opid \"%s\".",
+		"ErrInvalidDebtRepaymentPolicy":  "ErrInvalidDebtRepaymentPolicy: kebijakan pelunasan utang \"%s\" harus \"%s\" atau \"%s\".",
+		"ErrHoldNotFound":                "ErrHoldNotFound: penahanan dana \"%s\" tidak ditemukan.",
+		"ErrHoldNotActive":               "ErrHoldNotActive: penahanan dana \"%s\" berstatus %s dan tidak dapat diselesaikan lagi.",
+		"ErrFundsOnHold":                 "ErrFundsOnHold: akun \"%s\" memiliki dana %d yang ditahan.",
+		"ErrServerBusy":                  "ErrServerBusy: server sedang menangani terlalu banyak permintaan, coba lagi sebentar lagi.",
+		"ErrDuplicateSource":             "ErrDuplicateSource: sumber \"%s\" dicantumkan lebih dari sekali.",
+		"ErrPendingTransferNotFound":     "ErrPendingTransferNotFound: transfer tertunda \"%s\" tidak ditemukan.",
+		"ErrPendingTransferNotPending":   "ErrPendingTransferNotPending: transfer tertunda \"%s\" sudah berstatus %s.",
+		"ErrInvalidWebhookURL":           "ErrInvalidWebhookURL: \"%s\" bukan URL http atau https yang absolut.",
+		"ErrInvalidEventType":            "ErrInvalidEventType: \"%s\" bukan jenis peristiwa yang dapat dilanggan.",
+		"ErrWebhookNotFound":             "ErrWebhookNotFound: webhook \"%s\" tidak ditemukan.",
+		"ErrAmountPrecisionLoss":         "ErrAmountPrecisionLoss: %s %d melebihi %d dan mungkin kehilangan presisi saat dikirim.",
+		"ErrMaintenanceMode":             "ErrMaintenanceMode: layanan sedang dalam pemeliharaan dan hanya menerima pembacaan, coba lagi nanti.",
+		"ErrUnknownQueryParams":          "ErrUnknownQueryParams: parameter query tidak dikenal: %s.",
+		"ErrNegativeBalance":             "ErrNegativeBalance: akun \"%s\" memiliki saldo negatif sebesar %d.",
+		"ErrInvalidTransactionType":      "ErrInvalidTransactionType: \"%s\" bukan jenis transaksi.",
+		"ErrInvalidDateRange":            "ErrInvalidDateRange: from %s berada setelah to %s.",
+		"ErrIdempotencyKeyReused":        "ErrIdempotencyKeyReused: kunci idempotensi \"%s\" sudah dipakai untuk akun \"%s\".",
+		"ErrInvalidSignature":            "ErrInvalidSignature: tanda tangan permintaan tidak ada atau tidak valid.",
+		"ErrRequestExpired":              "ErrRequestExpired: waktu permintaan berselisih lebih dari %s dari waktu server.",
+		"ErrNonceReused":                 "ErrNonceReused: nonce \"%s\" sudah pernah dipakai.",
+		"ErrShuttingDown":                "ErrShuttingDown: server sedang dimatikan, coba lagi sebentar lagi.",
+		"ErrTooManyMetadataEntries":      "ErrTooManyMetadataEntries: sebuah akun hanya boleh memiliki paling banyak %d entri metadata.",
+		"ErrInvalidMetadataKey":          "ErrInvalidMetadataKey: \"%s\" kosong atau dicadangkan sehingga tidak bisa menjadi kunci metadata.",
+		"ErrTransferIDReused":            "ErrTransferIDReused: ID transfer \"%s\" sudah dipakai untuk transfer lain.",
+		"ErrInvalidAccountNumber":        "ErrInvalidAccountNumber: \"%s\" bukan nomor rekening yang valid.",
+		"ErrAccountNumberNotFound":       "ErrAccountNumberNotFound: tidak ada rekening dengan nomor \"%s\".",
+		"ErrPreconditionRequired":        "ErrPreconditionRequired: header If-Match berisi ETag akun \"%s\" wajib dikirim.",
+		"ErrHistoryPruned":               "ErrHistoryPruned: riwayat pengguna \"%s\" sebelum %s sudah dipangkas.",
+		"ErrSignatureRequired":           "ErrSignatureRequired: penulisan ke %s wajib ditandatangani dengan X-Request-Signature.",
+		"ErrSignedBodyTooLarge":          "ErrSignedBodyTooLarge: body permintaan bertanda tangan maksimal %d byte.",
+	},
+}
+
+func localizeError(language string, err catalogError) string {
+	template, ok := messageCatalog[language][err.Code()]
+	if !ok {
+		template = messageCatalog[defaultLanguage][err.Code()]
+	}
+	return fmt.Sprintf(template, err.messageArgs()...)
+}
+
+// requestLanguage picks the catalog language with the highest q-value from
+// the Accept-Language header, falling back to defaultLanguage.
+func requestLanguage(ctx *gin.Context) string {
+	type weightedLanguage struct {
+		language string
+		weight   float64
+	}
+
+	var candidates []weightedLanguage
+	for _, entry := range strings.Split(ctx.GetHeader("Accept-Language"), ",") {
+		language, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		language, _, _ = strings.Cut(strings.ToLower(language), "-")
+		if _, ok := messageCatalog[language]; !ok {
+			continue
+		}
+		weight := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsedWeight, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil {
+				weight = parsedWeight
+			}
+		}
+		if weight > 0 {
+			candidates = append(candidates, weightedLanguage{language: language, weight: weight})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return defaultLanguage
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].weight > candidates[j].weight
+	})
+	return candidates[0].language
+}
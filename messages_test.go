@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMessageCatalogComplete(t *testing.T) {
+	for language, messages := range messageCatalog {
+		for code := range messageCatalog[defaultLanguage] {
+			if _, ok := messages[code]; !ok {
+				t.Errorf("catalog %q has no message for %s", language, code)
+			}
+		}
+		for code := range messages {
+			if _, ok := messageCatalog[defaultLanguage][code]; !ok {
+				t.Errorf("catalog %q has %s, which %q lacks", language, code, defaultLanguage)
+			}
+		}
+	}
+}
+
+func TestRequestLanguage(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		language       string
+	}{
+		{acceptLanguage: "", language: defaultLanguage},
+		{acceptLanguage: "id", language: "id"},
+		{acceptLanguage: "id-ID", language: "id"},
+		{acceptLanguage: "fr, id;q=0.5", language: "id"},
+		{acceptLanguage: "en;q=0.4, id;q=0.8", language: "id"},
+		{acceptLanguage: "id;q=0", language: defaultLanguage},
+		{acceptLanguage: "fr", language: defaultLanguage},
+	}
+	for _, test := range tests {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		ctx.Request.Header.Set("Accept-Language", test.acceptLanguage)
+		if language := requestLanguage(ctx); language != test.language {
+			t.Errorf("requestLanguage(%q) = %q, want %q", test.acceptLanguage, language, test.language)
+		}
+	}
+}
+
+func TestLocalizedErrorResponse(t *testing.T) {
+	router := envelopeRouter(newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+
+	english := serveRequest(t, router, http.MethodGet, "/fail", nil, "Accept-Language", "en")
+	expectErrorCode(t, english, http.StatusBadRequest, "ErrNoDocuments")
+	indonesian := serveRequest(t, router, http.MethodGet, "/fail", nil, "Accept-Language", "id")
+	expectErrorCode(t, indonesian, http.StatusBadRequest, "ErrNoDocuments")
+
+	englishMessage := decodeResponse[JsonMessage](t, english).Message
+	indonesianMessage := decodeResponse[JsonMessage](t, indonesian).Message
+	if englishMessage != localizeError("en", &ErrUserNotFound{UserName: "ghost"}) ||
+		indonesianMessage != localizeError("id", &ErrUserNotFound{UserName: "ghost"}) ||
+		englishMessage == indonesianMessage {
+		t.Fatalf("messages = %q and %q, want the two catalog entries", englishMessage, indonesianMessage)
+	}
+}
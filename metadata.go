@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxMetadataEntries     = 16
+	maxMetadataKeyLength   = 40
+	maxMetadataValueLength = 256
+)
+
+type ErrTooManyMetadataEntries struct {
+	Max int
+}
+
+func (err *ErrTooManyMetadataEntries) Code() string {
+	return "ErrTooManyMetadataEntries"
+}
+
+func (err *ErrTooManyMetadataEntries) messageArgs() []any {
+	return []any{err.Max}
+}
+
+func (err *ErrTooManyMetadataEntries) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrInvalidMetadataKey struct {
+	Key string
+}
+
+func (err *ErrInvalidMetadataKey) Code() string {
+	return "ErrInvalidMetadataKey"
+}
+
+func (err *ErrInvalidMetadataKey) messageArgs() []any {
+	return []any{err.Key}
+}
+
+func (err *ErrInvalidMetadataKey) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+// isMetadataKeyReserved reports whether key names a core account field, in
+// any letter case, so labels can never be mistaken for account data.
+func isMetadataKeyReserved(key string) bool {
+	for field := range accountFieldNames {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// MetadataInput patches an account's metadata: each key is set to its
+// value, and a null value removes the key.
+type MetadataInput struct {
+	Metadata map[string]*string `json:"metadata"`
+}
+
+func (input *MetadataInput) Error() error {
+	var validationErrors MultiError
+	if len(input.Metadata) > maxMetadataEntries {
+		validationErrors.Add("metadata", &ErrTooManyMetadataEntries{Max: maxMetadataEntries})
+	}
+	for key, value := range input.Metadata {
+		addMetadataEntryErrors(&validationErrors, key, value)
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+// addMetadataEntryErrors checks one metadata key and, unless it is nil, its
+// value. Both the metadata patch and account creation apply these limits.
+func addMetadataEntryErrors(validationErrors *MultiError, key string, value *string) {
+	switch {
+	case key == "" || isMetadataKeyReserved(key):
+		validationErrors.Add("metadata", &ErrInvalidMetadataKey{Key: key})
+	case utf8.RuneCountInString(key) > maxMetadataKeyLength:
+		validationErrors.Add("metadata", &ErrFieldTooLong{Name: "metadata key", Max: maxMetadataKeyLength})
+	}
+	if value != nil && utf8.RuneCountInString(*value) > maxMetadataValueLength {
+		validationErrors.Add("metadata", &ErrFieldTooLong{Name: "metadata." + key, Max: maxMetadataValueLength})
+	}
+}
+
+// patchMetadataHandler merges labels such as region or tier into an
+// account's metadata. The result may hold at most maxMetadataEntries keys.
+func patchMetadataHandler(accountRepository *AccountRepository, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		metadataInput, ok := bindAndValidate[MetadataInput](ctx)
+		if !ok {
+			return
+		}
+
+		targetAccount, err := accountRepository.FindFreshByUsername(ctx.Request.Context(), userName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		if sendErrPreconditionFailed(ctx, config, targetAccount) {
+			return
+		}
+
+		if err := checkAccountOpen(targetAccount); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		changed := false
+		metadata := make(map[string]string, len(targetAccount.Metadata)+len(metadataInput.Metadata))
+		for key, value := range targetAccount.Metadata {
+			metadata[key] = value
+		}
+		for key, value := range metadataInput.Metadata {
+			currentValue, present := metadata[key]
+			switch {
+			case value == nil && present:
+				delete(metadata, key)
+				changed = true
+			case value != nil && (!present || currentValue != *value):
+				metadata[key] = *value
+				changed = true
+			}
+		}
+		if !changed {
+			setAccountETag(ctx, targetAccount)
+			respondNoChange(ctx)
+			return
+		}
+		if len(metadata) > maxMetadataEntries {
+			sendError(ctx, &ErrTooManyMetadataEntries{Max: maxMetadataEntries})
+			return
+		}
+
+		targetAccount.Metadata = metadata
+		if len(metadata) == 0 {
+			targetAccount.Metadata = nil
+		}
+		if err := accountRepository.Replace(ctx.Request.Context(), &targetAccount); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		setAccountETag(ctx, targetAccount)
+		respond(ctx, http.StatusOK, targetAccount)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccountMetadataValidation(t *testing.T) {
+	tooMany := make(sealedMetadata)
+	for i := 0; i <= maxMetadataEntries; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+
+	tests := []struct {
+		name     string
+		metadata sealedMetadata
+		want     error
+	}{
+		{name: "none"},
+		{name: "labels", metadata: sealedMetadata{"region": "eu", "tier": "gold"}},
+		{name: "too many entries", metadata: tooMany, want: &ErrTooManyMetadataEntries{}},
+		{name: "reserved key", metadata: sealedMetadata{"Balance": "1000"}, want: &ErrInvalidMetadataKey{}},
+		{name: "empty key", metadata: sealedMetadata{"": "x"}, want: &ErrInvalidMetadataKey{}},
+		{name: "long key", metadata: sealedMetadata{strings.Repeat("k", maxMetadataKeyLength+1): "x"},
+			want: &ErrFieldTooLong{}},
+		{name: "long value", metadata: sealedMetadata{"note": strings.Repeat("v", maxMetadataValueLength+1)},
+			want: &ErrFieldTooLong{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			account := BankAccount{UserName: "alice", Metadata: test.metadata}
+			err := account.Error()
+			switch want := test.want.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Error() = %v, want nil", err)
+				}
+			case *ErrTooManyMetadataEntries:
+				if !errors.As(err, &want) {
+					t.Fatalf("Error() = %v, want ErrTooManyMetadataEntries", err)
+				}
+			case *ErrInvalidMetadataKey:
+				if !errors.As(err, &want) {
+					t.Fatalf("Error() = %v, want ErrInvalidMetadataKey", err)
+				}
+			case *ErrFieldTooLong:
+				if !errors.As(err, &want) {
+					t.Fatalf("Error() = %v, want ErrFieldTooLong", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateAccountRejectsReservedMetadata(t *testing.T) {
+	server := newTestServer(t)
+
+	recorder := server.request(http.MethodPost, "/account/create",
+		BankAccount{UserName: "alice", Metadata: sealedMetadata{"balance": "1000000"}})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidMetadataKey")
+
+	recorder = server.request(http.MethodPost, "/account/create",
+		BankAccount{UserName: "alice", Metadata: sealedMetadata{"region": "eu"}})
+	expectStatus(t, recorder, http.StatusCreated)
+	if account := server.account("alice"); account.Metadata["region"] != "eu" {
+		t.Fatalf("stored metadata = %v", account.Metadata)
+	}
+}
+
+func TestPatchMetadata(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	patch := func(metadata map[string]*string) *httptest.ResponseRecorder {
+		t.Helper()
+		return server.request(http.MethodPatch, "/account/alice/metadata", MetadataInput{Metadata: metadata})
+	}
+	label := func(value string) *string {
+		return &value
+	}
+
+	recorder := patch(map[string]*string{"region": label("eu"), "tier": label("gold")})
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = patch(map[string]*string{"tier": nil, "channel": label("partner")})
+	expectStatus(t, recorder, http.StatusOK)
+
+	recorder = server.request(http.MethodGet, "/account", BankAccount{UserName: "alice"})
+	expectStatus(t, recorder, http.StatusOK)
+	want := sealedMetadata{"region": "eu", "channel": "partner"}
+	if metadata := decodeResponse[BankAccount](t, recorder).Metadata; fmt.Sprint(metadata) != fmt.Sprint(want) {
+		t.Fatalf("read metadata = %v, want %v", metadata, want)
+	}
+
+	for _, test := range []struct {
+		name     string
+		metadata map[string]*string
+		code     string
+	}{
+		{name: "reserved key", metadata: map[string]*string{"debt": label("0")}, code: "ErrInvalidMetadataKey"},
+		{name: "long key", metadata: map[string]*string{strings.Repeat("k", maxMetadataKeyLength+1): label("x")},
+			code: "ErrFieldTooLong"},
+		{name: "long value", metadata: map[string]*string{"note": label(strings.Repeat("v", maxMetadataValueLength+1))},
+			code: "ErrFieldTooLong"},
+	} {
+		recorder := patch(test.metadata)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", test.name, recorder.Code)
+		}
+		if code := decodeResponse[JsonMessage](t, recorder).Code; code != test.code {
+			t.Fatalf("%s: code = %q, want %q", test.name, code, test.code)
+		}
+	}
+
+	// Within the limit on its own, the patch overflows once merged.
+	filling := map[string]*string{}
+	for i := 0; i < maxMetadataEntries-1; i++ {
+		filling[fmt.Sprintf("key%d", i)] = label("value")
+	}
+	recorder = patch(filling)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrTooManyMetadataEntries")
+
+	if metadata := server.account("alice").Metadata; fmt.Sprint(metadata) != fmt.Sprint(want) {
+		t.Fatalf("stored metadata = %v after rejected patches, want %v", metadata, want)
+	}
+}
+
+func TestPatchMetadataPreconditions(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+		config.RequireIfMatch = true
+	})
+	server.createAccount("alice")
+	stale := accountETag(server.account("alice"))
+	recorder := server.request(http.MethodPost, "/deposit", TransactionInput{UserName: "alice", Amount: 10},
+		"If-Match", stale)
+	expectStatus(t, recorder, http.StatusOK)
+	region := "eu"
+	patch := MetadataInput{Metadata: map[string]*string{"region": &region}}
+
+	recorder = server.request(http.MethodPatch, "/account/alice/metadata", patch)
+	expectErrorCode(t, recorder, http.StatusPreconditionRequired, "ErrPreconditionRequired")
+	recorder = server.request(http.MethodPatch, "/account/alice/metadata", patch, "If-Match", stale)
+	expectErrorCode(t, recorder, http.StatusPreconditionFailed, "ErrPreconditionFailed")
+
+	recorder = server.request(http.MethodPost, "/account/alice/lock",
+		LockAccountInput{Reason: "ledger repair", Operator: "ops-7"}, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPatch, "/account/alice/metadata", patch,
+		"If-Match", accountETag(server.account("alice")))
+	expectErrorCode(t, recorder, http.StatusLocked, "ErrAccountLocked")
+	if metadata := server.account("alice").Metadata; len(metadata) != 0 {
+		t.Fatalf("stored metadata = %v after refused patches", metadata)
+	}
+
+	recorder = server.request(http.MethodDelete, "/account/alice/lock", nil, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = server.request(http.MethodPatch, "/account/alice/metadata", patch,
+		"If-Match", accountETag(server.account("alice")))
+	expectStatus(t, recorder, http.StatusOK)
+}
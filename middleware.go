@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader      = "X-Request-ID"
+	requestIDKey         = "requestID"
+	requestTimeoutHeader = "X-Request-Timeout"
+	maxRequestIDLength   = 64
+)
+
+// requestLogger writes one structured record per request, and the details
+// of requests that panic.
+var requestLogger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+type ErrInvalidHeader struct {
+	Name  string
+	Value string
+}
+
+func (err *ErrInvalidHeader) Code() string {
+	return "ErrInvalidHeader"
+}
+
+func (err *ErrInvalidHeader) messageArgs() []any {
+	return []any{err.Name, err.Value}
+}
+
+func (err *ErrInvalidHeader) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type InternalErrorMessage struct {
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
+}
+
+func randomHex(byteCount int) string {
+	buffer := make([]byte, byteCount)
+	rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
+
+// isRequestIDValid accepts short tokens of letters, digits, '-', '_' and
+// '.', which are safe to copy into logs and response headers.
+func isRequestIDValid(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, character := range requestID {
+		switch {
+		case character >= 'a' && character <= 'z', character >= 'A' && character <= 'Z',
+			character >= '0' && character <= '9', character == '-', character == '_', character == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware keeps the client's X-Request-ID when it is a safe
+// token and generates one otherwise.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestID := ctx.GetHeader(requestIDHeader)
+		if !isRequestIDValid(requestID) {
+			requestID = randomHex(8)
+		}
+		ctx.Set(requestIDKey, requestID)
+		ctx.Header(requestIDHeader, requestID)
+		ctx.Next()
+	}
+}
+
+// requestLogMiddleware logs every request once it has been answered.
+func requestLogMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		startedAt := time.Now()
+		ctx.Next()
+		requestLogger.Info("request",
+			slog.String("method", ctx.Request.Method),
+			slog.String("path", ctx.Request.URL.Path),
+			slog.Int("status", ctx.Writer.Status()),
+			slog.Duration("duration", time.Since(startedAt)),
+			slog.String("clientIp", ctx.ClientIP()),
+			slog.String("requestId", ctx.GetString(requestIDKey)))
+	}
+}
+
+// recoveryMiddleware replaces gin's plain text recovery so a panicking
+// handler still answers with JSON like the rest of the API.
+func recoveryMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				requestID := ctx.GetString(requestIDKey)
+				requestLogger.Error("panic",
+					slog.String("method", ctx.Request.Method),
+					slog.String("path", ctx.Request.URL.Path),
+					slog.String("requestId", requestID),
+					slog.Any("panic", recovered),
+					slog.String("stack", string(debug.Stack())))
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, InternalErrorMessage{
+					Message:   "internal error",
+					RequestID: requestID,
+				})
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// defaultRouteTimeouts gives batch and export routes more time than the
+// single-account reads and writes that REQUEST_TIMEOUT is sized for:
+//
+//	POST /account/create/batch    30s
+//	POST /account/freeze/batch    30s
+//	POST /transfer/batch          30s
+//	POST /transfer/multi-source   30s
+//	GET  /transactions/export     5m
+//
+// ROUTE_TIMEOUTS overrides or extends them.
+var defaultRouteTimeouts = map[string]time.Duration{
+	"POST /account/create/batch":  30 * time.Second,
+	"POST /account/freeze/batch":  30 * time.Second,
+	"POST /transfer/batch":        30 * time.Second,
+	"POST /transfer/multi-source": 30 * time.Second,
+	"GET /transactions/export":    5 * time.Minute,
+}
+
+// requestTimeoutMiddleware puts a deadline on the request context, which
+// handlers pass on to their database calls. The route's entry in
+// RouteTimeouts wins over RequestTimeout. X-Request-Timeout overrides both
+// with a whole number of seconds, clamped to MaxRequestTimeout.
+func requestTimeoutMiddleware(config *Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		timeout := config.RequestTimeout
+		if routeTimeout, ok := config.RouteTimeouts[ctx.Request.Method+" "+ctx.FullPath()]; ok {
+			timeout = routeTimeout
+		}
+		if rawTimeout := ctx.GetHeader(requestTimeoutHeader); rawTimeout != "" {
+			seconds, err := strconv.Atoi(rawTimeout)
+			if err != nil || seconds <= 0 {
+				sendError(ctx, &ErrInvalidHeader{Name: requestTimeoutHeader, Value: rawTimeout})
+				ctx.Abort()
+				return
+			}
+			timeout = config.MaxRequestTimeout
+			if seconds < int(config.MaxRequestTimeout/time.Second) {
+				timeout = time.Duration(seconds) * time.Second
+			}
+		}
+
+		requestContext, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(requestContext)
+		ctx.Next()
+	}
+}
+
+type ErrServerBusy struct{}
+
+func (err *ErrServerBusy) Code() string {
+	return "ErrServerBusy"
+}
+
+func (err *ErrServerBusy) messageArgs() []any {
+	return nil
+}
+
+func (err *ErrServerBusy) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrServerBusy) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+// inFlightLimitMiddleware caps how many requests are handled at once across
+// all clients. Requests beyond MaxInFlightRequests are refused with 503
+// right away instead of queueing up against the database. Zero disables
+// the limit.
+func inFlightLimitMiddleware(config *Config) gin.HandlerFunc {
+	if config.MaxInFlightRequests == 0 {
+		return func(ctx *gin.Context) {
+			ctx.Next()
+		}
+	}
+
+	slots := make(chan struct{}, config.MaxInFlightRequests)
+	return func(ctx *gin.Context) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			ctx.Next()
+		default:
+			ctx.Header("Retry-After", "1")
+			sendError(ctx, &ErrServerBusy{})
+			ctx.Abort()
+		}
+	}
+}
+
+type ErrRouteNotFound struct {
+	Method string
+	Path   string
+}
+
+func (err *ErrRouteNotFound) Code() string {
+	return "ErrRouteNotFound"
+}
+
+func (err *ErrRouteNotFound) messageArgs() []any {
+	return []any{err.Method, err.Path}
+}
+
+func (err *ErrRouteNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrRouteNotFound) Status() int {
+	return http.StatusNotFound
+}
+
+type ErrMethodNotAllowed struct {
+	Method string
+	Path   string
+}
+
+func (err *ErrMethodNotAllowed) Code() string {
+	return "ErrMethodNotAllowed"
+}
+
+func (err *ErrMethodNotAllowed) messageArgs() []any {
+	return []any{err.Method, err.Path}
+}
+
+func (err *ErrMethodNotAllowed) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrMethodNotAllowed) Status() int {
+	return http.StatusMethodNotAllowed
+}
+
+// noRouteHandler and noMethodHandler replace gin's plain text 404 and 405
+// bodies with the API's JSON errors.
+func noRouteHandler(ctx *gin.Context) {
+	sendError(ctx, &ErrRouteNotFound{Method: ctx.Request.Method, Path: ctx.Request.URL.Path})
+}
+
+func noMethodHandler(ctx *gin.Context) {
+	sendError(ctx, &ErrMethodNotAllowed{Method: ctx.Request.Method, Path: ctx.Request.URL.Path})
+}
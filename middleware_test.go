@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captureRequestLog sends requestLogger records to a buffer for the rest of
+// the test.
+func captureRequestLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buffer bytes.Buffer
+	previousLogger := requestLogger
+	requestLogger = slog.New(slog.NewJSONHandler(&buffer, nil))
+	t.Cleanup(func() {
+		requestLogger = previousLogger
+	})
+	return &buffer
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(requestIDMiddleware())
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString(requestIDKey))
+	})
+
+	tests := []struct {
+		name      string
+		requestID string
+		kept      bool
+	}{
+		{name: "safe token", requestID: "req-42_a.b", kept: true},
+		{name: "longest allowed", requestID: strings.Repeat("a", maxRequestIDLength), kept: true},
+		{name: "absent"},
+		{name: "too long", requestID: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "line break", requestID: "abc\r\nX-Injected: 1"},
+		{name: "spaces", requestID: "abc def"},
+		{name: "non-ASCII", requestID: "ré"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveRequest(t, router, http.MethodGet, "/", nil, requestIDHeader, test.requestID)
+			requestID := recorder.Header().Get(requestIDHeader)
+			if requestID != recorder.Body.String() {
+				t.Fatalf("header %q and context %q differ", requestID, recorder.Body.String())
+			}
+			if test.kept && requestID != test.requestID {
+				t.Fatalf("request ID = %q, want %q", requestID, test.requestID)
+			}
+			if !test.kept && (requestID == test.requestID || !isRequestIDValid(requestID)) {
+				t.Fatalf("request ID = %q, want a generated one", requestID)
+			}
+		})
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	logBuffer := captureRequestLog(t)
+	router := gin.New()
+	router.Use(requestIDMiddleware(), recoveryMiddleware())
+	router.GET("/panic", func(ctx *gin.Context) {
+		panic("deliberate")
+	})
+
+	recorder := serveRequest(t, router, http.MethodGet, "/panic", nil, requestIDHeader, "req-1")
+	expectStatus(t, recorder, http.StatusInternalServerError)
+	if body := decodeResponse[InternalErrorMessage](t, recorder); body.RequestID != "req-1" || body.Message == "" {
+		t.Fatalf("body = %+v", body)
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(logBuffer.Bytes(), &record); err != nil {
+		t.Fatalf("log record %q: %v", logBuffer.String(), err)
+	}
+	if record["msg"] != "panic" || record["panic"] != "deliberate" || record["requestId"] != "req-1" ||
+		record["stack"] == "" {
+		t.Fatalf("log record = %v", record)
+	}
+}
+
+func TestRequestLogMiddleware(t *testing.T) {
+	logBuffer := captureRequestLog(t)
+	router := gin.New()
+	router.Use(requestLogMiddleware(), requestIDMiddleware())
+	router.GET("/ping", func(ctx *gin.Context) {
+		ctx.Status(http.StatusTeapot)
+	})
+
+	serveRequest(t, router, http.MethodGet, "/ping", nil, requestIDHeader, "req-2")
+	var record map[string]any
+	if err := json.Unmarshal(logBuffer.Bytes(), &record); err != nil {
+		t.Fatalf("log record %q: %v", logBuffer.String(), err)
+	}
+	if record["path"] != "/ping" || record["status"] != float64(http.StatusTeapot) || record["requestId"] != "req-2" {
+		t.Fatalf("log record = %v", record)
+	}
+}
+
+func TestRequestTimeoutMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(requestTimeoutMiddleware(&Config{
+		RequestTimeout:    10 * time.Second,
+		MaxRequestTimeout: time.Minute,
+		RouteTimeouts:     map[string]time.Duration{"GET /slow": 30 * time.Second},
+	}))
+	remaining := func(ctx *gin.Context) {
+		deadline, _ := ctx.Request.Context().Deadline()
+		ctx.String(http.StatusOK, fmt.Sprint(time.Until(deadline).Round(time.Second)))
+	}
+	router.GET("/fast", remaining)
+	router.GET("/slow", remaining)
+
+	tests := []struct {
+		name      string
+		target    string
+		timeout   string
+		remaining string
+	}{
+		{name: "default", target: "/fast", remaining: "10s"},
+		{name: "route default", target: "/slow", remaining: "30s"},
+		{name: "override", target: "/fast", timeout: "5", remaining: "5s"},
+		{name: "override of a route default", target: "/slow", timeout: "45", remaining: "45s"},
+		{name: "clamped to the maximum", target: "/fast", timeout: "600", remaining: "1m0s"},
+	}
+	for _, test := range tests {
+		recorder := serveRequest(t, router, http.MethodGet, test.target, nil, requestTimeoutHeader, test.timeout)
+		expectStatus(t, recorder, http.StatusOK)
+		if remaining := recorder.Body.String(); remaining != test.remaining {
+			t.Errorf("%s: deadline in %s, want %s", test.name, remaining, test.remaining)
+		}
+	}
+
+	for _, timeout := range []string{"abc", "0", "-5", "1.5"} {
+		recorder := serveRequest(t, router, http.MethodGet, "/fast", nil, requestTimeoutHeader, timeout)
+		expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidHeader")
+	}
+}
+
+// The configured defaults give batches longer than plain reads.
+func TestDefaultRouteTimeouts(t *testing.T) {
+	config, _ := newTestConfig(t)
+	config.RequestTimeout = 5 * time.Second
+	router := gin.New()
+	router.Use(requestTimeoutMiddleware(config))
+	remaining := func(ctx *gin.Context) {
+		deadline, _ := ctx.Request.Context().Deadline()
+		ctx.String(http.StatusOK, fmt.Sprint(time.Until(deadline).Round(time.Second)))
+	}
+	router.GET("/account", remaining)
+	router.POST("/transfer/batch", remaining)
+
+	for _, test := range []struct {
+		method    string
+		target    string
+		remaining string
+	}{
+		{method: http.MethodGet, target: "/account", remaining: "5s"},
+		{method: http.MethodPost, target: "/transfer/batch", remaining: "30s"},
+	} {
+		recorder := serveRequest(t, router, test.method, test.target, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		if remaining := recorder.Body.String(); remaining != test.remaining {
+			t.Errorf("%s %s: deadline in %s, want %s", test.method, test.target, remaining, test.remaining)
+		}
+	}
+}
+
+func TestUnknownRouteAndMethod(t *testing.T) {
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(noRouteHandler)
+	router.NoMethod(noMethodHandler)
+	router.GET("/account", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	recorder := serveRequest(t, router, http.MethodDelete, "/account", nil)
+	expectErrorCode(t, recorder, http.StatusMethodNotAllowed, "ErrMethodNotAllowed")
+	if message := decodeResponse[JsonMessage](t, recorder).Message; !strings.Contains(message, "DELETE") {
+		t.Fatalf("405 message = %q", message)
+	}
+
+	recorder = serveRequest(t, router, http.MethodGet, "/no-such-route", nil)
+	expectErrorCode(t, recorder, http.StatusNotFound, "ErrRouteNotFound")
+	if message := decodeResponse[JsonMessage](t, recorder).Message; !strings.Contains(message, "/no-such-route") {
+		t.Fatalf("404 message = %q", message)
+	}
+}
+
+func TestRouterUnknownRouteAndMethod(t *testing.T) {
+	server := newTestServer(t)
+	expectErrorCode(t, server.request(http.MethodPut, "/deposit", nil), http.StatusMethodNotAllowed, "ErrMethodNotAllowed")
+	expectErrorCode(t, server.request(http.MethodGet, "/accounts", nil), http.StatusNotFound, "ErrRouteNotFound")
+}
+
+func TestInFlightLimitMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(inFlightLimitMiddleware(&Config{MaxInFlightRequests: 2}))
+	started, release := make(chan struct{}), make(chan struct{})
+	router.GET("/block", func(ctx *gin.Context) {
+		started <- struct{}{}
+		<-release
+		ctx.Status(http.StatusOK)
+	})
+	router.GET("/fast", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	blocked := make([]*httptest.ResponseRecorder, 2)
+	var wait sync.WaitGroup
+	for i := range blocked {
+		blocked[i] = httptest.NewRecorder()
+		wait.Add(1)
+		go func(recorder *httptest.ResponseRecorder) {
+			defer wait.Done()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/block", nil))
+		}(blocked[i])
+		<-started
+	}
+
+	// Both slots are taken, so even a cheap request is refused at once.
+	for i := 0; i < 3; i++ {
+		recorder := serveRequest(t, router, http.MethodGet, "/fast", nil)
+		expectErrorCode(t, recorder, http.StatusServiceUnavailable, "ErrServerBusy")
+		if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "1" {
+			t.Fatalf("Retry-After = %q", retryAfter)
+		}
+	}
+
+	close(release)
+	wait.Wait()
+	for i, recorder := range blocked {
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("blocked request %d finished with %d", i, recorder.Code)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		expectStatus(t, serveRequest(t, router, http.MethodGet, "/fast", nil), http.StatusOK)
+	}
+}
+
+func TestInFlightLimitDisabled(t *testing.T) {
+	router := gin.New()
+	router.Use(inFlightLimitMiddleware(&Config{}))
+	const requests = 10
+	var started sync.WaitGroup
+	started.Add(requests)
+	router.GET("/block", func(ctx *gin.Context) {
+		started.Done()
+		started.Wait()
+		ctx.Status(http.StatusOK)
+	})
+
+	recorders := make([]*httptest.ResponseRecorder, requests)
+	var wait sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wait.Add(1)
+		go func(recorder *httptest.ResponseRecorder) {
+			defer wait.Done()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/block", nil))
+		}(recorders[i])
+	}
+	wait.Wait()
+	for i, recorder := range recorders {
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %d finished with %d", i, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	appEnvProduction = "production"
+
+	mongoURICheckWarn   = "warn"
+	mongoURICheckRefuse = "refuse"
+)
+
+type ErrInsecureMongoURI struct {
+	Reason string
+}
+
+func (err *ErrInsecureMongoURI) Error() string {
+	return fmt.Sprintf("ErrInsecureMongoURI: the MongoDB URI is not safe for production: %s.", err.Reason)
+}
+
+func isMongoURICheckValid(check string) bool {
+	return check == mongoURICheckWarn || check == mongoURICheckRefuse
+}
+
+func isLocalHost(host string) bool {
+	if hostName, _, err := net.SplitHostPort(host); err == nil {
+		host = hostName
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validateMongoURI checks, when env is production, that the URI uses TLS,
+// either through the mongodb+srv scheme or tls=true, and that none of its
+// hosts is the local machine. The URI is never quoted in the error, since
+// it may carry credentials.
+func validateMongoURI(uri, env string) error {
+	if env != appEnvProduction {
+		return nil
+	}
+	scheme, rest, found := strings.Cut(uri, "://")
+	if !found || scheme != "mongodb" && scheme != "mongodb+srv" {
+		return &ErrInsecureMongoURI{Reason: "the scheme is neither mongodb nor mongodb+srv"}
+	}
+	hostList, rawOptions, _ := strings.Cut(rest, "?")
+	hostList, _, _ = strings.Cut(hostList, "/")
+	if at := strings.LastIndex(hostList, "@"); at >= 0 {
+		hostList = hostList[at+1:]
+	}
+
+	tls := scheme == "mongodb+srv"
+	for _, option := range strings.Split(rawOptions, "&") {
+		name, value, _ := strings.Cut(option, "=")
+		if strings.EqualFold(name, "tls") || strings.EqualFold(name, "ssl") {
+			tls = strings.EqualFold(value, "true")
+		}
+	}
+	if !tls {
+		return &ErrInsecureMongoURI{Reason: "TLS is not enabled"}
+	}
+	for _, host := range strings.Split(hostList, ",") {
+		if isLocalHost(host) {
+			return &ErrInsecureMongoURI{Reason: "it points at " + host}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ErrDuplicateSource struct {
+	UserName string
+}
+
+func (err *ErrDuplicateSource) Code() string {
+	return "ErrDuplicateSource"
+}
+
+func (err *ErrDuplicateSource) messageArgs() []any {
+	return []any{err.UserName}
+}
+
+func (err *ErrDuplicateSource) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type TransferSource struct {
+	UserName string `json:"username"`
+	Amount   int    `json:"amount"`
+}
+
+type MultiSourceTransferInput struct {
+	Sources  []TransferSource `json:"sources"`
+	ToUser   string           `json:"touser"`
+	Category string           `json:"category,omitempty"`
+	Memo     string           `json:"memo,omitempty"`
+}
+
+func (input *MultiSourceTransferInput) Error() error {
+	var validationErrors MultiError
+	if len(input.Sources) == 0 || len(input.Sources) > maxBatchSize {
+		validationErrors.Add("sources", &ErrBatchSize{Max: maxBatchSize})
+	}
+	if !isUsernameValid(input.ToUser) {
+		validationErrors.Add("touser", &ErrInvalidUsername{UserName: input.ToUser})
+	}
+	seenSources := make(map[string]bool, len(input.Sources))
+	for _, source := range input.Sources {
+		switch {
+		case !isUsernameValid(source.UserName):
+			validationErrors.Add("sources", &ErrInvalidUsername{UserName: source.UserName})
+		case source.UserName == input.ToUser:
+			validationErrors.Add("sources", &ErrSameSourceAndTarget{})
+		case seenSources[source.UserName]:
+			validationErrors.Add("sources", &ErrDuplicateSource{UserName: source.UserName})
+		}
+		seenSources[source.UserName] = true
+		if err := amountError("amount", source.Amount); err != nil {
+			validationErrors.Add("sources", err)
+		}
+	}
+	if !isCategoryValid(input.Category) {
+		validationErrors.Add("category", &ErrCategoryTooLong{Max: maxCategoryLength})
+	}
+	if utf8.RuneCountInString(input.Memo) > maxMemoLength {
+		validationErrors.Add("memo", &ErrFieldTooLong{Name: "memo", Max: maxMemoLength})
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+func (input *MultiSourceTransferInput) normalizeUsernames() {
+	input.ToUser = normalizeUsername(input.ToUser)
+	for i := range input.Sources {
+		input.Sources[i].UserName = normalizeUsername(input.Sources[i].UserName)
+	}
+}
+
+// multiSourceTransferHandler pools money from several sources into one
+// target in a single transaction: either every debit and the credit land
+// or none does. In strict mode one source short of funds rolls back the
+// whole transfer. Accounts are read and written in username order like
+// executeTransfer. Transfer fees are not charged on pooled transfers.
+// The response lists the final sources, in input order, then the target.
+func multiSourceTransferHandler(
+	accountRepository *AccountRepository, transactionCollection *mongo.Collection, config *Config,
+	publisher EventPublisher,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		transferInput, ok := bindAndValidate[MultiSourceTransferInput](ctx)
+		if !ok {
+			return
+		}
+
+		userNames := []string{transferInput.ToUser}
+		for _, source := range transferInput.Sources {
+			userNames = append(userNames, source.UserName)
+		}
+		sortedUserNames := append([]string(nil), userNames...)
+		sort.Strings(sortedUserNames)
+		strict := isStrictRequest(ctx) || config.LedgerMode == ledgerModePoints
+
+		var alertEvents []Event
+		accountCollection := accountRepository.Collection()
+		finalAccounts, err := runInTransaction(ctx.Request.Context(), accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+			alertEvents = nil
+
+			accounts := make(map[string]*BankAccount, len(sortedUserNames))
+			for _, userName := range sortedUserNames {
+				account, err := findAccountInSession(sessionCtx, accountCollection, userName)
+				if err != nil {
+					return nil, err
+				}
+				if err := checkAccountOpen(account); err != nil {
+					return nil, err
+				}
+				accounts[userName] = &account
+			}
+
+			targetAccount := accounts[transferInput.ToUser]
+			var historyEntries []Transaction
+			for _, source := range transferInput.Sources {
+				sourceAccount := accounts[source.UserName]
+				if err := checkUnverifiedLimit(config, *sourceAccount, source.Amount); err != nil {
+					return nil, err
+				}
+				if strict && !coversDebit(*sourceAccount, source.Amount) {
+					return nil, &ErrInsufficientFunds{
+						UserName: sourceAccount.UserName,
+						Balance:  availableBalance(*sourceAccount),
+						Amount:   source.Amount,
+					}
+				}
+
+				debitAccount(sourceAccount, source.Amount)
+				debitTransaction := newTransaction(config.Clock, *sourceAccount, transactionTypeTransferOut, source.Amount)
+				debitTransaction.Counterparty = targetAccount.UserName
+				debitTransaction.Category = transferInput.Category
+				debitTransaction.Memo = transferInput.Memo
+
+				// Crediting each share in turn ends in the same state as one
+				// credit of the total and keeps a counterparty per entry.
+				creditAccount(targetAccount, source.Amount)
+				creditTransaction := newTransaction(config.Clock, *targetAccount, transactionTypeTransferIn, source.Amount)
+				creditTransaction.Counterparty = sourceAccount.UserName
+				creditTransaction.Category = transferInput.Category
+				creditTransaction.Memo = transferInput.Memo
+
+				historyEntries = append(historyEntries, debitTransaction, creditTransaction)
+			}
+
+			changedAccounts := make([]*BankAccount, 0, len(accounts))
+			for _, userName := range sortedUserNames {
+				alertEvents = append(alertEvents, updateBalanceAlert(config.Clock, accounts[userName])...)
+				changedAccounts = append(changedAccounts, accounts[userName])
+			}
+			if err := replaceInOrder(sessionCtx, accountRepository, changedAccounts...); err != nil {
+				return nil, err
+			}
+			for _, transaction := range historyEntries {
+				if _, err := insertTransaction(sessionCtx, transactionCollection, transaction); err != nil {
+					return nil, err
+				}
+			}
+
+			affectedAccounts := make([]BankAccount, 0, len(userNames))
+			for _, userName := range userNames[1:] {
+				affectedAccounts = append(affectedAccounts, *accounts[userName])
+			}
+			return append(affectedAccounts, *targetAccount), nil
+		})
+		accountRepository.Invalidate(userNames...)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		retainHistory(transactionCollection, config, userNames...)
+		for _, source := range transferInput.Sources {
+			alertEvents = append(alertEvents, newEvent(config.Clock, eventTypeTransfer, source.UserName, TransferNote{
+				FromUser: source.UserName,
+				ToUser:   transferInput.ToUser,
+				Amount:   source.Amount,
+				Category: transferInput.Category,
+				Memo:     transferInput.Memo,
+			}))
+		}
+		publishEvents(publisher, alertEvents)
+
+		respond(ctx, http.StatusOK, finalAccounts)
+	}
+}
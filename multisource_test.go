@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMultiSourceTransfer(t *testing.T) {
+	server := newTestServer(t)
+	for _, userName := range []string{"alice", "bob", "carol", "dave"} {
+		server.createAccount(userName)
+	}
+	server.deposit("alice", 50)
+	server.deposit("carol", 40)
+	server.withdraw("dave", 10)
+
+	recorder := server.request(http.MethodPost, "/transfer/multi-source?strict=true", MultiSourceTransferInput{
+		Sources: []TransferSource{{UserName: "carol", Amount: 25}, {UserName: "alice", Amount: 30}},
+		ToUser:  "dave",
+		Memo:    "gift",
+	})
+	expectStatus(t, recorder, http.StatusOK)
+	finalAccounts := decodeResponse[[]BankAccount](t, recorder)
+	want := []struct {
+		userName      string
+		balance, debt int
+	}{
+		{userName: "carol", balance: 15},
+		{userName: "alice", balance: 20},
+		{userName: "dave", balance: 45},
+	}
+	if len(finalAccounts) != len(want) {
+		t.Fatalf("final accounts = %+v", finalAccounts)
+	}
+	for i, account := range finalAccounts {
+		if account.UserName != want[i].userName || account.Balance != want[i].balance || account.Debt != want[i].debt {
+			t.Fatalf("final accounts = %+v, want %+v", finalAccounts, want)
+		}
+		if stored := server.account(account.UserName); stored.Balance != account.Balance || stored.Debt != account.Debt {
+			t.Fatalf("stored %+v, responded %+v", stored, account)
+		}
+	}
+	history := server.history("dave")
+	if credits := history[len(history)-2:]; credits[0].Counterparty != "carol" || credits[0].Amount != 25 ||
+		credits[1].Counterparty != "alice" || credits[1].Amount != 30 || credits[1].Memo != "gift" {
+		t.Fatalf("dave's credits = %+v", credits)
+	}
+
+	// bob has nothing, so the strict transfer rolls back alice's share too.
+	historyLength := len(server.history("alice"))
+	recorder = server.request(http.MethodPost, "/transfer/multi-source?strict=true", MultiSourceTransferInput{
+		Sources: []TransferSource{{UserName: "alice", Amount: 10}, {UserName: "bob", Amount: 5}},
+		ToUser:  "dave",
+	})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInsufficientFunds")
+	if alice, bob, dave := server.account("alice"), server.account("bob"), server.account("dave"); alice.Balance != 20 ||
+		bob.Balance != 0 || bob.Debt != 0 || dave.Balance != 45 {
+		t.Fatalf("after rollback: alice %+v, bob %+v, dave %+v", alice, bob, dave)
+	}
+	if length := len(server.history("alice")); length != historyLength {
+		t.Fatalf("alice has %d history entries after the rollback, want %d", length, historyLength)
+	}
+
+	// Without strict mode bob's share becomes debt.
+	recorder = server.request(http.MethodPost, "/transfer/multi-source", MultiSourceTransferInput{
+		Sources: []TransferSource{{UserName: "alice", Amount: 10}, {UserName: "bob", Amount: 5}},
+		ToUser:  "dave",
+	})
+	expectStatus(t, recorder, http.StatusOK)
+	if bob := server.account("bob"); bob.Debt != 5 {
+		t.Fatalf("bob = %+v, want debt 5", bob)
+	}
+
+	recorder = server.request(http.MethodPost, "/transfer/multi-source", MultiSourceTransferInput{
+		Sources: []TransferSource{{UserName: "alice", Amount: 1}, {UserName: "alice", Amount: 1}},
+		ToUser:  "dave",
+	})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrDuplicateSource")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	fieldNamingDefault = "default"
+	fieldNamingCamel   = "camelCase"
+	fieldNamingSnake   = "snake_case"
+
+	fieldNamingHeader = "X-Field-Naming"
+	fieldNamingKey    = "fieldNaming"
+)
+
+// legacyCamelNames spells out the all-lowercase tags kept for backward
+// compatibility; every other tag is already camelCase.
+var legacyCamelNames = map[string]string{
+	"fromuser": "fromUser",
+	"touser":   "toUser",
+}
+
+func isFieldNamingValid(naming string) bool {
+	return naming == fieldNamingDefault || naming == fieldNamingCamel || naming == fieldNamingSnake
+}
+
+// fieldNamingMiddleware picks the naming style of JSON field names for the
+// request: the X-Field-Naming header when sent, JSON_FIELD_NAMING
+// otherwise. The default style keeps the struct tags as they are.
+func fieldNamingMiddleware(config *Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		naming := config.JSONFieldNaming
+		if rawNaming := ctx.GetHeader(fieldNamingHeader); rawNaming != "" {
+			if !isFieldNamingValid(rawNaming) {
+				sendError(ctx, &ErrInvalidHeader{Name: fieldNamingHeader, Value: rawNaming})
+				ctx.Abort()
+				return
+			}
+			naming = rawNaming
+		}
+		if naming != fieldNamingDefault {
+			ctx.Set(fieldNamingKey, naming)
+		}
+		ctx.Next()
+	}
+}
+
+func camelFieldName(name string) string {
+	if camelName, ok := legacyCamelNames[name]; ok {
+		return camelName
+	}
+	return name
+}
+
+func snakeFieldName(name string) string {
+	var snakeName strings.Builder
+	for i, character := range camelFieldName(name) {
+		if unicode.IsUpper(character) {
+			if i > 0 {
+				snakeName.WriteByte('_')
+			}
+			character = unicode.ToLower(character)
+		}
+		snakeName.WriteRune(character)
+	}
+	return snakeName.String()
+}
+
+// camelFromSnake turns an incoming snake_case name into camelCase, which
+// encoding/json then matches case-insensitively against the struct tags.
+func camelFromSnake(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func renameFields(value any, rename func(string) string) any {
+	switch typedValue := value.(type) {
+	case map[string]any:
+		renamed := make(map[string]any, len(typedValue))
+		for name, nested := range typedValue {
+			renamed[rename(name)] = renameFields(nested, rename)
+		}
+		return renamed
+	case []any:
+		for i, nested := range typedValue {
+			typedValue[i] = renameFields(nested, rename)
+		}
+	}
+	return value
+}
+
+// decodeGeneric parses a JSON document keeping numbers as json.Number so
+// amounts survive a round trip unchanged.
+func decodeGeneric(document []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var generic any
+	err := decoder.Decode(&generic)
+	return generic, err
+}
+
+// namedFields marshals value with its field names rewritten to naming.
+type namedFields struct {
+	value  any
+	naming string
+}
+
+func (fields namedFields) MarshalJSON() ([]byte, error) {
+	document, err := json.Marshal(fields.value)
+	if err != nil {
+		return nil, err
+	}
+	generic, err := decodeGeneric(document)
+	if err != nil {
+		return nil, err
+	}
+	rename := camelFieldName
+	if fields.naming == fieldNamingSnake {
+		rename = snakeFieldName
+	}
+	return json.Marshal(renameFields(generic, rename))
+}
+
+// withFieldNaming wraps data for the naming style chosen for the request.
+func withFieldNaming(ctx *gin.Context, data any) any {
+	naming := ctx.GetString(fieldNamingKey)
+	if naming == "" {
+		return data
+	}
+	return namedFields{value: data, naming: naming}
+}
+
+// requestFieldName spells a single field name, such as the one attached to
+// a validation error, in the naming style chosen for the request.
+func requestFieldName(ctx *gin.Context, name string) string {
+	switch ctx.GetString(fieldNamingKey) {
+	case fieldNamingCamel:
+		return camelFieldName(name)
+	case fieldNamingSnake:
+		return snakeFieldName(name)
+	}
+	return name
+}
+
+// renameRequestFields rewrites a snake_case request body to camelCase
+// before it is bound. camelCase bodies need nothing, because binding
+// already ignores case.
+func renameRequestFields(ctx *gin.Context) error {
+	if ctx.GetString(fieldNamingKey) != fieldNamingSnake || ctx.Request.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return err
+	}
+	generic, err := decodeGeneric(body)
+	if err != nil {
+		// Leave malformed bodies to the binder so it reports them.
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+	body, err = json.Marshal(renameFields(generic, camelFromSnake))
+	if err != nil {
+		return err
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
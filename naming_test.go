@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFieldNames(t *testing.T) {
+	tests := []struct {
+		name, camel, snake string
+	}{
+		{name: "username", camel: "username", snake: "username"},
+		{name: "fromuser", camel: "fromUser", snake: "from_user"},
+		{name: "touser", camel: "toUser", snake: "to_user"},
+		{name: "transferId", camel: "transferId", snake: "transfer_id"},
+		{name: "debtRepaymentPolicy", camel: "debtRepaymentPolicy", snake: "debt_repayment_policy"},
+	}
+	for _, test := range tests {
+		if camel := camelFieldName(test.name); camel != test.camel {
+			t.Errorf("camelFieldName(%q) = %q, want %q", test.name, camel, test.camel)
+		}
+		if snake := snakeFieldName(test.name); snake != test.snake {
+			t.Errorf("snakeFieldName(%q) = %q, want %q", test.name, snake, test.snake)
+		}
+		if camel := camelFromSnake(test.snake); camel != test.camel {
+			t.Errorf("camelFromSnake(%q) = %q, want %q", test.snake, camel, test.camel)
+		}
+	}
+}
+
+func namingRouter(naming string) *gin.Engine {
+	router := gin.New()
+	router.Use(fieldNamingMiddleware(&Config{JSONFieldNaming: naming}))
+	router.POST("/echo", func(ctx *gin.Context) {
+		transferNote, ok := bindAndValidate[TransferNote](ctx)
+		if !ok {
+			return
+		}
+		respond(ctx, http.StatusOK, transferNote)
+	})
+	return router
+}
+
+func TestFieldNaming(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		header string
+		body   string
+		want   string
+	}{
+		{name: "default", config: fieldNamingDefault,
+			body: `{"fromuser":"alice","touser":"bob","amount":5,"transferId":"t-1"}`,
+			want: `{"fromuser":"alice","touser":"bob","amount":5,"transferId":"t-1"}`},
+		{name: "camelCase header", config: fieldNamingDefault, header: fieldNamingCamel,
+			body: `{"fromUser":"alice","toUser":"bob","amount":5,"transferId":"t-1"}`,
+			want: `{"amount":5,"fromUser":"alice","toUser":"bob","transferId":"t-1"}`},
+		{name: "snake_case header", config: fieldNamingDefault, header: fieldNamingSnake,
+			body: `{"from_user":"alice","to_user":"bob","amount":5,"transfer_id":"t-1"}`,
+			want: `{"amount":5,"from_user":"alice","to_user":"bob","transfer_id":"t-1"}`},
+		{name: "snake_case config", config: fieldNamingSnake,
+			body: `{"from_user":"alice","to_user":"bob","amount":5,"transfer_id":"t-1"}`,
+			want: `{"amount":5,"from_user":"alice","to_user":"bob","transfer_id":"t-1"}`},
+		{name: "header overrides config", config: fieldNamingSnake, header: fieldNamingDefault,
+			body: `{"fromuser":"alice","touser":"bob","amount":5,"transferId":"t-1"}`,
+			want: `{"fromuser":"alice","touser":"bob","amount":5,"transferId":"t-1"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := namingRouter(test.config)
+			var header []string
+			if test.header != "" {
+				header = []string{fieldNamingHeader, test.header}
+			}
+			recorder := serveRequest(t, router, http.MethodPost, "/echo", json.RawMessage(test.body), header...)
+			expectStatus(t, recorder, http.StatusOK)
+			if body := recorder.Body.String(); body != test.want {
+				t.Fatalf("body = %s, want %s", body, test.want)
+			}
+		})
+	}
+}
+
+func TestFieldNamingValidationErrors(t *testing.T) {
+	router := namingRouter(fieldNamingSnake)
+	recorder := serveRequest(t, router, http.MethodPost, "/echo",
+		json.RawMessage(`{"from_user":"alice","to_user":"alice","amount":-5}`))
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrValidation")
+	var fields []string
+	for _, fieldMessage := range decodeResponse[JsonMessage](t, recorder).Errors {
+		fields = append(fields, fieldMessage.Field)
+	}
+	if len(fields) != 2 || fields[0] != "amount" || fields[1] != "to_user" {
+		t.Fatalf("error fields = %v, want [amount to_user]", fields)
+	}
+
+	recorder = serveRequest(t, router, http.MethodPost, "/echo", json.RawMessage(`{}`),
+		fieldNamingHeader, "kebab-case")
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidHeader")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ErrNegativeBalance struct {
+	UserName string
+	Balance  int
+}
+
+func (err *ErrNegativeBalance) Code() string {
+	return "ErrNegativeBalance"
+}
+
+func (err *ErrNegativeBalance) messageArgs() []any {
+	return []any{err.UserName, err.Balance}
+}
+
+func (err *ErrNegativeBalance) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type AllowNegativeInput struct {
+	AllowNegative bool `json:"allowNegative"`
+}
+
+// setAllowNegativeHandler designates an internal account, such as the fee
+// account or a mint, whose balance may go below zero instead of turning
+// overdrafts into debt. The flag can only be cleared once the balance is
+// back at zero or above.
+func setAllowNegativeHandler(accountRepository *AccountRepository) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		var allowNegativeInput AllowNegativeInput
+		if err := ctx.BindJSON(&allowNegativeInput); err != nil {
+			sendError(ctx, &ErrInputRead{InputError: err})
+			return
+		}
+
+		targetAccount, err := accountRepository.FindFreshByUsername(ctx.Request.Context(), userName)
+		if err != nil {
+			if sendErrUserNotFound(ctx, err, userName) {
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+		if !allowNegativeInput.AllowNegative && targetAccount.Balance < 0 {
+			sendError(ctx, &ErrNegativeBalance{UserName: targetAccount.UserName, Balance: targetAccount.Balance})
+			return
+		}
+
+		if targetAccount.AllowNegative == allowNegativeInput.AllowNegative {
+			setAccountETag(ctx, targetAccount)
+			respondNoChange(ctx)
+			return
+		}
+
+		targetAccount.AllowNegative = allowNegativeInput.AllowNegative
+		if err := accountRepository.Replace(ctx.Request.Context(), &targetAccount); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		setAccountETag(ctx, targetAccount)
+		respond(ctx, http.StatusOK, targetAccount)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAllowNegative(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	for _, userName := range []string{"mint", "alice", "bob"} {
+		server.createAccount(userName)
+	}
+
+	recorder := server.request(http.MethodPost, "/account/mint/allow-negative", AllowNegativeInput{AllowNegative: true})
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+	recorder = server.request(http.MethodPost, "/account/mint/allow-negative", AllowNegativeInput{AllowNegative: true},
+		"Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	if account := decodeResponse[BankAccount](t, recorder); !account.AllowNegative {
+		t.Fatalf("account = %+v, want allowNegative", account)
+	}
+
+	server.withdraw("mint", 30)
+	server.transfer("mint", "alice", 50)
+	if mint := server.account("mint"); mint.Balance != -80 || mint.Debt != 0 {
+		t.Fatalf("mint balance, debt = %d, %d, want -80, 0", mint.Balance, mint.Debt)
+	}
+	if alice := server.account("alice"); alice.Balance != 50 {
+		t.Fatalf("alice balance = %d, want 50", alice.Balance)
+	}
+
+	// A regular account still turns an overdraft into debt.
+	server.withdraw("bob", 30)
+	if bob := server.account("bob"); bob.Balance != 0 || bob.Debt != 30 {
+		t.Fatalf("bob balance, debt = %d, %d, want 0, 30", bob.Balance, bob.Debt)
+	}
+	server.transfer("alice", "bob", 70)
+	if alice := server.account("alice"); alice.Balance != 0 || alice.Debt != 20 {
+		t.Fatalf("alice balance, debt = %d, %d, want 0, 20", alice.Balance, alice.Debt)
+	}
+
+	recorder = server.request(http.MethodPost, "/account/mint/allow-negative", AllowNegativeInput{},
+		"Authorization", "Bearer secret")
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrNegativeBalance")
+	if mint := server.account("mint"); !mint.AllowNegative {
+		t.Fatal("allowNegative cleared while the balance is negative")
+	}
+}
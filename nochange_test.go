@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestNoOpOptionUpdates(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	server.createAccount("alice")
+	region := "eu"
+
+	for _, test := range []struct {
+		name   string
+		method string
+		target string
+		body   any
+		header []string
+	}{
+		{name: "debt repayment policy", method: http.MethodPost, target: "/account/alice/debt-repayment-policy",
+			body: DebtRepaymentPolicyInput{Policy: debtRepaymentBalanceFirst}},
+		{name: "balance alerts", method: http.MethodPost, target: "/account/alice/balance-alerts",
+			body: BalanceThresholdsInput{LowBalanceThreshold: 10, HighBalanceThreshold: 1000}},
+		{name: "metadata", method: http.MethodPatch, target: "/account/alice/metadata",
+			body: MetadataInput{Metadata: map[string]*string{"region": &region}}},
+		{name: "allow negative", method: http.MethodPost, target: "/account/alice/allow-negative",
+			body: AllowNegativeInput{AllowNegative: true}, header: []string{"Authorization", "Bearer secret"}},
+	} {
+		recorder := server.request(test.method, test.target, test.body, test.header...)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: first status = %d, want 200; body: %s", test.name, recorder.Code, recorder.Body.String())
+		}
+		before := server.account("alice")
+
+		recorder = server.request(test.method, test.target, test.body, test.header...)
+		if recorder.Code != http.StatusNoContent || recorder.Body.Len() != 0 {
+			t.Fatalf("%s: repeat = %d %q, want an empty 204", test.name, recorder.Code, recorder.Body.String())
+		}
+		if etag := recorder.Header().Get("ETag"); etag != accountETag(before) {
+			t.Fatalf("%s: ETag = %q, want %q", test.name, etag, accountETag(before))
+		}
+		if after := server.account("alice"); !reflect.DeepEqual(after, before) {
+			t.Fatalf("%s: no-op wrote the account: %+v, was %+v", test.name, after, before)
+		}
+	}
+
+	// Removing a key that is not there changes nothing either.
+	recorder := server.request(http.MethodPatch, "/account/alice/metadata",
+		MetadataInput{Metadata: map[string]*string{"missing": nil}})
+	expectStatus(t, recorder, http.StatusNoContent)
+
+	// Money movements always change state and keep answering 200.
+	recorder = server.request(http.MethodPost, "/deposit", TransactionInput{UserName: "alice", Amount: 1})
+	expectStatus(t, recorder, http.StatusOK)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultMinOperationDuration = time.Second
+
+type ErrOperationNotFound struct {
+	OpID string
+}
+
+func (err *ErrOperationNotFound) Code() string {
+	return "ErrOperationNotFound"
+}
+
+func (err *ErrOperationNotFound) messageArgs() []any {
+	return []any{err.OpID}
+}
+
+func (err *ErrOperationNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrOperationNotFound) Status() int {
+	return http.StatusNotFound
+}
+
+// RunningOperation is one entry of currentOp's inprog array. OpID is a
+// number on a replica set member and a "shard:number" string on mongos.
+type RunningOperation struct {
+	OpID             any    `bson:"opid" json:"opid"`
+	Type             string `bson:"op" json:"type"`
+	Namespace        string `bson:"ns" json:"namespace,omitempty"`
+	Description      string `bson:"desc" json:"description,omitempty"`
+	Client           string `bson:"client" json:"client,omitempty"`
+	MicrosecsRunning int64  `bson:"microsecs_running" json:"microsecsRunning"`
+	WaitingForLock   bool   `bson:"waitingForLock" json:"waitingForLock"`
+	Command          bson.M `bson:"command" json:"command,omitempty"`
+}
+
+// currentOperations runs currentOp on the admin database with filter added
+// to the active operations selector.
+func currentOperations(ctx context.Context, client *mongo.Client, filter bson.D) ([]RunningOperation, error) {
+	command := append(bson.D{
+		{Key: "currentOp", Value: 1},
+		{Key: "active", Value: true},
+	}, filter...)
+	var result struct {
+		InProg []RunningOperation `bson:"inprog"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, command).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.InProg, nil
+}
+
+// parseOpID keeps mongos "shard:number" ids as strings and turns plain
+// numbers into the integer mongod expects.
+func parseOpID(rawOpID string) any {
+	if opID, err := strconv.ParseInt(rawOpID, 10, 64); err == nil {
+		return opID
+	}
+	return rawOpID
+}
+
+// listOperationsHandler lists active operations that have been running for
+// at least ?minDuration= (a Go duration, one second by default).
+func listOperationsHandler(client *mongo.Client) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		minDuration := defaultMinOperationDuration
+		if rawMinDuration, ok := ctx.GetQuery("minDuration"); ok {
+			parsedDuration, err := time.ParseDuration(rawMinDuration)
+			if err != nil || parsedDuration < 0 {
+				sendError(ctx, &ErrInvalidQueryParam{Name: "minDuration", Value: rawMinDuration})
+				return
+			}
+			minDuration = parsedDuration
+		}
+
+		operations, err := currentOperations(ctx.Request.Context(), client, bson.D{
+			{Key: "microsecs_running", Value: bson.D{{Key: "$gte", Value: minDuration.Microseconds()}}},
+		})
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if operations == nil {
+			operations = []RunningOperation{}
+		}
+		respond(ctx, http.StatusOK, operations)
+	}
+}
+
+// killOperationHandler kills the operation with the given opid. The id is
+// looked up first because killOp reports success even for unknown ids.
+func killOperationHandler(client *mongo.Client) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		rawOpID := ctx.Param("opid")
+		opID := parseOpID(rawOpID)
+
+		operations, err := currentOperations(ctx.Request.Context(), client, bson.D{{Key: "opid", Value: opID}})
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if len(operations) == 0 {
+			sendError(ctx, &ErrOperationNotFound{OpID: rawOpID})
+			return
+		}
+
+		err = client.Database("admin").RunCommand(ctx.Request.Context(), bson.D{
+			{Key: "killOp", Value: 1},
+			{Key: "op", Value: opID},
+		}).Err()
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		respond(ctx, http.StatusOK, operations[0])
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseOpID(t *testing.T) {
+	tests := []struct {
+		rawOpID string
+		want    any
+	}{
+		{rawOpID: "12345", want: int64(12345)},
+		{rawOpID: "shard01:12345", want: "shard01:12345"},
+		{rawOpID: "abc", want: "abc"},
+	}
+	for _, test := range tests {
+		if opID := parseOpID(test.rawOpID); !reflect.DeepEqual(opID, test.want) {
+			t.Errorf("parseOpID(%q) = %#v, want %#v", test.rawOpID, opID, test.want)
+		}
+	}
+}
+
+func TestOperationsAdmin(t *testing.T) {
+	disabled := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	recorder := disabled.request(http.MethodGet, "/admin/operations", nil, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusNotFound)
+
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+		config.OperationsAdmin = true
+	})
+	server.createAccount("alice")
+	recorder = server.request(http.MethodGet, "/admin/operations", nil)
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+
+	// A find whose filter sleeps on each document stays active long enough
+	// to be listed and killed.
+	namespace := server.database.Name() + "." + server.accounts.Collection().Name()
+	slowDone := make(chan error, 1)
+	go func() {
+		slowDone <- server.database.RunCommand(context.Background(), bson.D{
+			{Key: "find", Value: server.accounts.Collection().Name()},
+			{Key: "filter", Value: bson.D{{Key: "$where", Value: "sleep(10000) || true"}}},
+		}).Err()
+	}()
+
+	var slowOperation *RunningOperation
+	for deadline := time.Now().Add(5 * time.Second); slowOperation == nil && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		recorder = server.request(http.MethodGet, "/admin/operations?minDuration=200ms", nil,
+			"Authorization", "Bearer secret")
+		expectStatus(t, recorder, http.StatusOK)
+		for _, operation := range decodeResponse[[]RunningOperation](t, recorder) {
+			if operation.Namespace == namespace && operation.MicrosecsRunning >= 200000 {
+				operation := operation
+				slowOperation = &operation
+			}
+		}
+	}
+	if slowOperation == nil {
+		t.Fatalf("slow find on %s never appeared in the listing", namespace)
+	}
+
+	opID, ok := slowOperation.OpID.(float64)
+	if !ok {
+		t.Fatalf("opid %#v is not a number", slowOperation.OpID)
+	}
+	recorder = server.request(http.MethodPost, fmt.Sprintf("/admin/operations/%d/kill", int64(opID)), nil,
+		"Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	select {
+	case err := <-slowDone:
+		if err == nil {
+			t.Fatal("killed find completed without an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("killed find is still running")
+	}
+
+	recorder = server.request(http.MethodPost, fmt.Sprintf("/admin/operations/%d/kill", int64(opID)), nil,
+		"Authorization", "Bearer secret")
+	expectErrorCode(t, recorder, http.StatusNotFound, "ErrOperationNotFound")
+
+	recorder = server.request(http.MethodGet, "/admin/operations?minDuration=soon", nil,
+		"Authorization", "Bearer secret")
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidQueryParam")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPage  = 1
+	defaultLimit = 20
+
+	pageLimitHeader = "X-Page-Limit"
+)
+
+type ErrInvalidQueryParam struct {
+	Name  string
+	Value string
+}
+
+func (err *ErrInvalidQueryParam) Code() string {
+	return "ErrInvalidQueryParam"
+}
+
+func (err *ErrInvalidQueryParam) messageArgs() []any {
+	return []any{err.Name, err.Value}
+}
+
+func (err *ErrInvalidQueryParam) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type Pagination struct {
+	Page  int64 `json:"page"`
+	Limit int64 `json:"limit"`
+}
+
+func (pagination Pagination) Skip() int64 {
+	return (pagination.Page - 1) * pagination.Limit
+}
+
+type AccountPage struct {
+	Accounts []BankAccount `json:"accounts"`
+	Total    int64         `json:"total"`
+	Pagination
+}
+
+func parsePositiveQuery(ctx *gin.Context, name string, defaultValue int64) (int64, error) {
+	rawValue, ok := ctx.GetQuery(name)
+	if !ok {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseInt(rawValue, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, &ErrInvalidQueryParam{Name: name, Value: rawValue}
+	}
+	return value, nil
+}
+
+// parsePagination reads ?page= and ?limit=. A limit above maxLimit is
+// clamped rather than rejected; the applied limit is echoed back in the
+// page metadata.
+func parsePagination(ctx *gin.Context, maxLimit int64) (Pagination, error) {
+	page, err := parsePositiveQuery(ctx, "page", defaultPage)
+	if err != nil {
+		return Pagination{}, err
+	}
+	limit, err := parsePositiveQuery(ctx, "limit", defaultLimit)
+	if err != nil {
+		return Pagination{}, err
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return Pagination{Page: page, Limit: limit}, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Pagination
+		valid bool
+	}{
+		{query: "", want: Pagination{Page: defaultPage, Limit: defaultLimit}, valid: true},
+		{query: "page=3&limit=20", want: Pagination{Page: 3, Limit: 20}, valid: true},
+		{query: "limit=50", want: Pagination{Page: defaultPage, Limit: 50}, valid: true},
+		{query: "limit=51", want: Pagination{Page: defaultPage, Limit: 50}, valid: true},
+		{query: "limit=1000000", want: Pagination{Page: defaultPage, Limit: 50}, valid: true},
+		{query: "limit=0"},
+		{query: "page=-1"},
+		{query: "limit=ten"},
+	}
+	for _, test := range tests {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/?"+test.query, nil)
+		pagination, err := parsePagination(ctx, 50)
+		if test.valid && (err != nil || pagination != test.want) {
+			t.Errorf("%q: %+v, %v, want %+v", test.query, pagination, err, test.want)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%q: accepted as %+v", test.query, pagination)
+		}
+	}
+}
+
+func TestListAccountsClampsLimit(t *testing.T) {
+	for _, paged := range []bool{false, true} {
+		t.Run(fmt.Sprintf("paged %v", paged), func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.MaxPageSize = 3
+				config.PagedAccountList = paged
+			})
+			for i := 0; i < 5; i++ {
+				server.createAccount(fmt.Sprintf("user%d", i))
+			}
+
+			recorder := server.request(http.MethodGet, "/account/all?limit=1000", nil)
+			expectStatus(t, recorder, http.StatusOK)
+			if limit := recorder.Header().Get(pageLimitHeader); limit != "3" {
+				t.Fatalf("%s = %q, want 3", pageLimitHeader, limit)
+			}
+			if !paged {
+				if accounts := decodeResponse[[]BankAccount](t, recorder); len(accounts) != 3 {
+					t.Fatalf("listed %d accounts, want 3", len(accounts))
+				}
+				return
+			}
+			page := decodeResponse[AccountPage](t, recorder)
+			if page.Limit != 3 || len(page.Accounts) != 3 || page.Total != 5 {
+				t.Fatalf("page = %d accounts of %d with limit %d, want 3 of 5 with limit 3",
+					len(page.Accounts), page.Total, page.Limit)
+			}
+		})
+	}
+}
+
+func TestListAccountsModifiedSince(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.createAccount("carol")
+	since := server.clock.Now().Add(30 * time.Minute)
+	server.clock.Advance(time.Hour)
+	server.deposit("carol", 5)
+	server.clock.Advance(time.Hour)
+	server.deposit("bob", 5)
+
+	recorder := server.request(http.MethodGet, "/account/all?modifiedSince="+url.QueryEscape(since.Format(time.RFC3339)), nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var userNames []string
+	for _, account := range decodeResponse[[]BankAccount](t, recorder) {
+		userNames = append(userNames, account.UserName)
+	}
+	// Oldest change first, so a sync can resume from the last one it saw.
+	if strings.Join(userNames, ",") != "carol,bob" {
+		t.Fatalf("modified since %s: %v, want carol then bob", since, userNames)
+	}
+
+	recorder = server.request(http.MethodGet, "/account/all?modifiedSince=yesterday", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidQueryParam")
+}
+
+func TestListAccountsSortedByUsername(t *testing.T) {
+	server := newTestServer(t)
+	for _, userName := range []string{"carol", "alice", "erin", "bob", "dave"} {
+		server.createAccount(userName)
+	}
+	server.deposit("carol", 10)
+
+	for i := 0; i < 3; i++ {
+		recorder := server.request(http.MethodGet, "/account/all", nil)
+		expectStatus(t, recorder, http.StatusOK)
+		var userNames []string
+		for _, account := range decodeResponse[[]BankAccount](t, recorder) {
+			userNames = append(userNames, account.UserName)
+		}
+		if listed := strings.Join(userNames, ","); listed != "alice,bob,carol,dave,erin" {
+			t.Fatalf("listed %s, want username order", listed)
+		}
+	}
+
+	// The sort is served by the username index rather than sorted in memory.
+	var explanation bson.Raw
+	err := server.database.RunCommand(context.Background(), bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "find", Value: server.accounts.Collection().Name()},
+			{Key: "filter", Value: bson.D{}},
+			{Key: "sort", Value: bson.D{{Key: "username", Value: 1}}},
+		}},
+		{Key: "verbosity", Value: "queryPlanner"},
+	}).Decode(&explanation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	winningPlan := explanation.Lookup("queryPlanner", "winningPlan").String()
+	if !strings.Contains(winningPlan, `"IXSCAN"`) || strings.Contains(winningPlan, `"SORT"`) {
+		t.Fatalf("winning plan %s does not use the username index", winningPlan)
+	}
+}
+
+func TestListAccountsEmpty(t *testing.T) {
+	for _, test := range []struct {
+		paged    bool
+		want     string
+		pastEnd  string
+		wantPast string
+	}{
+		{paged: false, want: `[]`, pastEnd: "/account/all?modifiedSince=2030-01-01T00:00:00Z", wantPast: `[]`},
+		{
+			paged: true, want: `{"accounts":[],"total":0,"page":1,"limit":3}`,
+			pastEnd: "/account/all?page=2", wantPast: `{"accounts":[],"total":1,"page":2,"limit":3}`,
+		},
+	} {
+		t.Run(fmt.Sprintf("paged %v", test.paged), func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.MaxPageSize = 3
+				config.PagedAccountList = test.paged
+			})
+
+			recorder := server.request(http.MethodGet, "/account/all", nil)
+			expectStatus(t, recorder, http.StatusOK)
+			if body := recorder.Body.String(); body != test.want {
+				t.Fatalf("empty list = %s, want %s", body, test.want)
+			}
+
+			// A filter or page that matches nothing has the same shape.
+			server.createAccount("alice")
+			recorder = server.request(http.MethodGet, test.pastEnd, nil)
+			expectStatus(t, recorder, http.StatusOK)
+			if body := recorder.Body.String(); body != test.wantPast {
+				t.Fatalf("%s = %s, want %s", test.pastEnd, body, test.wantPast)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	pendingStatusPending   = "pending"
+	pendingStatusConfirmed = "confirmed"
+	pendingStatusCancelled = "cancelled"
+	pendingStatusExpired   = "expired"
+)
+
+// PendingTransfer is the first phase of a two-phase transfer. Its amount is
+// held on the source until it is confirmed, which performs the transfer,
+// or cancelled or expired, which releases the funds.
+type PendingTransfer struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	FromUser  string             `json:"fromuser"`
+	ToUser    string             `json:"touser"`
+	Amount    int                `json:"amount"`
+	Category  string             `json:"category,omitempty"`
+	Memo      string             `json:"memo,omitempty"`
+	Status    string             `json:"status"`
+	CreatedAt time.Time          `json:"createdAt"`
+	ExpiresAt time.Time          `json:"expiresAt"`
+	SettledAt *time.Time         `json:"settledAt,omitempty"`
+}
+
+func (pendingTransfer *PendingTransfer) TransferNote() TransferNote {
+	return TransferNote{
+		FromUser: pendingTransfer.FromUser,
+		ToUser:   pendingTransfer.ToUser,
+		Amount:   pendingTransfer.Amount,
+		Category: pendingTransfer.Category,
+		Memo:     pendingTransfer.Memo,
+	}
+}
+
+type PendingTransferResult struct {
+	Transfer PendingTransfer `json:"transfer"`
+	Accounts []BankAccount   `json:"accounts"`
+}
+
+type PendingTransferIDInput struct {
+	ID string `json:"id"`
+}
+
+type ErrPendingTransferNotFound struct {
+	ID string
+}
+
+func (err *ErrPendingTransferNotFound) Code() string {
+	return "ErrPendingTransferNotFound"
+}
+
+func (err *ErrPendingTransferNotFound) messageArgs() []any {
+	return []any{err.ID}
+}
+
+func (err *ErrPendingTransferNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrPendingTransferNotFound) Status() int {
+	return http.StatusNotFound
+}
+
+type ErrPendingTransferNotPending struct {
+	ID     string
+	Status string
+}
+
+func (err *ErrPendingTransferNotPending) Code() string {
+	return "ErrPendingTransferNotPending"
+}
+
+func (err *ErrPendingTransferNotPending) messageArgs() []any {
+	return []any{err.ID, err.Status}
+}
+
+func (err *ErrPendingTransferNotPending) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func findPendingTransfer(
+	ctx context.Context, pendingCollection *mongo.Collection, pendingID primitive.ObjectID,
+) (PendingTransfer, error) {
+	var pendingTransfer PendingTransfer
+	err := pendingCollection.FindOne(ctx, bson.D{{Key: "_id", Value: pendingID}}).Decode(&pendingTransfer)
+	if err == mongo.ErrNoDocuments {
+		return pendingTransfer, &ErrPendingTransferNotFound{ID: pendingID.Hex()}
+	}
+	return pendingTransfer, err
+}
+
+// bindPendingTransferID reads the id of the pending transfer a confirm or
+// cancel request refers to.
+func bindPendingTransferID(ctx *gin.Context) (primitive.ObjectID, bool) {
+	var idInput PendingTransferIDInput
+	if err := ctx.BindJSON(&idInput); err != nil {
+		sendError(ctx, &ErrInputRead{InputError: err})
+		return primitive.NilObjectID, false
+	}
+	pendingID, err := parseObjectID(idInput.ID)
+	if err != nil {
+		sendError(ctx, err)
+		return primitive.NilObjectID, false
+	}
+	return pendingID, true
+}
+
+// initiateTransferHandler holds the amount on the source and records a
+// pending transfer that must be confirmed within PendingTransferTTL.
+func initiateTransferHandler(
+	accountRepository *AccountRepository, transactionCollection, pendingCollection *mongo.Collection, config *Config,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		transferNote, ok := bindAndValidate[TransferNote](ctx)
+		if !ok {
+			return
+		}
+
+		accountCollection := accountRepository.Collection()
+		pendingResult, err := runInTransaction(ctx.Request.Context(), accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+			sourceAccount, targetAccount, err := findAccountPairInSession(
+				sessionCtx, accountCollection, transferNote.FromUser, transferNote.ToUser)
+			if err != nil {
+				return nil, err
+			}
+			if sourceAccount.UserName == targetAccount.UserName {
+				return nil, &ErrSameSourceAndTarget{}
+			}
+			if err := checkAccountOpen(sourceAccount); err != nil {
+				return nil, err
+			}
+			if err := checkUnverifiedLimit(config, sourceAccount, transferNote.Amount); err != nil {
+				return nil, err
+			}
+			if err := checkAccountOpen(targetAccount); err != nil {
+				return nil, err
+			}
+			if availableBalance(sourceAccount) < transferNote.Amount {
+				return nil, &ErrInsufficientFunds{
+					UserName: sourceAccount.UserName,
+					Balance:  availableBalance(sourceAccount),
+					Amount:   transferNote.Amount,
+				}
+			}
+
+			sourceAccount.Held += transferNote.Amount
+			if err := accountRepository.Replace(sessionCtx, &sourceAccount); err != nil {
+				return nil, err
+			}
+			holdTransaction := newTransaction(config.Clock, sourceAccount, transactionTypeHold, transferNote.Amount)
+			holdTransaction.Counterparty = targetAccount.UserName
+			if _, err := insertTransaction(sessionCtx, transactionCollection, holdTransaction); err != nil {
+				return nil, err
+			}
+
+			createdAt := config.Clock.Now().UTC()
+			pendingTransfer := PendingTransfer{
+				FromUser:  sourceAccount.UserName,
+				ToUser:    targetAccount.UserName,
+				Amount:    transferNote.Amount,
+				Category:  transferNote.Category,
+				Memo:      transferNote.Memo,
+				Status:    pendingStatusPending,
+				CreatedAt: createdAt,
+				ExpiresAt: createdAt.Add(config.PendingTransferTTL),
+			}
+			insertResult, err := pendingCollection.InsertOne(sessionCtx, pendingTransfer)
+			if err != nil {
+				return nil, err
+			}
+			pendingTransfer.ID = insertResult.InsertedID.(primitive.ObjectID)
+			return PendingTransferResult{Transfer: pendingTransfer, Accounts: []BankAccount{sourceAccount}}, nil
+		})
+		accountRepository.Invalidate(transferNote.FromUser)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		retainHistory(transactionCollection, config, transferNote.FromUser)
+
+		respond(ctx, http.StatusCreated, pendingResult)
+	}
+}
+
+// releasePendingTransfer moves a pending transfer to status, cancelled or
+// expired, and frees the funds held on its source in the same transaction.
+func releasePendingTransfer(
+	ctx context.Context, accountRepository *AccountRepository,
+	transactionCollection, pendingCollection *mongo.Collection, config *Config,
+	pendingID primitive.ObjectID, status string,
+) (PendingTransferResult, error) {
+	accountCollection := accountRepository.Collection()
+	pendingResult, err := runInTransaction(ctx, accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+		pendingTransfer, err := findPendingTransfer(sessionCtx, pendingCollection, pendingID)
+		if err != nil {
+			return nil, err
+		}
+		if pendingTransfer.Status != pendingStatusPending {
+			return nil, &ErrPendingTransferNotPending{ID: pendingID.Hex(), Status: pendingTransfer.Status}
+		}
+
+		settledAt := config.Clock.Now().UTC()
+		updateResult, err := pendingCollection.UpdateOne(sessionCtx, bson.D{
+			{Key: "_id", Value: pendingID},
+			{Key: "status", Value: pendingStatusPending},
+		}, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: status},
+			{Key: "settledat", Value: settledAt},
+		}}})
+		if err != nil {
+			return nil, err
+		}
+		if updateResult.ModifiedCount == 0 {
+			return nil, &ErrPendingTransferNotPending{ID: pendingID.Hex(), Status: pendingTransfer.Status}
+		}
+		pendingTransfer.Status, pendingTransfer.SettledAt = status, &settledAt
+
+		sourceAccount, err := findAccountInSession(sessionCtx, accountCollection, pendingTransfer.FromUser)
+		if err != nil {
+			return nil, err
+		}
+		sourceAccount.Held -= pendingTransfer.Amount
+		if err := accountRepository.Replace(sessionCtx, &sourceAccount); err != nil {
+			return nil, err
+		}
+		releaseTransaction := newTransaction(config.Clock, sourceAccount, transactionTypeHoldRelease, pendingTransfer.Amount)
+		releaseTransaction.Counterparty = pendingTransfer.ToUser
+		if _, err := insertTransaction(sessionCtx, transactionCollection, releaseTransaction); err != nil {
+			return nil, err
+		}
+		return PendingTransferResult{Transfer: pendingTransfer, Accounts: []BankAccount{sourceAccount}}, nil
+	})
+	if err != nil {
+		return PendingTransferResult{}, err
+	}
+	userName := pendingResult.(PendingTransferResult).Transfer.FromUser
+	accountRepository.Invalidate(userName)
+	retainHistory(transactionCollection, config, userName)
+	return pendingResult.(PendingTransferResult), nil
+}
+
+var errPendingTransferClaimed = errors.New("pending transfer is no longer pending")
+
+// confirmTransferHandler performs a pending transfer, releasing its hold
+// and moving the money in one transaction. A transfer past its expiry is
+// expired on the spot instead.
+func confirmTransferHandler(
+	accountRepository *AccountRepository, transactionCollection, pendingCollection *mongo.Collection, config *Config,
+	publisher EventPublisher,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		pendingID, ok := bindPendingTransferID(ctx)
+		if !ok {
+			return
+		}
+
+		pendingTransfer, err := findPendingTransfer(ctx.Request.Context(), pendingCollection, pendingID)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if pendingTransfer.Status != pendingStatusPending {
+			sendError(ctx, &ErrPendingTransferNotPending{ID: pendingID.Hex(), Status: pendingTransfer.Status})
+			return
+		}
+		if !config.Clock.Now().Before(pendingTransfer.ExpiresAt) {
+			_, err := releasePendingTransfer(ctx.Request.Context(), accountRepository, transactionCollection,
+				pendingCollection, config, pendingID, pendingStatusExpired)
+			if err == nil {
+				err = &ErrPendingTransferNotPending{ID: pendingID.Hex(), Status: pendingStatusExpired}
+			}
+			sendError(ctx, err)
+			return
+		}
+
+		settledAt := config.Clock.Now().UTC()
+		transferResult, err := executeTransfer(ctx.Request.Context(), accountRepository, transactionCollection,
+			config, publisher, pendingTransfer.TransferNote(), transferOptions{
+				strict:      isStrictRequest(ctx),
+				releaseHold: pendingTransfer.Amount,
+				inTransaction: func(sessionCtx mongo.SessionContext) error {
+					updateResult, err := pendingCollection.UpdateOne(sessionCtx, bson.D{
+						{Key: "_id", Value: pendingID},
+						{Key: "status", Value: pendingStatusPending},
+					}, bson.D{{Key: "$set", Value: bson.D{
+						{Key: "status", Value: pendingStatusConfirmed},
+						{Key: "settledat", Value: settledAt},
+					}}})
+					if err != nil {
+						return err
+					}
+					if updateResult.ModifiedCount == 0 {
+						return errPendingTransferClaimed
+					}
+					return nil
+				},
+			},
+		)
+		if errors.Is(err, errPendingTransferClaimed) {
+			if pendingTransfer, err = findPendingTransfer(ctx.Request.Context(), pendingCollection, pendingID); err == nil {
+				err = &ErrPendingTransferNotPending{ID: pendingID.Hex(), Status: pendingTransfer.Status}
+			}
+		}
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		pendingTransfer.Status, pendingTransfer.SettledAt = pendingStatusConfirmed, &settledAt
+
+		respond(ctx, http.StatusOK, PendingTransferResult{Transfer: pendingTransfer, Accounts: transferResult.Accounts})
+	}
+}
+
+func cancelTransferHandler(
+	accountRepository *AccountRepository, transactionCollection, pendingCollection *mongo.Collection, config *Config,
+) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		pendingID, ok := bindPendingTransferID(ctx)
+		if !ok {
+			return
+		}
+
+		pendingResult, err := releasePendingTransfer(ctx.Request.Context(), accountRepository, transactionCollection,
+			pendingCollection, config, pendingID, pendingStatusCancelled)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		respond(ctx, http.StatusOK, pendingResult)
+	}
+}
+
+// expirePendingTransfers releases every pending transfer whose TTL has run
+// out. Transfers confirmed or cancelled meanwhile are skipped.
+func expirePendingTransfers(
+	ctx context.Context, accountRepository *AccountRepository,
+	transactionCollection, pendingCollection *mongo.Collection, config *Config, now time.Time,
+) (int, error) {
+	expiredSearchResult, err := pendingCollection.Find(ctx, bson.D{
+		{Key: "status", Value: pendingStatusPending},
+		{Key: "expiresat", Value: bson.D{{Key: "$lte", Value: now}}},
+	}, options.Find().SetSort(bson.D{{Key: "expiresat", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	var expiredTransfers []PendingTransfer
+	if err := expiredSearchResult.All(ctx, &expiredTransfers); err != nil {
+		return 0, err
+	}
+
+	expiredCount := 0
+	for _, pendingTransfer := range expiredTransfers {
+		_, err := releasePendingTransfer(ctx, accountRepository, transactionCollection, pendingCollection, config,
+			pendingTransfer.ID, pendingStatusExpired)
+		var notPendingErr *ErrPendingTransferNotPending
+		if errors.As(err, &notPendingErr) {
+			continue
+		}
+		if err != nil {
+			return expiredCount, err
+		}
+		expiredCount++
+	}
+	return expiredCount, nil
+}
+
+// startPendingTransferExpiry expires overdue pending transfers at startup
+// and then on every scheduler tick.
+func startPendingTransferExpiry(
+	accountRepository *AccountRepository, transactionCollection, pendingCollection *mongo.Collection, config *Config,
+) {
+	expire := func() {
+		if _, err := expirePendingTransfers(context.TODO(), accountRepository,
+			transactionCollection, pendingCollection, config, config.Clock.Now()); err != nil {
+			log.Printf("pending transfer expiry failed: %v", err)
+		}
+	}
+
+	go func() {
+		expire()
+		ticker := time.NewTicker(config.SchedulerInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			expire()
+		}
+	}()
+}
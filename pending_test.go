@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (server *testServer) initiateTransfer(fromUser, toUser string, amount int) PendingTransfer {
+	server.t.Helper()
+	recorder := server.request(http.MethodPost, "/transfer/initiate",
+		TransferNote{FromUser: fromUser, ToUser: toUser, Amount: amount})
+	expectStatus(server.t, recorder, http.StatusCreated)
+	pendingTransfer := decodeResponse[PendingTransferResult](server.t, recorder).Transfer
+	if pendingTransfer.Status != pendingStatusPending || pendingTransfer.ID.IsZero() {
+		server.t.Fatalf("initiated transfer = %+v", pendingTransfer)
+	}
+	return pendingTransfer
+}
+
+func (server *testServer) pendingTransfer(pendingID primitive.ObjectID) PendingTransfer {
+	server.t.Helper()
+	pendingTransfer, err := findPendingTransfer(context.Background(), server.pending, pendingID)
+	if err != nil {
+		server.t.Fatal(err)
+	}
+	return pendingTransfer
+}
+
+func (server *testServer) expectBalances(userName string, balance, held int) {
+	server.t.Helper()
+	if account := server.account(userName); account.Balance != balance || account.Held != held || account.Debt != 0 {
+		server.t.Fatalf("%s = %+v, want balance %d and held %d", userName, account, balance, held)
+	}
+}
+
+func TestConfirmPendingTransfer(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	pendingTransfer := server.initiateTransfer("alice", "bob", 60)
+	if !pendingTransfer.ExpiresAt.Equal(server.clock.Now().Add(server.config.PendingTransferTTL)) {
+		t.Fatalf("expires at %s", pendingTransfer.ExpiresAt)
+	}
+	server.expectBalances("alice", 100, 60)
+	server.expectBalances("bob", 0, 0)
+	recorder := server.request(http.MethodPost, "/transfer/initiate",
+		TransferNote{FromUser: "alice", ToUser: "bob", Amount: 50})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInsufficientFunds")
+
+	recorder = server.request(http.MethodPost, "/transfer/confirm", PendingTransferIDInput{ID: pendingTransfer.ID.Hex()})
+	expectStatus(t, recorder, http.StatusOK)
+	if result := decodeResponse[PendingTransferResult](t, recorder); result.Transfer.Status != pendingStatusConfirmed ||
+		result.Transfer.SettledAt == nil || len(result.Accounts) != 2 {
+		t.Fatalf("confirmed = %+v", result)
+	}
+	server.expectBalances("alice", 40, 0)
+	server.expectBalances("bob", 60, 0)
+	if stored := server.pendingTransfer(pendingTransfer.ID); stored.Status != pendingStatusConfirmed {
+		t.Fatalf("stored status %q", stored.Status)
+	}
+
+	for _, target := range []string{"/transfer/confirm", "/transfer/cancel"} {
+		recorder := server.request(http.MethodPost, target, PendingTransferIDInput{ID: pendingTransfer.ID.Hex()})
+		expectErrorCode(t, recorder, http.StatusBadRequest, "ErrPendingTransferNotPending")
+	}
+	server.expectBalances("alice", 40, 0)
+}
+
+func TestCancelPendingTransfer(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	pendingTransfer := server.initiateTransfer("alice", "bob", 60)
+	recorder := server.request(http.MethodPost, "/transfer/cancel", PendingTransferIDInput{ID: pendingTransfer.ID.Hex()})
+	expectStatus(t, recorder, http.StatusOK)
+	if result := decodeResponse[PendingTransferResult](t, recorder); result.Transfer.Status != pendingStatusCancelled {
+		t.Fatalf("cancelled = %+v", result)
+	}
+	server.expectBalances("alice", 100, 0)
+	server.expectBalances("bob", 0, 0)
+
+	recorder = server.request(http.MethodPost, "/transfer/confirm", PendingTransferIDInput{ID: pendingTransfer.ID.Hex()})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrPendingTransferNotPending")
+	server.expectBalances("bob", 0, 0)
+
+	recorder = server.request(http.MethodPost, "/transfer/cancel", PendingTransferIDInput{ID: primitive.NewObjectID().Hex()})
+	expectErrorCode(t, recorder, http.StatusNotFound, "ErrPendingTransferNotFound")
+}
+
+func TestExpirePendingTransfers(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.PendingTransferTTL = time.Hour
+	})
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	first := server.initiateTransfer("alice", "bob", 30)
+	second := server.initiateTransfer("alice", "bob", 20)
+	server.clock.Advance(30 * time.Minute)
+	third := server.initiateTransfer("alice", "bob", 10)
+	server.clock.Advance(45 * time.Minute)
+
+	expiredCount, err := expirePendingTransfers(context.Background(), server.accounts, server.transactions,
+		server.pending, server.config, server.clock.Now())
+	if err != nil || expiredCount != 2 {
+		t.Fatalf("expired %d (%v), want 2", expiredCount, err)
+	}
+	for _, pendingTransfer := range []PendingTransfer{first, second} {
+		if stored := server.pendingTransfer(pendingTransfer.ID); stored.Status != pendingStatusExpired {
+			t.Fatalf("transfer of %d has status %q, want expired", stored.Amount, stored.Status)
+		}
+	}
+	server.expectBalances("alice", 100, 10)
+	recorder := server.request(http.MethodPost, "/transfer/confirm", PendingTransferIDInput{ID: first.ID.Hex()})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrPendingTransferNotPending")
+
+	// Confirming after the TTL expires the transfer on the spot, even
+	// before the expiry job has run.
+	server.clock.Advance(time.Hour)
+	recorder = server.request(http.MethodPost, "/transfer/confirm", PendingTransferIDInput{ID: third.ID.Hex()})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrPendingTransferNotPending")
+	if stored := server.pendingTransfer(third.ID); stored.Status != pendingStatusExpired {
+		t.Fatalf("late confirmation left status %q", stored.Status)
+	}
+	server.expectBalances("alice", 100, 0)
+	server.expectBalances("bob", 0, 0)
+
+	if expiredCount, err := expirePendingTransfers(context.Background(), server.accounts, server.transactions,
+		server.pending, server.config, server.clock.Now()); err != nil || expiredCount != 0 {
+		t.Fatalf("second run expired %d (%v), want 0", expiredCount, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const strictQueryHeader = "X-Strict-Query"
+
+// acceptedQueryParams declares the query parameters each route reads, keyed
+// by method and route pattern. Routes missing here take none.
+var acceptedQueryParams = map[string][]string{
+	"GET /account":                        {"fields"},
+	"GET /account/all":                    {"page", "limit", "stream", "modifiedSince", "fields"},
+	"GET /account/debtors":                {"page", "limit", "fields"},
+	"GET /account/created":                {"from", "to", "page", "limit", "fields"},
+	"GET /account/as-of":                  {"username", "at"},
+	"GET /account/average-daily-balance":  {"username", "month"},
+	"GET /account/interest/projection":    {"username", "rate", "days"},
+	"GET /account/interest/summary":       {"username", "from", "to"},
+	"GET /account/:username/transactions": {"page", "limit", "category"},
+	"POST /transactions/search":           {"page", "limit"},
+	"GET /transactions/export":            {"from", "to", "type", "username", "format"},
+	"POST /account/create":                {"upsert"},
+	"POST /account/create/batch":          {"upsert"},
+	"POST /deposit":                       {"maxBalance"},
+	"POST /withdraw":                      {"strict"},
+	"POST /transfer":                      {"strict", "transactionIds"},
+	"POST /transfer/batch":                {"strict"},
+	"POST /transfer/multi-source":         {"strict"},
+	"POST /transfer/confirm":              {"strict"},
+	"GET /admin/dashboard":                {"refresh"},
+	"GET /admin/transfer-graph":           {"username", "depth"},
+	"GET /admin/reconciliation":           {"page", "limit"},
+	"GET /admin/operations":               {"minDuration"},
+	"GET /admin/webhooks/:id/deliveries":  {"page", "limit"},
+}
+
+type ErrUnknownQueryParams struct {
+	Names []string
+}
+
+func (err *ErrUnknownQueryParams) Code() string {
+	return "ErrUnknownQueryParams"
+}
+
+func (err *ErrUnknownQueryParams) messageArgs() []any {
+	return []any{strings.Join(err.Names, ", ")}
+}
+
+func (err *ErrUnknownQueryParams) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+// unknownQueryParams lists, sorted, the query parameters of the request that
+// its route does not declare.
+func unknownQueryParams(ctx *gin.Context) []string {
+	accepted := acceptedQueryParams[ctx.Request.Method+" "+ctx.FullPath()]
+	var unknownNames []string
+	for name := range ctx.Request.URL.Query() {
+		known := false
+		for _, acceptedName := range accepted {
+			if name == acceptedName {
+				known = true
+				break
+			}
+		}
+		if !known {
+			unknownNames = append(unknownNames, name)
+		}
+	}
+	sort.Strings(unknownNames)
+	return unknownNames
+}
+
+// strictQueryMiddleware rejects requests carrying query parameters their
+// route does not read, which would otherwise be ignored silently, such as
+// a mistyped ?limt=. It applies when STRICT_QUERY_PARAMS is set or the
+// request sends X-Strict-Query: true, and the header can also turn it off.
+func strictQueryMiddleware(config *Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		strict := config.StrictQueryParams
+		if rawStrict := ctx.GetHeader(strictQueryHeader); rawStrict != "" {
+			var err error
+			if strict, err = strconv.ParseBool(rawStrict); err != nil {
+				sendError(ctx, &ErrInvalidHeader{Name: strictQueryHeader, Value: rawStrict})
+				ctx.Abort()
+				return
+			}
+		}
+		if strict && ctx.FullPath() != "" {
+			if unknownNames := unknownQueryParams(ctx); len(unknownNames) > 0 {
+				sendError(ctx, &ErrUnknownQueryParams{Names: unknownNames})
+				ctx.Abort()
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStrictQueryMiddleware(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		strict     bool
+		target     string
+		header     []string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "lenient ignores typo", target: "/account/all?limt=10", wantStatus: http.StatusOK},
+		{name: "lenient accepts declared", target: "/account/all?limit=10", wantStatus: http.StatusOK},
+		{
+			name: "strict rejects typo", strict: true, target: "/account/all?limt=10&pgae=2",
+			wantStatus: http.StatusBadRequest, wantCode: "ErrUnknownQueryParams",
+		},
+		{name: "strict accepts declared", strict: true, target: "/account/all?limit=10&page=2", wantStatus: http.StatusOK},
+		{
+			name: "strict rejects params on a route taking none", strict: true, target: "/system/extremes?limit=1",
+			wantStatus: http.StatusBadRequest, wantCode: "ErrUnknownQueryParams",
+		},
+		{
+			name: "header turns strict on", target: "/account/all?limt=10", header: []string{strictQueryHeader, "true"},
+			wantStatus: http.StatusBadRequest, wantCode: "ErrUnknownQueryParams",
+		},
+		{
+			name: "header turns strict off", strict: true, target: "/account/all?limt=10",
+			header: []string{strictQueryHeader, "false"}, wantStatus: http.StatusOK,
+		},
+		{
+			name: "invalid header", target: "/account/all", header: []string{strictQueryHeader, "sometimes"},
+			wantStatus: http.StatusBadRequest, wantCode: "ErrInvalidHeader",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(strictQueryMiddleware(&Config{StrictQueryParams: test.strict}))
+			ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+			router.GET("/account/all", ok)
+			router.GET("/system/extremes", ok)
+
+			recorder := serveRequest(t, router, http.MethodGet, test.target, nil, test.header...)
+			if test.wantCode == "" {
+				expectStatus(t, recorder, test.wantStatus)
+				return
+			}
+			expectErrorCode(t, recorder, test.wantStatus, test.wantCode)
+		})
+	}
+}
+
+func TestUnknownQueryParamsListed(t *testing.T) {
+	router := gin.New()
+	router.Use(strictQueryMiddleware(&Config{StrictQueryParams: true}))
+	router.GET("/account/all", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+
+	recorder := serveRequest(t, router, http.MethodGet, "/account/all?zeta=1&limt=10&page=1", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrUnknownQueryParams")
+	if message := decodeResponse[JsonMessage](t, recorder).Message; !strings.Contains(message, "limt, zeta") {
+		t.Fatalf("message = %q, want the unknown names sorted", message)
+	}
+}
+
+func TestStrictQueryParams(t *testing.T) {
+	server := newTestServer(t, func(config *Config) { config.StrictQueryParams = true })
+	server.createAccount("alice")
+
+	expectErrorCode(t, server.request(http.MethodGet, "/account/all?limt=10", nil),
+		http.StatusBadRequest, "ErrUnknownQueryParams")
+	expectStatus(t, server.request(http.MethodGet, "/account/all?limit=10", nil), http.StatusOK)
+	expectStatus(t, server.request(http.MethodGet, "/account/all?limt=10", nil, strictQueryHeader, "false"),
+		http.StatusOK)
+}
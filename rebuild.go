@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RebuildReport struct {
+	ReplayedTransactions int64 `json:"replayedTransactions"`
+	RebuiltAccounts      int64 `json:"rebuiltAccounts"`
+	ChangedAccounts      int64 `json:"changedAccounts"`
+	RecreatedAccounts    int64 `json:"recreatedAccounts"`
+}
+
+// rebuildAccounts recomputes every balance and debt by replaying the whole
+// transaction log user by user. Accounts missing from the collection are
+// recreated; fields other than balance and debt are left alone.
+func rebuildAccounts(
+	ctx context.Context, accountCollection, transactionCollection *mongo.Collection, clock Clock,
+) (RebuildReport, error) {
+	var report RebuildReport
+
+	historySearchResult, err := transactionCollection.Find(ctx, bson.D{},
+		options.Find().SetSort(bson.D{
+			{Key: "username", Value: 1}, {Key: "createdat", Value: 1}, {Key: "_id", Value: 1},
+		}))
+	if err != nil {
+		return report, err
+	}
+	defer historySearchResult.Close(ctx)
+
+	storeAccount := func(account BankAccount) error {
+		updateResult, err := accountCollection.UpdateOne(ctx, bson.D{
+			{Key: "username", Value: account.UserName},
+		}, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "balance", Value: account.Balance},
+			{Key: "debt", Value: account.Debt},
+			{Key: "held", Value: account.Held},
+			{Key: "updatedat", Value: clock.Now().UTC()},
+		}}, incrementVersion}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		report.RebuiltAccounts++
+		report.ChangedAccounts += updateResult.ModifiedCount
+		report.RecreatedAccounts += updateResult.UpsertedCount
+		return nil
+	}
+
+	var replayedAccount *BankAccount
+	for historySearchResult.Next(ctx) {
+		var transaction Transaction
+		if err := historySearchResult.Decode(&transaction); err != nil {
+			return report, err
+		}
+		if replayedAccount == nil || replayedAccount.UserName != transaction.UserName {
+			if replayedAccount != nil {
+				if err := storeAccount(*replayedAccount); err != nil {
+					return report, err
+				}
+			}
+			replayedAccount = &BankAccount{UserName: transaction.UserName}
+		}
+		applyTransaction(replayedAccount, transaction)
+		report.ReplayedTransactions++
+	}
+	if err := historySearchResult.Err(); err != nil {
+		return report, err
+	}
+	if replayedAccount != nil {
+		if err := storeAccount(*replayedAccount); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func rebuildAccountsHandler(accountRepository *AccountRepository, transactionCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		report, err := rebuildAccounts(ctx.Request.Context(), accountRepository.Collection(), transactionCollection,
+			accountRepository.Clock())
+		accountRepository.InvalidateAll()
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		respond(ctx, http.StatusOK, report)
+	}
+}
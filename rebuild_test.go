@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRebuildAccounts(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+	ctx := context.Background()
+	// alice's stored balance is wrong and bob's account is gone.
+	if _, err := server.accounts.Collection().InsertOne(ctx, BankAccount{UserName: "alice", Balance: 999}); err != nil {
+		t.Fatal(err)
+	}
+
+	transactionLog := []struct {
+		userName        string
+		transactionType string
+		amount          int
+	}{
+		{userName: "alice", transactionType: transactionTypeBonus},
+		{userName: "alice", transactionType: transactionTypeDeposit, amount: 100},
+		{userName: "alice", transactionType: transactionTypeWithdraw, amount: 130},
+		{userName: "alice", transactionType: transactionTypeDeposit, amount: 50},
+		{userName: "bob", transactionType: transactionTypeBonus},
+		{userName: "bob", transactionType: transactionTypeDeposit, amount: 40},
+		{userName: "bob", transactionType: transactionTypeTransferIn, amount: 10},
+	}
+	for _, entry := range transactionLog {
+		server.clock.Advance(time.Minute)
+		transaction := newTransaction(server.clock, BankAccount{UserName: entry.userName}, entry.transactionType, entry.amount)
+		if _, err := insertTransaction(ctx, server.transactions, transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := server.request(http.MethodPost, "/admin/rebuild-accounts", nil, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusOK)
+	report := decodeResponse[RebuildReport](t, recorder)
+	if report != (RebuildReport{ReplayedTransactions: 7, RebuiltAccounts: 2, ChangedAccounts: 1, RecreatedAccounts: 1}) {
+		t.Fatalf("report = %+v", report)
+	}
+	if alice := server.account("alice"); alice.Balance != 20 || alice.Debt != 0 {
+		t.Fatalf("rebuilt alice = %+v, want balance 20", alice)
+	}
+	if bob := server.account("bob"); bob.Balance != 50 || bob.Debt != 0 {
+		t.Fatalf("recreated bob = %+v, want balance 50", bob)
+	}
+
+	// Rebuilding an intact collection changes nothing.
+	report, err := rebuildAccounts(ctx, server.accounts.Collection(), server.transactions, server.clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.RecreatedAccounts != 0 || report.RebuiltAccounts != 2 {
+		t.Fatalf("second report = %+v", report)
+	}
+	if accountCount, err := server.accounts.Collection().CountDocuments(ctx, bson.D{}); err != nil || accountCount != 2 {
+		t.Fatalf("%d accounts (%v), want 2", accountCount, err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AccountDivergence compares an account's stored state with the state
+// replayed from its history. Deltas are stored minus replayed.
+type AccountDivergence struct {
+	UserName        string `json:"username"`
+	StoredBalance   int    `json:"storedBalance"`
+	ReplayedBalance int    `json:"replayedBalance"`
+	BalanceDelta    int    `json:"balanceDelta"`
+	StoredDebt      int    `json:"storedDebt"`
+	ReplayedDebt    int    `json:"replayedDebt"`
+	DebtDelta       int    `json:"debtDelta"`
+}
+
+type ReconciliationPage struct {
+	Divergences     []AccountDivergence `json:"divergences"`
+	CheckedAccounts int                 `json:"checkedAccounts"`
+	Total           int64               `json:"total"`
+	Pagination
+}
+
+// reconcileAccount reports whether the stored account differs from the
+// replayed one.
+func reconcileAccount(stored, replayed BankAccount) (AccountDivergence, bool) {
+	divergence := AccountDivergence{
+		UserName:        stored.UserName,
+		StoredBalance:   stored.Balance,
+		ReplayedBalance: replayed.Balance,
+		BalanceDelta:    stored.Balance - replayed.Balance,
+		StoredDebt:      stored.Debt,
+		ReplayedDebt:    replayed.Debt,
+		DebtDelta:       stored.Debt - replayed.Debt,
+	}
+	return divergence, divergence.BalanceDelta != 0 || divergence.DebtDelta != 0
+}
+
+// reconcileAllHandler replays the history of one page of accounts, ordered
+// by username, and lists those whose stored balance or debt diverges. The
+// page size is capped by MaxPageSize, which bounds the work per request;
+// clients walk the pages to cover every account.
+func reconcileAllHandler(accountCollection, transactionCollection *mongo.Collection, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		pagination, err := parsePagination(ctx, int64(config.MaxPageSize))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		total, err := accountCollection.CountDocuments(ctx.Request.Context(), bson.D{})
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		accountSearchResult, err := accountCollection.Find(ctx.Request.Context(), bson.D{},
+			options.Find().
+				SetSort(bson.D{{Key: "username", Value: 1}}).
+				SetSkip(pagination.Skip()).
+				SetLimit(pagination.Limit))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		var storedAccounts []BankAccount
+		if err := accountSearchResult.All(ctx.Request.Context(), &storedAccounts); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		userNames := make([]string, 0, len(storedAccounts))
+		replayedAccounts := make(map[string]*BankAccount, len(storedAccounts))
+		for _, storedAccount := range storedAccounts {
+			userNames = append(userNames, storedAccount.UserName)
+			replayedAccounts[storedAccount.UserName] = &BankAccount{UserName: storedAccount.UserName}
+		}
+
+		historySearchResult, err := transactionCollection.Find(ctx.Request.Context(), bson.D{
+			{Key: "username", Value: bson.D{{Key: "$in", Value: userNames}}},
+		}, options.Find().SetSort(bson.D{
+			{Key: "username", Value: 1}, {Key: "createdat", Value: 1}, {Key: "_id", Value: 1},
+		}))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		defer historySearchResult.Close(ctx.Request.Context())
+		for historySearchResult.Next(ctx.Request.Context()) {
+			var transaction Transaction
+			if err := historySearchResult.Decode(&transaction); err != nil {
+				sendError(ctx, err)
+				return
+			}
+			applyTransaction(replayedAccounts[transaction.UserName], transaction)
+		}
+		if err := historySearchResult.Err(); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		reconciliation := ReconciliationPage{
+			Divergences:     []AccountDivergence{},
+			CheckedAccounts: len(storedAccounts),
+			Total:           total,
+			Pagination:      pagination,
+		}
+		for _, storedAccount := range storedAccounts {
+			if divergence, diverges := reconcileAccount(storedAccount, *replayedAccounts[storedAccount.UserName]); diverges {
+				reconciliation.Divergences = append(reconciliation.Divergences, divergence)
+			}
+		}
+		respond(ctx, http.StatusOK, reconciliation)
+	}
+}
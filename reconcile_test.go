@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReconcileAccount(t *testing.T) {
+	if _, diverges := reconcileAccount(BankAccount{Balance: 40, Debt: 5}, BankAccount{Balance: 40, Debt: 5}); diverges {
+		t.Fatal("matching accounts reported as divergent")
+	}
+	divergence, diverges := reconcileAccount(
+		BankAccount{UserName: "alice", Balance: 65, Debt: 0},
+		BankAccount{UserName: "alice", Balance: 40, Debt: 5})
+	want := AccountDivergence{UserName: "alice", StoredBalance: 65, ReplayedBalance: 40, BalanceDelta: 25,
+		StoredDebt: 0, ReplayedDebt: 5, DebtDelta: -5}
+	if !diverges || divergence != want {
+		t.Fatalf("divergence = %+v, %v, want %+v", divergence, diverges, want)
+	}
+}
+
+func TestReconcileAll(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+		config.MaxPageSize = 3
+	})
+	for _, userName := range []string{"alice", "bob", "carol", "dave"} {
+		server.createAccount(userName)
+	}
+	server.deposit("alice", 100)
+	server.transfer("alice", "bob", 30)
+	server.withdraw("carol", 20)
+	server.deposit("dave", 50)
+
+	// Corrupt two accounts behind the history's back.
+	ctx := context.Background()
+	for userName, corruption := range map[string]bson.D{
+		"bob":  {{Key: "$inc", Value: bson.D{{Key: "balance", Value: 25}}}},
+		"dave": {{Key: "$set", Value: bson.D{{Key: "balance", Value: 0}, {Key: "debt", Value: 7}}}},
+	} {
+		if _, err := server.accounts.Collection().UpdateOne(ctx, bson.D{{Key: "username", Value: userName}}, corruption); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcile := func(target string) ReconciliationPage {
+		t.Helper()
+		recorder := server.request(http.MethodGet, target, nil, "Authorization", "Bearer secret")
+		expectStatus(t, recorder, http.StatusOK)
+		return decodeResponse[ReconciliationPage](t, recorder)
+	}
+	bob := AccountDivergence{UserName: "bob", StoredBalance: 55, ReplayedBalance: 30, BalanceDelta: 25}
+	dave := AccountDivergence{UserName: "dave", StoredBalance: 0, ReplayedBalance: 50, BalanceDelta: -50,
+		StoredDebt: 7, DebtDelta: 7}
+
+	tests := []struct {
+		target      string
+		checked     int
+		pagination  Pagination
+		divergences []AccountDivergence
+	}{
+		{target: "/admin/reconciliation?limit=10", checked: 3, pagination: Pagination{Page: 1, Limit: 3},
+			divergences: []AccountDivergence{bob}},
+		{target: "/admin/reconciliation?limit=3&page=2", checked: 1, pagination: Pagination{Page: 2, Limit: 3},
+			divergences: []AccountDivergence{dave}},
+		{target: "/admin/reconciliation?limit=2&page=2", checked: 2, pagination: Pagination{Page: 2, Limit: 2},
+			divergences: []AccountDivergence{dave}},
+		{target: "/admin/reconciliation?limit=2&page=3", checked: 0, pagination: Pagination{Page: 3, Limit: 2},
+			divergences: []AccountDivergence{}},
+	}
+	for _, test := range tests {
+		page := reconcile(test.target)
+		if page.CheckedAccounts != test.checked || page.Total != 4 || page.Pagination != test.pagination ||
+			!reflect.DeepEqual(page.Divergences, test.divergences) {
+			t.Errorf("%s: %+v, want %d checked on %+v with divergences %+v",
+				test.target, page, test.checked, test.pagination, test.divergences)
+		}
+	}
+
+	recorder := server.request(http.MethodGet, "/admin/reconciliation", nil)
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrUnauthorized")
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type cachedAccount struct {
+	account  BankAccount
+	storedAt time.Time
+}
+
+// accountCache is a size-bounded LRU of accounts keyed by username whose
+// entries also expire after ttl.
+type accountCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	clock   Clock
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newAccountCache(size int, ttl time.Duration, clock Clock) *accountCache {
+	return &accountCache{
+		size:    size,
+		ttl:     ttl,
+		clock:   clock,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (cache *accountCache) get(userName string) (BankAccount, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, ok := cache.entries[userName]
+	if !ok {
+		return BankAccount{}, false
+	}
+	entry := element.Value.(*cachedAccount)
+	if cache.clock.Now().Sub(entry.storedAt) > cache.ttl {
+		cache.order.Remove(element)
+		delete(cache.entries, userName)
+		return BankAccount{}, false
+	}
+	cache.order.MoveToFront(element)
+	return entry.account, true
+}
+
+func (cache *accountCache) put(account BankAccount) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry := &cachedAccount{account: account, storedAt: cache.clock.Now()}
+	if element, ok := cache.entries[account.UserName]; ok {
+		element.Value = entry
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.entries[account.UserName] = cache.order.PushFront(entry)
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cachedAccount).account.UserName)
+	}
+}
+
+func (cache *accountCache) remove(userName string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[userName]; ok {
+		cache.order.Remove(element)
+		delete(cache.entries, userName)
+	}
+}
+
+func (cache *accountCache) clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.order.Init()
+	cache.entries = make(map[string]*list.Element)
+}
+
+// AccountRepository reads and writes single accounts by username. Reads
+// through FindByUsername may be served from an in-process cache; every
+// write made through the repository invalidates the cached entry.
+type AccountRepository struct {
+	collection        *mongo.Collection
+	cache             *accountCache
+	slowThreshold     time.Duration
+	enforceInvariants bool
+	debtGracePeriod   time.Duration
+	clock             Clock
+}
+
+// newAccountRepository creates a repository whose cache is disabled when
+// AccountCacheSize is zero. Database calls slower than
+// SlowOperationThreshold are logged; zero disables the logging.
+func newAccountRepository(accountCollection *mongo.Collection, config *Config) *AccountRepository {
+	accountRepository := &AccountRepository{
+		collection:        accountCollection,
+		slowThreshold:     config.SlowOperationThreshold,
+		enforceInvariants: config.EnforceInvariants,
+		debtGracePeriod:   config.DebtGracePeriod,
+		clock:             config.Clock,
+	}
+	if config.AccountCacheSize > 0 {
+		accountRepository.cache = newAccountCache(config.AccountCacheSize, config.AccountCacheTTL, config.Clock)
+	}
+	return accountRepository
+}
+
+// observe starts a tracing span for the operation and returns its context
+// with a function to defer, which ends the span and logs the operation when
+// it ran longer than the slow threshold.
+func (accountRepository *AccountRepository) observe(
+	ctx context.Context, operation, userName string,
+) (context.Context, func()) {
+	startedAt := time.Now()
+	spanContext, span := startSpan(ctx, operation, userName)
+	return spanContext, func() {
+		span.End()
+		elapsed := time.Since(startedAt)
+		if accountRepository.slowThreshold > 0 && elapsed > accountRepository.slowThreshold {
+			log.Printf("slow account operation %s took %s (threshold %s)",
+				operation, elapsed, accountRepository.slowThreshold)
+		}
+	}
+}
+
+func (accountRepository *AccountRepository) checkInvariants(account BankAccount) error {
+	if !accountRepository.enforceInvariants {
+		return nil
+	}
+	return assertAccountInvariants(account)
+}
+
+func (accountRepository *AccountRepository) Collection() *mongo.Collection {
+	return accountRepository.collection
+}
+
+// Clock is the clock the repository stamps writes with, Config.Clock.
+func (accountRepository *AccountRepository) Clock() Clock {
+	return accountRepository.clock
+}
+
+func (accountRepository *AccountRepository) FindByUsername(ctx context.Context, userName string) (BankAccount, error) {
+	if accountRepository.cache != nil {
+		if account, ok := accountRepository.cache.get(userName); ok {
+			return account, nil
+		}
+	}
+
+	account, err := accountRepository.FindFreshByUsername(ctx, userName)
+	if err != nil {
+		return account, err
+	}
+	if accountRepository.cache != nil {
+		accountRepository.cache.put(account)
+	}
+	return account, nil
+}
+
+// FindFreshByUsername always reads from the database. Mutations use it so
+// they never build on a stale cached balance.
+func (accountRepository *AccountRepository) FindFreshByUsername(ctx context.Context, userName string) (BankAccount, error) {
+	ctx, done := accountRepository.observe(ctx, "FindFreshByUsername", userName)
+	defer done()
+	var account BankAccount
+	err := accountRepository.collection.FindOne(ctx, bson.D{{
+		Key: "username", Value: userName,
+	}}).Decode(&account)
+	return account, err
+}
+
+// stamp sets UpdatedAt before a write. Debt that appears on an account
+// without any starts its grace period; paying it off ends it.
+func (accountRepository *AccountRepository) stamp(account *BankAccount) {
+	now := accountRepository.clock.Now().UTC()
+	account.UpdatedAt = now
+	switch {
+	case account.Debt == 0:
+		account.DebtGraceUntil = time.Time{}
+	case account.DebtGraceUntil.IsZero() && accountRepository.debtGracePeriod > 0:
+		account.DebtGraceUntil = now.Add(accountRepository.debtGracePeriod)
+	}
+}
+
+// versionFilter matches accounts still at version. Accounts written before
+// versions were recorded have no version field and count as version zero.
+func versionFilter(version int64) bson.E {
+	if version == 0 {
+		return bson.E{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}}
+	}
+	return bson.E{Key: "version", Value: version}
+}
+
+// incrementVersion is the update operator every targeted account write
+// carries so that a whole-document write based on an older read is refused.
+var incrementVersion = bson.E{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}
+
+// Replace writes the whole account, stamping its UpdatedAt first. The write
+// is refused with ErrConcurrentModification if the account changed since it
+// was read.
+func (accountRepository *AccountRepository) Replace(ctx context.Context, account *BankAccount) error {
+	replaced, err := accountRepository.ReplaceIfUnchanged(ctx, *account, account)
+	if err != nil {
+		return err
+	}
+	if !replaced {
+		return &ErrConcurrentModification{UserName: account.UserName}
+	}
+	return nil
+}
+
+// ReplaceIfUnchanged writes the account only if it is still at the version
+// of previous, reporting whether the write happened.
+func (accountRepository *AccountRepository) ReplaceIfUnchanged(
+	ctx context.Context, previous BankAccount, account *BankAccount,
+) (bool, error) {
+	if err := accountRepository.checkInvariants(*account); err != nil {
+		return false, err
+	}
+	ctx, done := accountRepository.observe(ctx, "ReplaceIfUnchanged", account.UserName)
+	defer done()
+	defer accountRepository.Invalidate(account.UserName)
+	accountRepository.stamp(account)
+	account.Version = previous.Version + 1
+	updateResult, err := accountRepository.collection.ReplaceOne(ctx, bson.D{
+		{Key: "username", Value: previous.UserName},
+		versionFilter(previous.Version),
+	}, account)
+	if err != nil {
+		account.Version = previous.Version
+		return false, err
+	}
+	if updateResult.MatchedCount == 0 {
+		account.Version = previous.Version
+		return false, nil
+	}
+	return true, nil
+}
+
+// DebitIfCovered subtracts amount from the balance only while the available
+// (unheld) balance still covers it, or the account may go negative,
+// returning the updated account.
+// mongo.ErrNoDocuments means the balance was too low at write time.
+func (accountRepository *AccountRepository) DebitIfCovered(
+	ctx context.Context, userName string, amount int,
+) (BankAccount, error) {
+	ctx, done := accountRepository.observe(ctx, "DebitIfCovered", userName)
+	defer done()
+	defer accountRepository.Invalidate(userName)
+	var account BankAccount
+	err := accountRepository.collection.FindOneAndUpdate(ctx, bson.D{
+		{Key: "username", Value: userName},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "allownegative", Value: true}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$gte", Value: bson.A{
+				bson.D{{Key: "$subtract", Value: bson.A{"$balance", bson.D{{Key: "$ifNull", Value: bson.A{"$held", 0}}}}}},
+				amount,
+			}}}}},
+		}},
+	}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "balance", Value: -amount}, {Key: "version", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "updatedat", Value: accountRepository.clock.Now().UTC()}}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&account)
+	return account, err
+}
+
+// InsertIfAbsent creates the account unless one with the same username
+// exists, in which case the stored account is returned untouched.
+func (accountRepository *AccountRepository) InsertIfAbsent(
+	ctx context.Context, account BankAccount,
+) (BankAccount, bool, error) {
+	ctx, done := accountRepository.observe(ctx, "InsertIfAbsent", account.UserName)
+	defer done()
+	var existingAccount BankAccount
+	err := accountRepository.collection.FindOneAndUpdate(ctx, bson.D{
+		{Key: "username", Value: account.UserName},
+	}, bson.D{
+		{Key: "$setOnInsert", Value: account},
+	}, options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.Before),
+	).Decode(&existingAccount)
+	if err == mongo.ErrNoDocuments {
+		return account, true, nil
+	}
+	if err != nil {
+		return account, false, err
+	}
+	return existingAccount, false, nil
+}
+
+func (accountRepository *AccountRepository) SetBalanceAlertState(
+	ctx context.Context, userName, state string,
+) error {
+	ctx, done := accountRepository.observe(ctx, "SetBalanceAlertState", userName)
+	defer done()
+	defer accountRepository.Invalidate(userName)
+	_, err := accountRepository.collection.UpdateOne(ctx, bson.D{
+		{Key: "username", Value: userName},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "balancealertstate", Value: state},
+			{Key: "updatedat", Value: accountRepository.clock.Now().UTC()},
+		}},
+		incrementVersion,
+	})
+	return err
+}
+
+// SetLock marks the account locked by operator for reason and returns it.
+func (accountRepository *AccountRepository) SetLock(
+	ctx context.Context, userName, reason, operator string,
+) (BankAccount, error) {
+	return accountRepository.updateLock(ctx, userName, bson.D{
+		{Key: "locked", Value: true},
+		{Key: "lockreason", Value: reason},
+		{Key: "lockedby", Value: operator},
+	})
+}
+
+func (accountRepository *AccountRepository) ClearLock(ctx context.Context, userName string) (BankAccount, error) {
+	return accountRepository.updateLock(ctx, userName, bson.D{
+		{Key: "locked", Value: false},
+		{Key: "lockreason", Value: ""},
+		{Key: "lockedby", Value: ""},
+	})
+}
+
+func (accountRepository *AccountRepository) updateLock(
+	ctx context.Context, userName string, lockFields bson.D,
+) (BankAccount, error) {
+	ctx, done := accountRepository.observe(ctx, "updateLock", userName)
+	defer done()
+	defer accountRepository.Invalidate(userName)
+	var account BankAccount
+	err := accountRepository.collection.FindOneAndUpdate(ctx, bson.D{
+		{Key: "username", Value: userName},
+	}, bson.D{
+		{Key: "$set", Value: append(lockFields, bson.E{Key: "updatedat", Value: accountRepository.clock.Now().UTC()})},
+		incrementVersion,
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&account)
+	return account, err
+}
+
+func (accountRepository *AccountRepository) Invalidate(userNames ...string) {
+	if accountRepository.cache == nil {
+		return
+	}
+	for _, userName := range userNames {
+		accountRepository.cache.remove(userName)
+	}
+}
+
+// InvalidateAll drops every cached account, for writes that touch accounts
+// in bulk.
+func (accountRepository *AccountRepository) InvalidateAll() {
+	if accountRepository.cache == nil {
+		return
+	}
+	accountRepository.cache.clear()
+}
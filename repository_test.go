@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReplaceRefusesStaleAccount(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.deposit("alice", 100)
+	ctx := context.Background()
+
+	stale := server.account("alice")
+	if _, err := server.accounts.SetLock(ctx, "alice", "fraud review", "ops"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing the account read before the lock must not unlock it again.
+	stale.Balance += 10
+	var conflict *ErrConcurrentModification
+	if err := server.accounts.Replace(ctx, &stale); !errors.As(err, &conflict) {
+		t.Fatalf("Replace of a stale account = %v, want ErrConcurrentModification", err)
+	}
+	if account := server.account("alice"); !account.Locked || account.Balance != 100 {
+		t.Fatalf("after refused write: %+v", account)
+	}
+
+	fresh := server.account("alice")
+	fresh.Balance += 10
+	if err := server.accounts.Replace(ctx, &fresh); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.accounts.Replace(ctx, &fresh); err != nil {
+		t.Fatalf("second Replace of the same account: %v", err)
+	}
+	if account := server.account("alice"); !account.Locked || account.Balance != 110 || account.Version != fresh.Version {
+		t.Fatalf("after fresh write: %+v, want version %d", account, fresh.Version)
+	}
+}
+
+func TestReplaceAccountWithoutVersion(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	if _, err := server.accounts.Collection().InsertOne(ctx, bson.D{
+		{Key: "username", Value: "legacy"},
+		{Key: "balance", Value: 5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	account := server.account("legacy")
+	account.Balance = 7
+	if err := server.accounts.Replace(ctx, &account); err != nil {
+		t.Fatal(err)
+	}
+	if stored := server.account("legacy"); stored.Balance != 7 || stored.Version != 1 {
+		t.Fatalf("stored account = %+v", stored)
+	}
+}
+
+func TestAccountCache(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	cache := newAccountCache(2, time.Minute, clock)
+
+	cache.put(BankAccount{UserName: "alice", Balance: 1})
+	cache.put(BankAccount{UserName: "bob", Balance: 2})
+	if account, ok := cache.get("alice"); !ok || account.Balance != 1 {
+		t.Fatalf("get(alice) = %+v, %v", account, ok)
+	}
+
+	// bob is now the least recently used entry, so carol evicts him.
+	cache.put(BankAccount{UserName: "carol", Balance: 3})
+	if _, ok := cache.get("bob"); ok {
+		t.Fatal("bob survived eviction")
+	}
+
+	cache.remove("alice")
+	if _, ok := cache.get("alice"); ok {
+		t.Fatal("alice survived remove")
+	}
+
+	clock.Advance(time.Minute + time.Second)
+	if _, ok := cache.get("carol"); ok {
+		t.Fatal("carol survived the TTL")
+	}
+}
+
+func TestCachedAccountReads(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.AccountCacheSize = 10
+		config.AccountCacheTTL = time.Minute
+	})
+	server.createAccount("alice")
+	server.deposit("alice", 10)
+	ctx := context.Background()
+
+	if account, err := server.accounts.FindByUsername(ctx, "alice"); err != nil || account.Balance != 10 {
+		t.Fatalf("first read = %+v, %v", account, err)
+	}
+	// A write behind the repository's back is not seen while cached.
+	if _, err := server.accounts.Collection().UpdateOne(ctx, bson.D{{Key: "username", Value: "alice"}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "balance", Value: 5}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if account, err := server.accounts.FindByUsername(ctx, "alice"); err != nil || account.Balance != 10 {
+		t.Fatalf("cached read = %+v, %v, want the balance of 10", account, err)
+	}
+
+	// A deposit invalidates the entry, so the next read sees it at once.
+	server.deposit("alice", 1)
+	if account, err := server.accounts.FindByUsername(ctx, "alice"); err != nil || account.Balance != 16 {
+		t.Fatalf("read after deposit = %+v, %v, want the balance of 16", account, err)
+	}
+
+	if _, err := server.accounts.Collection().UpdateOne(ctx, bson.D{{Key: "username", Value: "alice"}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "balance", Value: 4}}}}); err != nil {
+		t.Fatal(err)
+	}
+	server.clock.Advance(time.Minute + time.Second)
+	if account, err := server.accounts.FindByUsername(ctx, "alice"); err != nil || account.Balance != 20 {
+		t.Fatalf("read after the TTL = %+v, %v, want the balance of 20", account, err)
+	}
+}
+
+func TestSlowOperationLog(t *testing.T) {
+	var logBuffer bytes.Buffer
+	previousOutput := log.Writer()
+	log.SetOutput(&logBuffer)
+	t.Cleanup(func() {
+		log.SetOutput(previousOutput)
+	})
+
+	accountRepository := &AccountRepository{slowThreshold: 10 * time.Millisecond}
+	_, end := accountRepository.observe(context.Background(), "FindByUsername", "alice")
+	end()
+	if logBuffer.Len() != 0 {
+		t.Fatalf("fast operation logged %q", logBuffer.String())
+	}
+
+	_, end = accountRepository.observe(context.Background(), "Replace", "alice")
+	time.Sleep(20 * time.Millisecond)
+	end()
+	if logged := logBuffer.String(); !strings.Contains(logged, "slow account operation Replace") ||
+		!strings.Contains(logged, "threshold 10ms") {
+		t.Fatalf("slow operation logged %q", logged)
+	}
+
+	logBuffer.Reset()
+	accountRepository.slowThreshold = 0
+	_, end = accountRepository.observe(context.Background(), "Replace", "alice")
+	time.Sleep(20 * time.Millisecond)
+	end()
+	if logBuffer.Len() != 0 {
+		t.Fatalf("logged %q with the threshold disabled", logBuffer.String())
+	}
+}
+
+func TestAssertAccountInvariants(t *testing.T) {
+	previousOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		log.SetOutput(previousOutput)
+	})
+
+	tests := []struct {
+		name    string
+		account BankAccount
+		valid   bool
+	}{
+		{name: "empty", account: BankAccount{}, valid: true},
+		{name: "balance and debt", account: BankAccount{Balance: 50, Debt: 20, Held: 50}, valid: true},
+		{name: "negative balance", account: BankAccount{Balance: -1}},
+		{name: "wrapped balance", account: BankAccount{Balance: math.MinInt}},
+		{name: "negative debt", account: BankAccount{Debt: -5}},
+		{name: "negative hold", account: BankAccount{Balance: 10, Held: -1}},
+		{name: "hold above balance", account: BankAccount{Balance: 10, Held: 11}},
+		{name: "allowed negative balance", account: BankAccount{AllowNegative: true, Balance: -30}, valid: true},
+		{name: "allowed negative with negative debt", account: BankAccount{AllowNegative: true, Balance: -30, Debt: -1}},
+	}
+	for _, test := range tests {
+		err := assertAccountInvariants(test.account)
+		var violation *ErrInvariantViolation
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case !test.valid && !errors.As(err, &violation):
+			t.Errorf("%s: error = %v, want ErrInvariantViolation", test.name, err)
+		}
+	}
+}
+
+// A deposit that wraps the balance past math.MaxInt must not reach the
+// database unless enforcement is switched off.
+func TestInvariantViolationRefusesWrite(t *testing.T) {
+	var logBuffer bytes.Buffer
+	previousOutput := log.Writer()
+	log.SetOutput(&logBuffer)
+	t.Cleanup(func() {
+		log.SetOutput(previousOutput)
+	})
+
+	for _, enforce := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enforce=%v", enforce), func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.EnforceInvariants = enforce
+				config.RejectUnsafeAmounts = false
+			})
+			server.createAccount("alice")
+			server.deposit("alice", 1)
+			historyLength := len(server.history("alice"))
+			logBuffer.Reset()
+
+			recorder := server.request(http.MethodPost, "/deposit",
+				TransactionInput{UserName: "alice", Amount: math.MaxInt})
+			if !enforce {
+				expectStatus(t, recorder, http.StatusOK)
+				if balance := server.account("alice").Balance; balance >= 0 {
+					t.Fatalf("balance = %d, want the wrapped value to be stored", balance)
+				}
+				return
+			}
+			expectErrorCode(t, recorder, http.StatusInternalServerError, "ErrInvariantViolation")
+			if balance := server.account("alice").Balance; balance != 1 {
+				t.Fatalf("balance = %d, want the refused write to leave 1", balance)
+			}
+			if length := len(server.history("alice")); length != historyLength {
+				t.Fatalf("history length = %d, want %d", length, historyLength)
+			}
+			if logged := logBuffer.String(); !strings.Contains(logged, "refusing to store account alice") {
+				t.Fatalf("log = %q", logged)
+			}
+		})
+	}
+}
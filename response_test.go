@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func envelopeRouter(clock Clock) *gin.Engine {
+	router := gin.New()
+	router.Use(clockMiddleware(&Config{Clock: clock}))
+	router.GET("/ok", func(ctx *gin.Context) {
+		respond(ctx, http.StatusOK, BankAccount{UserName: "alice", Balance: 5})
+	})
+	router.GET("/fail", func(ctx *gin.Context) {
+		sendError(ctx, &ErrUserNotFound{UserName: "ghost"})
+	})
+	return router
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	router := envelopeRouter(clock)
+	version := strconv.Itoa(envelopeAPIVersion)
+
+	recorder := serveRequest(t, router, http.MethodGet, "/ok", nil, apiVersionHeader, version)
+	expectStatus(t, recorder, http.StatusOK)
+	success := decodeResponse[ResponseEnvelope](t, recorder)
+	if !success.Success || success.Error != nil || !success.Timestamp.Equal(clock.Now()) {
+		t.Fatalf("success envelope = %+v", success)
+	}
+	if account, _ := success.Data.(map[string]any); account["username"] != "alice" {
+		t.Fatalf("envelope data = %v", success.Data)
+	}
+
+	recorder = serveRequest(t, router, http.MethodGet, "/fail", nil, apiVersionHeader, version)
+	expectStatus(t, recorder, http.StatusBadRequest)
+	failure := decodeResponse[ResponseEnvelope](t, recorder)
+	if failure.Success || failure.Error == nil || *failure.Error == "" || failure.Code != "ErrNoDocuments" ||
+		failure.Data != nil || !failure.Timestamp.Equal(clock.Now()) {
+		t.Fatalf("error envelope = %+v", failure)
+	}
+}
+
+func TestResponseWithoutEnvelope(t *testing.T) {
+	router := envelopeRouter(systemClock{})
+
+	for _, version := range []string{"", "1", "two"} {
+		recorder := serveRequest(t, router, http.MethodGet, "/ok", nil, apiVersionHeader, version)
+		var body map[string]any
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if _, wrapped := body["success"]; wrapped || body["username"] != "alice" {
+			t.Fatalf("version %q: body = %v, want the bare account", version, body)
+		}
+	}
+	recorder := serveRequest(t, router, http.MethodGet, "/fail", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrNoDocuments")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pruneHistory folds all but the keep most recent transactions of userName,
+// ordered like the history endpoints by createdat then _id, into a single
+// opening-balance entry that takes the place of the newest pruned one. It
+// returns how many transactions were folded.
+func pruneHistory(ctx context.Context, transactionCollection *mongo.Collection, userName string, keep int) (int64, error) {
+	var oldestKept Transaction
+	err := transactionCollection.FindOne(ctx, bson.D{
+		{Key: "username", Value: userName},
+		{Key: "type", Value: bson.D{{Key: "$ne", Value: transactionTypeOpeningBalance}}},
+	},
+		options.FindOne().
+			SetSort(bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64(keep-1)).
+			SetProjection(bson.D{{Key: "createdat", Value: 1}}),
+	).Decode(&oldestKept)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	prunedFilter := bson.D{
+		{Key: "username", Value: userName},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "createdat", Value: bson.D{{Key: "$lt", Value: oldestKept.CreatedAt}}}},
+			bson.D{
+				{Key: "createdat", Value: oldestKept.CreatedAt},
+				{Key: "_id", Value: bson.D{{Key: "$lt", Value: oldestKept.ID}}},
+			},
+		}},
+	}
+	foldedCount, err := runInTransaction(ctx, transactionCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+		prunedSearchResult, err := transactionCollection.Find(sessionCtx, prunedFilter,
+			options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			return int64(0), err
+		}
+		var prunedTransactions []Transaction
+		if err := prunedSearchResult.All(sessionCtx, &prunedTransactions); err != nil {
+			return int64(0), err
+		}
+		openingTransaction, foldedCount := foldHistory(userName, prunedTransactions)
+		if foldedCount == 0 {
+			return int64(0), nil
+		}
+
+		if _, err := transactionCollection.DeleteMany(sessionCtx, prunedFilter); err != nil {
+			return int64(0), err
+		}
+		if _, err := transactionCollection.InsertOne(sessionCtx, openingTransaction); err != nil {
+			return int64(0), err
+		}
+		return foldedCount, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return foldedCount.(int64), nil
+}
+
+// foldHistory replays transactions, oldest first, into the opening-balance
+// entry that replaces them, reusing the ID and time of the newest so it
+// sorts where they did. An earlier opening-balance entry is folded in but
+// not counted.
+func foldHistory(userName string, transactions []Transaction) (Transaction, int64) {
+	replayedAccount := BankAccount{UserName: userName}
+	var foldedCount int64
+	for _, transaction := range transactions {
+		applyTransaction(&replayedAccount, transaction)
+		if transaction.Type != transactionTypeOpeningBalance {
+			foldedCount++
+		}
+	}
+	if foldedCount == 0 {
+		return Transaction{}, 0
+	}
+
+	newest := transactions[len(transactions)-1]
+	return Transaction{
+		ID:                  newest.ID,
+		UserName:            userName,
+		Type:                transactionTypeOpeningBalance,
+		Balance:             replayedAccount.Balance,
+		Debt:                replayedAccount.Debt,
+		Held:                replayedAccount.Held,
+		CreatedAt:           newest.CreatedAt,
+		DebtRepaymentPolicy: newest.DebtRepaymentPolicy,
+		AllowNegative:       newest.AllowNegative,
+	}, foldedCount
+}
+
+// retainHistory applies the HistoryRetention limit to each user after new
+// transactions were recorded for them. Like recordTransaction it runs after
+// the account write, so failures are only logged.
+func retainHistory(transactionCollection *mongo.Collection, config *Config, userNames ...string) {
+	if config.HistoryRetention == 0 {
+		return
+	}
+	for _, userName := range userNames {
+		if _, err := pruneHistory(context.TODO(), transactionCollection, userName, config.HistoryRetention); err != nil {
+			log.Printf("failed to prune transaction history for user %s: %v", userName, err)
+		}
+	}
+}
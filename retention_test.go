@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFoldHistory(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	transactions := []Transaction{
+		{Type: transactionTypeOpeningBalance, Balance: 50, Debt: 0, Held: 10, CreatedAt: createdAt},
+		{Type: transactionTypeWithdraw, Amount: 70, CreatedAt: createdAt.Add(time.Hour)},
+		{Type: transactionTypeHoldRelease, Amount: 10, CreatedAt: createdAt.Add(2 * time.Hour)},
+		{Type: transactionTypeDeposit, Amount: 5, CreatedAt: createdAt.Add(3 * time.Hour)},
+	}
+
+	opening, foldedCount := foldHistory("alice", transactions)
+	if foldedCount != 3 {
+		t.Fatalf("folded %d transactions, want 3", foldedCount)
+	}
+	// Only the 40 not on hold covers the withdrawal, leaving 30 of debt, of
+	// which the deposit repays 5.
+	if opening.Type != transactionTypeOpeningBalance || opening.Balance != 10 || opening.Debt != 25 || opening.Held != 0 {
+		t.Fatalf("opening entry = %+v", opening)
+	}
+	if !opening.CreatedAt.Equal(transactions[3].CreatedAt) {
+		t.Fatalf("opening entry at %s, want the newest folded time", opening.CreatedAt)
+	}
+
+	replayed := BankAccount{UserName: "alice"}
+	applyTransaction(&replayed, opening)
+	if replayed.Balance != 10 || replayed.Debt != 25 {
+		t.Fatalf("replayed opening entry = %+v", replayed)
+	}
+
+	if _, foldedCount := foldHistory("alice", transactions[:1]); foldedCount != 0 {
+		t.Fatalf("refolding a lone opening entry folded %d", foldedCount)
+	}
+}
+
+func TestHistoryRetentionKeepsReplayable(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.HistoryRetention = 2
+	})
+	server.createAccount("alice")
+	openedAt := server.clock.Now()
+	for _, amount := range []int{100, 20, 30} {
+		server.clock.Advance(time.Minute)
+		server.deposit("alice", amount)
+	}
+	server.clock.Advance(time.Minute)
+	server.withdraw("alice", 200)
+
+	history := server.history("alice")
+	if len(history) != 3 || history[0].Type != transactionTypeOpeningBalance {
+		t.Fatalf("history = %+v, want an opening balance and 2 entries", history)
+	}
+	stored := server.account("alice")
+	if stored.Balance != 0 || stored.Debt != 50 {
+		t.Fatalf("stored account = %+v", stored)
+	}
+
+	if _, err := rebuildAccounts(context.Background(), server.accounts.Collection(), server.transactions,
+		server.clock); err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt := server.account("alice"); rebuilt.Balance != stored.Balance || rebuilt.Debt != stored.Debt {
+		t.Fatalf("after rebuild: %+v, want %+v", rebuilt, stored)
+	}
+
+	recorder := server.request(http.MethodGet, "/account/as-of?username=alice&at="+openedAt.Format(time.RFC3339), nil)
+	expectErrorCode(t, recorder, http.StatusGone, "ErrHistoryPruned")
+}
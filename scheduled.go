@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	scheduledStatusPending   = "pending"
+	scheduledStatusCompleted = "completed"
+	scheduledStatusFailed    = "failed"
+	scheduledStatusCancelled = "cancelled"
+)
+
+type ScheduledTransfer struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	FromUser   string             `json:"fromuser"`
+	ToUser     string             `json:"touser"`
+	Amount     int                `json:"amount"`
+	Category   string             `json:"category,omitempty"`
+	Memo       string             `json:"memo,omitempty"`
+	ExecuteAt  time.Time          `json:"executeAt"`
+	Status     string             `json:"status"`
+	Failure    string             `json:"failure,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	ExecutedAt *time.Time         `json:"executedAt,omitempty"`
+}
+
+func (scheduledTransfer *ScheduledTransfer) TransferNote() TransferNote {
+	return TransferNote{
+		FromUser: scheduledTransfer.FromUser,
+		ToUser:   scheduledTransfer.ToUser,
+		Amount:   scheduledTransfer.Amount,
+		Category: scheduledTransfer.Category,
+		Memo:     scheduledTransfer.Memo,
+	}
+}
+
+type ErrExecuteAtNotInFuture struct {
+	ExecuteAt time.Time
+}
+
+func (err *ErrExecuteAtNotInFuture) Code() string {
+	return "ErrExecuteAtNotInFuture"
+}
+
+func (err *ErrExecuteAtNotInFuture) messageArgs() []any {
+	return []any{err.ExecuteAt.Format(time.RFC3339)}
+}
+
+func (err *ErrExecuteAtNotInFuture) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrScheduledTransferNotFound struct {
+	ID string
+}
+
+func (err *ErrScheduledTransferNotFound) Code() string {
+	return "ErrScheduledTransferNotFound"
+}
+
+func (err *ErrScheduledTransferNotFound) messageArgs() []any {
+	return []any{err.ID}
+}
+
+func (err *ErrScheduledTransferNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrScheduledTransferNotFound) Status() int {
+	return http.StatusNotFound
+}
+
+type ErrScheduledTransferNotPending struct {
+	ID     string
+	Status string
+}
+
+func (err *ErrScheduledTransferNotPending) Code() string {
+	return "ErrScheduledTransferNotPending"
+}
+
+func (err *ErrScheduledTransferNotPending) messageArgs() []any {
+	return []any{err.ID, err.Status}
+}
+
+func (err *ErrScheduledTransferNotPending) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ScheduleTransferInput struct {
+	TransferNote
+	ExecuteAt time.Time `json:"executeAt"`
+}
+
+func (input *ScheduleTransferInput) Error() error {
+	var validationErrors MultiError
+	if err := input.TransferNote.Error(); err != nil {
+		var multiError *MultiError
+		if errors.As(err, &multiError) {
+			validationErrors.Errors = append(validationErrors.Errors, multiError.Errors...)
+		} else {
+			validationErrors.Add("transfer", err)
+		}
+	}
+	if input.ExecuteAt.IsZero() {
+		validationErrors.Add("executeAt", &ErrExecuteAtNotInFuture{ExecuteAt: input.ExecuteAt})
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+type CancelScheduledTransferInput struct {
+	ID string `json:"id"`
+}
+
+func scheduleTransferHandler(scheduledCollection *mongo.Collection, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		scheduleInput, ok := bindAndValidate[ScheduleTransferInput](ctx)
+		if !ok {
+			return
+		}
+		// The input validator has no clock, so the time check happens here.
+		now := config.Clock.Now()
+		if !scheduleInput.ExecuteAt.After(now) {
+			sendError(ctx, &ErrExecuteAtNotInFuture{ExecuteAt: scheduleInput.ExecuteAt})
+			return
+		}
+
+		scheduledTransfer := ScheduledTransfer{
+			FromUser:  scheduleInput.FromUser,
+			ToUser:    scheduleInput.ToUser,
+			Amount:    scheduleInput.Amount,
+			Category:  scheduleInput.Category,
+			Memo:      scheduleInput.Memo,
+			ExecuteAt: scheduleInput.ExecuteAt.UTC(),
+			Status:    scheduledStatusPending,
+			CreatedAt: now.UTC(),
+		}
+		insertResult, err := scheduledCollection.InsertOne(ctx.Request.Context(), scheduledTransfer)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		scheduledTransfer.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+		respond(ctx, http.StatusCreated, scheduledTransfer)
+	}
+}
+
+func cancelScheduledTransferHandler(scheduledCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		var cancelInput CancelScheduledTransferInput
+		if err := ctx.BindJSON(&cancelInput); err != nil {
+			sendError(ctx, &ErrInputRead{InputError: err})
+			return
+		}
+
+		scheduledID, err := parseObjectID(cancelInput.ID)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		var scheduledTransfer ScheduledTransfer
+		if err := scheduledCollection.FindOneAndUpdate(ctx.Request.Context(), bson.D{
+			{Key: "_id", Value: scheduledID},
+			{Key: "status", Value: scheduledStatusPending},
+		}, bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: scheduledStatusCancelled}}}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&scheduledTransfer); err == nil {
+			respond(ctx, http.StatusOK, scheduledTransfer)
+			return
+		} else if err != mongo.ErrNoDocuments {
+			sendError(ctx, err)
+			return
+		}
+
+		if err := scheduledCollection.FindOne(ctx.Request.Context(), bson.D{{
+			Key: "_id", Value: scheduledID,
+		}}).Decode(&scheduledTransfer); err != nil {
+			if err == mongo.ErrNoDocuments {
+				sendError(ctx, &ErrScheduledTransferNotFound{ID: cancelInput.ID})
+				return
+			}
+			sendError(ctx, err)
+			return
+		}
+		sendError(ctx, &ErrScheduledTransferNotPending{ID: cancelInput.ID, Status: scheduledTransfer.Status})
+	}
+}
+
+var errScheduledTransferClaimed = errors.New("scheduled transfer is no longer pending")
+
+// executeDueTransfers runs every pending transfer whose time has come. The
+// status flip to completed happens inside the transfer's own transaction,
+// so a transfer is either applied and completed or neither; after a crash
+// the entry is still pending and is picked up again.
+func executeDueTransfers(
+	ctx context.Context, accountRepository *AccountRepository,
+	transactionCollection, scheduledCollection *mongo.Collection, config *Config,
+	publisher EventPublisher, now time.Time,
+) (int, error) {
+	dueSearchResult, err := scheduledCollection.Find(ctx, bson.D{
+		{Key: "status", Value: scheduledStatusPending},
+		{Key: "executeat", Value: bson.D{{Key: "$lte", Value: now}}},
+	}, options.Find().SetSort(bson.D{{Key: "executeat", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	var dueTransfers []ScheduledTransfer
+	if err := dueSearchResult.All(ctx, &dueTransfers); err != nil {
+		return 0, err
+	}
+
+	executedCount := 0
+	for _, scheduledTransfer := range dueTransfers {
+		pendingFilter := bson.D{
+			{Key: "_id", Value: scheduledTransfer.ID},
+			{Key: "status", Value: scheduledStatusPending},
+		}
+		_, err := executeTransfer(ctx, accountRepository, transactionCollection, config, publisher,
+			scheduledTransfer.TransferNote(), transferOptions{
+				inTransaction: func(sessionCtx mongo.SessionContext) error {
+					updateResult, err := scheduledCollection.UpdateOne(sessionCtx, pendingFilter,
+						bson.D{{Key: "$set", Value: bson.D{
+							{Key: "status", Value: scheduledStatusCompleted},
+							{Key: "executedat", Value: config.Clock.Now().UTC()},
+						}}})
+					if err != nil {
+						return err
+					}
+					if updateResult.ModifiedCount == 0 {
+						return errScheduledTransferClaimed
+					}
+					return nil
+				},
+			})
+		if errors.Is(err, errScheduledTransferClaimed) {
+			continue
+		}
+		if err != nil {
+			if _, updateErr := scheduledCollection.UpdateOne(ctx, pendingFilter,
+				bson.D{{Key: "$set", Value: bson.D{
+					{Key: "status", Value: scheduledStatusFailed},
+					{Key: "failure", Value: err.Error()},
+					{Key: "executedat", Value: config.Clock.Now().UTC()},
+				}}}); updateErr != nil {
+				return executedCount, updateErr
+			}
+			continue
+		}
+		executedCount++
+	}
+	return executedCount, nil
+}
+
+// startTransferScheduler executes due transfers at startup, which recovers
+// anything that came due while the service was down, and then on every
+// tick.
+func startTransferScheduler(
+	accountRepository *AccountRepository, transactionCollection, scheduledCollection *mongo.Collection,
+	config *Config, publisher EventPublisher,
+) {
+	execute := func() {
+		if _, err := executeDueTransfers(context.TODO(), accountRepository,
+			transactionCollection, scheduledCollection, config, publisher, config.Clock.Now()); err != nil {
+			log.Printf("scheduled transfer execution failed: %v", err)
+		}
+	}
+
+	go func() {
+		execute()
+		ticker := time.NewTicker(config.SchedulerInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			execute()
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (server *testServer) schedule(fromUser, toUser string, amount int, executeAt time.Time) ScheduledTransfer {
+	server.t.Helper()
+	recorder := server.request(http.MethodPost, "/transfer/schedule", ScheduleTransferInput{
+		TransferNote: TransferNote{FromUser: fromUser, ToUser: toUser, Amount: amount},
+		ExecuteAt:    executeAt,
+	})
+	expectStatus(server.t, recorder, http.StatusCreated)
+	return decodeResponse[ScheduledTransfer](server.t, recorder)
+}
+
+func (server *testServer) scheduledTransfer(scheduled ScheduledTransfer) ScheduledTransfer {
+	server.t.Helper()
+	var stored ScheduledTransfer
+	if err := server.scheduled.FindOne(context.Background(), bson.D{{Key: "_id", Value: scheduled.ID}}).
+		Decode(&stored); err != nil {
+		server.t.Fatal(err)
+	}
+	return stored
+}
+
+func (server *testServer) executeDueTransfers() int {
+	server.t.Helper()
+	executedCount, err := executeDueTransfers(context.Background(), server.accounts, server.transactions,
+		server.scheduled, server.config, server.events, server.clock.Now())
+	if err != nil {
+		server.t.Fatal(err)
+	}
+	return executedCount
+}
+
+func TestScheduledTransfers(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+	now := server.clock.Now()
+
+	recorder := server.request(http.MethodPost, "/transfer/schedule", ScheduleTransferInput{
+		TransferNote: TransferNote{FromUser: "alice", ToUser: "bob", Amount: 10},
+		ExecuteAt:    now,
+	})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrExecuteAtNotInFuture")
+
+	due := server.schedule("alice", "bob", 30, now.Add(time.Hour))
+	toGhost := server.schedule("alice", "ghost", 5, now.Add(time.Hour))
+	later := server.schedule("alice", "bob", 40, now.Add(2*time.Hour))
+
+	if executedCount := server.executeDueTransfers(); executedCount != 0 {
+		t.Fatalf("executed %d transfers before they were due", executedCount)
+	}
+	server.clock.Advance(time.Hour)
+	if executedCount := server.executeDueTransfers(); executedCount != 1 {
+		t.Fatalf("executed %d transfers at the due time, want 1", executedCount)
+	}
+
+	if alice, bob := server.account("alice"), server.account("bob"); alice.Balance != 70 || bob.Balance != 30 {
+		t.Fatalf("balances alice %d, bob %d; want 70 and 30", alice.Balance, bob.Balance)
+	}
+	if stored := server.scheduledTransfer(due); stored.Status != scheduledStatusCompleted || stored.ExecutedAt == nil ||
+		!stored.ExecutedAt.Equal(server.clock.Now()) {
+		t.Fatalf("due transfer = %+v, want completed now", stored)
+	}
+	if stored := server.scheduledTransfer(toGhost); stored.Status != scheduledStatusFailed || stored.Failure == "" {
+		t.Fatalf("transfer to a missing account = %+v, want failed", stored)
+	}
+	if stored := server.scheduledTransfer(later); stored.Status != scheduledStatusPending {
+		t.Fatalf("later transfer = %+v, want pending", stored)
+	}
+
+	// Running again must not repeat anything that already ran.
+	if executedCount := server.executeDueTransfers(); executedCount != 0 {
+		t.Fatalf("second run executed %d transfers", executedCount)
+	}
+}
+
+func TestCancelScheduledTransfer(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+	scheduled := server.schedule("alice", "bob", 30, server.clock.Now().Add(time.Hour))
+
+	recorder := server.request(http.MethodPost, "/transfer/schedule/cancel",
+		CancelScheduledTransferInput{ID: scheduled.ID.Hex()})
+	expectStatus(t, recorder, http.StatusOK)
+	if cancelled := decodeResponse[ScheduledTransfer](t, recorder); cancelled.Status != scheduledStatusCancelled {
+		t.Fatalf("cancelled transfer = %+v", cancelled)
+	}
+	recorder = server.request(http.MethodPost, "/transfer/schedule/cancel",
+		CancelScheduledTransferInput{ID: scheduled.ID.Hex()})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrScheduledTransferNotPending")
+	recorder = server.request(http.MethodPost, "/transfer/schedule/cancel",
+		CancelScheduledTransferInput{ID: "0123456789abcdef01234567"})
+	expectErrorCode(t, recorder, http.StatusNotFound, "ErrScheduledTransferNotFound")
+
+	server.clock.Advance(2 * time.Hour)
+	if executedCount := server.executeDueTransfers(); executedCount != 0 {
+		t.Fatalf("executed %d cancelled transfers", executedCount)
+	}
+	if alice := server.account("alice"); alice.Balance != 100 {
+		t.Fatalf("balance = %d after a cancelled transfer, want 100", alice.Balance)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ErrInvalidTransactionType struct {
+	Type string
+}
+
+func (err *ErrInvalidTransactionType) Code() string {
+	return "ErrInvalidTransactionType"
+}
+
+func (err *ErrInvalidTransactionType) messageArgs() []any {
+	return []any{err.Type}
+}
+
+func (err *ErrInvalidTransactionType) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrInvalidDateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (err *ErrInvalidDateRange) Code() string {
+	return "ErrInvalidDateRange"
+}
+
+func (err *ErrInvalidDateRange) messageArgs() []any {
+	return []any{err.From.Format(time.RFC3339), err.To.Format(time.RFC3339)}
+}
+
+func (err *ErrInvalidDateRange) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func isTransactionTypeValid(transactionType string) bool {
+	switch transactionType {
+	case transactionTypeBonus, transactionTypeDeposit, transactionTypeWithdraw,
+		transactionTypeTransferIn, transactionTypeTransferOut, transactionTypePenalty, transactionTypeInterest,
+		transactionTypeFee, transactionTypeFeeIncome,
+		transactionTypeHold, transactionTypeHoldCapture, transactionTypeHoldRelease, transactionTypeMigration,
+		transactionTypeMergeIn, transactionTypeOpeningBalance:
+		return true
+	}
+	return false
+}
+
+// appendHistoryRange narrows a history filter to createdAt between from and
+// to inclusive, either of which may be nil, and to the given types if any.
+func appendHistoryRange(historyFilter bson.D, from, to *time.Time, types []string) bson.D {
+	createdAtRange := bson.D{}
+	if from != nil {
+		createdAtRange = append(createdAtRange, bson.E{Key: "$gte", Value: from.UTC()})
+	}
+	if to != nil {
+		createdAtRange = append(createdAtRange, bson.E{Key: "$lte", Value: to.UTC()})
+	}
+	if len(createdAtRange) > 0 {
+		historyFilter = append(historyFilter, bson.E{Key: "createdat", Value: createdAtRange})
+	}
+	if len(types) > 0 {
+		historyFilter = append(historyFilter, bson.E{Key: "type", Value: bson.D{{Key: "$in", Value: types}}})
+	}
+	return historyFilter
+}
+
+// TransactionSearchInput selects the history of up to maxBatchSize
+// accounts. From and To bound createdAt inclusively; empty Types matches
+// every type.
+type TransactionSearchInput struct {
+	UserNames []string   `json:"usernames"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	Types     []string   `json:"types,omitempty"`
+}
+
+func (input *TransactionSearchInput) Error() error {
+	var validationErrors MultiError
+	if len(input.UserNames) == 0 || len(input.UserNames) > maxBatchSize {
+		validationErrors.Add("usernames", &ErrBatchSize{Max: maxBatchSize})
+	}
+	for _, userName := range input.UserNames {
+		if !isUsernameValid(userName) {
+			validationErrors.Add("usernames", &ErrInvalidUsername{UserName: userName})
+		}
+	}
+	if input.From != nil && input.To != nil && input.From.After(*input.To) {
+		validationErrors.Add("from", &ErrInvalidDateRange{From: *input.From, To: *input.To})
+	}
+	for _, transactionType := range input.Types {
+		if !isTransactionTypeValid(transactionType) {
+			validationErrors.Add("types", &ErrInvalidTransactionType{Type: transactionType})
+		}
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+func (input *TransactionSearchInput) normalizeUsernames() {
+	for i := range input.UserNames {
+		input.UserNames[i] = normalizeUsername(input.UserNames[i])
+	}
+}
+
+// searchTransactionsHandler lists the history of several accounts at once,
+// newest first, paginated with ?page= and ?limit= like the single account
+// history.
+func searchTransactionsHandler(transactionCollection *mongo.Collection, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		searchInput, ok := bindAndValidate[TransactionSearchInput](ctx)
+		if !ok {
+			return
+		}
+		pagination, err := parsePagination(ctx, int64(config.MaxPageSize))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		historyFilter := appendHistoryRange(
+			bson.D{{Key: "username", Value: bson.D{{Key: "$in", Value: searchInput.UserNames}}}},
+			searchInput.From, searchInput.To, searchInput.Types)
+
+		total, err := transactionCollection.CountDocuments(ctx.Request.Context(), historyFilter)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		historySearchResult, err := transactionCollection.Find(ctx.Request.Context(), historyFilter,
+			options.Find().
+				SetSort(bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}).
+				SetSkip(pagination.Skip()).
+				SetLimit(pagination.Limit),
+		)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		transactionList := []Transaction{}
+		if err := historySearchResult.All(ctx.Request.Context(), &transactionList); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		respond(ctx, http.StatusOK, TransactionPage{
+			Transactions: transactionList,
+			Total:        total,
+			Pagination:   pagination,
+		})
+	}
+}
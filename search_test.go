@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTransactionSearchInput(t *testing.T) {
+	from := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	tooMany := make([]string, maxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = "alice"
+	}
+	for _, test := range []struct {
+		name  string
+		input TransactionSearchInput
+		valid bool
+	}{
+		{name: "usernames only", input: TransactionSearchInput{UserNames: []string{"alice", "bob"}}, valid: true},
+		{name: "all filters", input: TransactionSearchInput{
+			UserNames: []string{"alice"}, From: &from, To: &to, Types: []string{transactionTypeDeposit},
+		}, valid: true},
+		{name: "interest", input: TransactionSearchInput{
+			UserNames: []string{"alice"}, Types: []string{transactionTypeInterest, transactionTypePenalty},
+		}, valid: true},
+		{name: "no usernames", input: TransactionSearchInput{}},
+		{name: "too many usernames", input: TransactionSearchInput{UserNames: tooMany}},
+		{name: "invalid username", input: TransactionSearchInput{UserNames: []string{"a b"}}},
+		{name: "reversed range", input: TransactionSearchInput{UserNames: []string{"alice"}, From: &to, To: &from}},
+		{name: "unknown type", input: TransactionSearchInput{UserNames: []string{"alice"}, Types: []string{"gift"}}},
+	} {
+		if err := test.input.Error(); (err == nil) != test.valid {
+			t.Errorf("%s: Error() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestSearchTransactions(t *testing.T) {
+	server := newTestServer(t)
+	for _, userName := range []string{"alice", "bob", "carol"} {
+		server.createAccount(userName)
+	}
+	server.clock.Advance(time.Hour)
+	server.deposit("alice", 10)
+	server.deposit("bob", 20)
+	server.deposit("carol", 30)
+	server.clock.Advance(time.Hour)
+	since := server.clock.Now()
+	server.withdraw("alice", 5)
+	server.withdraw("bob", 5)
+	server.deposit("bob", 7)
+
+	search := func(input TransactionSearchInput, query string) TransactionPage {
+		t.Helper()
+		recorder := server.request(http.MethodPost, "/transactions/search"+query, input)
+		expectStatus(t, recorder, http.StatusOK)
+		return decodeResponse[TransactionPage](t, recorder)
+	}
+	expectAmounts := func(name string, page TransactionPage, total int64, want ...int) {
+		t.Helper()
+		if page.Total != total || len(page.Transactions) != len(want) {
+			t.Fatalf("%s: total %d with %d transactions, want %d with %d",
+				name, page.Total, len(page.Transactions), total, len(want))
+		}
+		for i, transaction := range page.Transactions {
+			if transaction.UserName == "carol" {
+				t.Fatalf("%s: returned carol's transaction %+v", name, transaction)
+			}
+			if transaction.Amount != want[i] {
+				t.Fatalf("%s: transaction %d amount = %d, want %d", name, i, transaction.Amount, want[i])
+			}
+		}
+	}
+
+	page := search(TransactionSearchInput{UserNames: []string{"alice", "bob"}}, "")
+	expectAmounts("unfiltered", page, 7, 7, 5, 5, 20, 10, 0, 0)
+	userNames := map[string]bool{}
+	for _, transaction := range page.Transactions {
+		userNames[transaction.UserName] = true
+	}
+	if !userNames["alice"] || !userNames["bob"] {
+		t.Fatalf("unfiltered: usernames = %v, want alice and bob", userNames)
+	}
+
+	expectAmounts("deposits",
+		search(TransactionSearchInput{UserNames: []string{"alice", "bob"}, Types: []string{transactionTypeDeposit}}, ""),
+		3, 7, 20, 10)
+	expectAmounts("deposits since",
+		search(TransactionSearchInput{
+			UserNames: []string{"alice", "bob"}, From: &since, Types: []string{transactionTypeDeposit},
+		}, ""),
+		1, 7)
+	before := since.Add(-time.Minute)
+	expectAmounts("withdrawals before",
+		search(TransactionSearchInput{
+			UserNames: []string{"alice", "bob"}, To: &before, Types: []string{transactionTypeWithdraw},
+		}, ""),
+		0)
+	expectAmounts("second page",
+		search(TransactionSearchInput{UserNames: []string{"alice", "bob"}}, "?page=2&limit=3"),
+		7, 20, 10, 0)
+
+	recorder := server.request(http.MethodPost, "/transactions/search", TransactionSearchInput{})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrBatchSize")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newServer builds the HTTP server for the router. When a certificate is
+// configured it is loaded here so a bad pair fails at startup rather than
+// on the first handshake.
+func newServer(config *Config, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{Addr: config.ListenAddr, Handler: handler}
+	if config.TLSCertFile == "" {
+		return server, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate %s and key %s: %w",
+			config.TLSCertFile, config.TLSKeyFile, err)
+	}
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return server, nil
+}
+
+// serve answers HTTPS when newServer loaded a certificate, HTTP otherwise.
+func serve(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+type ErrShuttingDown struct{}
+
+func (err *ErrShuttingDown) Code() string {
+	return "ErrShuttingDown"
+}
+
+func (err *ErrShuttingDown) messageArgs() []any {
+	return nil
+}
+
+func (err *ErrShuttingDown) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrShuttingDown) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+// requestDrain tracks the mutating requests in progress so shutdown can
+// wait for them before the database connection goes away. Once closed it
+// refuses new ones.
+type requestDrain struct {
+	mutex     sync.Mutex
+	closed    bool
+	waitGroup sync.WaitGroup
+}
+
+func newRequestDrain() *requestDrain {
+	return &requestDrain{}
+}
+
+func (drain *requestDrain) begin() bool {
+	drain.mutex.Lock()
+	defer drain.mutex.Unlock()
+	if drain.closed {
+		return false
+	}
+	drain.waitGroup.Add(1)
+	return true
+}
+
+func (drain *requestDrain) close() {
+	drain.mutex.Lock()
+	defer drain.mutex.Unlock()
+	drain.closed = true
+}
+
+// wait blocks until every tracked request has finished or ctx is done.
+func (drain *requestDrain) wait(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		drain.waitGroup.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainMiddleware counts every write in flight with the drain and answers
+// 503 to writes that arrive once shutdown has begun. Reads are left to
+// http.Server.Shutdown.
+func drainMiddleware(drain *requestDrain) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+		if !drain.begin() {
+			ctx.Header("Retry-After", "1")
+			sendError(ctx, &ErrShuttingDown{})
+			ctx.Abort()
+			return
+		}
+		defer drain.waitGroup.Done()
+		ctx.Next()
+	}
+}
+
+// serveUntilSignalled serves until SIGINT or SIGTERM, then shuts down
+// gracefully: new writes are refused, the listener is closed and writes in
+// progress get up to ShutdownTimeout to commit. Only then does it return,
+// so the caller can disconnect from MongoDB without cutting a transfer off
+// mid-transaction.
+func serveUntilSignalled(server *http.Server, drain *requestDrain, config *Config) {
+	signalled, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErrors := make(chan error, 1)
+	go func() {
+		serveErrors <- serve(server)
+	}()
+	select {
+	case err := <-serveErrors:
+		log.Println(err)
+		return
+	case <-signalled.Done():
+	}
+
+	log.Printf("shutting down, waiting up to %s for requests in progress", config.ShutdownTimeout)
+	drain.close()
+	shutdownContext, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownContext); err != nil {
+		log.Printf("server shutdown: %v", err)
+	}
+	if err := drain.wait(shutdownContext); err != nil {
+		log.Printf("writes still in progress after %s: %v", config.ShutdownTimeout, err)
+	}
+}
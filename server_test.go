@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeSelfSignedCertificate writes a certificate for 127.0.0.1 and its key
+// as PEM files and returns their paths along with the parsed certificate.
+func writeSelfSignedCertificate(t *testing.T) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certificateDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := t.TempDir()
+	certFile, keyFile := filepath.Join(directory, "cert.pem"), filepath.Join(directory, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, certificate
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, certificate := writeSelfSignedCertificate(t)
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		io.WriteString(writer, "secure")
+	})
+	server, err := newServer(&Config{ListenAddr: "127.0.0.1:0", TLSCertFile: certFile, TLSKeyFile: keyFile}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.TLSConfig == nil {
+		t.Fatal("newServer did not load the certificate")
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServeTLS(listener, "", "")
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("ServeTLS: %v", err)
+		}
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	response, err := client.Get("https://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.TLS == nil || string(body) != "secure" {
+		t.Fatalf("response over TLS %v: %q", response.TLS != nil, body)
+	}
+}
+
+func TestNewServerRejectsBadCertificate(t *testing.T) {
+	certFile, _, _ := writeSelfSignedCertificate(t)
+	if _, err := newServer(&Config{TLSCertFile: certFile, TLSKeyFile: certFile}, http.NotFoundHandler()); err == nil {
+		t.Fatal("newServer accepted a certificate as its own key")
+	}
+	if _, err := newServer(&Config{TLSCertFile: "missing.pem", TLSKeyFile: "missing.pem"}, http.NotFoundHandler()); err == nil {
+		t.Fatal("newServer accepted missing files")
+	}
+	if server, err := newServer(&Config{ListenAddr: ":8080"}, http.NotFoundHandler()); err != nil || server.TLSConfig != nil {
+		t.Fatalf("newServer without a certificate = %+v, %v", server, err)
+	}
+}
+
+func TestRequestDrain(t *testing.T) {
+	drain := newRequestDrain()
+	started, release := make(chan struct{}), make(chan struct{})
+	var (
+		mutex sync.Mutex
+		steps []string
+	)
+	record := func(step string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		steps = append(steps, step)
+	}
+	router := gin.New()
+	router.Use(drainMiddleware(drain))
+	router.POST("/transfer", func(ctx *gin.Context) {
+		close(started)
+		<-release
+		record("transfer committed")
+		ctx.Status(http.StatusOK)
+	})
+	router.GET("/account", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	transferDone := make(chan int)
+	go func() {
+		transferDone <- serveRequest(t, router, http.MethodPost, "/transfer", nil).Code
+	}()
+	<-started
+
+	drain.close()
+	recorder := serveRequest(t, router, http.MethodPost, "/transfer", nil)
+	expectErrorCode(t, recorder, http.StatusServiceUnavailable, "ErrShuttingDown")
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Fatalf("Retry-After = %q, want 1", retryAfter)
+	}
+	expectStatus(t, serveRequest(t, router, http.MethodGet, "/account", nil), http.StatusOK)
+
+	// A wait that runs out of time reports it, with the transfer still going.
+	shortContext, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := drain.wait(shortContext); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait with the transfer in progress = %v, want DeadlineExceeded", err)
+	}
+
+	drained := make(chan error)
+	go func() {
+		err := drain.wait(context.Background())
+		record("disconnect")
+		drained <- err
+	}()
+	select {
+	case <-drained:
+		t.Fatal("drain finished with the transfer in progress")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if status := <-transferDone; status != http.StatusOK {
+		t.Fatalf("transfer status = %d, want 200", status)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0] != "transfer committed" || steps[1] != "disconnect" {
+		t.Fatalf("steps = %v, want the transfer committed before disconnecting", steps)
+	}
+}
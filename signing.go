@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestTimestampHeader = "X-Request-Timestamp"
+	requestNonceHeader     = "X-Request-Nonce"
+	requestSignatureHeader = "X-Request-Signature"
+	// maxSignedBodySize bounds the body read into memory to be verified.
+	maxSignedBodySize = 1 << 20
+)
+
+type ErrInvalidSignature struct{}
+
+func (err *ErrInvalidSignature) Code() string {
+	return "ErrInvalidSignature"
+}
+
+func (err *ErrInvalidSignature) messageArgs() []any {
+	return nil
+}
+
+func (err *ErrInvalidSignature) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrInvalidSignature) Status() int {
+	return http.StatusUnauthorized
+}
+
+type ErrSignatureRequired struct {
+	Route string
+}
+
+func (err *ErrSignatureRequired) Code() string {
+	return "ErrSignatureRequired"
+}
+
+func (err *ErrSignatureRequired) messageArgs() []any {
+	return []any{err.Route}
+}
+
+func (err *ErrSignatureRequired) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrSignatureRequired) Status() int {
+	return http.StatusUnauthorized
+}
+
+type ErrSignedBodyTooLarge struct {
+	Max int
+}
+
+func (err *ErrSignedBodyTooLarge) Code() string {
+	return "ErrSignedBodyTooLarge"
+}
+
+func (err *ErrSignedBodyTooLarge) messageArgs() []any {
+	return []any{err.Max}
+}
+
+func (err *ErrSignedBodyTooLarge) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrSignedBodyTooLarge) Status() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+type ErrRequestExpired struct {
+	Skew time.Duration
+}
+
+func (err *ErrRequestExpired) Code() string {
+	return "ErrRequestExpired"
+}
+
+func (err *ErrRequestExpired) messageArgs() []any {
+	return []any{err.Skew.String()}
+}
+
+func (err *ErrRequestExpired) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrRequestExpired) Status() int {
+	return http.StatusUnauthorized
+}
+
+type ErrNonceReused struct {
+	Nonce string
+}
+
+func (err *ErrNonceReused) Code() string {
+	return "ErrNonceReused"
+}
+
+func (err *ErrNonceReused) messageArgs() []any {
+	return []any{err.Nonce}
+}
+
+func (err *ErrNonceReused) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrNonceReused) Status() int {
+	return http.StatusConflict
+}
+
+// nonceCache remembers the nonces of signed requests for ttl. It lives in
+// the process, so replicas do not see each other's nonces.
+type nonceCache struct {
+	mutex    sync.Mutex
+	ttl      time.Duration
+	seen     map[string]time.Time
+	prunedAt time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// claim records nonce, reporting false if it was already seen within ttl.
+func (cache *nonceCache) claim(nonce string, now time.Time) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if now.Sub(cache.prunedAt) > cache.ttl {
+		for seenNonce, seenAt := range cache.seen {
+			if now.Sub(seenAt) > cache.ttl {
+				delete(cache.seen, seenNonce)
+			}
+		}
+		cache.prunedAt = now
+	}
+	if seenAt, ok := cache.seen[nonce]; ok && now.Sub(seenAt) <= cache.ttl {
+		return false
+	}
+	cache.seen[nonce] = now
+	return true
+}
+
+// signedRequestPayload is what clients sign: the timestamp, nonce, method,
+// request URI and body, separated by newlines.
+func signedRequestPayload(timestamp, nonce string, request *http.Request, body []byte) []byte {
+	var payload bytes.Buffer
+	for _, part := range []string{timestamp, nonce, request.Method, request.URL.RequestURI()} {
+		payload.WriteString(part)
+		payload.WriteByte('\n')
+	}
+	payload.Write(body)
+	return payload.Bytes()
+}
+
+// isSignatureRequired reports whether writes to route must be signed.
+func isSignatureRequired(config *Config, route string) bool {
+	for _, signedRoute := range config.SignedRoutes {
+		if signedRoute == "*" || signedRoute == route {
+			return true
+		}
+	}
+	return false
+}
+
+// signedRequestMiddleware checks writes that carry X-Request-Signature, an
+// HMAC-SHA256 with REQUEST_SIGNING_SECRET in the webhook signature format.
+// X-Request-Timestamp, in Unix seconds, must be within RequestMaxSkew of
+// now, and X-Request-Nonce must not have been used in the last two skews,
+// so a captured request cannot be sent again. Unsigned writes are refused
+// on SignedRoutes and pass elsewhere; the middleware does nothing without a
+// secret. Signed bodies are limited to maxSignedBodySize.
+func signedRequestMiddleware(config *Config) gin.HandlerFunc {
+	nonces := newNonceCache(2 * config.RequestMaxSkew)
+	return func(ctx *gin.Context) {
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+		timestamp := ctx.GetHeader(requestTimestampHeader)
+		nonce := ctx.GetHeader(requestNonceHeader)
+		signature := ctx.GetHeader(requestSignatureHeader)
+		if config.RequestSigningSecret == "" {
+			ctx.Next()
+			return
+		}
+
+		reject := func(err error) {
+			sendError(ctx, err)
+			ctx.Abort()
+		}
+		if timestamp == "" && nonce == "" && signature == "" {
+			if isSignatureRequired(config, ctx.FullPath()) {
+				reject(&ErrSignatureRequired{Route: ctx.FullPath()})
+				return
+			}
+			ctx.Next()
+			return
+		}
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || nonce == "" || signature == "" {
+			reject(&ErrInvalidSignature{})
+			return
+		}
+		var body []byte
+		if ctx.Request.Body != nil {
+			body, err = io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSignedBodySize))
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				reject(&ErrSignedBodyTooLarge{Max: maxSignedBodySize})
+				return
+			}
+			if err != nil {
+				reject(&ErrInputRead{InputError: err})
+				return
+			}
+			ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		expectedSignature := signWebhookPayload(config.RequestSigningSecret,
+			signedRequestPayload(timestamp, nonce, ctx.Request, body))
+		if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
+			reject(&ErrInvalidSignature{})
+			return
+		}
+
+		now := config.Clock.Now()
+		skew := now.Sub(time.Unix(seconds, 0))
+		if skew > config.RequestMaxSkew || skew < -config.RequestMaxSkew {
+			reject(&ErrRequestExpired{Skew: config.RequestMaxSkew})
+			return
+		}
+		if !nonces.claim(nonce, now) {
+			reject(&ErrNonceReused{Nonce: nonce})
+			return
+		}
+		ctx.Next()
+	}
+}
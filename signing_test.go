@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNonceCacheClaim(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cache := newNonceCache(time.Minute)
+
+	steps := []struct {
+		nonce string
+		at    time.Duration
+		want  bool
+	}{
+		{nonce: "a", at: 0, want: true},
+		{nonce: "a", at: 30 * time.Second, want: false},
+		{nonce: "b", at: 30 * time.Second, want: true},
+		{nonce: "a", at: time.Minute, want: false},
+		// Once the ttl has passed the nonce may be used again.
+		{nonce: "a", at: time.Minute + time.Second, want: true},
+		{nonce: "b", at: 2 * time.Minute, want: true},
+	}
+	for _, step := range steps {
+		if claimed := cache.claim(step.nonce, start.Add(step.at)); claimed != step.want {
+			t.Fatalf("claim(%q) at +%s = %v, want %v", step.nonce, step.at, claimed, step.want)
+		}
+	}
+	if len(cache.seen) != 2 {
+		t.Fatalf("cache holds %d nonces, want expired ones pruned", len(cache.seen))
+	}
+}
+
+func TestSignedRequestMiddleware(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	config := &Config{
+		RequestSigningSecret: "secret",
+		RequestMaxSkew:       time.Minute,
+		SignedRoutes:         []string{"/account/:username/lock"},
+		Clock:                clock,
+	}
+	router := gin.New()
+	router.Use(signedRequestMiddleware(config))
+	for _, route := range []string{"/deposit", "/account/:username/lock"} {
+		router.POST(route, func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+	}
+
+	input := TransactionInput{UserName: "alice", Amount: 10}
+	sign := func(target, nonce string, timestamp time.Time) []string {
+		body, err := json.Marshal(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seconds := strconv.FormatInt(timestamp.Unix(), 10)
+		request := httptest.NewRequest(http.MethodPost, target, nil)
+		return []string{
+			requestTimestampHeader, seconds,
+			requestNonceHeader, nonce,
+			requestSignatureHeader, signWebhookPayload("secret", signedRequestPayload(seconds, nonce, request, body)),
+		}
+	}
+
+	recorder := serveRequest(t, router, http.MethodPost, "/deposit", input)
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = serveRequest(t, router, http.MethodPost, "/account/alice/lock", input)
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrSignatureRequired")
+
+	headers := sign("/account/alice/lock", "n1", clock.Now())
+	recorder = serveRequest(t, router, http.MethodPost, "/account/alice/lock", input, headers...)
+	expectStatus(t, recorder, http.StatusOK)
+	recorder = serveRequest(t, router, http.MethodPost, "/account/alice/lock", input, headers...)
+	expectErrorCode(t, recorder, http.StatusConflict, "ErrNonceReused")
+
+	headers = sign("/account/bob/lock", "n2", clock.Now())
+	recorder = serveRequest(t, router, http.MethodPost, "/account/alice/lock", input, headers...)
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrInvalidSignature")
+
+	headers = sign("/deposit", "n3", clock.Now().Add(-2*time.Minute))
+	recorder = serveRequest(t, router, http.MethodPost, "/deposit", input, headers...)
+	expectErrorCode(t, recorder, http.StatusUnauthorized, "ErrRequestExpired")
+
+	headers = sign("/deposit", "n4", clock.Now())
+	largeInput := map[string]string{"padding": strings.Repeat("x", maxSignedBodySize)}
+	recorder = serveRequest(t, router, http.MethodPost, "/deposit", largeInput, headers...)
+	expectErrorCode(t, recorder, http.StatusRequestEntityTooLarge, "ErrSignedBodyTooLarge")
+}
+
+func TestIsSignatureRequired(t *testing.T) {
+	config := &Config{SignedRoutes: []string{"/transfer"}}
+	if !isSignatureRequired(config, "/transfer") || isSignatureRequired(config, "/deposit") {
+		t.Fatal("only /transfer should require a signature")
+	}
+	config.SignedRoutes = []string{"*"}
+	if !isSignatureRequired(config, "/deposit") {
+		t.Fatal(`"*" should cover every route`)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// streamCursor writes the cursor's documents as a JSON array, decoding and
+// encoding one element at a time so memory does not grow with the result
+// size. API v2 clients get the usual envelope around the array. Once the
+// first byte is out the status can no longer change, so a failure midway
+// is logged and the response is cut short, leaving invalid JSON behind.
+func streamCursor[T any](ctx *gin.Context, cursor *mongo.Cursor) {
+	defer cursor.Close(ctx.Request.Context())
+
+	envelope := wantsEnvelope(ctx)
+	hideDebt := ctx.GetBool(hideDebtKey)
+	fields := ctx.GetStringSlice(accountFieldsKey)
+	ctx.Header("Content-Type", "application/json; charset=utf-8")
+	ctx.Status(http.StatusOK)
+
+	writer := ctx.Writer
+	encoder := json.NewEncoder(writer)
+	if envelope {
+		writer.WriteString(`{"success":true,"data":`)
+	}
+	writer.WriteString("[")
+	for first := true; cursor.Next(ctx.Request.Context()); first = false {
+		var element T
+		if err := cursor.Decode(&element); err != nil {
+			log.Printf("aborting streamed response: %v", err)
+			return
+		}
+		if !first {
+			writer.WriteString(",")
+		}
+		var encoded any = element
+		if hideDebt {
+			encoded = withoutDebt(element)
+		}
+		if len(fields) > 0 {
+			encoded = withAccountFields(encoded, fields)
+		}
+		encoded = withFieldNaming(ctx, encoded)
+		if err := encoder.Encode(encoded); err != nil {
+			log.Printf("aborting streamed response: %v", err)
+			return
+		}
+		writer.Flush()
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("aborting streamed response: %v", err)
+		return
+	}
+	writer.WriteString("]")
+	if envelope {
+		timestamp, _ := json.Marshal(requestClock(ctx).Now().UTC())
+		writer.WriteString(`,"error":null,"timestamp":` + string(timestamp) + "}")
+	}
+}
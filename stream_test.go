@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// insertAccounts stores count generated accounts directly, bypassing the
+// API so large datasets are quick to set up.
+func insertAccounts(t *testing.T, server *testServer, count int) {
+	t.Helper()
+	accounts := make([]any, count)
+	for i := range accounts {
+		accounts[i] = BankAccount{UserName: fmt.Sprintf("user%05d", i), AccountNumber: fmt.Sprintf("%010d", i)}
+	}
+	if _, err := server.accounts.Collection().InsertMany(context.Background(), accounts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flushCountingRecorder records how much was written between flushes.
+type flushCountingRecorder struct {
+	*httptest.ResponseRecorder
+	unflushed    int
+	flushes      int
+	largestChunk int
+}
+
+func (recorder *flushCountingRecorder) Write(data []byte) (int, error) {
+	recorder.unflushed += len(data)
+	return recorder.ResponseRecorder.Write(data)
+}
+
+func (recorder *flushCountingRecorder) WriteString(data string) (int, error) {
+	recorder.unflushed += len(data)
+	return recorder.ResponseRecorder.WriteString(data)
+}
+
+func (recorder *flushCountingRecorder) Flush() {
+	recorder.flushes++
+	if recorder.unflushed > recorder.largestChunk {
+		recorder.largestChunk = recorder.unflushed
+	}
+	recorder.unflushed = 0
+	recorder.ResponseRecorder.Flush()
+}
+
+// The streamed list must be the same JSON array the buffered list is, and
+// it must leave the handler one account at a time: no write between two
+// flushes may be much larger than a single account, whatever the size of
+// the collection.
+func TestStreamAccounts(t *testing.T) {
+	server := newTestServer(t)
+	const accountCount = 3000
+	insertAccounts(t, server, accountCount)
+
+	request := httptest.NewRequest(http.MethodGet, "/account/all?stream=true", nil)
+	recorder := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	server.router.ServeHTTP(recorder, request)
+	expectStatus(t, recorder.ResponseRecorder, http.StatusOK)
+
+	var streamed []BankAccount
+	if err := json.Unmarshal(recorder.Body.Bytes(), &streamed); err != nil {
+		t.Fatalf("streamed body is not a JSON array: %v", err)
+	}
+	if len(streamed) != accountCount {
+		t.Fatalf("streamed %d accounts, want %d", len(streamed), accountCount)
+	}
+	for i, account := range streamed {
+		if want := fmt.Sprintf("user%05d", i); account.UserName != want {
+			t.Fatalf("account %d is %s, want %s", i, account.UserName, want)
+		}
+	}
+	if recorder.flushes != accountCount {
+		t.Fatalf("flushed %d times, want once per account", recorder.flushes)
+	}
+	singleAccount, err := json.Marshal(streamed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit := 2 * len(singleAccount); recorder.largestChunk > limit {
+		t.Fatalf("wrote %d bytes between flushes, want at most %d", recorder.largestChunk, limit)
+	}
+
+	recorder = &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	server.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/account/all?stream=true&limit=5", nil))
+	expectStatus(t, recorder.ResponseRecorder, http.StatusOK)
+	if limited := decodeResponse[[]BankAccount](t, recorder.ResponseRecorder); len(limited) != 5 {
+		t.Fatalf("streamed %d accounts with limit 5", len(limited))
+	}
+}
+
+// disconnectingRecorder stands in for a client that goes away once the
+// first streamed element has arrived.
+type disconnectingRecorder struct {
+	*httptest.ResponseRecorder
+	disconnect context.CancelFunc
+}
+
+func (recorder *disconnectingRecorder) Flush() {
+	recorder.ResponseRecorder.Flush()
+	recorder.disconnect()
+}
+
+func TestStreamStopsOnClientDisconnect(t *testing.T) {
+	server := newTestServer(t)
+	// More accounts than the first cursor batch holds, so the stream has to
+	// go back to the database after the client is gone.
+	const accountCount = 250
+	insertAccounts(t, server, accountCount)
+
+	requestContext, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+	request := httptest.NewRequest(http.MethodGet, "/account/all?stream=true", nil).WithContext(requestContext)
+	recorder := &disconnectingRecorder{ResponseRecorder: httptest.NewRecorder(), disconnect: disconnect}
+	server.router.ServeHTTP(recorder, request)
+
+	body := recorder.Body.String()
+	if strings.HasSuffix(body, "]") {
+		t.Fatal("stream ran to completion after the client disconnected")
+	}
+	if streamed := strings.Count(body, `"username"`); streamed == 0 || streamed >= accountCount {
+		t.Fatalf("streamed %d of %d accounts before stopping", streamed, accountCount)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go-mongo-db"
+
+// setupTracing installs an OTLP/HTTP trace exporter configured through the
+// standard OTEL_EXPORTER_OTLP_* and OTEL_SERVICE_NAME variables. When no
+// endpoint is set the global no-op tracer stays in place and spans cost
+// nothing. The returned function flushes pending spans on shutdown.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+	serviceResource, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.version", buildVersion),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("building trace resource: %w", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return tracerProvider.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span per request, continuing any trace
+// propagated by the caller, and hands its context to the handlers through
+// ctx.Request so database calls become child spans.
+func tracingMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		parentContext := otel.GetTextMapPropagator().Extract(
+			ctx.Request.Context(), propagation.HeaderCarrier(ctx.Request.Header),
+		)
+		spanContext, span := otel.Tracer(tracerName).Start(parentContext,
+			ctx.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", ctx.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("http.request_id", ctx.GetString(requestIDKey)),
+			),
+		)
+		defer span.End()
+
+		ctx.Request = ctx.Request.WithContext(spanContext)
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// startSpan opens a child span for one repository operation on userName.
+func startSpan(ctx context.Context, operation, userName string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "AccountRepository."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.operation", operation),
+			attribute.String("account.username", userName),
+		),
+	)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs an in-memory tracer provider for the rest of the
+// test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	spanRecorder := tracetest.NewSpanRecorder()
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+	})
+	return spanRecorder
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, keyValue := range span.Attributes() {
+		if keyValue.Key == key {
+			return keyValue.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	spanRecorder := recordSpans(t)
+	router := gin.New()
+	router.Use(tracingMiddleware())
+	router.GET("/account/:username/payoff", func(ctx *gin.Context) {
+		_, span := startSpan(ctx.Request.Context(), "FindFreshByUsername", ctx.Param("username"))
+		span.End()
+		ctx.Status(http.StatusInternalServerError)
+	})
+
+	recorder := serveRequest(t, router, http.MethodGet, "/account/alice/payoff", nil)
+	expectStatus(t, recorder, http.StatusInternalServerError)
+	spans := spanRecorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name() != "GET /account/:username/payoff" || server.Parent().IsValid() {
+		t.Fatalf("server span %q with parent %v", server.Name(), server.Parent())
+	}
+	if server.Status().Code != codes.Error || spanAttribute(server, "http.status_code").AsInt64() != 500 {
+		t.Fatalf("server span status %+v, attributes %v", server.Status(), server.Attributes())
+	}
+	if child.Name() != "AccountRepository.FindFreshByUsername" ||
+		child.Parent().SpanID() != server.SpanContext().SpanID() ||
+		spanAttribute(child, "account.username").AsString() != "alice" {
+		t.Fatalf("child span %q, parent %v, attributes %v", child.Name(), child.Parent(), child.Attributes())
+	}
+}
+
+func TestDepositSpanTree(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	spanRecorder := recordSpans(t)
+
+	server.deposit("alice", 100)
+	spans := spanRecorder.Ended()
+	var requestSpan sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() == "POST /deposit" {
+			requestSpan = span
+		}
+	}
+	if requestSpan == nil {
+		t.Fatalf("no request span among %d spans", len(spans))
+	}
+
+	var operations []string
+	for _, span := range spans {
+		if span == requestSpan {
+			continue
+		}
+		if span.Parent().SpanID() != requestSpan.SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of the request span", span.Name())
+		}
+		if userName := spanAttribute(span, "account.username").AsString(); userName != "alice" {
+			t.Errorf("span %q has username %q", span.Name(), userName)
+		}
+		operations = append(operations, span.Name())
+	}
+	want := []string{"AccountRepository.FindFreshByUsername", "AccountRepository.ReplaceIfUnchanged"}
+	if len(operations) != len(want) || operations[0] != want[0] || operations[1] != want[1] {
+		t.Fatalf("child spans %v, want %v", operations, want)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	transactionTypeBonus       = "bonus"
+	transactionTypeDeposit     = "deposit"
+	transactionTypeWithdraw    = "withdraw"
+	transactionTypeTransferIn  = "transfer-in"
+	transactionTypeTransferOut = "transfer-out"
+	transactionTypePenalty     = "penalty"
+	transactionTypeInterest    = "interest"
+	transactionTypeFee         = "fee"
+	transactionTypeFeeIncome   = "fee-income"
+	transactionTypeHold        = "hold"
+	transactionTypeHoldCapture = "hold-capture"
+	transactionTypeHoldRelease = "hold-release"
+	// transactionTypeMigration sets the balance to an imported figure; its
+	// Amount is the signed difference it made.
+	transactionTypeMigration = "migration"
+	// transactionTypeMergeIn folds a merged account into the primary; its
+	// Amount is the merged balance and MergedDebt the merged debt.
+	transactionTypeMergeIn = "merge-in"
+	// transactionTypeOpeningBalance stands in for history pruned by
+	// HistoryRetention; its Balance, Debt and Held are the state the pruned
+	// entries left behind.
+	transactionTypeOpeningBalance = "opening-balance"
+)
+
+// Transaction is one entry of an account's history. Balance and Debt hold
+// the account state right after the entry was applied.
+type Transaction struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserName     string             `json:"username"`
+	Type         string             `json:"type"`
+	Amount       int                `json:"amount"`
+	Counterparty string             `json:"counterparty,omitempty"`
+	Category     string             `json:"category,omitempty"`
+	Memo         string             `json:"memo,omitempty"`
+	Balance      int                `json:"balance"`
+	Debt         int                `json:"debt"`
+	CreatedAt    time.Time          `json:"createdAt"`
+	// DebtRepaymentPolicy is the account's policy when the entry was made,
+	// so replays split credits the same way.
+	DebtRepaymentPolicy string `json:"debtRepaymentPolicy,omitempty"`
+	// AllowNegative records whether the account could go below zero, so
+	// replays book overdrafts the same way.
+	AllowNegative bool `json:"allowNegative,omitempty"`
+	// IdempotencyKey is set on migration entries, at most one per key.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// MergedDebt is the debt a merge-in entry brought along.
+	MergedDebt int `json:"mergedDebt,omitempty"`
+	// MergedFrom names the account an entry was reassigned from by a merge.
+	// Replays skip such entries; the merge-in entry accounts for them.
+	MergedFrom string `json:"mergedFrom,omitempty"`
+	// Held is only recorded on opening-balance entries.
+	Held int `json:"held,omitempty"`
+}
+
+const (
+	maxCategoryLength = 32
+	maxMemoLength     = 140
+)
+
+type ErrCategoryTooLong struct {
+	Max int
+}
+
+func (err *ErrCategoryTooLong) Code() string {
+	return "ErrCategoryTooLong"
+}
+
+func (err *ErrCategoryTooLong) messageArgs() []any {
+	return []any{err.Max}
+}
+
+func (err *ErrCategoryTooLong) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func isCategoryValid(category string) bool {
+	return utf8.RuneCountInString(category) <= maxCategoryLength
+}
+
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	Total        int64         `json:"total"`
+	Pagination
+}
+
+type ErrTransactionNotFound struct {
+	ID string
+}
+
+func (err *ErrTransactionNotFound) Code() string {
+	return "ErrTransactionNotFound"
+}
+
+func (err *ErrTransactionNotFound) messageArgs() []any {
+	return []any{err.ID}
+}
+
+func (err *ErrTransactionNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrTransactionNotFound) Status() int {
+	return http.StatusNotFound
+}
+
+type ErrTransactionForbidden struct {
+	ID       string
+	UserName string
+}
+
+func (err *ErrTransactionForbidden) Code() string {
+	return "ErrTransactionForbidden"
+}
+
+func (err *ErrTransactionForbidden) messageArgs() []any {
+	return []any{err.ID, err.UserName}
+}
+
+func (err *ErrTransactionForbidden) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrTransactionForbidden) Status() int {
+	return http.StatusForbidden
+}
+
+func newTransaction(clock Clock, account BankAccount, transactionType string, amount int) Transaction {
+	return Transaction{
+		UserName:            account.UserName,
+		Type:                transactionType,
+		Amount:              amount,
+		Balance:             account.Balance,
+		Debt:                account.Debt,
+		CreatedAt:           clock.Now().UTC(),
+		DebtRepaymentPolicy: account.DebtRepaymentPolicy,
+		AllowNegative:       account.AllowNegative,
+	}
+}
+
+func insertTransaction(
+	ctx context.Context, transactionCollection *mongo.Collection, transaction Transaction,
+) (Transaction, error) {
+	insertResult, err := transactionCollection.InsertOne(ctx, transaction)
+	if err != nil {
+		return transaction, err
+	}
+	transaction.ID = insertResult.InsertedID.(primitive.ObjectID)
+	return transaction, nil
+}
+
+// recordTransaction appends the transaction to the history. The account
+// has already been written at this point, so a failure is logged rather
+// than reported to the client, and the write deliberately ignores client
+// disconnects.
+func recordTransaction(transactionCollection *mongo.Collection, transaction Transaction) Transaction {
+	transaction, err := insertTransaction(context.TODO(), transactionCollection, transaction)
+	if err != nil {
+		log.Printf("failed to record %s transaction for user %s: %v",
+			transaction.Type, transaction.UserName, err)
+	}
+	return transaction
+}
+
+// runInTransaction executes operation inside a multi-document transaction,
+// which requires MongoDB to run as a replica set. Cancelling ctx, e.g. when
+// the client disconnects, aborts the transaction.
+func runInTransaction(
+	ctx context.Context, collection *mongo.Collection, operation func(sessionCtx mongo.SessionContext) (any, error),
+) (any, error) {
+	session, err := collection.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(context.TODO())
+	return session.WithTransaction(ctx, operation)
+}
+
+func getTransactionHandler(transactionCollection *mongo.Collection) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		rawID := ctx.Param("id")
+		transactionID, err := parseObjectID(rawID)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		var transaction Transaction
+		err = transactionCollection.FindOne(ctx.Request.Context(), bson.D{{Key: "_id", Value: transactionID}}).Decode(&transaction)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			sendError(ctx, &ErrTransactionNotFound{ID: rawID})
+			return
+		}
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		if transaction.UserName != userName {
+			sendError(ctx, &ErrTransactionForbidden{ID: rawID, UserName: userName})
+			return
+		}
+
+		respond(ctx, http.StatusOK, transaction)
+	}
+}
+
+// getTransactionsHandler lists a user's history, newest first, optionally
+// narrowed to a single ?category=.
+func getTransactionsHandler(transactionCollection *mongo.Collection, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		userName := usernameParam(ctx)
+		if !isUsernameValid(userName) {
+			sendError(ctx, &ErrInvalidUsername{UserName: userName})
+			return
+		}
+
+		pagination, err := parsePagination(ctx, int64(config.MaxPageSize))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		historyFilter := bson.D{{Key: "username", Value: userName}}
+		if category, ok := ctx.GetQuery("category"); ok {
+			if !isCategoryValid(category) {
+				sendError(ctx, &ErrInvalidQueryParam{Name: "category", Value: category})
+				return
+			}
+			historyFilter = append(historyFilter, bson.E{Key: "category", Value: category})
+		}
+
+		total, err := transactionCollection.CountDocuments(ctx.Request.Context(), historyFilter)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		historySearchResult, err := transactionCollection.Find(ctx.Request.Context(), historyFilter,
+			options.Find().
+				SetSort(bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}).
+				SetSkip(pagination.Skip()).
+				SetLimit(pagination.Limit),
+		)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		transactionList := []Transaction{}
+		if err := historySearchResult.All(ctx.Request.Context(), &transactionList); err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		respond(ctx, http.StatusOK, TransactionPage{
+			Transactions: transactionList,
+			Total:        total,
+			Pagination:   pagination,
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetTransaction(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 40)
+	history := server.history("alice")
+	deposit := history[len(history)-1]
+
+	recorder := server.request(http.MethodGet, "/account/alice/transactions/"+deposit.ID.Hex(), nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if found := decodeResponse[Transaction](t, recorder); found.ID != deposit.ID ||
+		found.Type != transactionTypeDeposit || found.Amount != 40 {
+		t.Fatalf("transaction = %+v, want the deposit of 40", found)
+	}
+
+	recorder = server.request(http.MethodGet, "/account/bob/transactions/"+deposit.ID.Hex(), nil)
+	expectErrorCode(t, recorder, http.StatusForbidden, "ErrTransactionForbidden")
+	recorder = server.request(http.MethodGet, "/account/alice/transactions/0123456789abcdef01234567", nil)
+	expectErrorCode(t, recorder, http.StatusNotFound, "ErrTransactionNotFound")
+	recorder = server.request(http.MethodGet, "/account/alice/transactions/not-an-id", nil)
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrInvalidID")
+}
+
+func TestTransactionCategories(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	for _, input := range []TransactionInput{
+		{UserName: "alice", Amount: 1000, Category: "salary"},
+		{UserName: "alice", Amount: 20},
+		{UserName: "alice", Amount: 1000, Category: "salary"},
+	} {
+		recorder := server.request(http.MethodPost, "/deposit", input)
+		expectStatus(t, recorder, http.StatusOK)
+	}
+	recorder := server.request(http.MethodPost, "/transfer",
+		TransferNote{FromUser: "alice", ToUser: "bob", Amount: 700, Category: "rent"})
+	expectStatus(t, recorder, http.StatusOK)
+
+	tests := []struct {
+		target string
+		count  int
+	}{
+		{target: "/account/alice/transactions?category=salary", count: 2},
+		{target: "/account/alice/transactions?category=rent", count: 1},
+		{target: "/account/bob/transactions?category=rent", count: 1},
+		{target: "/account/alice/transactions?category=groceries", count: 0},
+	}
+	for _, test := range tests {
+		recorder := server.request(http.MethodGet, test.target, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		page := decodeResponse[TransactionPage](t, recorder)
+		if page.Total != int64(test.count) || len(page.Transactions) != test.count {
+			t.Fatalf("%s: %d of %d transactions, want %d", test.target, len(page.Transactions), page.Total, test.count)
+		}
+		category := test.target[strings.Index(test.target, "=")+1:]
+		for _, transaction := range page.Transactions {
+			if transaction.Category != category {
+				t.Fatalf("%s: listed %+v", test.target, transaction)
+			}
+		}
+	}
+
+	recorder = server.request(http.MethodPost, "/deposit",
+		TransactionInput{UserName: "alice", Amount: 1, Category: strings.Repeat("c", maxCategoryLength+1)})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrCategoryTooLong")
+}
+
+func TestTransferMemo(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	recorder := server.request(http.MethodPost, "/transfer",
+		TransferNote{FromUser: "alice", ToUser: "bob", Amount: 25, Memo: "dinner on friday"})
+	expectStatus(t, recorder, http.StatusOK)
+	aliceHistory, bobHistory := server.history("alice"), server.history("bob")
+	if debit := aliceHistory[len(aliceHistory)-1]; debit.Type != transactionTypeTransferOut || debit.Memo != "dinner on friday" {
+		t.Fatalf("alice's entry = %+v", debit)
+	}
+	if credit := bobHistory[len(bobHistory)-1]; credit.Type != transactionTypeTransferIn || credit.Memo != "dinner on friday" {
+		t.Fatalf("bob's entry = %+v", credit)
+	}
+
+	server.transfer("alice", "bob", 5)
+	if history := server.history("bob"); history[len(history)-1].Memo != "" {
+		t.Fatalf("transfer without a memo recorded %q", history[len(history)-1].Memo)
+	}
+
+	recorder = server.request(http.MethodPost, "/transfer",
+		TransferNote{FromUser: "alice", ToUser: "bob", Amount: 1, Memo: strings.Repeat("m", maxMemoLength+1)})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrFieldTooLong")
+}
+
+// Transfers can only address accounts by username in this tree, so the
+// ways of naming oneself are the username as stored and, with
+// normalization on, any other casing of it.
+func TestSelfTransfer(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.NormalizeUsernames = true
+	})
+	server.createAccount("alice")
+	server.deposit("alice", 100)
+	historyLength := len(server.history("alice"))
+
+	for _, note := range []TransferNote{
+		{FromUser: "alice", ToUser: "alice", Amount: 10},
+		{FromUser: "Alice", ToUser: "alice", Amount: 10},
+		{FromUser: "alice", ToUser: "ALICE", Amount: 10},
+	} {
+		recorder := server.request(http.MethodPost, "/transfer", note)
+		expectErrorCode(t, recorder, http.StatusBadRequest, "ErrSameSourceAndTarget")
+	}
+	if alice := server.account("alice"); alice.Balance != 100 || alice.Debt != 0 {
+		t.Fatalf("alice = %+v after refused self-transfers", alice)
+	}
+	if length := len(server.history("alice")); length != historyLength {
+		t.Fatalf("history length = %d, want %d", length, historyLength)
+	}
+}
+
+func TestTransferToUnavailableTarget(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		disable    func(server *testServer)
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name: "frozen",
+			disable: func(server *testServer) {
+				recorder := server.request(http.MethodPost, "/account/freeze/batch",
+					FreezeBatchInput{UserNames: []string{"bob"}}, "Authorization", "Bearer secret")
+				expectStatus(server.t, recorder, http.StatusOK)
+			},
+			wantStatus: http.StatusForbidden, wantCode: "ErrAccountFrozen",
+		},
+		{
+			name: "closed",
+			disable: func(server *testServer) {
+				recorder := server.request(http.MethodPost, "/account/close-with-transfer",
+					CloseWithTransferInput{UserName: "bob", Beneficiary: "carol"})
+				expectStatus(server.t, recorder, http.StatusOK)
+			},
+			wantStatus: http.StatusBadRequest, wantCode: "ErrAccountClosed",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(config *Config) {
+				config.AdminToken = "secret"
+				config.TransferFee = TransferFee{Flat: 5}
+				config.FeeAccount = "bank"
+			})
+			for _, userName := range []string{"alice", "bob", "carol", "bank"} {
+				server.createAccount(userName)
+			}
+			server.deposit("alice", 100)
+			test.disable(server)
+			alice, bob, bank := server.account("alice"), server.account("bob"), server.account("bank")
+			aliceHistory := len(server.history("alice"))
+
+			recorder := server.request(http.MethodPost, "/transfer", TransferNote{FromUser: "alice", ToUser: "bob", Amount: 30})
+			expectErrorCode(t, recorder, test.wantStatus, test.wantCode)
+
+			for userName, before := range map[string]BankAccount{"alice": alice, "bob": bob, "bank": bank} {
+				if after := server.account(userName); after.Balance != before.Balance || after.Debt != before.Debt {
+					t.Errorf("%s balance, debt = %d, %d, want %d, %d untouched",
+						userName, after.Balance, after.Debt, before.Balance, before.Debt)
+				}
+			}
+			if history := len(server.history("alice")); history != aliceHistory {
+				t.Errorf("alice has %d transactions, want %d", history, aliceHistory)
+			}
+		})
+	}
+}
+
+func TestTransferTransactionIDs(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	// Without the flag the response stays the two account states.
+	recorder := server.request(http.MethodPost, "/transfer", TransferNote{FromUser: "alice", ToUser: "bob", Amount: 10})
+	expectStatus(t, recorder, http.StatusOK)
+	if accounts := decodeResponse[[]BankAccount](t, recorder); len(accounts) != 2 {
+		t.Fatalf("response = %s, want the two accounts", recorder.Body.String())
+	}
+
+	recorder = server.request(http.MethodPost, "/transfer?transactionIds=true",
+		TransferNote{FromUser: "alice", ToUser: "bob", Amount: 25, Memo: "lunch"})
+	expectStatus(t, recorder, http.StatusOK)
+	result := decodeResponse[TransferResult](t, recorder)
+	if len(result.Accounts) != 2 || result.Accounts[0].Balance != 65 || result.Accounts[1].Balance != 35 {
+		t.Fatalf("accounts = %+v, want alice at 65 and bob at 35", result.Accounts)
+	}
+
+	for _, side := range []struct {
+		userName        string
+		id              string
+		transactionType string
+		counterparty    string
+	}{
+		{userName: "alice", id: result.DebitTransactionID.Hex(), transactionType: transactionTypeTransferOut,
+			counterparty: "bob"},
+		{userName: "bob", id: result.CreditTransactionID.Hex(), transactionType: transactionTypeTransferIn,
+			counterparty: "alice"},
+	} {
+		recorder := server.request(http.MethodGet, "/account/"+side.userName+"/transactions/"+side.id, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		transaction := decodeResponse[Transaction](t, recorder)
+		if transaction.Type != side.transactionType || transaction.Amount != 25 ||
+			transaction.Counterparty != side.counterparty || transaction.Memo != "lunch" {
+			t.Fatalf("%s transaction %s = %+v, want a %s of 25 with %s",
+				side.userName, side.id, transaction, side.transactionType, side.counterparty)
+		}
+	}
+}
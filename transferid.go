@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const transferReplayedHeader = "X-Transfer-Replayed"
+
+type ErrTransferIDReused struct {
+	TransferID string
+}
+
+func (err *ErrTransferIDReused) Code() string {
+	return "ErrTransferIDReused"
+}
+
+func (err *ErrTransferIDReused) messageArgs() []any {
+	return []any{err.TransferID}
+}
+
+func (err *ErrTransferIDReused) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrTransferIDReused) Status() int {
+	return http.StatusConflict
+}
+
+// ExecutedTransfer remembers the outcome of a transfer sent with a client
+// generated transfer ID, so a retry is answered with the original accounts
+// and history entries instead of moving the money again. Records expire
+// after TransferIDRetention.
+type ExecutedTransfer struct {
+	TransferID          string             `json:"transferId"`
+	FromUser            string             `json:"fromuser"`
+	ToUser              string             `json:"touser"`
+	Amount              int                `json:"amount"`
+	Accounts            []BankAccount      `json:"accounts"`
+	DebitTransactionID  primitive.ObjectID `json:"debitTransactionId"`
+	CreditTransactionID primitive.ObjectID `json:"creditTransactionId"`
+	CreatedAt           time.Time          `json:"createdAt"`
+}
+
+func (executedTransfer *ExecutedTransfer) matches(transferNote TransferNote) bool {
+	return executedTransfer.FromUser == transferNote.FromUser &&
+		executedTransfer.ToUser == transferNote.ToUser &&
+		executedTransfer.Amount == transferNote.Amount
+}
+
+// findExecutedTransfer returns the result recorded for the note's transfer
+// ID, or an error when the ID was used for a different transfer.
+func findExecutedTransfer(
+	ctx context.Context, executedCollection *mongo.Collection, transferNote TransferNote,
+) (TransferResult, bool, error) {
+	var executedTransfer ExecutedTransfer
+	err := executedCollection.FindOne(ctx, bson.D{
+		{Key: "transferid", Value: transferNote.TransferID},
+	}).Decode(&executedTransfer)
+	if err == mongo.ErrNoDocuments {
+		return TransferResult{}, false, nil
+	}
+	if err != nil {
+		return TransferResult{}, false, err
+	}
+	if !executedTransfer.matches(transferNote) {
+		return TransferResult{}, false, &ErrTransferIDReused{TransferID: transferNote.TransferID}
+	}
+	return TransferResult{
+		Accounts:            executedTransfer.Accounts,
+		DebitTransactionID:  executedTransfer.DebitTransactionID,
+		CreditTransactionID: executedTransfer.CreditTransactionID,
+	}, true, nil
+}
+
+// executeTransferOnce runs executeTransfer unless the note carries a
+// transfer ID that already went through, in which case the recorded
+// result is returned and replayed is true. The record is written in the
+// transfer's own transaction, and its unique index turns a concurrent
+// retry into a replay rather than a second transfer.
+func executeTransferOnce(
+	ctx context.Context, accountRepository *AccountRepository,
+	transactionCollection, executedCollection *mongo.Collection,
+	config *Config, publisher EventPublisher, transferNote TransferNote, transferOptions transferOptions,
+) (transferResult TransferResult, replayed bool, err error) {
+	if transferNote.TransferID == "" {
+		transferResult, err = executeTransfer(ctx, accountRepository, transactionCollection,
+			config, publisher, transferNote, transferOptions)
+		return transferResult, false, err
+	}
+
+	if transferResult, replayed, err = findExecutedTransfer(ctx, executedCollection, transferNote); replayed || err != nil {
+		return transferResult, replayed, err
+	}
+	transferOptions.recordResult = func(sessionCtx mongo.SessionContext, transferResult TransferResult) error {
+		_, err := executedCollection.InsertOne(sessionCtx, ExecutedTransfer{
+			TransferID:          transferNote.TransferID,
+			FromUser:            transferNote.FromUser,
+			ToUser:              transferNote.ToUser,
+			Amount:              transferNote.Amount,
+			Accounts:            transferResult.Accounts,
+			DebitTransactionID:  transferResult.DebitTransactionID,
+			CreditTransactionID: transferResult.CreditTransactionID,
+			CreatedAt:           config.Clock.Now().UTC(),
+		})
+		return err
+	}
+	transferResult, err = executeTransfer(ctx, accountRepository, transactionCollection,
+		config, publisher, transferNote, transferOptions)
+	if mongo.IsDuplicateKeyError(err) {
+		return findExecutedTransfer(ctx, executedCollection, transferNote)
+	}
+	return transferResult, false, err
+}
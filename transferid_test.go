@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTransferIDReplay(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+	server.deposit("alice", 100)
+
+	note := TransferNote{FromUser: "alice", ToUser: "bob", Amount: 30, TransferID: "retry-1"}
+	recorder := server.request(http.MethodPost, "/transfer?transactionIds=true", note)
+	expectStatus(t, recorder, http.StatusOK)
+	if replayed := recorder.Header().Get(transferReplayedHeader); replayed != "" {
+		t.Fatalf("first attempt has %s: %q", transferReplayedHeader, replayed)
+	}
+	original := decodeResponse[TransferResult](t, recorder)
+
+	// Money moved since then does not change what the retry reports.
+	server.deposit("alice", 10)
+	for attempt := 2; attempt <= 3; attempt++ {
+		recorder = server.request(http.MethodPost, "/transfer?transactionIds=true", note)
+		expectStatus(t, recorder, http.StatusOK)
+		if replayed := recorder.Header().Get(transferReplayedHeader); replayed != "true" {
+			t.Fatalf("attempt %d: %s = %q, want true", attempt, transferReplayedHeader, replayed)
+		}
+		retried := decodeResponse[TransferResult](t, recorder)
+		if retried.DebitTransactionID != original.DebitTransactionID ||
+			retried.CreditTransactionID != original.CreditTransactionID ||
+			len(retried.Accounts) != 2 || retried.Accounts[0].Balance != 70 || retried.Accounts[1].Balance != 30 {
+			t.Fatalf("attempt %d: %+v, want the original result %+v", attempt, retried, original)
+		}
+	}
+
+	if alice, bob := server.account("alice"), server.account("bob"); alice.Balance != 80 || bob.Balance != 30 {
+		t.Fatalf("balances = %d, %d, want 80, 30 after a single transfer", alice.Balance, bob.Balance)
+	}
+	transfers := 0
+	for _, transaction := range server.history("alice") {
+		if transaction.Type == transactionTypeTransferOut {
+			transfers++
+		}
+	}
+	if transfers != 1 {
+		t.Fatalf("alice has %d transfer-out entries, want 1", transfers)
+	}
+
+	// The same ID for another transfer is refused rather than replayed.
+	note.Amount = 40
+	recorder = server.request(http.MethodPost, "/transfer", note)
+	expectErrorCode(t, recorder, http.StatusConflict, "ErrTransferIDReused")
+
+	// Batch items honour transfer IDs shared with single transfers.
+	recorder = server.request(http.MethodPost, "/transfer/batch", TransferBatchInput{Transfers: []TransferNote{
+		{FromUser: "alice", ToUser: "bob", Amount: 30, TransferID: "retry-1"},
+		{FromUser: "alice", ToUser: "bob", Amount: 5, TransferID: "retry-2"},
+	}})
+	expectStatus(t, recorder, http.StatusMultiStatus)
+	expectMultiStatus(t, decodeResponse[MultiStatusResponse](t, recorder),
+		[]expectedItem{{status: http.StatusOK}, {status: http.StatusOK}})
+	if alice := server.account("alice"); alice.Balance != 75 {
+		t.Fatalf("alice balance = %d, want 75 after the batch", alice.Balance)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/text/unicode/norm"
+)
+
+// usernameNormalization mirrors Config.NormalizeUsernames. It is set once at
+// startup because input validators have no access to the config.
+var usernameNormalization bool
+
+// foldUsername returns the canonical form of userName: Unicode NFC,
+// lowercased.
+func foldUsername(userName string) string {
+	return strings.ToLower(norm.NFC.String(userName))
+}
+
+// normalizeUsername folds userName when normalization is enabled, so that
+// "Alice" and "alice" name the same account. Usernames are validated and
+// stored in this form.
+func normalizeUsername(userName string) string {
+	if !usernameNormalization {
+		return userName
+	}
+	return foldUsername(userName)
+}
+
+// usernameNormalizer is implemented by inputs that carry usernames.
+// bindAndValidate normalizes them before validating.
+type usernameNormalizer interface {
+	normalizeUsernames()
+}
+
+func usernameParam(ctx *gin.Context) string {
+	return normalizeUsername(ctx.Param("username"))
+}
+
+func usernameQuery(ctx *gin.Context) string {
+	return normalizeUsername(ctx.Query("username"))
+}
+
+// usernameReference is a stored field holding a username, which has to be
+// rewritten along with the account.
+type usernameReference struct {
+	collection *mongo.Collection
+	field      string
+}
+
+// migrateUsernames rewrites stored usernames that are not in folded form,
+// together with every reference to them, so that normalized lookups find
+// them. Usernames that fold to the same name as another account are only
+// logged: merging them needs an operator. It returns how many accounts were
+// renamed.
+func migrateUsernames(
+	ctx context.Context, accountCollection *mongo.Collection, references []usernameReference,
+) (int, error) {
+	accountSearchResult, err := accountCollection.Find(ctx, bson.D{},
+		options.Find().SetProjection(bson.D{{Key: "username", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	var accounts []BankAccount
+	if err := accountSearchResult.All(ctx, &accounts); err != nil {
+		return 0, err
+	}
+	userNamesByFolded := make(map[string][]string)
+	for _, account := range accounts {
+		folded := foldUsername(account.UserName)
+		userNamesByFolded[folded] = append(userNamesByFolded[folded], account.UserName)
+	}
+
+	renamedCount := 0
+	for folded, userNames := range userNamesByFolded {
+		if len(userNames) > 1 {
+			log.Printf("usernames %q all normalize to %q; left unchanged", userNames, folded)
+			continue
+		}
+		if userNames[0] == folded {
+			continue
+		}
+		if err := renameUsername(ctx, accountCollection, references, userNames[0], folded); err != nil {
+			return renamedCount, err
+		}
+		renamedCount++
+	}
+	return renamedCount, nil
+}
+
+func renameUsername(
+	ctx context.Context, accountCollection *mongo.Collection, references []usernameReference, from, to string,
+) error {
+	_, err := runInTransaction(ctx, accountCollection, func(sessionCtx mongo.SessionContext) (any, error) {
+		if _, err := accountCollection.UpdateOne(sessionCtx, bson.D{{Key: "username", Value: from}}, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "username", Value: to}}},
+			incrementVersion,
+		}); err != nil {
+			return nil, err
+		}
+		for _, reference := range references {
+			if _, err := reference.collection.UpdateMany(sessionCtx, bson.D{{Key: reference.field, Value: from}},
+				bson.D{{Key: "$set", Value: bson.D{{Key: reference.field, Value: to}}}}); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
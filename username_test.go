@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFoldUsername(t *testing.T) {
+	tests := []struct {
+		userName string
+		want     string
+	}{
+		{userName: "alice", want: "alice"},
+		{userName: "Alice", want: "alice"},
+		// "e" followed by a combining acute accent composes to "é".
+		{userName: "Rene\u0301", want: "ren\u00e9"},
+	}
+	for _, test := range tests {
+		if folded := foldUsername(test.userName); folded != test.want {
+			t.Errorf("foldUsername(%q) = %q, want %q", test.userName, folded, test.want)
+		}
+	}
+}
+
+func TestIsUsernameValid(t *testing.T) {
+	tests := []struct {
+		userName string
+		valid    bool
+	}{
+		{userName: "alice", valid: true},
+		{userName: "Alice_2", valid: true},
+		{userName: ""},
+		{userName: "   "},
+		{userName: "\t"},
+		{userName: "ali ce"},
+		{userName: "ali\u200bce"}, // zero-width space
+		{userName: "ali\u200dce"}, // zero-width joiner
+		{userName: "\ufeffalice"}, // byte order mark
+		{userName: "\u202ealice"}, // right-to-left override
+		{userName: "alice\x00"},
+		{userName: "alice\n"},
+		{userName: "ali\u0085ce"}, // next line
+		{userName: "ali\x7fce"},
+	}
+	for _, test := range tests {
+		if valid := isUsernameValid(test.userName); valid != test.valid {
+			t.Errorf("isUsernameValid(%q) = %v, want %v", test.userName, valid, test.valid)
+		}
+	}
+}
+
+func TestCreateAccountRejectsInvisibleCharacters(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.NormalizeUsernames = true
+	})
+	for _, userName := range []string{"ali\u200bce", "\u200dalice", "alice\u0000", "\u00a0"} {
+		recorder := server.request(http.MethodPost, "/account/create", BankAccount{UserName: userName})
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("%q: status = %d, want 400; body: %s", userName, recorder.Code, recorder.Body.String())
+		}
+	}
+	recorder := server.request(http.MethodGet, "/account/all", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if accounts := decodeResponse[[]BankAccount](t, recorder); len(accounts) != 0 {
+		t.Fatalf("created %d accounts, want none", len(accounts))
+	}
+}
+
+func TestMigrateUsernames(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	accountCollection := server.accounts.Collection()
+	for _, userName := range []string{"Alice", "BOB", "bob", "carol"} {
+		if _, err := accountCollection.InsertOne(ctx, BankAccount{UserName: userName}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := server.transactions.InsertOne(ctx, Transaction{
+		UserName: "Alice", Type: transactionTypeTransferOut, Counterparty: "BOB",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.transactions.InsertOne(ctx, Transaction{
+		UserName: "carol", Type: transactionTypeTransferIn, Counterparty: "Alice",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	renamedCount, err := migrateUsernames(ctx, accountCollection, []usernameReference{
+		{collection: server.transactions, field: "username"},
+		{collection: server.transactions, field: "counterparty"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamedCount != 1 {
+		t.Fatalf("renamed %d accounts, want 1", renamedCount)
+	}
+
+	// BOB and bob collide, so both are left for an operator.
+	for _, userName := range []string{"alice", "BOB", "bob", "carol"} {
+		server.account(userName)
+	}
+	if count, err := accountCollection.CountDocuments(ctx, bson.D{{Key: "username", Value: "Alice"}}); err != nil || count != 0 {
+		t.Fatalf("%d accounts still named Alice (%v)", count, err)
+	}
+	if history := server.history("alice"); len(history) != 1 || history[0].Counterparty != "BOB" {
+		t.Fatalf("alice's history = %+v", history)
+	}
+	if history := server.history("carol"); len(history) != 1 || history[0].Counterparty != "alice" {
+		t.Fatalf("carol's history = %+v", history)
+	}
+}
+
+func TestEnsureAccountIndexesWithDuplicates(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	accountCollection := server.database.Collection("DuplicatedAccounts")
+	for i := 0; i < 2; i++ {
+		if _, err := accountCollection.InsertOne(ctx, BankAccount{UserName: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ensureAccountIndexes(accountCollection); err != nil {
+		t.Fatalf("ensureAccountIndexes = %v, want the duplicates to be logged", err)
+	}
+	duplicates, err := findDuplicateUsernames(ctx, accountCollection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 1 || duplicates[0] != "alice" {
+		t.Fatalf("duplicates = %q", duplicates)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ErrInvalidID struct {
+	ID string
+}
+
+func (err *ErrInvalidID) Code() string {
+	return "ErrInvalidID"
+}
+
+func (err *ErrInvalidID) messageArgs() []any {
+	return []any{err.ID}
+}
+
+func (err *ErrInvalidID) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+// parseObjectID rejects malformed ids before they reach the database.
+func parseObjectID(rawID string) (primitive.ObjectID, error) {
+	objectID, err := primitive.ObjectIDFromHex(rawID)
+	if err != nil {
+		return primitive.NilObjectID, &ErrInvalidID{ID: rawID}
+	}
+	return objectID, nil
+}
+
+// FieldError ties a validation failure to the input field that caused it.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (err *FieldError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *FieldError) Unwrap() error {
+	return err.Err
+}
+
+// MultiError collects every validation failure of one input so the client
+// can fix them all at once.
+type MultiError struct {
+	Errors []*FieldError
+}
+
+func (err *MultiError) Add(field string, fieldErr error) {
+	err.Errors = append(err.Errors, &FieldError{Field: field, Err: fieldErr})
+}
+
+// ErrorOrNil keeps single failures reported exactly as before and only
+// switches to the aggregated form when several fields are invalid.
+func (err *MultiError) ErrorOrNil() error {
+	switch len(err.Errors) {
+	case 0:
+		return nil
+	case 1:
+		return err.Errors[0].Err
+	default:
+		return err
+	}
+}
+
+func (err *MultiError) Code() string {
+	return "ErrValidation"
+}
+
+func (err *MultiError) messageArgs() []any {
+	return []any{len(err.Errors)}
+}
+
+func (err *MultiError) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type FieldErrorMessage struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+func fieldErrorMessages(language string, multiError *MultiError) []FieldErrorMessage {
+	fieldMessages := make([]FieldErrorMessage, len(multiError.Errors))
+	for index, fieldErr := range multiError.Errors {
+		fieldMessages[index] = FieldErrorMessage{Field: fieldErr.Field, Message: fieldErr.Error()}
+		if knownError, ok := fieldErr.Err.(catalogError); ok {
+			fieldMessages[index].Message = localizeError(language, knownError)
+			fieldMessages[index].Code = knownError.Code()
+		}
+	}
+	return fieldMessages
+}
+
+// Validatable is implemented by request bodies that can check themselves.
+type Validatable interface {
+	Error() error
+}
+
+// bindAndValidate binds the JSON body into a T and validates it, answering
+// the request with the error when either step fails.
+func bindAndValidate[T any, PT interface {
+	*T
+	Validatable
+}](ctx *gin.Context) (T, bool) {
+	var input T
+	if err := renameRequestFields(ctx); err != nil {
+		sendError(ctx, &ErrInputRead{InputError: err})
+		return input, false
+	}
+	if err := ctx.BindJSON(&input); err != nil {
+		sendError(ctx, &ErrInputRead{InputError: err})
+		return input, false
+	}
+
+	if normalizer, ok := any(&input).(usernameNormalizer); ok {
+		normalizer.normalizeUsernames()
+	}
+	if err := PT(&input).Error(); err != nil {
+		sendError(ctx, err)
+		return input, false
+	}
+	return input, true
+}
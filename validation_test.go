@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorFields lists the fields of a validation error, in order.
+func errorFields(err error) string {
+	var multiError *MultiError
+	if !errors.As(err, &multiError) {
+		return ""
+	}
+	var fields []string
+	for _, fieldErr := range multiError.Errors {
+		fields = append(fields, fieldErr.Field)
+	}
+	return strings.Join(fields, ",")
+}
+
+func TestValidationReportsEveryField(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  Validatable
+		fields string
+	}{
+		{name: "valid deposit", input: &TransactionInput{UserName: "alice", Amount: 5}},
+		{name: "deposit", input: &TransactionInput{UserName: "al ice", Amount: 0},
+			fields: "username,amount"},
+		{name: "transfer", input: &TransferNote{FromUser: "", ToUser: "bob!", Amount: -1},
+			fields: "amount,fromuser,touser"},
+		{name: "transfer to self", input: &TransferNote{FromUser: "alice", ToUser: "alice", Amount: 0},
+			fields: "amount,touser"},
+		{name: "account", input: &BankAccount{UserName: "al ice", Email: "not-an-email"},
+			fields: "username,email"},
+	}
+	for _, test := range tests {
+		err := test.input.Error()
+		if test.fields == "" {
+			if err != nil {
+				t.Errorf("%s: Error() = %v, want nil", test.name, err)
+			}
+			continue
+		}
+		if fields := errorFields(err); fields != test.fields {
+			t.Errorf("%s: fields = %q, want %q (error %v)", test.name, fields, test.fields, err)
+		}
+	}
+}
+
+func TestSingleValidationErrorIsUnwrapped(t *testing.T) {
+	err := (&TransactionInput{UserName: "alice", Amount: 0}).Error()
+	var zeroError *ErrLessThanEqualZero
+	var multiError *MultiError
+	if !errors.As(err, &zeroError) || errors.As(err, &multiError) {
+		t.Fatalf("Error() = %#v, want a bare ErrLessThanEqualZero", err)
+	}
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	router := gin.New()
+	router.POST("/transfer", func(ctx *gin.Context) {
+		if _, ok := bindAndValidate[TransferNote](ctx); ok {
+			ctx.Status(http.StatusNoContent)
+		}
+	})
+
+	recorder := serveRequest(t, router, http.MethodPost, "/transfer",
+		TransferNote{FromUser: "alice", ToUser: "alice", Amount: 0})
+	expectErrorCode(t, recorder, http.StatusBadRequest, "ErrValidation")
+	errorMessage := decodeResponse[JsonMessage](t, recorder)
+	if len(errorMessage.Errors) != 2 ||
+		errorMessage.Errors[0].Field != "amount" || errorMessage.Errors[0].Code != "ErrLessThanEqualZero" ||
+		errorMessage.Errors[1].Field != "touser" || errorMessage.Errors[1].Code != "ErrSameSourceAndTarget" {
+		t.Fatalf("field errors = %+v", errorMessage.Errors)
+	}
+}
+
+// TestBindAndValidateResponses checks that bindAndValidate answers exactly
+// as the handlers' own bind, validate and sendError steps used to.
+func TestBindAndValidateResponses(t *testing.T) {
+	router := gin.New()
+	router.POST("/helper", func(ctx *gin.Context) {
+		if _, ok := bindAndValidate[TransactionInput](ctx); ok {
+			ctx.Status(http.StatusNoContent)
+		}
+	})
+	router.POST("/inline", func(ctx *gin.Context) {
+		var input TransactionInput
+		if err := ctx.BindJSON(&input); err != nil {
+			sendError(ctx, &ErrInputRead{InputError: err})
+			return
+		}
+		if err := input.Error(); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		ctx.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		body   any
+		status int
+	}{
+		{name: "valid", body: TransactionInput{UserName: "alice", Amount: 5}, status: http.StatusNoContent},
+		{name: "malformed", body: "not an object", status: http.StatusBadRequest},
+		{name: "one invalid field", body: TransactionInput{UserName: "alice"}, status: http.StatusBadRequest},
+		{name: "two invalid fields", body: TransactionInput{UserName: "al ice"}, status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		helper := serveRequest(t, router, http.MethodPost, "/helper", test.body)
+		inline := serveRequest(t, router, http.MethodPost, "/inline", test.body)
+		if helper.Code != test.status || inline.Code != test.status || helper.Body.String() != inline.Body.String() {
+			t.Errorf("%s: helper %d %s, inline %d %s", test.name,
+				helper.Code, helper.Body.String(), inline.Code, inline.Body.String())
+		}
+	}
+}
+
+func TestParseObjectID(t *testing.T) {
+	if objectID, err := parseObjectID("65e1a2b3c4d5e6f708192a3b"); err != nil || objectID.Hex() != "65e1a2b3c4d5e6f708192a3b" {
+		t.Fatalf("parseObjectID(valid) = %v, %v", objectID, err)
+	}
+	for _, rawID := range []string{"", "abc", "65e1a2b3c4d5e6f708192a3", "65e1a2b3c4d5e6f708192a3bc",
+		"zze1a2b3c4d5e6f708192a3b", "65e1a2b3-4d5e-6f70-8192-a3b"} {
+		var idError *ErrInvalidID
+		if _, err := parseObjectID(rawID); !errors.As(err, &idError) || idError.ID != rawID {
+			t.Errorf("parseObjectID(%q) = %v, want ErrInvalidID", rawID, err)
+		}
+	}
+}
+
+func TestAmountPrecisionGuard(t *testing.T) {
+	previousGuard := amountPrecisionGuard
+	t.Cleanup(func() {
+		amountPrecisionGuard = previousGuard
+	})
+
+	tests := []struct {
+		guard  bool
+		amount int
+		code   string
+	}{
+		{guard: true, amount: maxSafeAmount},
+		{guard: true, amount: maxSafeAmount + 1, code: "ErrAmountPrecisionLoss"},
+		{guard: true, amount: 1 << 62, code: "ErrAmountPrecisionLoss"},
+		{guard: true, amount: 0, code: "ErrLessThanEqualZero"},
+		{guard: false, amount: maxSafeAmount + 1},
+	}
+	for _, test := range tests {
+		amountPrecisionGuard = test.guard
+		err := amountError("amount", test.amount)
+		code := ""
+		var knownError catalogError
+		if errors.As(err, &knownError) {
+			code = knownError.Code()
+		}
+		if code != test.code {
+			t.Errorf("guard %v, amount %d: code %q, want %q", test.guard, test.amount, code, test.code)
+		}
+	}
+}
+
+func TestRejectUnsafeAmounts(t *testing.T) {
+	server := newTestServer(t)
+	server.createAccount("alice")
+	server.createAccount("bob")
+
+	// Written out as JSON so the amounts reach the binder exactly as a
+	// client would send them.
+	for _, test := range []struct {
+		target string
+		body   string
+	}{
+		{target: "/deposit", body: `{"username":"alice","amount":9007199254740993}`},
+		{target: "/withdraw", body: `{"username":"alice","amount":18014398509481984}`},
+		{target: "/transfer", body: `{"fromuser":"alice","touser":"bob","amount":9007199254740993}`},
+	} {
+		recorder := server.request(http.MethodPost, test.target, json.RawMessage(test.body))
+		expectErrorCode(t, recorder, http.StatusBadRequest, "ErrAmountPrecisionLoss")
+	}
+	if alice := server.account("alice"); alice.Balance != 0 || alice.Debt != 0 {
+		t.Fatalf("alice = %+v after refused amounts", alice)
+	}
+
+	recorder := server.request(http.MethodPost, "/deposit", json.RawMessage(`{"username":"alice","amount":9007199254740991}`))
+	expectStatus(t, recorder, http.StatusOK)
+	if alice := server.account("alice"); alice.Balance != maxSafeAmount {
+		t.Fatalf("alice balance = %d, want %d", alice.Balance, maxSafeAmount)
+	}
+}
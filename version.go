@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, set at build time with e.g.
+//
+//	go build -ldflags "-X main.buildVersion=1.4.0 -X main.buildCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	buildVersion = "dev"
+	buildCommit  = "unknown"
+	buildTime    = "unknown"
+)
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+}
+
+func versionHandler(ctx *gin.Context) {
+	respond(ctx, http.StatusOK, VersionInfo{
+		Version:   buildVersion,
+		Commit:    buildCommit,
+		BuildTime: buildTime,
+	})
+}
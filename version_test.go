@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVersionHandler(t *testing.T) {
+	router := gin.New()
+	router.GET("/version", versionHandler)
+
+	recorder := serveRequest(t, router, http.MethodGet, "/version", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if info := decodeResponse[VersionInfo](t, recorder); info != (VersionInfo{Version: "dev", Commit: "unknown", BuildTime: "unknown"}) {
+		t.Fatalf("default version = %+v", info)
+	}
+
+	version, commit, built := buildVersion, buildCommit, buildTime
+	t.Cleanup(func() {
+		buildVersion, buildCommit, buildTime = version, commit, built
+	})
+	buildVersion, buildCommit, buildTime = "1.4.0", "3f2c1a9", "2024-03-01T12:00:00Z"
+	recorder = serveRequest(t, router, http.MethodGet, "/version", nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if info := decodeResponse[VersionInfo](t, recorder); info != (VersionInfo{Version: "1.4.0", Commit: "3f2c1a9", BuildTime: "2024-03-01T12:00:00Z"}) {
+		t.Fatalf("injected version = %+v", info)
+	}
+}
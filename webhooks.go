@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	eventTypeDeposit  = "account.deposit"
+	eventTypeWithdraw = "account.withdraw"
+	eventTypeTransfer = "account.transfer"
+
+	deliveryStatusPending   = "pending"
+	deliveryStatusDelivered = "delivered"
+	deliveryStatusFailed    = "failed"
+
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+
+	webhookRequestTimeout = 10 * time.Second
+)
+
+// webhookEventTypes lists the events partners may subscribe to.
+var webhookEventTypes = map[string]bool{
+	eventTypeDeposit:     true,
+	eventTypeWithdraw:    true,
+	eventTypeTransfer:    true,
+	eventTypeBalanceLow:  true,
+	eventTypeBalanceHigh: true,
+}
+
+// WebhookSubscription asks for events of EventTypes to be POSTed to URL.
+// The secret signs every delivery and is only shown when registering.
+type WebhookSubscription struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	URL        string             `json:"url"`
+	EventTypes []string           `json:"eventTypes"`
+	Secret     string             `json:"secret,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+}
+
+// WebhookDelivery tracks one event sent to one subscription.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SubscriptionID primitive.ObjectID `json:"subscriptionId"`
+	EventType      string             `json:"eventType"`
+	Payload        string             `json:"payload"`
+	Status         string             `json:"status"`
+	Attempts       int                `json:"attempts"`
+	LastStatusCode int                `json:"lastStatusCode,omitempty"`
+	LastError      string             `json:"lastError,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt"`
+	DeliveredAt    *time.Time         `json:"deliveredAt,omitempty"`
+}
+
+type ErrInvalidWebhookURL struct {
+	URL string
+}
+
+func (err *ErrInvalidWebhookURL) Code() string {
+	return "ErrInvalidWebhookURL"
+}
+
+func (err *ErrInvalidWebhookURL) messageArgs() []any {
+	return []any{err.URL}
+}
+
+func (err *ErrInvalidWebhookURL) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrInvalidEventType struct {
+	EventType string
+}
+
+func (err *ErrInvalidEventType) Code() string {
+	return "ErrInvalidEventType"
+}
+
+func (err *ErrInvalidEventType) messageArgs() []any {
+	return []any{err.EventType}
+}
+
+func (err *ErrInvalidEventType) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+type ErrWebhookNotFound struct {
+	ID string
+}
+
+func (err *ErrWebhookNotFound) Code() string {
+	return "ErrWebhookNotFound"
+}
+
+func (err *ErrWebhookNotFound) messageArgs() []any {
+	return []any{err.ID}
+}
+
+func (err *ErrWebhookNotFound) Error() string {
+	return localizeError(defaultLanguage, err)
+}
+
+func (err *ErrWebhookNotFound) Status() int {
+	return http.StatusNotFound
+}
+
+type WebhookInput struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+	Secret     string   `json:"secret,omitempty"`
+}
+
+func (input *WebhookInput) Error() error {
+	var validationErrors MultiError
+	if parsedURL, err := url.Parse(input.URL); err != nil || parsedURL.Host == "" ||
+		(parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		validationErrors.Add("url", &ErrInvalidWebhookURL{URL: input.URL})
+	}
+	if len(input.EventTypes) == 0 {
+		validationErrors.Add("eventTypes", &ErrInvalidEventType{EventType: ""})
+	}
+	for _, eventType := range input.EventTypes {
+		if !webhookEventTypes[eventType] {
+			validationErrors.Add("eventTypes", &ErrInvalidEventType{EventType: eventType})
+		}
+	}
+	return validationErrors.ErrorOrNil()
+}
+
+// signWebhookPayload is the hex HMAC-SHA256 of the body, sent as
+// "sha256=<hex>" so receivers can check the delivery came from us.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher is an EventPublisher that hands every event to next
+// and POSTs it to the subscribed webhooks in the background.
+type WebhookDispatcher struct {
+	subscriptionCollection *mongo.Collection
+	deliveryCollection     *mongo.Collection
+	client                 *http.Client
+	maxAttempts            int
+	retryBackoff           time.Duration
+	clock                  Clock
+	next                   EventPublisher
+}
+
+func newWebhookDispatcher(
+	subscriptionCollection, deliveryCollection *mongo.Collection, config *Config, next EventPublisher,
+) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		subscriptionCollection: subscriptionCollection,
+		deliveryCollection:     deliveryCollection,
+		client:                 &http.Client{Timeout: webhookRequestTimeout},
+		maxAttempts:            config.WebhookMaxAttempts,
+		retryBackoff:           config.WebhookRetryBackoff,
+		clock:                  config.Clock,
+		next:                   next,
+	}
+}
+
+func (dispatcher *WebhookDispatcher) Publish(event Event) {
+	dispatcher.next.Publish(event)
+	go dispatcher.dispatch(event)
+}
+
+func (dispatcher *WebhookDispatcher) dispatch(event Event) {
+	ctx := context.TODO()
+	subscriptionSearchResult, err := dispatcher.subscriptionCollection.Find(ctx, bson.D{
+		{Key: "eventtypes", Value: event.Type},
+	})
+	if err != nil {
+		log.Printf("webhook lookup for event %s failed: %v", event.Type, err)
+		return
+	}
+	var subscriptions []WebhookSubscription
+	if err := subscriptionSearchResult.All(ctx, &subscriptions); err != nil {
+		log.Printf("webhook lookup for event %s failed: %v", event.Type, err)
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("webhook payload for event %s failed: %v", event.Type, err)
+		return
+	}
+	for _, subscription := range subscriptions {
+		delivery := WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventType:      event.Type,
+			Payload:        string(payload),
+			Status:         deliveryStatusPending,
+			CreatedAt:      dispatcher.clock.Now().UTC(),
+		}
+		insertResult, err := dispatcher.deliveryCollection.InsertOne(ctx, delivery)
+		if err != nil {
+			log.Printf("webhook delivery to %s could not be recorded: %v", subscription.URL, err)
+			continue
+		}
+		delivery.ID = insertResult.InsertedID.(primitive.ObjectID)
+		go dispatcher.deliver(subscription, delivery)
+	}
+}
+
+// deliver POSTs the payload until the receiver answers 2xx or the attempts
+// run out, doubling the wait after every failure. Each attempt's outcome
+// is stored on the delivery.
+func (dispatcher *WebhookDispatcher) deliver(subscription WebhookSubscription, delivery WebhookDelivery) {
+	backoff := dispatcher.retryBackoff
+	for delivery.Attempts < dispatcher.maxAttempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		delivery.Attempts++
+
+		statusCode, err := dispatcher.post(subscription, delivery)
+		delivery.LastStatusCode, delivery.LastError = statusCode, ""
+		if err != nil {
+			delivery.LastError = err.Error()
+		}
+		switch {
+		case err == nil:
+			deliveredAt := dispatcher.clock.Now().UTC()
+			delivery.Status, delivery.DeliveredAt = deliveryStatusDelivered, &deliveredAt
+		case delivery.Attempts >= dispatcher.maxAttempts:
+			delivery.Status = deliveryStatusFailed
+		}
+
+		if _, updateErr := dispatcher.deliveryCollection.ReplaceOne(context.TODO(),
+			bson.D{{Key: "_id", Value: delivery.ID}}, delivery); updateErr != nil {
+			log.Printf("webhook delivery %s could not be updated: %v", delivery.ID.Hex(), updateErr)
+		}
+		if err == nil {
+			return
+		}
+	}
+}
+
+func (dispatcher *WebhookDispatcher) post(subscription WebhookSubscription, delivery WebhookDelivery) (int, error) {
+	payload := []byte(delivery.Payload)
+	request, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookSignatureHeader, signWebhookPayload(subscription.Secret, payload))
+	request.Header.Set(webhookEventHeader, delivery.EventType)
+	request.Header.Set(webhookDeliveryHeader, delivery.ID.Hex())
+
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("receiver answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// registerWebhookHandler stores a subscription. Without a secret in the
+// request one is generated; either way it is returned only here.
+func registerWebhookHandler(dispatcher *WebhookDispatcher) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		webhookInput, ok := bindAndValidate[WebhookInput](ctx)
+		if !ok {
+			return
+		}
+
+		subscription := WebhookSubscription{
+			URL:        webhookInput.URL,
+			EventTypes: webhookInput.EventTypes,
+			Secret:     webhookInput.Secret,
+			CreatedAt:  dispatcher.clock.Now().UTC(),
+		}
+		if subscription.Secret == "" {
+			subscription.Secret = randomHex(32)
+		}
+		insertResult, err := dispatcher.subscriptionCollection.InsertOne(ctx.Request.Context(), subscription)
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		subscription.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+		respond(ctx, http.StatusCreated, subscription)
+	}
+}
+
+func unregisterWebhookHandler(dispatcher *WebhookDispatcher) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		subscriptionID, err := parseObjectID(ctx.Param("id"))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		deleteResult, err := dispatcher.subscriptionCollection.DeleteOne(ctx.Request.Context(),
+			bson.D{{Key: "_id", Value: subscriptionID}})
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		if deleteResult.DeletedCount == 0 {
+			sendError(ctx, &ErrWebhookNotFound{ID: ctx.Param("id")})
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// getWebhookDeliveriesHandler lists a subscription's deliveries, newest
+// first.
+func getWebhookDeliveriesHandler(dispatcher *WebhookDispatcher, config *Config) func(*gin.Context) {
+	return func(ctx *gin.Context) {
+		subscriptionID, err := parseObjectID(ctx.Param("id"))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		pagination, err := parsePagination(ctx, int64(config.MaxPageSize))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+
+		deliverySearchResult, err := dispatcher.deliveryCollection.Find(ctx.Request.Context(),
+			bson.D{{Key: "subscriptionid", Value: subscriptionID}},
+			options.Find().
+				SetSort(bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}).
+				SetSkip(pagination.Skip()).
+				SetLimit(pagination.Limit))
+		if err != nil {
+			sendError(ctx, err)
+			return
+		}
+		deliveries := []WebhookDelivery{}
+		if err := deliverySearchResult.All(ctx.Request.Context(), &deliveries); err != nil {
+			sendError(ctx, err)
+			return
+		}
+		respond(ctx, http.StatusOK, deliveries)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	// RFC 4231, test case 2.
+	signature := signWebhookPayload("Jefe", []byte("what do ya want for nothing?"))
+	if want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"; signature != want {
+		t.Fatalf("signature = %s, want %s", signature, want)
+	}
+}
+
+func TestWebhookInputValidation(t *testing.T) {
+	tests := []struct {
+		input WebhookInput
+		valid bool
+	}{
+		{input: WebhookInput{URL: "https://partner.example.com/hook", EventTypes: []string{eventTypeDeposit}}, valid: true},
+		{input: WebhookInput{URL: "ftp://partner.example.com/hook", EventTypes: []string{eventTypeDeposit}}},
+		{input: WebhookInput{URL: "/hook", EventTypes: []string{eventTypeDeposit}}},
+		{input: WebhookInput{URL: "https://partner.example.com/hook"}},
+		{input: WebhookInput{URL: "https://partner.example.com/hook", EventTypes: []string{"account.opened"}}},
+	}
+	for _, test := range tests {
+		if err := test.input.Error(); (err == nil) != test.valid {
+			t.Errorf("%+v: error = %v, want valid %v", test.input, err, test.valid)
+		}
+	}
+}
+
+// receivedWebhook is one request seen by a webhookReceiver.
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver answers with statuses in turn, repeating the last one,
+// and records every request.
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	received []receivedWebhook
+}
+
+func (receiver *webhookReceiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	status := receiver.statuses[min(len(receiver.received), len(receiver.statuses)-1)]
+	receiver.received = append(receiver.received, receivedWebhook{header: request.Header.Clone(), body: body})
+	writer.WriteHeader(status)
+}
+
+func (receiver *webhookReceiver) requests() []receivedWebhook {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	return append([]receivedWebhook(nil), receiver.received...)
+}
+
+// newWebhookServer wires a WebhookDispatcher into the test server's router
+// and registers a subscription to eventTypes on a receiver answering with
+// statuses.
+func newWebhookServer(t *testing.T, statuses ...int) (*testServer, *webhookReceiver, WebhookSubscription) {
+	t.Helper()
+	server := newTestServer(t, func(config *Config) {
+		config.AdminToken = "secret"
+		config.WebhookMaxAttempts = 3
+		config.WebhookRetryBackoff = 10 * time.Millisecond
+	})
+	dispatcher := newWebhookDispatcher(server.webhooks, server.deliveries, server.config, server.events)
+	server.router = newRouter(server.accounts, server.transactions, server.scheduled, server.holds,
+		server.pending, server.executed, server.config, dispatcher, dispatcher, newRequestDrain())
+
+	receiver := &webhookReceiver{statuses: statuses}
+	receiverServer := httptest.NewServer(receiver)
+	t.Cleanup(receiverServer.Close)
+
+	recorder := server.request(http.MethodPost, "/admin/webhooks", WebhookInput{
+		URL:        receiverServer.URL + "/hook",
+		EventTypes: []string{eventTypeDeposit, eventTypeTransfer},
+		Secret:     "partner-secret",
+	}, "Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusCreated)
+	subscription := decodeResponse[WebhookSubscription](t, recorder)
+	if subscription.ID.IsZero() || subscription.Secret != "partner-secret" {
+		t.Fatalf("registered subscription = %+v", subscription)
+	}
+	return server, receiver, subscription
+}
+
+// awaitDelivery polls the delivery log until its newest delivery is no
+// longer pending.
+func (server *testServer) awaitDelivery(subscription WebhookSubscription) WebhookDelivery {
+	server.t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		recorder := server.request(http.MethodGet, "/admin/webhooks/"+subscription.ID.Hex()+"/deliveries", nil,
+			"Authorization", "Bearer secret")
+		expectStatus(server.t, recorder, http.StatusOK)
+		deliveries := decodeResponse[[]WebhookDelivery](server.t, recorder)
+		if len(deliveries) > 0 && deliveries[0].Status != deliveryStatusPending {
+			return deliveries[0]
+		}
+	}
+	server.t.Fatal("webhook delivery never settled")
+	return WebhookDelivery{}
+}
+
+func TestWebhookSignedDelivery(t *testing.T) {
+	server, receiver, subscription := newWebhookServer(t, http.StatusOK)
+	server.createAccount("alice")
+	server.withdraw("alice", 5)
+	server.deposit("alice", 100)
+
+	delivery := server.awaitDelivery(subscription)
+	if delivery.Status != deliveryStatusDelivered || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusOK ||
+		delivery.EventType != eventTypeDeposit || delivery.DeliveredAt == nil {
+		t.Fatalf("delivery = %+v", delivery)
+	}
+	requests := receiver.requests()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want only the deposit", len(requests))
+	}
+	request := requests[0]
+	if signature := request.header.Get(webhookSignatureHeader); signature != signWebhookPayload("partner-secret", request.body) {
+		t.Fatalf("signature %q does not match the body", signature)
+	}
+	if request.header.Get(webhookEventHeader) != eventTypeDeposit || request.header.Get(webhookDeliveryHeader) != delivery.ID.Hex() {
+		t.Fatalf("headers = %v", request.header)
+	}
+	var event Event
+	if err := json.Unmarshal(request.body, &event); err != nil || event.Type != eventTypeDeposit || event.UserName != "alice" {
+		t.Fatalf("payload = %s (%v)", request.body, err)
+	}
+
+	recorder := server.request(http.MethodDelete, "/admin/webhooks/"+subscription.ID.Hex(), nil,
+		"Authorization", "Bearer secret")
+	expectStatus(t, recorder, http.StatusNoContent)
+	recorder = server.request(http.MethodDelete, "/admin/webhooks/"+subscription.ID.Hex(), nil,
+		"Authorization", "Bearer secret")
+	expectErrorCode(t, recorder, http.StatusNotFound, "ErrWebhookNotFound")
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		status     string
+		attempts   int
+		lastStatus int
+	}{
+		{name: "recovers", statuses: []int{http.StatusInternalServerError, http.StatusOK},
+			status: deliveryStatusDelivered, attempts: 2, lastStatus: http.StatusOK},
+		{name: "gives up", statuses: []int{http.StatusInternalServerError},
+			status: deliveryStatusFailed, attempts: 3, lastStatus: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, receiver, subscription := newWebhookServer(t, test.statuses...)
+			server.createAccount("alice")
+			server.deposit("alice", 100)
+
+			delivery := server.awaitDelivery(subscription)
+			if delivery.Status != test.status || delivery.Attempts != test.attempts ||
+				delivery.LastStatusCode != test.lastStatus {
+				t.Fatalf("delivery = %+v, want %s after %d attempts", delivery, test.status, test.attempts)
+			}
+			if test.status == deliveryStatusFailed && delivery.LastError == "" {
+				t.Fatal("failed delivery has no error")
+			}
+			requests := receiver.requests()
+			if len(requests) != test.attempts {
+				t.Fatalf("receiver got %d requests, want %d", len(requests), test.attempts)
+			}
+			for _, request := range requests[1:] {
+				if string(request.body) != string(requests[0].body) ||
+					request.header.Get(webhookDeliveryHeader) != requests[0].header.Get(webhookDeliveryHeader) {
+					t.Fatal("a retry changed the delivery")
+				}
+			}
+		})
+	}
+}